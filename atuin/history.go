@@ -0,0 +1,89 @@
+// Package atuin reads the SQLite history kept by atuin. It lives apart from
+// model so the SQLite driver is only linked into the binaries that import
+// shell history, and not into the hooks that run on every prompt.
+package atuin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	_ "modernc.org/sqlite"
+)
+
+// ReadHistory reads atuin's history database at path, oldest first. atuin
+// records the exit code, duration, directory, session and `host:user` of
+// every command, but not the shell.
+func ReadHistory(ctx context.Context, path string) ([]model.Command, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	const columns = `SELECT timestamp, duration, exit, command, cwd, session, hostname FROM history`
+	rows, err := db.QueryContext(ctx, columns+` WHERE deleted_at IS NULL ORDER BY timestamp`)
+	if err != nil {
+		// databases from before atuin 14 have no deleted_at
+		rows, err = db.QueryContext(ctx, columns+` ORDER BY timestamp`)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read atuin history: %w", err)
+	}
+	defer rows.Close()
+
+	shell := filepath.Base(os.Getenv("SHELL"))
+	if shell == "." || shell == "/" {
+		shell = "unknown"
+	}
+	result := make([]model.Command, 0)
+	for rows.Next() {
+		var (
+			timestamp, duration         int64
+			exit                        int
+			command, cwd, session, host string
+		)
+		if err := rows.Scan(&timestamp, &duration, &exit, &command, &cwd, &session, &host); err != nil {
+			return nil, err
+		}
+		if duration < 0 {
+			duration = 0
+		}
+		end := time.Unix(0, timestamp).Add(time.Duration(duration))
+		cmd := model.Command{
+			Shell:     shell,
+			SessionID: SessionID(session),
+			Command:   command,
+			Time:      end,
+			EndTime:   end,
+			Result:    exit,
+			Phase:     model.CommandPhasePost,
+			Duration:  time.Duration(duration),
+			Cwd:       cwd,
+		}
+		cmd.Hostname, cmd.Username, _ = strings.Cut(host, ":")
+		result = append(result, cmd)
+	}
+	return result, rows.Err()
+}
+
+// SessionID maps an atuin session (a UUID) to a stable shelltime session ID,
+// so commands of one atuin session stay together across imports. Like
+// model.NewSessionID, the ID fits in 53 bits to survive a float64.
+func SessionID(session string) int64 {
+	if session == "" {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(session))
+	return int64(h.Sum64() & (1<<53 - 1))
+}
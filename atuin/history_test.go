@@ -0,0 +1,46 @@
+package atuin
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE history (id TEXT, timestamp INTEGER, duration INTEGER, exit INTEGER, command TEXT, cwd TEXT, session TEXT, hostname TEXT, deleted_at INTEGER)`)
+	require.NoError(t, err)
+	start := time.Unix(1700000000, 0)
+	_, err = db.Exec(`INSERT INTO history VALUES ('a', ?, ?, 1, 'cargo build', '/src', 's1', 'box:alice', NULL), ('b', ?, -1, 0, 'rm -rf x', '/', 's1', 'box:alice', 1), ('c', ?, 0, 0, 'ls', '/', 's2', 'box:alice', NULL)`,
+		start.UnixNano(), int64(2*time.Second), start.Add(time.Minute).UnixNano(), start.Add(time.Hour).UnixNano())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	cmds, err := ReadHistory(context.Background(), path)
+	require.NoError(t, err)
+	require.Len(t, cmds, 2)
+	cmd := cmds[0]
+	assert.Equal(t, "cargo build", cmd.Command)
+	assert.Equal(t, 1, cmd.Result)
+	assert.Equal(t, "/src", cmd.Cwd)
+	assert.Equal(t, "box", cmd.Hostname)
+	assert.Equal(t, "alice", cmd.Username)
+	assert.Equal(t, start.Add(2*time.Second), cmd.Time)
+	assert.Equal(t, SessionID("s1"), cmd.SessionID)
+	assert.NotEqual(t, cmd.SessionID, cmds[1].SessionID)
+}
+
+func TestSessionID(t *testing.T) {
+	id := SessionID("0190a1b2c3d4e5f6a7b8c9d0e1f2a3b4")
+	assert.Equal(t, id, SessionID("0190a1b2c3d4e5f6a7b8c9d0e1f2a3b4"))
+	assert.Positive(t, id)
+	assert.Less(t, id, int64(1)<<53)
+	assert.Zero(t, SessionID(""))
+}
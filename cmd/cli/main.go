@@ -88,11 +88,17 @@ func main() {
 		commands.InitCommand,
 		commands.AuthCommand,
 		commands.TrackCommand,
+		commands.SessionCommand,
 		commands.GCCommand,
 		commands.SyncCommand,
 		commands.DaemonCommand,
 		commands.HooksCommand,
 		commands.LsCommand,
+		commands.HistoryCommand,
+		commands.StorageCommand,
+		commands.ImportCommand,
+		commands.ExportCommand,
+		commands.StatsCommand,
 		commands.WebCommand,
 		commands.AliasCommand,
 		commands.DotfilesCommand,
@@ -102,6 +108,7 @@ func main() {
 		commands.CodexCommand,
 		commands.SchemaCommand,
 		commands.GrepCommand,
+		commands.WatchCommand,
 		commands.ConfigCommand,
 		commands.IosCommand,
 		commands.UpdateCommand,
@@ -80,18 +80,21 @@ func main() {
 
 	daemon.Init(daemonConfigService, version)
 	model.InjectVar(version)
+	model.ConfigureStorageEncryption(cfg)
+	daemon.PersistSentRecords()
 	cmdService := model.NewCommandService()
 
 	// When the bolt storage engine is enabled, the daemon owns the bolt-backed
-	// command store for its lifetime (bbolt holds an exclusive file lock).
-	if cfg.Storage != nil && cfg.Storage.Engine == model.StorageEngineBolt {
+	// command store for its lifetime (bbolt holds an exclusive file lock). The
+	// segment store is shared with the CLI, but the daemon keeps one open too.
+	if cfg.Storage != nil && (cfg.Storage.Engine == model.StorageEngineBolt || cfg.Storage.Engine == model.StorageEngineSegment) {
 		store, err := model.NewCommandStore(cfg)
 		if err != nil {
-			slog.Error("Failed to open bolt command store", slog.Any("err", err))
+			slog.Error("Failed to open command store", slog.String("engine", cfg.Storage.Engine), slog.Any("err", err))
 		} else {
 			daemon.InitCommandStore(store)
 			defer store.Close()
-			slog.Info("Bolt command store initialized")
+			slog.Info("Command store initialized", slog.String("engine", store.Engine()))
 		}
 	}
 
@@ -112,73 +115,13 @@ func main() {
 		defer syncCircuitBreakerService.Stop()
 	}
 
-	// Start cleanup timer service if enabled (enabled by default)
-	if cfg.LogCleanup != nil && cfg.LogCleanup.Enabled != nil && *cfg.LogCleanup.Enabled {
-		cleanupTimerService := daemon.NewCleanupTimerService(cfg)
-		if err := cleanupTimerService.Start(ctx); err != nil {
-			slog.Error("Failed to start cleanup timer service", slog.Any("err", err))
-		} else {
-			slog.Info("Cleanup timer service started",
-				slog.Int64("thresholdMB", cfg.LogCleanup.ThresholdMB))
-			defer cleanupTimerService.Stop()
-		}
-	}
-
 	go daemon.SocketTopicProcessor(msg)
 
-	// Start CCUsage service if enabled (v1 - ccusage CLI based)
-	if cfg.CCUsage != nil && cfg.CCUsage.Enabled != nil && *cfg.CCUsage.Enabled {
-		ccUsageService := model.NewCCUsageService(cfg, cmdService)
-		if err := ccUsageService.Start(ctx); err != nil {
-			slog.Error("Failed to start CCUsage service", slog.Any("err", err))
-		} else {
-			slog.Info("CCUsage service started")
-			defer ccUsageService.Stop()
-		}
-	}
-
-	// Start AICodeOtel service if enabled (OTEL gRPC passthrough for Claude Code, Codex, etc.)
-	var aiCodeOtelServer *daemon.AICodeOtelServer
-	if cfg.AICodeOtel != nil && cfg.AICodeOtel.Enabled != nil && *cfg.AICodeOtel.Enabled {
-		aiCodeOtelProcessor := daemon.NewAICodeOtelProcessor(cfg)
-		aiCodeOtelServer = daemon.NewAICodeOtelServer(cfg.AICodeOtel.GRPCPort, aiCodeOtelProcessor)
-		if err := aiCodeOtelServer.Start(); err != nil {
-			slog.Error("Failed to start AICodeOtel gRPC server", slog.Any("err", err))
-		} else {
-			slog.Info("AICodeOtel gRPC server started", slog.Int("port", cfg.AICodeOtel.GRPCPort))
-			defer aiCodeOtelServer.Stop()
-		}
-	}
-
-	// Start heartbeat resync service if codeTracking is enabled
-	if cfg.CodeTracking != nil && cfg.CodeTracking.Enabled != nil && *cfg.CodeTracking.Enabled {
-		heartbeatResyncService := daemon.NewHeartbeatResyncService(cfg)
-		if err := heartbeatResyncService.Start(ctx); err != nil {
-			slog.Error("Failed to start heartbeat resync service", slog.Any("err", err))
-		} else {
-			slog.Info("Heartbeat resync service started")
-			defer heartbeatResyncService.Stop()
-		}
-	}
-
-	codexInstalled, err := daemon.CodexInstallationStatus()
-	if err != nil {
-		if reason, ok := daemon.CodexSyncSkipReason(err); ok {
-			slog.Info("Skipping Codex usage sync service startup", slog.String("reason", reason))
-		} else {
-			slog.Error("Failed to check Codex installation status", slog.Any("err", err))
-		}
-	} else if !codexInstalled {
-		slog.Info("Skipping Codex usage sync service startup", slog.String("reason", "codex_not_configured"))
-	} else {
-		codexUsageSyncService := daemon.NewCodexUsageSyncService(cfg)
-		if err := codexUsageSyncService.Start(ctx); err != nil {
-			slog.Error("Failed to start Codex usage sync service", slog.Any("err", err))
-		} else {
-			slog.Info("Codex usage sync service started")
-			defer codexUsageSyncService.Stop()
-		}
-	}
+	// The optional services follow the config: a reload starts, stops or
+	// restarts them to match it.
+	supervisor := daemon.NewServiceSupervisor(ctx, daemonServices(cmdService)...)
+	supervisor.Apply(cfg, daemon.ReloadTriggerStartup)
+	defer supervisor.Stop()
 
 	// Create processor instance
 	processor := daemon.NewSocketHandler(&cfg, pubsub)
@@ -187,17 +130,135 @@ func main() {
 	if err := processor.Start(); err != nil {
 		slog.Error("Failed to start processor", slog.Any("err", err))
 	}
+	supervisor.OnConfig(processor.SetConfig)
+	supervisor.OnConfig(model.ConfigureStorageEncryption)
+
+	// Reload the config on SIGHUP and when the config files change
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	go supervisor.WatchConfig(watchCtx, configDir, daemon.ConfigPollInterval)
 
 	// Handle shutdown gracefully
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		supervisor.Reload(ctx, daemon.ReloadTriggerSignal)
+	}
 
 	// Cleanup
 	pubsub.Close()
 	processor.Stop()
 }
 
+// daemonServices are the services the config turns on and off.
+func daemonServices(cmdService model.CommandService) []daemon.ManagedService {
+	// checked once, so a reload doesn't log the skip reason again
+	codex := codexInstalled()
+	return []daemon.ManagedService{
+		{
+			// enabled by default
+			Name: "cleanup-timer",
+			Enabled: func(cfg model.ShellTimeConfig) bool {
+				return cfg.LogCleanup != nil && cfg.LogCleanup.Enabled != nil && *cfg.LogCleanup.Enabled
+			},
+			Settings: func(cfg model.ShellTimeConfig) interface{} { return cfg.LogCleanup },
+			Start: func(ctx context.Context, cfg model.ShellTimeConfig) (func(), error) {
+				service := daemon.NewCleanupTimerService(cfg)
+				return service.Stop, service.Start(ctx)
+			},
+		},
+		{
+			// v1 - ccusage CLI based
+			Name: "ccusage",
+			Enabled: func(cfg model.ShellTimeConfig) bool {
+				return cfg.CCUsage != nil && cfg.CCUsage.Enabled != nil && *cfg.CCUsage.Enabled
+			},
+			Settings: func(cfg model.ShellTimeConfig) interface{} {
+				return []interface{}{cfg.Token, cfg.APIEndpoint, cfg.CCUsage}
+			},
+			Start: func(ctx context.Context, cfg model.ShellTimeConfig) (func(), error) {
+				service := model.NewCCUsageService(cfg, cmdService)
+				return service.Stop, service.Start(ctx)
+			},
+		},
+		{
+			// OTEL gRPC passthrough for Claude Code, Codex, etc.
+			Name: "aicode-otel",
+			Enabled: func(cfg model.ShellTimeConfig) bool {
+				return cfg.AICodeOtel != nil && cfg.AICodeOtel.Enabled != nil && *cfg.AICodeOtel.Enabled
+			},
+			Settings: func(cfg model.ShellTimeConfig) interface{} {
+				return []interface{}{cfg.Token, cfg.APIEndpoint, cfg.AICodeOtel}
+			},
+			Start: func(ctx context.Context, cfg model.ShellTimeConfig) (func(), error) {
+				server := daemon.NewAICodeOtelServer(cfg.AICodeOtel.GRPCPort, daemon.NewAICodeOtelProcessor(cfg))
+				if err := server.Start(); err != nil {
+					return nil, err
+				}
+				slog.Info("AICodeOtel gRPC server started", slog.Int("port", cfg.AICodeOtel.GRPCPort))
+				return server.Stop, nil
+			},
+		},
+		{
+			// resends the heartbeats of code tracking that failed
+			Name: "heartbeat-resync",
+			Enabled: func(cfg model.ShellTimeConfig) bool {
+				return cfg.CodeTracking != nil && cfg.CodeTracking.Enabled != nil && *cfg.CodeTracking.Enabled
+			},
+			Settings: func(cfg model.ShellTimeConfig) interface{} {
+				return []interface{}{cfg.Token, cfg.APIEndpoint}
+			},
+			Start: func(ctx context.Context, cfg model.ShellTimeConfig) (func(), error) {
+				service := daemon.NewHeartbeatResyncService(cfg)
+				return service.Stop, service.Start(ctx)
+			},
+		},
+		{
+			// Prometheus metrics on loopback or a unix socket, opt-in
+			Name: "metrics-endpoint",
+			Enabled: func(cfg model.ShellTimeConfig) bool {
+				return cfg.MetricsEndpoint != nil && cfg.MetricsEndpoint.Enabled != nil && *cfg.MetricsEndpoint.Enabled
+			},
+			Settings: func(cfg model.ShellTimeConfig) interface{} { return cfg.MetricsEndpoint },
+			Start: func(ctx context.Context, cfg model.ShellTimeConfig) (func(), error) {
+				server := daemon.NewMetricsServer(cfg.MetricsEndpoint.Listen, cfg.SocketPath)
+				return server.Stop, server.Start()
+			},
+		},
+		{
+			// runs when Codex was installed at startup
+			Name:    "codex-usage-sync",
+			Enabled: func(cfg model.ShellTimeConfig) bool { return codex },
+			Settings: func(cfg model.ShellTimeConfig) interface{} {
+				return []interface{}{cfg.Token, cfg.APIEndpoint}
+			},
+			Start: func(ctx context.Context, cfg model.ShellTimeConfig) (func(), error) {
+				service := daemon.NewCodexUsageSyncService(cfg)
+				return service.Stop, service.Start(ctx)
+			},
+		},
+	}
+}
+
+func codexInstalled() bool {
+	installed, err := daemon.CodexInstallationStatus()
+	if err != nil {
+		if reason, ok := daemon.CodexSyncSkipReason(err); ok {
+			slog.Info("Skipping Codex usage sync service startup", slog.String("reason", reason))
+		} else {
+			slog.Error("Failed to check Codex installation status", slog.Any("err", err))
+		}
+		return false
+	}
+	if !installed {
+		slog.Info("Skipping Codex usage sync service startup", slog.String("reason", "codex_not_configured"))
+	}
+	return installed
+}
+
 func printVersionInfo() {
 	fmt.Printf("shelltime-daemon %s\n", version)
 	fmt.Printf("  Commit:     %s\n", commit)
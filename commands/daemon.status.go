@@ -1,10 +1,9 @@
 package commands
 
 import (
-	"encoding/json"
 	"fmt"
-	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gookit/color"
@@ -69,6 +68,32 @@ func commandDaemonStatus(c *cli.Context) error {
 		fmt.Printf("  Uptime:     %s (since %s)\n", statusResp.Uptime, statusResp.StartedAt.Format("2006-01-02 15:04:05"))
 		fmt.Printf("  Go Version: %s\n", statusResp.GoVersion)
 		fmt.Printf("  Platform:   %s\n", statusResp.Platform)
+		fmt.Printf("  Protocol:   %s\n", formatProtocol(statusResp.Protocol))
+		// daemons before the config reload don't report their services
+		if reload := statusResp.LastReload; reload != nil {
+			services := "none"
+			if len(statusResp.Services) > 0 {
+				services = strings.Join(statusResp.Services, ", ")
+			}
+			fmt.Printf("  Services:   %s\n", services)
+			fmt.Printf("  Config:     %s\n", formatReload(reload))
+			if reload.Error != "" {
+				printWarning(fmt.Sprintf("Last config reload failed, the daemon runs the previous config: %s", reload.Error))
+			}
+			for name, reason := range reload.Failed {
+				printWarning(fmt.Sprintf("Service %s failed to start: %s", name, reason))
+			}
+			if len(reload.NeedsRestart) > 0 {
+				printWarning(fmt.Sprintf("Changed settings need a daemon restart: %s", strings.Join(reload.NeedsRestart, ", ")))
+			}
+		}
+
+		if len(statusResp.Endpoints) > 0 {
+			printSectionHeader("Sync Endpoints")
+			for _, endpoint := range statusResp.Endpoints {
+				fmt.Printf("  %s: %d pending, %d queued for retry (%s)\n", endpoint.APIEndpoint, endpoint.Pending, endpoint.RetryQueued, formatCircuit(endpoint.Circuit))
+			}
+		}
 	}
 
 	// Configuration section
@@ -91,6 +116,12 @@ func commandDaemonStatus(c *cli.Context) error {
 		fmt.Println("  Code Tracking: disabled")
 	}
 
+	if cfg.MetricsEndpoint != nil && cfg.MetricsEndpoint.Enabled != nil && *cfg.MetricsEndpoint.Enabled {
+		fmt.Printf("  Metrics Endpoint: %s\n", formatMetricsEndpoint(cfg.MetricsEndpoint.Listen))
+	} else {
+		fmt.Println("  Metrics Endpoint: disabled")
+	}
+
 	// Overall status
 	fmt.Println()
 	if connected {
@@ -104,6 +135,24 @@ func commandDaemonStatus(c *cli.Context) error {
 	return nil
 }
 
+// formatCircuit describes the circuit breaker of a sync endpoint.
+func formatCircuit(circuit *model.CircuitBreakerStatus) string {
+	if circuit == nil {
+		return "circuit unknown"
+	}
+	switch circuit.State {
+	case model.CircuitOpen:
+		wait := time.Until(circuit.NextRetryAt).Round(time.Second)
+		if wait < 0 {
+			wait = 0
+		}
+		return fmt.Sprintf("circuit open after %d failures, next retry in %s", circuit.ConsecutiveFailures, wait)
+	case model.CircuitHalfOpen:
+		return "circuit half-open, probing"
+	}
+	return "circuit closed"
+}
+
 func checkSocketFileExists(socketPath string) bool {
 	_, err := os.Stat(socketPath)
 	return err == nil
@@ -111,28 +160,61 @@ func checkSocketFileExists(socketPath string) bool {
 
 func requestDaemonStatus(socketPath string, timeout time.Duration) (*daemon.StatusResponse, time.Duration, error) {
 	start := time.Now()
-	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	response, err := daemon.RequestStatus(socketPath, timeout)
 	if err != nil {
 		return nil, 0, err
 	}
-	defer conn.Close()
+	return response, time.Since(start), nil
+}
 
-	// Send status request
-	msg := daemon.SocketMessage{
-		Type: daemon.SocketMessageTypeStatus,
+// formatProtocol describes the socket protocol of the daemon, which predates
+// the versioning when it doesn't report one.
+func formatProtocol(protocol int) string {
+	if protocol == 0 {
+		protocol = 1
 	}
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(msg); err != nil {
-		return nil, 0, err
+	switch {
+	case protocol < daemon.SocketProtocolVersion:
+		return fmt.Sprintf("v%d, older than this CLI (v%d): run 'shelltime daemon reinstall'", protocol, daemon.SocketProtocolVersion)
+	case protocol > daemon.SocketProtocolVersion:
+		return fmt.Sprintf("v%d, newer than this CLI (v%d): update the CLI", protocol, daemon.SocketProtocolVersion)
 	}
+	return fmt.Sprintf("v%d", protocol)
+}
 
-	// Read response
-	var response daemon.StatusResponse
-	decoder := json.NewDecoder(conn)
-	if err := decoder.Decode(&response); err != nil {
-		return nil, 0, err
+// formatReload describes the last time the daemon applied its config.
+func formatReload(reload *daemon.ReloadStatus) string {
+	at := reload.At.Local().Format("2006-01-02 15:04:05")
+	if reload.Error != "" {
+		return fmt.Sprintf("reload failed at %s (%s)", at, reload.Trigger)
+	}
+	s := fmt.Sprintf("loaded at %s (%s)", at, reload.Trigger)
+	if reload.Trigger == daemon.ReloadTriggerStartup {
+		return s
 	}
+	var changes []string
+	for _, c := range []struct {
+		verb  string
+		names []string
+	}{
+		{"started", reload.Started},
+		{"stopped", reload.Stopped},
+		{"restarted", reload.Restarted},
+	} {
+		if len(c.names) > 0 {
+			changes = append(changes, c.verb+" "+strings.Join(c.names, ", "))
+		}
+	}
+	if len(changes) > 0 {
+		s += ": " + strings.Join(changes, "; ")
+	}
+	return s
+}
 
-	latency := time.Since(start)
-	return &response, latency, nil
+// formatMetricsEndpoint describes where the daemon serves its metrics.
+func formatMetricsEndpoint(listen string) string {
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		return "http://unix/metrics on " + path
+	}
+	return "http://" + listen + "/metrics"
 }
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
@@ -162,3 +163,30 @@ func TestCommandDaemonStatus_ConfigReadErrorUsesDefaultSocket(t *testing.T) {
 	err := app.Run([]string{"t", "status"})
 	require.NoError(t, err)
 }
+
+func TestFormatProtocol(t *testing.T) {
+	assert.Equal(t, fmt.Sprintf("v%d", daemon.SocketProtocolVersion), formatProtocol(daemon.SocketProtocolVersion))
+	// a daemon that doesn't report a version predates the versioning
+	assert.Contains(t, formatProtocol(0), "v1, older than this CLI")
+	assert.Contains(t, formatProtocol(daemon.SocketProtocolVersion+1), "update the CLI")
+}
+
+func TestFormatReload(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	assert.Equal(t, "loaded at 2026-01-02 03:04:05 (startup)",
+		formatReload(&daemon.ReloadStatus{At: at, Trigger: daemon.ReloadTriggerStartup, Started: []string{"ccusage"}}))
+	assert.Equal(t, "loaded at 2026-01-02 03:04:05 (SIGHUP): started aicode-otel; stopped ccusage, heartbeat-resync",
+		formatReload(&daemon.ReloadStatus{
+			At:      at,
+			Trigger: daemon.ReloadTriggerSignal,
+			Started: []string{"aicode-otel"},
+			Stopped: []string{"ccusage", "heartbeat-resync"},
+		}))
+	assert.Equal(t, "reload failed at 2026-01-02 03:04:05 (config change)",
+		formatReload(&daemon.ReloadStatus{At: at, Trigger: daemon.ReloadTriggerConfigChange, Error: "bad yaml"}))
+}
+
+func TestFormatMetricsEndpoint(t *testing.T) {
+	assert.Equal(t, "http://127.0.0.1:54028/metrics", formatMetricsEndpoint("127.0.0.1:54028"))
+	assert.Equal(t, "http://unix/metrics on /tmp/metrics.sock", formatMetricsEndpoint("unix:/tmp/metrics.sock"))
+}
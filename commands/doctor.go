@@ -119,6 +119,9 @@ func commandDoctor(c *cli.Context) error {
 		zshHookService,
 		fishHookService,
 		bashHookService,
+		model.NewNushellHookService(),
+		model.NewXonshHookService(),
+		model.NewElvishHookService(),
 	}
 
 	for _, hookService := range hookServices {
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ExportCommand *cli.Command = &cli.Command{
+	Name:  "export",
+	Usage: "export your command history as a shell history file, JSON lines or CSV",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Value:   model.HistoryFormatJSONL,
+			Usage:   "output format: zsh, bash, fish, jsonl or csv",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "file to write to (default: stdout)",
+		},
+		&cli.DurationFlag{
+			Name:  "since",
+			Usage: "only export commands from this long ago, e.g. 720h",
+		},
+		&cli.BoolFlag{
+			Name:  "server",
+			Usage: "also export the commands synced to the server",
+		},
+	},
+	Action: commandExport,
+}
+
+// exportPageSize is the number of commands fetched per server request.
+const exportPageSize = 500
+
+func commandExport(c *cli.Context) error {
+	ctx, span := commandTracer.Start(c.Context, "export", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	format := c.String("format")
+	switch format {
+	case model.HistoryFormatZsh, model.HistoryFormatBash, model.HistoryFormatFish, model.HistoryFormatJSONL, model.HistoryFormatCSV:
+	default:
+		return fmt.Errorf("unsupported format: %s. Use zsh, bash, fish, jsonl or csv", format)
+	}
+
+	cfg, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	query := model.ArchiveQuery{}
+	if since := c.Duration("since"); since > 0 {
+		query.Since = time.Now().Add(-since)
+	}
+	local, err := loadLocalCommands(ctx, cfg, query)
+	if err != nil {
+		return err
+	}
+	commands := make([]model.ListedCommand, 0, len(local))
+	for _, cmd := range local {
+		if query.Matches(cmd) {
+			commands = append(commands, cmd)
+		}
+	}
+
+	if c.Bool("server") {
+		if cfg.Token == "" {
+			return fmt.Errorf("not authenticated. Please run 'shelltime auth' first")
+		}
+		remote, err := fetchServerCommands(ctx, cfg, query.Since)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, remote...)
+	}
+
+	commands = dedupeExportCommands(commands)
+
+	var w io.Writer = os.Stdout
+	if output := c.String("output"); output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	return model.WriteHistory(w, format, commands)
+}
+
+// fetchServerCommands pages through the commands on the server, newest
+// first, until it reaches since or runs out.
+func fetchServerCommands(ctx context.Context, cfg model.ShellTimeConfig, since time.Time) ([]model.ListedCommand, error) {
+	endpoint := model.Endpoint{APIEndpoint: cfg.APIEndpoint, Token: cfg.Token}
+	filter := &model.SearchCommandsFilter{
+		Shell:       []string{},
+		MainCommand: []string{},
+		Hostname:    []string{},
+		Username:    []string{},
+		IP:          []string{},
+		Result:      []int{},
+		Time:        []float64{},
+		SessionID:   []float64{},
+	}
+
+	result := make([]model.ListedCommand, 0)
+	pagination := &model.SearchCommandsPagination{Limit: exportPageSize}
+	for {
+		page, err := model.FetchCommandsFromServer(ctx, endpoint, filter, pagination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch commands from server: %w", err)
+		}
+		done := len(page.Edges) == 0
+		for _, edge := range page.Edges {
+			command := edge.Command
+			if edge.IsEncrypted && edge.OriginalCommand != "" {
+				command = edge.OriginalCommand
+			}
+			start := time.UnixMilli(int64(edge.Time))
+			if !since.IsZero() && start.Before(since) {
+				done = true
+				continue
+			}
+			result = append(result, model.ListedCommand{
+				Command:   command,
+				Shell:     edge.Shell,
+				StartTime: start,
+				EndTime:   time.UnixMilli(int64(edge.EndTime)),
+				Result:    edge.Result,
+				Username:  edge.Username,
+				Hostname:  edge.Hostname,
+			})
+		}
+		last := page.Edges
+		if done || len(last) < exportPageSize || last[len(last)-1].ID == pagination.LastID {
+			break
+		}
+		pagination.LastID = last[len(last)-1].ID
+		slog.Debug("fetching next export page", slog.Int("lastId", pagination.LastID))
+	}
+	return result, nil
+}
+
+// dedupeExportCommands sorts the commands oldest first and drops the ones
+// present both locally and on the server.
+func dedupeExportCommands(commands []model.ListedCommand) []model.ListedCommand {
+	sort.SliceStable(commands, func(i, j int) bool { return commands[i].StartTime.Before(commands[j].StartTime) })
+	seen := make(map[string]bool, len(commands))
+	result := commands[:0]
+	for _, cmd := range commands {
+		key := fmt.Sprintf("%d\x00%s", cmd.StartTime.Unix(), cmd.Command)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, cmd)
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestExportCommand_LocalToZshFile(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{SocketPath: filepath.Join(home, "missing.sock")}, nil)
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	cmd := model.Command{Shell: "zsh", SessionID: 1, Command: "go test ./...", Username: "u", Hostname: "h", Time: start}
+	require.NoError(t, store.SavePre(ctx, cmd, start))
+	end := cmd
+	end.Time = start.Add(4 * time.Second)
+	require.NoError(t, store.SavePost(ctx, end, 0, end.Time))
+
+	output := filepath.Join(home, "out_history")
+	app := &cli.App{Name: "t", Commands: []*cli.Command{ExportCommand}}
+	require.NoError(t, app.Run([]string{"t", "export", "-f", "zsh", "-o", output}))
+
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	history, err := model.ParseZshHistory(f)
+	require.NoError(t, err)
+	require.Len(t, history.Commands, 1)
+	assert.Equal(t, "go test ./...", history.Commands[0].Command)
+	assert.Equal(t, 4*time.Second, history.Commands[0].Duration)
+}
+
+func TestExportCommand_InvalidFormat(t *testing.T) {
+	setupGrepActionTest(t)
+	app := &cli.App{Name: "t", Commands: []*cli.Command{ExportCommand}}
+	err := app.Run([]string{"t", "export", "-f", "xml"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported format")
+}
+
+func TestDedupeExportCommands(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	commands := dedupeExportCommands([]model.ListedCommand{
+		{Command: "b", StartTime: start.Add(time.Second)},
+		{Command: "a", StartTime: start},
+		{Command: "a", StartTime: start.Add(300 * time.Millisecond)},
+	})
+	require.Len(t, commands, 2)
+	assert.Equal(t, "a", commands[0].Command)
+}
@@ -3,10 +3,12 @@ package commands
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"sort"
+	"time"
 
 	"github.com/malamtime/cli/model"
 	"github.com/urfave/cli/v2"
@@ -32,18 +34,19 @@ var GCCommand *cli.Command = &cli.Command{
 	Action: commandGC,
 }
 
-// backupAndWriteFile backs up the existing file and writes new content.
+// backupAndWriteFile copies the existing file to .bak and replaces it with
+// content through a rename, so a crash never leaves it truncated.
 func backupAndWriteFile(filePath string, content []byte) error {
 	backupFile := filePath + ".bak"
 
-	if _, err := os.Stat(filePath); err == nil {
-		if err := os.Rename(filePath, backupFile); err != nil {
+	if old, err := os.ReadFile(filePath); err == nil {
+		if err := os.WriteFile(backupFile, old, 0600); err != nil {
 			slog.Warn("failed to backup file", slog.String("file", filePath), slog.Any("err", err))
 			return fmt.Errorf("failed to backup file %s: %w", filePath, err)
 		}
 	}
 
-	if err := os.WriteFile(filePath, content, 0644); err != nil {
+	if err := model.ReplaceFile(filePath, content); err != nil {
 		slog.Warn("failed to write file", slog.String("file", filePath), slog.Any("err", err))
 		return fmt.Errorf("failed to write file %s: %w", filePath, err)
 	}
@@ -51,17 +54,73 @@ func backupAndWriteFile(filePath string, content []byte) error {
 	return nil
 }
 
+// cleanSessionEventFile drops the session events that were synced along with
+// the commands before the cursor. The caller holds model.LockCommandStorage.
+func cleanSessionEventFile(ctx context.Context, lastCursor time.Time) error {
+	events, err := model.NewFileStore().GetSessionEvents(ctx)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+
+	content := bytes.Buffer{}
+	for _, ev := range events {
+		if !ev.RecordingTime.After(lastCursor) {
+			continue
+		}
+		line, err := ev.ToLine(ev.RecordingTime)
+		if err != nil {
+			return fmt.Errorf("failed to convert session event to line: %w", err)
+		}
+		content.Write(line)
+	}
+	return backupAndWriteFile(model.GetSessionEventFilePath(), content.Bytes())
+}
+
+// pruneSegmentStore archives and drops the synced segments of the segment
+// engine.
+func pruneSegmentStore(ctx context.Context, cfg model.ShellTimeConfig) error {
+	store := model.NewLocalStore(cfg)
+	defer store.Close()
+
+	lastCursor, noCursor, err := store.GetLastCursor(ctx)
+	if err != nil || noCursor {
+		return err
+	}
+	if err := model.ArchiveSynced(ctx, cfg, store, lastCursor); err != nil {
+		return fmt.Errorf("failed to archive synced commands: %w", err)
+	}
+	return store.Prune(ctx, lastCursor)
+}
+
 // cleanCommandFiles cleans up the command storage files based on the cursor position.
-func cleanCommandFiles(ctx context.Context) error {
+func cleanCommandFiles(ctx context.Context, cfg model.ShellTimeConfig) error {
 	commandsFolder := model.GetCommandsStoragePath()
 	if _, err := os.Stat(commandsFolder); os.IsNotExist(err) {
 		return nil
 	}
 
-	lastCursor, _, err := model.GetLastCursor(ctx)
+	lastCursor, noCursor, err := model.GetLastCursor(ctx)
+	if err != nil {
+		return err
+	}
+
+	// keep a local copy of everything about to be dropped
+	if !noCursor {
+		if err := model.ArchiveSynced(ctx, cfg, model.NewFileStore(), lastCursor); err != nil {
+			return fmt.Errorf("failed to archive synced commands: %w", err)
+		}
+	}
+
+	// the hooks of other shells wait while the files are rewritten
+	unlock, err := model.LockCommandStorage()
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	if err := cleanSessionEventFile(ctx, lastCursor); err != nil {
+		return err
+	}
 
 	postCommandsRaw, postCount, err := model.GetPostCommands(ctx)
 	if err != nil {
@@ -73,6 +132,9 @@ func cleanCommandFiles(ctx context.Context) error {
 		cmd := new(model.Command)
 		_, err := cmd.FromLineBytes(raw)
 		if err != nil {
+			if errors.Is(err, model.ErrStorageKeyUnavailable) {
+				return err
+			}
 			slog.Warn("failed to parse command from line", slog.Any("err", err))
 			continue
 		}
@@ -209,9 +271,21 @@ func commandGC(c *cli.Context) error {
 
 	// Clean command files. In bolt mode the daemon prunes synced commands from
 	// the DB after each sync, and the txt files are only fallback leftovers, so
-	// skip the txt compaction (post.txt may not even exist).
-	if cfg.Storage == nil || cfg.Storage.Engine != model.StorageEngineBolt {
-		if err := cleanCommandFiles(ctx); err != nil {
+	// skip the txt compaction (post.txt may not even exist). The segment engine
+	// drops whole synced segments instead of rewriting files.
+	engine := model.StorageEngineFile
+	if cfg.Storage != nil && cfg.Storage.Engine != "" {
+		engine = cfg.Storage.Engine
+	}
+	model.ConfigureStorageEncryption(cfg)
+	switch engine {
+	case model.StorageEngineBolt:
+	case model.StorageEngineSegment:
+		if err := pruneSegmentStore(ctx, cfg); err != nil {
+			return err
+		}
+	default:
+		if err := cleanCommandFiles(ctx, cfg); err != nil {
 			return err
 		}
 	}
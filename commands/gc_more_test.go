@@ -79,7 +79,7 @@ func TestC2CleanCommandFiles_KeepsUnmatchedPre(t *testing.T) {
 	// Cursor sits between the orphan pre and the live pair.
 	require.NoError(t, store.SetCursor(ctx, base.Add(30*time.Minute)))
 
-	require.NoError(t, cleanCommandFiles(ctx))
+	require.NoError(t, cleanCommandFiles(ctx, model.ShellTimeConfig{}))
 
 	// The rewritten pre.txt must still contain the orphan command.
 	preData, err := os.ReadFile(filepath.Join(cmdDir, "pre.txt"))
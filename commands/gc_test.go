@@ -76,7 +76,7 @@ func TestBackupAndWriteFile_BacksUpExisting(t *testing.T) {
 func TestCleanCommandFiles_NoCommandsFolder(t *testing.T) {
 	setupGCTest(t)
 	// commands folder absent -> returns nil immediately.
-	require.NoError(t, cleanCommandFiles(context.Background()))
+	require.NoError(t, cleanCommandFiles(context.Background(), model.ShellTimeConfig{}))
 }
 
 func TestCleanCommandFiles_NoPostCommands(t *testing.T) {
@@ -85,7 +85,7 @@ func TestCleanCommandFiles_NoPostCommands(t *testing.T) {
 	require.NoError(t, os.MkdirAll(cmdDir, 0755))
 	require.NoError(t, os.WriteFile(filepath.Join(cmdDir, "post.txt"), []byte(""), 0644))
 
-	require.NoError(t, cleanCommandFiles(context.Background()))
+	require.NoError(t, cleanCommandFiles(context.Background(), model.ShellTimeConfig{}))
 }
 
 func TestCleanCommandFiles_CompactsSyncedCommands(t *testing.T) {
@@ -107,7 +107,7 @@ func TestCleanCommandFiles_CompactsSyncedCommands(t *testing.T) {
 	// Set cursor to AFTER the post so it counts as synced.
 	require.NoError(t, store.SetCursor(ctx, base.Add(2*time.Second)))
 
-	require.NoError(t, cleanCommandFiles(ctx))
+	require.NoError(t, cleanCommandFiles(ctx, model.ShellTimeConfig{}))
 
 	// Backups of all three files should exist after compaction.
 	for _, name := range []string{"pre.txt.bak", "post.txt.bak", "cursor.txt.bak"} {
@@ -187,3 +187,96 @@ func TestCommandGC_WithLogForceCleansLogFile(t *testing.T) {
 	_, statErr := os.Stat(logPath)
 	assert.True(t, os.IsNotExist(statErr), "log.log should be removed by --withLog")
 }
+
+func TestCleanCommandFiles_ArchivesSyncedCommands(t *testing.T) {
+	_, cmdDir, _ := setupGCTest(t)
+	require.NoError(t, os.MkdirAll(cmdDir, 0755))
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+
+	base := time.Now().Add(-time.Hour)
+	synced := model.Command{Shell: "bash", SessionID: 1, Command: "git status", Username: "u", Hostname: "h", Time: base}
+	require.NoError(t, store.SavePre(ctx, synced, base))
+	post := synced
+	post.Time = base.Add(time.Second)
+	require.NoError(t, store.SavePost(ctx, post, 0, post.Time))
+	require.NoError(t, store.SetCursor(ctx, base.Add(2*time.Second)))
+
+	enabled := true
+	cfg := model.ShellTimeConfig{Storage: &model.StorageConfig{Archive: &model.ArchiveConfig{Enabled: &enabled}}}
+	require.NoError(t, cleanCommandFiles(ctx, cfg))
+
+	posts, err := store.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+
+	archived, err := model.ListArchived(ctx, store, model.ArchiveQuery{})
+	require.NoError(t, err)
+	require.Len(t, archived, 1)
+	assert.Equal(t, "git status", archived[0].Command)
+}
+
+func TestPruneSegmentStore_ArchivesAndDropsSyncedSegments(t *testing.T) {
+	setupGCTest(t)
+
+	ctx := context.Background()
+	enabled := true
+	cfg := model.ShellTimeConfig{Storage: &model.StorageConfig{
+		Engine:  model.StorageEngineSegment,
+		Archive: &model.ArchiveConfig{Enabled: &enabled},
+	}}
+	store := model.NewLocalStore(cfg)
+
+	base := time.Now().AddDate(0, 0, -3)
+	cmd := model.Command{Shell: "bash", SessionID: 1, Command: "git status", Username: "u", Hostname: "h", Time: base}
+	require.NoError(t, store.SavePre(ctx, cmd, base))
+	require.NoError(t, store.SavePost(ctx, cmd, 0, base.Add(time.Second)))
+
+	// nothing happens before the first sync
+	require.NoError(t, pruneSegmentStore(ctx, cfg))
+	posts, err := store.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+
+	require.NoError(t, store.SetCursor(ctx, base.Add(time.Minute)))
+	require.NoError(t, pruneSegmentStore(ctx, cfg))
+
+	posts, err = store.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+
+	archived, err := model.ListArchived(ctx, store, model.ArchiveQuery{})
+	require.NoError(t, err)
+	require.Len(t, archived, 1)
+	assert.Equal(t, "git status", archived[0].Command)
+}
+
+func TestCleanCommandFiles_WaitsForLock(t *testing.T) {
+	_, cmdDir, _ := setupGCTest(t)
+	require.NoError(t, os.MkdirAll(cmdDir, 0755))
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+	base := time.Now().Add(-time.Hour)
+	synced := model.Command{Shell: "bash", SessionID: 1, Command: "git status", Username: "u", Hostname: "h", Time: base}
+	require.NoError(t, store.SavePre(ctx, synced, base))
+	require.NoError(t, store.SavePost(ctx, synced, 0, base.Add(time.Second)))
+	require.NoError(t, store.SetCursor(ctx, base.Add(2*time.Second)))
+
+	unlock, err := model.LockCommandStorage()
+	require.NoError(t, err)
+	cleaned := make(chan error, 1)
+	go func() { cleaned <- cleanCommandFiles(ctx, model.ShellTimeConfig{}) }()
+	select {
+	case <-cleaned:
+		t.Fatal("gc didn't wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	require.NoError(t, <-cleaned)
+
+	posts, err := store.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -19,7 +20,7 @@ import (
 var GrepCommand *cli.Command = &cli.Command{
 	Name:      "rg",
 	Aliases:   []string{"grep"},
-	Usage:     "Search server-synced commands",
+	Usage:     "Search server-synced commands, or local ones with --local",
 	ArgsUsage: "<search-text>",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
@@ -73,6 +74,18 @@ var GrepCommand *cli.Command = &cli.Command{
 			Name:  "until",
 			Usage: "filter commands until date (2024, 2024-01, or 2024-01-15)",
 		},
+		&cli.BoolFlag{
+			Name:  "local",
+			Usage: "search commands stored on this machine instead of the server (works offline)",
+		},
+		&cli.BoolFlag{
+			Name:  "regex",
+			Usage: "treat the search text as a regular expression (with --local)",
+		},
+		&cli.BoolFlag{
+			Name:  "fuzzy",
+			Usage: "fuzzy-match the search text and rank by match quality (with --local)",
+		},
 	},
 	Action: commandGrep,
 	OnUsageError: func(cCtx *cli.Context, err error, isSubcommand bool) error {
@@ -109,6 +122,13 @@ func commandGrep(c *cli.Context) error {
 		return fmt.Errorf("failed to read config: %w", err)
 	}
 
+	if c.Bool("local") {
+		return commandGrepLocal(ctx, c, cfg, searchText, format)
+	}
+	if c.Bool("regex") || c.Bool("fuzzy") {
+		return fmt.Errorf("--regex and --fuzzy require --local")
+	}
+
 	if cfg.Token == "" {
 		return fmt.Errorf("not authenticated. Please run 'shelltime auth' first")
 	}
@@ -155,6 +175,7 @@ func commandGrep(c *cli.Context) error {
 			fmt.Println(string(jsonData))
 		} else {
 			color.Red.Printf("Error: %s\n", err.Error())
+			color.Gray.Println("Use --local to search the commands stored on this machine")
 		}
 		return nil
 	}
@@ -175,6 +196,74 @@ func commandGrep(c *cli.Context) error {
 	return outputGrepTable(result.Edges, result.Count, c.Int("limit"))
 }
 
+// commandGrepLocal searches the buffered and archived commands on this
+// machine. It needs neither a token nor network access.
+func commandGrepLocal(ctx context.Context, c *cli.Context, cfg model.ShellTimeConfig, searchText, format string) error {
+	if c.Bool("regex") && c.Bool("fuzzy") {
+		return fmt.Errorf("--regex and --fuzzy can't be combined")
+	}
+
+	filter, err := buildGrepFilter(c, searchText)
+	if err != nil {
+		return err
+	}
+	opts := model.LocalSearchOptions{Mode: model.LocalSearchSubstring}
+	if c.Bool("regex") {
+		opts.Mode = model.LocalSearchRegex
+	} else if c.Bool("fuzzy") {
+		opts.Mode = model.LocalSearchFuzzy
+	}
+	opts.Since, opts.Until, err = grepTimeRange(c)
+	if err != nil {
+		return err
+	}
+
+	commands, err := loadLocalCommands(ctx, cfg, model.ArchiveQuery{Since: opts.Since})
+	if err != nil {
+		return err
+	}
+
+	pagination := &model.SearchCommandsPagination{
+		LastID: c.Int("last-id"),
+		Limit:  c.Int("limit"),
+	}
+	result, err := model.SearchLocalCommands(commands, filter, pagination, opts)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("local grep result",
+		slog.Int("searched", len(commands)),
+		slog.Int("count", result.Count))
+
+	if len(result.Edges) == 0 {
+		color.Yellow.Println("No local commands found matching your search")
+		return nil
+	}
+
+	if format == "json" {
+		return outputGrepJSON(result.Edges, result.Count)
+	}
+	return outputGrepTable(result.Edges, result.Count, c.Int("limit"))
+}
+
+// grepTimeRange parses the --since / --until flags. Unset bounds are zero.
+func grepTimeRange(c *cli.Context) (since, until time.Time, err error) {
+	if s := c.String("since"); s != "" {
+		since, err = parseFlexibleDate(s, false)
+		if err != nil {
+			return since, until, fmt.Errorf("invalid --since date: %w", err)
+		}
+	}
+	if u := c.String("until"); u != "" {
+		until, err = parseFlexibleDate(u, true)
+		if err != nil {
+			return since, until, fmt.Errorf("invalid --until date: %w", err)
+		}
+	}
+	return since, until, nil
+}
+
 func buildGrepFilter(c *cli.Context, searchText string) (*model.SearchCommandsFilter, error) {
 	filter := &model.SearchCommandsFilter{
 		Shell:       []string{},
@@ -210,20 +299,16 @@ func buildGrepFilter(c *cli.Context, searchText string) (*model.SearchCommandsFi
 	}
 
 	// Handle time filters with flexible date parsing
+	since, until, err := grepTimeRange(c)
+	if err != nil {
+		return nil, err
+	}
 	var timeFilters []float64
-	if since := c.String("since"); since != "" {
-		t, err := parseFlexibleDate(since, false)
-		if err != nil {
-			return nil, fmt.Errorf("invalid --since date: %w", err)
-		}
-		timeFilters = append(timeFilters, float64(t.UnixMilli()))
+	if !since.IsZero() {
+		timeFilters = append(timeFilters, float64(since.UnixMilli()))
 	}
-	if until := c.String("until"); until != "" {
-		t, err := parseFlexibleDate(until, true)
-		if err != nil {
-			return nil, fmt.Errorf("invalid --until date: %w", err)
-		}
-		timeFilters = append(timeFilters, float64(t.UnixMilli()))
+	if !until.IsZero() {
+		timeFilters = append(timeFilters, float64(until.UnixMilli()))
 	}
 	if len(timeFilters) > 0 {
 		filter.Time = timeFilters
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"io"
@@ -364,3 +365,45 @@ func TestCommandGrep_ServerErrorJSONFormat(t *testing.T) {
 	err := app.Run([]string{"t", "rg", "-f", "json", "git"})
 	require.NoError(t, err)
 }
+
+// --- commandGrep --local ------------------------------------------------------
+
+func TestCommandGrep_LocalWithoutToken(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	t.Setenv("HOME", t.TempDir())
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{}, nil)
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+	base := time.Now().Add(-time.Minute)
+	for i, command := range []string{"git status", "sudo git commit -m wip", "ls -la"} {
+		cmd := model.Command{Shell: "zsh", SessionID: 1, Command: command, Username: "u", Hostname: "h", Time: base.Add(time.Duration(i) * time.Second)}
+		require.NoError(t, store.SavePre(ctx, cmd, cmd.Time))
+		require.NoError(t, store.SavePost(ctx, cmd, i, cmd.Time))
+	}
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{GrepCommand}}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "rg", "--local", "-f", "json", "-m", "git", "GIT"}))
+	})
+
+	var parsed struct {
+		TotalCount int                       `json:"totalCount"`
+		Commands   []model.SearchCommandEdge `json:"commands"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &parsed))
+	assert.Equal(t, 2, parsed.TotalCount)
+	require.Len(t, parsed.Commands, 2)
+	// newest first
+	assert.Equal(t, "sudo git commit -m wip", parsed.Commands[0].Command)
+	assert.Equal(t, "git commit", parsed.Commands[0].MainCommand)
+}
+
+func TestCommandGrep_RegexRequiresLocal(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{Token: "tok"}, nil)
+	app := &cli.App{Name: "t", Commands: []*cli.Command{GrepCommand}}
+	err := app.Run([]string{"t", "rg", "--regex", "^git"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "require --local")
+}
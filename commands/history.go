@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var HistoryCommand *cli.Command = &cli.Command{
+	Name:  "history",
+	Usage: "browse your command history",
+	Subcommands: []*cli.Command{
+		HistoryPickCommand,
+	},
+}
+
+var HistoryPickCommand *cli.Command = &cli.Command{
+	Name:  "pick",
+	Usage: "fuzzy find a command in your history and print it (bound to a key by `shelltime hooks install`)",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "query",
+			Aliases: []string{"q"},
+			Usage:   "initial query, e.g. the current line buffer",
+		},
+		&cli.BoolFlag{
+			Name:  "server",
+			Usage: "also search the commands synced to the server",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Value: 500,
+			Usage: "maximum number of commands fetched from the server",
+		},
+	},
+	Action: commandHistoryPick,
+}
+
+// pickHistory runs the interactive picker. Tests replace it.
+var pickHistory = runHistoryPicker
+
+func commandHistoryPick(c *cli.Context) error {
+	ctx, span := commandTracer.Start(c.Context, "history.pick", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	cfg, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	items, err := loadHistoryItems(ctx, cfg, c.Bool("server"), c.Int("limit"))
+	if err != nil {
+		return err
+	}
+
+	selected, err := pickHistory(items, c.String("query"))
+	if err != nil {
+		return err
+	}
+	// the shell widgets capture stdout; nothing is printed on cancel so the
+	// line buffer stays as it was
+	if selected != "" {
+		fmt.Println(selected)
+	}
+	return nil
+}
+
+// loadHistoryItems collects the local commands and, if asked, the ones on the
+// server, newest first with repeated commands collapsed to their newest run.
+// A failing server is logged and the local history still shown.
+func loadHistoryItems(ctx context.Context, cfg model.ShellTimeConfig, withServer bool, serverLimit int) ([]historyItem, error) {
+	local, err := loadLocalCommands(ctx, cfg, model.ArchiveQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]historyItem, 0, len(local))
+	for _, cmd := range local {
+		items = append(items, historyItem{
+			Command:  cmd.Command,
+			Result:   cmd.Result,
+			Duration: cmd.EndTime.Sub(cmd.StartTime),
+			Hostname: cmd.Hostname,
+			Time:     cmd.EndTime,
+		})
+	}
+
+	if withServer && cfg.Token != "" {
+		endpoint := model.Endpoint{APIEndpoint: cfg.APIEndpoint, Token: cfg.Token}
+		filter := &model.SearchCommandsFilter{
+			Shell:       []string{},
+			MainCommand: []string{},
+			Hostname:    []string{},
+			Username:    []string{},
+			IP:          []string{},
+			Result:      []int{},
+			Time:        []float64{},
+			SessionID:   []float64{},
+		}
+		result, err := model.FetchCommandsFromServer(ctx, endpoint, filter, &model.SearchCommandsPagination{Limit: serverLimit})
+		if err != nil {
+			slog.Warn("failed to fetch history from server", slog.Any("err", err))
+		} else {
+			for _, edge := range result.Edges {
+				command := edge.Command
+				if edge.IsEncrypted && edge.OriginalCommand != "" {
+					command = edge.OriginalCommand
+				}
+				items = append(items, historyItem{
+					Command:  command,
+					Result:   edge.Result,
+					Duration: time.Duration(edge.EndTime-edge.Time) * time.Millisecond,
+					Hostname: edge.Hostname,
+					Time:     time.UnixMilli(int64(edge.EndTime)),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Time.After(items[j].Time) })
+
+	seen := make(map[string]bool, len(items))
+	deduped := items[:0]
+	for _, item := range items {
+		if item.Command == "" || seen[item.Command] {
+			continue
+		}
+		seen[item.Command] = true
+		deduped = append(deduped, item)
+	}
+	return deduped, nil
+}
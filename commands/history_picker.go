@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/malamtime/cli/model"
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+// historyItem is one row of the history picker.
+type historyItem struct {
+	Command  string
+	Result   int
+	Duration time.Duration
+	Hostname string
+	Time     time.Time
+}
+
+type pickerKeyKind int
+
+const (
+	pickerKeyRune pickerKeyKind = iota
+	pickerKeyUp
+	pickerKeyDown
+	pickerKeyPageUp
+	pickerKeyPageDown
+	pickerKeyEnter
+	pickerKeyCancel
+	pickerKeyBackspace
+	pickerKeyClear
+	pickerKeyDeleteWord
+)
+
+type pickerKey struct {
+	kind pickerKeyKind
+	r    rune
+}
+
+// decodePickerKeys turns raw terminal input into picker keys. A lone ESC
+// (not the start of an arrow key sequence) cancels, like in fzf.
+func decodePickerKeys(buf []byte) []pickerKey {
+	var keys []pickerKey
+	for len(buf) > 0 {
+		b := buf[0]
+		switch {
+		case b == 0x1b:
+			if len(buf) >= 3 && (buf[1] == '[' || buf[1] == 'O') {
+				switch buf[2] {
+				case 'A':
+					keys = append(keys, pickerKey{kind: pickerKeyUp})
+				case 'B':
+					keys = append(keys, pickerKey{kind: pickerKeyDown})
+				case '5', '6':
+					if len(buf) >= 4 && buf[3] == '~' {
+						kind := pickerKeyPageUp
+						if buf[2] == '6' {
+							kind = pickerKeyPageDown
+						}
+						keys = append(keys, pickerKey{kind: kind})
+						buf = buf[4:]
+						continue
+					}
+				}
+				buf = buf[3:]
+				continue
+			}
+			if len(buf) == 1 {
+				keys = append(keys, pickerKey{kind: pickerKeyCancel})
+			}
+			// alt-modified keys are ignored
+			buf = buf[min(2, len(buf)):]
+			continue
+		case b == '\r' || b == '\n':
+			keys = append(keys, pickerKey{kind: pickerKeyEnter})
+		case b == 0x03 || b == 0x07 || b == 0x04: // ^C ^G ^D
+			keys = append(keys, pickerKey{kind: pickerKeyCancel})
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, pickerKey{kind: pickerKeyBackspace})
+		case b == 0x15: // ^U
+			keys = append(keys, pickerKey{kind: pickerKeyClear})
+		case b == 0x17: // ^W
+			keys = append(keys, pickerKey{kind: pickerKeyDeleteWord})
+		case b == 0x10: // ^P
+			keys = append(keys, pickerKey{kind: pickerKeyUp})
+		case b == 0x0e || b == 0x12: // ^N, and ^R again walks further back
+			keys = append(keys, pickerKey{kind: pickerKeyDown})
+		case b < 0x20:
+			// other control characters are ignored
+		default:
+			r, size := utf8.DecodeRune(buf)
+			if r != utf8.RuneError {
+				keys = append(keys, pickerKey{kind: pickerKeyRune, r: r})
+			}
+			buf = buf[size:]
+			continue
+		}
+		buf = buf[1:]
+	}
+	return keys
+}
+
+// historyPicker is the state of the fuzzy finder: the query, the matching
+// items (best first) and the selected row.
+type historyPicker struct {
+	items    []historyItem
+	query    []rune
+	matches  []int
+	selected int
+	offset   int
+	// pageSize is the number of visible rows, updated on every render.
+	pageSize int
+}
+
+func newHistoryPicker(items []historyItem, query string) *historyPicker {
+	p := &historyPicker{items: items, query: []rune(query), pageSize: 10}
+	p.filter()
+	return p
+}
+
+func (p *historyPicker) filter() {
+	q := string(p.query)
+	type scored struct {
+		idx   int
+		score int
+	}
+	matched := make([]scored, 0, len(p.items))
+	for i, item := range p.items {
+		score, ok := model.FuzzyScore(item.Command, q)
+		if ok {
+			matched = append(matched, scored{idx: i, score: score})
+		}
+	}
+	// items are newest first, so a stable sort keeps recency among equals
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].score > matched[j].score })
+
+	p.matches = p.matches[:0]
+	for _, m := range matched {
+		p.matches = append(p.matches, m.idx)
+	}
+	p.selected = 0
+	p.offset = 0
+}
+
+// handleKey applies a key. done is true once the user accepted or cancelled;
+// the accepted command is returned, or "" on cancel.
+func (p *historyPicker) handleKey(k pickerKey) (selected string, done bool) {
+	switch k.kind {
+	case pickerKeyRune:
+		p.query = append(p.query, k.r)
+		p.filter()
+	case pickerKeyBackspace:
+		if len(p.query) > 0 {
+			p.query = p.query[:len(p.query)-1]
+			p.filter()
+		}
+	case pickerKeyClear:
+		p.query = p.query[:0]
+		p.filter()
+	case pickerKeyDeleteWord:
+		q := strings.TrimRight(string(p.query), " ")
+		if i := strings.LastIndex(q, " "); i >= 0 {
+			q = q[:i+1]
+		} else {
+			q = ""
+		}
+		p.query = []rune(q)
+		p.filter()
+	case pickerKeyUp:
+		p.move(-1)
+	case pickerKeyDown:
+		p.move(1)
+	case pickerKeyPageUp:
+		p.move(-p.pageSize)
+	case pickerKeyPageDown:
+		p.move(p.pageSize)
+	case pickerKeyEnter:
+		if len(p.matches) == 0 {
+			return "", true
+		}
+		return p.items[p.matches[p.selected]].Command, true
+	case pickerKeyCancel:
+		return "", true
+	}
+	return "", false
+}
+
+func (p *historyPicker) move(delta int) {
+	if len(p.matches) == 0 {
+		return
+	}
+	p.selected = min(max(p.selected+delta, 0), len(p.matches)-1)
+}
+
+// render draws the picker: the query line, a match counter and one row per
+// visible match with exit code, duration and host.
+func (p *historyPicker) render(w io.Writer, width, height int) {
+	p.pageSize = max(height-2, 1)
+	if p.selected < p.offset {
+		p.offset = p.selected
+	}
+	if p.selected >= p.offset+p.pageSize {
+		p.offset = p.selected - p.pageSize + 1
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "\x1b[1m>\x1b[0m %s\r\n", string(p.query))
+	fmt.Fprintf(&b, "\x1b[2m  %d/%d\x1b[0m\r\n", len(p.matches), len(p.items))
+
+	for row := 0; row < p.pageSize && p.offset+row < len(p.matches); row++ {
+		i := p.offset + row
+		item := p.items[p.matches[i]]
+
+		status := "\x1b[32m"
+		if item.Result != 0 {
+			status = "\x1b[31m"
+		}
+		exitCode := fmt.Sprintf("%-4s", strconv.Itoa(item.Result))
+		meta := fmt.Sprintf(" %7s %-12s ",
+			formatCommandDuration(item.Duration),
+			runewidth.Truncate(item.Hostname, 12, "…"))
+		// newlines would break the layout; show them as ↵
+		command := strings.ReplaceAll(item.Command, "\n", "↵")
+		command = runewidth.Truncate(command, max(width-len(exitCode)-runewidth.StringWidth(meta)-2, 1), "…")
+
+		marker, highlight := "  ", ""
+		if i == p.selected {
+			highlight = "\x1b[7m"
+			marker = highlight + "> "
+		}
+		fmt.Fprintf(&b, "%s%s%s\x1b[0m%s%s%s\x1b[0m", marker, status, exitCode, highlight, meta, command)
+		if row < p.pageSize-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	// leave the cursor at the end of the query
+	fmt.Fprintf(&b, "\x1b[1;%dH", runewidth.StringWidth(string(p.query))+3)
+	io.WriteString(w, b.String())
+}
+
+func formatCommandDuration(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "-"
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+// runHistoryPicker runs the picker full screen on the controlling terminal,
+// so stdout stays free for the chosen command (shell widgets capture it).
+func runHistoryPicker(items []historyItem, query string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("history pick needs a terminal: %w", err)
+	}
+	defer tty.Close()
+
+	fd := int(tty.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(fd, state)
+
+	// alternate screen, restored on the way out
+	io.WriteString(tty, "\x1b[?1049h")
+	defer io.WriteString(tty, "\x1b[?1049l")
+
+	p := newHistoryPicker(items, query)
+	buf := make([]byte, 256)
+	for {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		p.render(tty, width, height)
+
+		n, err := tty.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		for _, k := range decodePickerKeys(buf[:n]) {
+			if selected, done := p.handleKey(k); done {
+				return selected, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func typePicker(p *historyPicker, input string) (string, bool) {
+	for _, k := range decodePickerKeys([]byte(input)) {
+		if selected, done := p.handleKey(k); done {
+			return selected, true
+		}
+	}
+	return "", false
+}
+
+func TestDecodePickerKeys(t *testing.T) {
+	keys := decodePickerKeys([]byte("g\x1b[A\x1bOB\x1b[6~é\x7f\x15\x17\x12\r"))
+	kinds := make([]pickerKeyKind, 0, len(keys))
+	for _, k := range keys {
+		kinds = append(kinds, k.kind)
+	}
+	assert.Equal(t, []pickerKeyKind{
+		pickerKeyRune, pickerKeyUp, pickerKeyDown, pickerKeyPageDown, pickerKeyRune,
+		pickerKeyBackspace, pickerKeyClear, pickerKeyDeleteWord, pickerKeyDown, pickerKeyEnter,
+	}, kinds)
+	assert.Equal(t, 'é', keys[4].r)
+
+	// a lone ESC cancels, an alt-modified key does not
+	assert.Equal(t, []pickerKey{{kind: pickerKeyCancel}}, decodePickerKeys([]byte{0x1b}))
+	assert.Empty(t, decodePickerKeys([]byte("\x1bx")))
+}
+
+func TestHistoryPicker_FilterAndSelect(t *testing.T) {
+	items := []historyItem{
+		{Command: "git status"},
+		{Command: "go test ./..."},
+		{Command: "git commit -m wip"},
+	}
+
+	p := newHistoryPicker(items, "")
+	require.Len(t, p.matches, 3)
+
+	selected, done := typePicker(p, "gc\r")
+	require.True(t, done)
+	assert.Equal(t, "git commit -m wip", selected)
+
+	// moving past the end stays on the last match; ^W clears the word
+	p = newHistoryPicker(items, "git")
+	require.Len(t, p.matches, 2)
+	selected, done = typePicker(p, "\x1b[B\x1b[B\x1b[B\r")
+	require.True(t, done)
+	assert.Equal(t, "git commit -m wip", selected)
+
+	p = newHistoryPicker(items, "zzz")
+	assert.Empty(t, p.matches)
+	_, done = typePicker(p, "\x17")
+	assert.False(t, done)
+	assert.Len(t, p.matches, 3)
+
+	selected, done = typePicker(p, "\x03")
+	assert.True(t, done)
+	assert.Empty(t, selected)
+}
+
+func TestHistoryPicker_Render(t *testing.T) {
+	items := []historyItem{
+		{Command: "make build", Result: 0, Duration: 1500 * time.Millisecond, Hostname: "laptop"},
+		{Command: "make test", Result: 2, Duration: 90 * time.Second, Hostname: "server"},
+		{Command: "make lint", Result: -130, Duration: time.Second, Hostname: "server"},
+	}
+	p := newHistoryPicker(items, "make")
+	p.move(1)
+
+	var buf bytes.Buffer
+	p.render(&buf, 80, 10)
+	out := buf.String()
+
+	assert.Contains(t, out, "3/3")
+	assert.Contains(t, out, "1.5s")
+	assert.Contains(t, out, "1m30s")
+	assert.Contains(t, out, "laptop")
+	assert.Contains(t, out, "\x1b[31m2   \x1b[0m")
+	// a long exit code keeps the columns after it in place
+	assert.Contains(t, out, "\x1b[31m-130\x1b[0m    1.0s server       make lint")
+	assert.Contains(t, out, "\x1b[31m2   \x1b[0m\x1b[7m   1m30s server       make test")
+	// the selected row is drawn in reverse video
+	assert.Contains(t, out, "\x1b[7m> \x1b[31m")
+	// ...and turns it back on after the colored exit code
+	assert.Equal(t, 2, strings.Count(out, "\x1b[7m"))
+}
+
+func TestHistoryPick_PrintsSelection(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	t.Setenv("HOME", t.TempDir())
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{}, nil)
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+	base := time.Now().Add(-time.Minute)
+	for i, command := range []string{"ls", "git status", "ls"} {
+		cmd := model.Command{Shell: "zsh", SessionID: 1, Command: command, Username: "u", Hostname: "h", Time: base.Add(time.Duration(i) * time.Second)}
+		require.NoError(t, store.SavePre(ctx, cmd, cmd.Time))
+		require.NoError(t, store.SavePost(ctx, cmd, i, cmd.Time))
+	}
+
+	var got []historyItem
+	var gotQuery string
+	orig := pickHistory
+	pickHistory = func(items []historyItem, query string) (string, error) {
+		got, gotQuery = items, query
+		return items[len(items)-1].Command, nil
+	}
+	t.Cleanup(func() { pickHistory = orig })
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{HistoryCommand}}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "history", "pick", "--query", "gi"}))
+	})
+
+	assert.Equal(t, "git status\n", out)
+	assert.Equal(t, "gi", gotQuery)
+	// newest first, repeated commands collapsed to their newest run
+	require.Len(t, got, 2)
+	assert.Equal(t, "ls", got[0].Command)
+	assert.Equal(t, 2, got[0].Result)
+	assert.Equal(t, "h", got[0].Hostname)
+}
@@ -33,6 +33,9 @@ func commandHooksInstall(c *cli.Context) error {
 	zshService := model.NewZshHookService()
 	fishService := model.NewFishHookService()
 	bashService := model.NewBashHookService()
+	nushellService := model.NewNushellHookService()
+	xonshService := model.NewXonshHookService()
+	elvishService := model.NewElvishHookService()
 
 	// Install hooks for all shells
 	if err := zshService.Install(); err != nil {
@@ -50,6 +53,12 @@ func commandHooksInstall(c *cli.Context) error {
 		// return err
 	}
 
+	for _, service := range []model.ShellHookService{nushellService, xonshService, elvishService} {
+		if err := service.Install(); err != nil {
+			color.Red.Printf("❌ Failed to install %s hook: %v\n", service.ShellName(), err)
+		}
+	}
+
 	color.Green.Println("✅ Shell hooks have been successfully installed!")
 	return nil
 }
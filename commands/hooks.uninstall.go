@@ -25,6 +25,9 @@ func commandHooksUninstall(c *cli.Context) error {
 	zshService := model.NewZshHookService()
 	fishService := model.NewFishHookService()
 	bashService := model.NewBashHookService()
+	nushellService := model.NewNushellHookService()
+	xonshService := model.NewXonshHookService()
+	elvishService := model.NewElvishHookService()
 
 	// Uninstall hooks for all shells
 	if err := zshService.Uninstall(); err != nil {
@@ -42,6 +45,13 @@ func commandHooksUninstall(c *cli.Context) error {
 		return err
 	}
 
+	for _, service := range []model.ShellHookService{nushellService, xonshService, elvishService} {
+		if err := service.Uninstall(); err != nil {
+			color.Red.Printf("❌ Failed to uninstall %s hook: %v\n", service.ShellName(), err)
+			return err
+		}
+	}
+
 	color.Green.Println("✅ Shell hooks have been successfully uninstalled!")
 	return nil
 }
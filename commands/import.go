@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/malamtime/cli/atuin"
+	"github.com/malamtime/cli/model"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ImportCommand *cli.Command = &cli.Command{
+	Name:  "import",
+	Usage: "import your existing shell history and sync it to ShellTime",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "history to import: zsh, bash, fish or atuin",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "file",
+			Usage: "history file to read instead of the shell's default",
+		},
+		&cli.IntFlag{
+			Name:  "batch-size",
+			Value: 500,
+			Usage: "commands sent per request",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "import everything again, including the commands of an earlier import",
+		},
+		&cli.BoolFlag{
+			Name:    "dry-run",
+			Aliases: []string{"dr"},
+			Usage:   "only parse the history and report what would be imported",
+		},
+	},
+	Action: commandImport,
+}
+
+// importState remembers, per history file, up to which time the commands were
+// imported, so running an import again only sends the new ones.
+type importState map[string]int64
+
+func importStatePath() string {
+	return model.GetStoragePath("imports.json")
+}
+
+func loadImportState() importState {
+	state := importState{}
+	content, err := os.ReadFile(importStatePath())
+	if err == nil {
+		json.Unmarshal(content, &state)
+	}
+	return state
+}
+
+func (s importState) save() error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(model.GetBaseStoragePath(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(importStatePath(), content, 0644)
+}
+
+func commandImport(c *cli.Context) error {
+	ctx, span := commandTracer.Start(c.Context, "import", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	from := c.String("from")
+	switch from {
+	case model.HistoryFormatZsh, model.HistoryFormatBash, model.HistoryFormatFish, model.HistoryFormatAtuin:
+	default:
+		return fmt.Errorf("unsupported history: %s. Use zsh, bash, fish or atuin", from)
+	}
+	path := c.String("file")
+	if path == "" {
+		path = model.DefaultHistoryPath(from)
+	}
+
+	cfg, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	dryRun := c.Bool("dry-run")
+	if cfg.Token == "" && !dryRun {
+		return fmt.Errorf("not authenticated. Please run 'shelltime auth' first")
+	}
+
+	history, err := readHistory(ctx, from, path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s history %s: %w", from, path, err)
+	}
+
+	hostname, _ := os.Hostname()
+	username := os.Getenv("USER")
+	state := loadImportState()
+	stateKey := from + ":" + path
+	commands := make([]model.Command, 0, len(history.Commands))
+	for _, cmd := range history.Commands {
+		if !c.Bool("force") && cmd.Time.UnixNano() <= state[stateKey] {
+			continue
+		}
+		if cmd.Hostname == "" {
+			cmd.Hostname = hostname
+		}
+		if cmd.Username == "" {
+			cmd.Username = username
+		}
+		commands = append(commands, cmd)
+	}
+
+	payloads := model.BuildImportPayloads(commands, cfg, c.Int("batch-size"))
+	total := 0
+	for _, p := range payloads {
+		total += len(p.Data)
+	}
+	fmt.Printf("Found %d commands in %s (%d already imported, %d excluded, %d without a timestamp)\n",
+		len(history.Commands), path, len(history.Commands)-len(commands), len(commands)-total, history.Skipped)
+	if dryRun || total == 0 {
+		return nil
+	}
+
+	sent := 0
+	for i, p := range payloads {
+		if err := DoSyncData(ctx, cfg, time.Unix(0, p.CursorID), p.Data, nil, p.Meta, model.SyncOptions{}); err != nil {
+			fmt.Fprintln(os.Stderr)
+			return fmt.Errorf("import stopped after %d of %d commands, run it again to resume: %w", sent, total, err)
+		}
+		sent += len(p.Data)
+		fmt.Fprintf(os.Stderr, "\rUploading %d/%d commands", sent, total)
+
+		// payloads start in time order, so everything before the next one's
+		// first command has been sent
+		watermark := commands[len(commands)-1].Time.UnixNano()
+		if i+1 < len(payloads) {
+			watermark = payloads[i+1].Data[0].EndTimeNano - 1
+		}
+		state[stateKey] = watermark
+		if err := state.save(); err != nil {
+			return fmt.Errorf("failed to save the import progress: %w", err)
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+	color.Green.Printf("✅ Imported %d commands from %s\n", sent, from)
+	return nil
+}
+
+// readHistory reads the history at path, written by the shell named by from.
+func readHistory(ctx context.Context, from, path string) (model.HistoryImport, error) {
+	if from != model.HistoryFormatAtuin {
+		return model.ReadShellHistory(from, path)
+	}
+	cmds, err := atuin.ReadHistory(ctx, path)
+	if err != nil {
+		return model.HistoryImport{}, err
+	}
+	return model.HistoryImport{Commands: model.DedupeHistory(cmds)}, nil
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestImportCommand_ZshInBatchesAndResumes(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	var (
+		mu       sync.Mutex
+		payloads []model.PostTrackArgs
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p model.PostTrackArgs
+		body, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(body).Decode(&p))
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		Token:       "tok",
+		APIEndpoint: srv.URL,
+		SocketPath:  filepath.Join(home, "missing.sock"),
+		Exclude:     []string{"export *"},
+	}, nil)
+
+	path := filepath.Join(home, "zsh_history")
+	history := ": 1700000000:1;ls\n: 1700000010:0;export TOKEN=x\n: 1700000020:0;git status\n: 1700000030:0;make\n"
+	require.NoError(t, os.WriteFile(path, []byte(history), 0600))
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{ImportCommand}}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "import", "--from", "zsh", "--file", path, "--batch-size", "2"}))
+	})
+	assert.Contains(t, out, "Found 4 commands")
+	require.Len(t, payloads, 2)
+	assert.Equal(t, "ls", payloads[0].Data[0].Command)
+	assert.Equal(t, "git status", payloads[0].Data[1].Command)
+	assert.Equal(t, "zsh", payloads[0].Meta.Shell)
+
+	// a second run only sends what was added since
+	require.NoError(t, os.WriteFile(path, []byte(history+": 1700000040:0;vim\n"), 0600))
+	captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "import", "--from", "zsh", "--file", path}))
+	})
+	require.Len(t, payloads, 3)
+	require.Len(t, payloads[2].Data, 1)
+	assert.Equal(t, "vim", payloads[2].Data[0].Command)
+}
+
+func TestImportCommand_RequiresAuthUnlessDryRun(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{}, nil)
+
+	path := filepath.Join(home, "bash_history")
+	require.NoError(t, os.WriteFile(path, []byte("#1700000000\nls\n"), 0600))
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{ImportCommand}}
+	err := app.Run([]string{"t", "import", "--from", "bash", "--file", path})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not authenticated")
+
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "import", "--from", "bash", "--file", path, "--dry-run"}))
+	})
+	assert.Contains(t, out, "Found 1 commands")
+
+	err = app.Run([]string{"t", "import", "--from", "powershell"})
+	require.Error(t, err)
+}
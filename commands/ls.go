@@ -2,9 +2,12 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -26,6 +29,22 @@ var LsCommand *cli.Command = &cli.Command{
 			Value:   "table",
 			Usage:   "output format (table/json)",
 		},
+		&cli.StringFlag{
+			Name:  "cwd",
+			Usage: "only list commands run in this directory or its subdirectories",
+		},
+		&cli.BoolFlag{
+			Name:  "here",
+			Usage: "only list commands run in the current directory or its subdirectories",
+		},
+		&cli.DurationFlag{
+			Name:  "since",
+			Usage: "only list commands that finished within this duration (e.g. 24h)",
+		},
+		&cli.Int64Flag{
+			Name:  "session",
+			Usage: "only list commands of this shell session",
+		},
 	},
 	Action: commandList,
 	OnUsageError: func(cCtx *cli.Context, err error, isSubcommand bool) error {
@@ -50,30 +69,48 @@ func commandList(c *cli.Context) error {
 		color.Yellow.Println("⚠️ Note: Unsaved commands are not included in this list")
 	}
 
-	if format == "table" {
+	config, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return err
+	}
+
+	if format == "table" && !config.ArchiveEnabled() {
 		color.Yellow.Println("⚠️ Note: Local data will be cleaned periodically for performance and disk efficiency. To view all of your commands, please run 'shelltime web'")
 	}
 
-	config, err := configService.ReadConfigFile(ctx)
+	query := model.ArchiveQuery{SessionID: c.Int64("session")}
+	if since := c.Duration("since"); since > 0 {
+		query.Since = time.Now().Add(-since)
+	}
+
+	commands, err := loadLocalCommands(ctx, config, query)
 	if err != nil {
 		return err
 	}
 
-	// In bolt mode the daemon owns the (exclusively locked) DB, so query it over
-	// the socket. Otherwise read the txt file store directly.
-	var commands []model.ListedCommand
-	useBolt := config.Storage != nil && config.Storage.Engine == model.StorageEngineBolt
-	if useBolt && daemon.IsSocketReady(ctx, config.SocketPath) {
-		resp, err := daemon.RequestListCommands(config.SocketPath, 2*time.Second)
+	filtered := make([]model.ListedCommand, 0, len(commands))
+	for _, cmd := range commands {
+		if query.Matches(cmd) {
+			filtered = append(filtered, cmd)
+		}
+	}
+	commands = filtered
+
+	dir := c.String("cwd")
+	if c.Bool("here") {
+		dir, err = os.Getwd()
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to get current directory: %w", err)
 		}
-		commands = resp.Commands
-	} else {
-		commands, err = model.BuildListedCommands(ctx, model.NewFileStore())
+	}
+	if dir != "" {
+		// records keep absolute directories, so resolve a relative --cwd
+		// against the current one
+		dir, err = filepath.Abs(dir)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to resolve directory: %w", err)
 		}
+		commands = model.FilterListedCommandsByDir(commands, dir)
 	}
 
 	// Output based on format
@@ -94,7 +131,7 @@ func outputJSON(commands interface{}) error {
 
 func outputTable(commands []model.ListedCommand) error {
 	w := tablewriter.NewWriter(os.Stdout)
-	w.Header([]string{"COMMAND", "SHELL", "START TIME", "END TIME", "DURATION(ms)", "STATUS", "USER", "HOST"})
+	w.Header([]string{"COMMAND", "SHELL", "START TIME", "END TIME", "DURATION(ms)", "STATUS", "USER", "HOST", "CWD"})
 
 	for _, cmd := range commands {
 		duration := cmd.EndTime.Sub(cmd.StartTime).Milliseconds()
@@ -107,9 +144,80 @@ func outputTable(commands []model.ListedCommand) error {
 			strconv.Itoa(cmd.Result),
 			cmd.Username,
 			cmd.Hostname,
+			cmd.Cwd,
 		})
 	}
 
 	w.Render()
 	return nil
 }
+
+// loadLocalCommands returns the commands kept on this machine: the archived
+// ones matching query (when the archive is enabled) followed by the buffered
+// ones. In bolt mode the daemon owns the (exclusively locked) DB, so it is
+// queried over the socket; without a daemon the DB is opened read-only and
+// listed along with the txt store the CLI falls back to. Otherwise the local
+// store is read directly.
+func loadLocalCommands(ctx context.Context, config model.ShellTimeConfig, query model.ArchiveQuery) ([]model.ListedCommand, error) {
+	useBolt := config.Storage != nil && config.Storage.Engine == model.StorageEngineBolt
+	if useBolt && daemon.IsSocketReady(ctx, config.SocketPath) {
+		req := daemon.ListCommandsRequest{SessionID: query.SessionID}
+		if !query.Since.IsZero() {
+			req.SinceNano = query.Since.UnixNano()
+		}
+		resp, err := daemon.RequestListCommands(config.SocketPath, req, 2*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Commands, nil
+	}
+
+	store := model.NewLocalStore(config)
+	var commands []model.ListedCommand
+	if useBolt {
+		boltStore, err := model.OpenBoltStoreReadOnly()
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case errors.Is(err, model.ErrBoltStoreLocked):
+			return nil, fmt.Errorf("%w and its socket %s isn't ready, run 'shelltime daemon status' to check the daemon", err, config.SocketPath)
+		case err != nil:
+			return nil, err
+		default:
+			defer boltStore.Close()
+			commands, err = listStoredCommands(ctx, config, boltStore, query)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if useBolt {
+		// the CLI only falls back to the txt store while the daemon is down
+		if _, err := os.Stat(model.GetPostCommandFilePath()); os.IsNotExist(err) {
+			return commands, nil
+		}
+	}
+	local, err := listStoredCommands(ctx, config, store, query)
+	if err != nil {
+		return nil, err
+	}
+	return model.MergeListedCommands(commands, local), nil
+}
+
+// listStoredCommands lists the archived commands of store matching query,
+// when the archive is enabled, followed by its buffered ones.
+func listStoredCommands(ctx context.Context, config model.ShellTimeConfig, store model.CommandStore, query model.ArchiveQuery) ([]model.ListedCommand, error) {
+	var commands []model.ListedCommand
+	if config.ArchiveEnabled() {
+		archived, err := model.ListArchived(ctx, store, query)
+		if err != nil {
+			return nil, err
+		}
+		commands = archived
+	}
+	buffered, err := model.BuildListedCommands(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	return model.MergeListedCommands(commands, buffered), nil
+}
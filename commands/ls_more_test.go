@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"net"
 	"path/filepath"
@@ -155,3 +156,70 @@ func TestC2OutputJSON_MarshalError(t *testing.T) {
 	err := outputJSON(make(chan int))
 	require.Error(t, err)
 }
+
+func TestLoadLocalCommands_ListsArchivedCommandsOnce(t *testing.T) {
+	setupGCTest(t)
+
+	ctx := context.Background()
+	enabled := true
+	cfg := model.ShellTimeConfig{Storage: &model.StorageConfig{
+		Engine:  model.StorageEngineSegment,
+		Archive: &model.ArchiveConfig{Enabled: &enabled},
+	}}
+	store := model.NewLocalStore(cfg)
+
+	now := time.Now()
+	for i, name := range []string{"make", "make test"} {
+		cmd := model.Command{Shell: "zsh", SessionID: 1, Command: name, Username: "u", Time: now.Add(time.Duration(i) * time.Second)}
+		require.NoError(t, store.SavePre(ctx, cmd, cmd.Time))
+		require.NoError(t, store.SavePost(ctx, cmd, 0, cmd.Time.Add(time.Millisecond)))
+	}
+	// the first command is synced and archived, but today's segment keeps it
+	cursor := now.Add(500 * time.Millisecond)
+	require.NoError(t, model.ArchiveSynced(ctx, cfg, store, cursor))
+	require.NoError(t, store.Prune(ctx, cursor))
+
+	commands, err := loadLocalCommands(ctx, cfg, model.ArchiveQuery{})
+	require.NoError(t, err)
+	require.Len(t, commands, 2)
+	require.Equal(t, "make", commands[0].Command)
+	require.Equal(t, "make test", commands[1].Command)
+}
+
+func TestLoadLocalCommands_BoltWithoutDaemon(t *testing.T) {
+	setupGCTest(t)
+
+	ctx := context.Background()
+	cfg := model.ShellTimeConfig{
+		Storage:    &model.StorageConfig{Engine: model.StorageEngineBolt},
+		SocketPath: filepath.Join(t.TempDir(), "missing.sock"),
+	}
+
+	// no DB yet: only the txt store the CLI falls back to
+	commands, err := loadLocalCommands(ctx, cfg, model.ArchiveQuery{})
+	require.NoError(t, err)
+	require.Empty(t, commands)
+
+	now := time.Now()
+	bolt, err := model.NewCommandStore(cfg)
+	require.NoError(t, err)
+	saved := model.Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Time: now}
+	require.NoError(t, bolt.SavePre(ctx, saved, saved.Time))
+	require.NoError(t, bolt.SavePost(ctx, saved, 0, saved.Time.Add(time.Millisecond)))
+
+	// the DB is held by another process and no daemon answers
+	_, err = loadLocalCommands(ctx, cfg, model.ArchiveQuery{})
+	require.ErrorIs(t, err, model.ErrBoltStoreLocked)
+	require.NoError(t, bolt.Close())
+
+	fallback := model.NewLocalStore(cfg)
+	tracked := model.Command{Shell: "zsh", SessionID: 1, Command: "make test", Username: "u", Time: now.Add(time.Second)}
+	require.NoError(t, fallback.SavePre(ctx, tracked, tracked.Time))
+	require.NoError(t, fallback.SavePost(ctx, tracked, 0, tracked.Time.Add(time.Millisecond)))
+
+	commands, err = loadLocalCommands(ctx, cfg, model.ArchiveQuery{})
+	require.NoError(t, err)
+	require.Len(t, commands, 2)
+	assert.Equal(t, "make", commands[0].Command)
+	assert.Equal(t, "make test", commands[1].Command)
+}
@@ -2,7 +2,10 @@ package commands
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -40,3 +43,97 @@ func TestLsCommandFileMode(t *testing.T) {
 	require.NoError(t, app.Run([]string{"mtt", "ls", "-f", "json"}))
 	require.NoError(t, app.Run([]string{"mtt", "ls", "-f", "table"}))
 }
+
+func TestLsCommandCwdFilter(t *testing.T) {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	SKIP_LOGGER_SETTINGS = true
+
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+
+	store := model.NewFileStore()
+	now := time.Now()
+	for i, dir := range []string{"/repo/a", "/repo/b"} {
+		cmd := model.Command{Shell: "zsh", SessionID: int64(i + 1), Command: "make", Username: "u", Time: now, Cwd: dir}
+		require.NoError(t, store.SavePre(context.Background(), cmd, now))
+		post := cmd
+		post.Time = now.Add(time.Second)
+		require.NoError(t, store.SavePost(context.Background(), post, 0, post.Time))
+	}
+
+	cs := model.NewMockConfigService(t)
+	cs.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{}, nil)
+	configService = cs
+
+	out := captureStdout(t, func() {
+		app := &cli.App{Name: "mtt", Commands: []*cli.Command{LsCommand}}
+		require.NoError(t, app.Run([]string{"mtt", "ls", "-f", "json", "--cwd", "/repo/b"}))
+	})
+
+	var listed []model.ListedCommand
+	require.NoError(t, json.Unmarshal([]byte(out), &listed))
+	require.Len(t, listed, 1)
+	require.Equal(t, "/repo/b", listed[0].Cwd)
+}
+
+func TestLsCommandCwdFilter_RelativePath(t *testing.T) {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	SKIP_LOGGER_SETTINGS = true
+
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, "src"), 0755))
+	t.Chdir(repo)
+
+	store := model.NewFileStore()
+	now := time.Now()
+	for i, dir := range []string{repo, filepath.Join(repo, "src"), "/elsewhere"} {
+		cmd := model.Command{Shell: "zsh", SessionID: int64(i + 1), Command: "make", Username: "u", Time: now, Cwd: dir}
+		require.NoError(t, store.SavePre(context.Background(), cmd, now))
+		post := cmd
+		post.Time = now.Add(time.Second)
+		require.NoError(t, store.SavePost(context.Background(), post, 0, post.Time))
+	}
+
+	cs := model.NewMockConfigService(t)
+	cs.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{}, nil)
+	configService = cs
+
+	list := func(dir string) []model.ListedCommand {
+		out := captureStdout(t, func() {
+			app := &cli.App{Name: "mtt", Commands: []*cli.Command{LsCommand}}
+			require.NoError(t, app.Run([]string{"mtt", "ls", "-f", "json", "--cwd", dir}))
+		})
+		var listed []model.ListedCommand
+		require.NoError(t, json.Unmarshal([]byte(out), &listed))
+		return listed
+	}
+
+	require.Len(t, list("."), 2)
+	listed := list("src")
+	require.Len(t, listed, 1)
+	require.Equal(t, filepath.Join(repo, "src"), listed[0].Cwd)
+}
+
+// captureStdout runs fn with os.Stdout redirected to a pipe and returns what
+// it printed.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	done := make(chan []byte)
+	go func() {
+		buf, _ := io.ReadAll(r)
+		done <- buf
+	}()
+
+	fn()
+	require.NoError(t, w.Close())
+	return string(<-done)
+}
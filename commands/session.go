@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/malamtime/cli/daemon"
+	"github.com/malamtime/cli/model"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var SessionCommand *cli.Command = &cli.Command{
+	Name:  "session",
+	Usage: "manage shell sessions",
+	Subcommands: []*cli.Command{
+		SessionNewCommand,
+		SessionStartCommand,
+		SessionEndCommand,
+	},
+}
+
+var SessionNewCommand *cli.Command = &cli.Command{
+	Name:  "new",
+	Usage: "print a new session ID for the shell hooks",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "pid",
+			Usage: "pid of the shell the session belongs to (defaults to the parent process)",
+		},
+	},
+	Action: commandSessionNew,
+}
+
+var sessionEventFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "shell",
+		Aliases: []string{"s"},
+		Usage:   "the shell that user use",
+	},
+	&cli.Int64Flag{
+		Name:    "sessionId",
+		Aliases: []string{"id"},
+		Usage:   "session ID from `shelltime session new`",
+	},
+	&cli.IntFlag{
+		Name:  "ppid",
+		Usage: "Parent process ID of the shell (for terminal detection)",
+	},
+	&cli.StringFlag{
+		Name:  "cwd",
+		Usage: "working directory of the shell",
+	},
+	&cli.StringFlag{
+		Name:  "tty",
+		Usage: "the terminal device of the shell ($TTY / $(tty))",
+	},
+	&cli.StringFlag{
+		Name:  "time",
+		Usage: "event time measured by the shell, as epoch seconds with a fractional part ($EPOCHREALTIME)",
+	},
+}
+
+// sessionEndFlags lets the hooks of shells without an exit hook start
+// `session end` in the background when the shell starts.
+var sessionEndFlags = append([]cli.Flag{
+	&cli.IntFlag{
+		Name:  "shell-pid",
+		Usage: "wait for the shell with this pid to exit, then record the end",
+	},
+}, sessionEventFlags...)
+
+var SessionStartCommand *cli.Command = &cli.Command{
+	Name:   "start",
+	Usage:  "record the start of a shell session (called by the shell hooks)",
+	Flags:  sessionEventFlags,
+	Action: commandSessionEvent(model.SessionEventStart),
+	OnUsageError: func(cCtx *cli.Context, err error, isSubcommand bool) error {
+		return nil
+	},
+}
+
+var SessionEndCommand *cli.Command = &cli.Command{
+	Name:   "end",
+	Usage:  "record the end of a shell session (called by the shell hooks)",
+	Flags:  sessionEndFlags,
+	Action: commandSessionEvent(model.SessionEventEnd),
+	OnUsageError: func(cCtx *cli.Context, err error, isSubcommand bool) error {
+		return nil
+	},
+}
+
+// sessionEndCheckInterval is how often `session end --shell-pid` checks that
+// the shell is still running.
+var sessionEndCheckInterval = time.Second
+
+// commandSessionNew runs once when a hook is sourced, so it stays free of
+// config, logger and tracing setup.
+func commandSessionNew(c *cli.Context) error {
+	pid := c.Int("pid")
+	if pid == 0 {
+		pid = os.Getppid()
+	}
+	fmt.Println(model.NewSessionID(time.Now(), pid))
+	return nil
+}
+
+func commandSessionEvent(evType model.SessionEventType) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		ctx, span := commandTracer.Start(c.Context, "session."+string(evType), trace.WithSpanKind(trace.SpanKindClient))
+		defer span.End()
+		SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+		if pid := c.Int("shell-pid"); pid > 0 {
+			// the hook starts this in the background; outlive the terminal
+			// closing so the end is still recorded
+			signal.Ignore(syscall.SIGHUP)
+			for processAlive(pid) {
+				time.Sleep(sessionEndCheckInterval)
+			}
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			slog.Error("failed to get hostname", slog.Any("err", err))
+			return err
+		}
+
+		ev := model.SessionEvent{
+			Type:          evType,
+			Shell:         c.String("shell"),
+			SessionID:     c.Int64("sessionId"),
+			Hostname:      hostname,
+			Username:      os.Getenv("USER"),
+			Time:          time.Now(),
+			PPID:          c.Int("ppid"),
+			TTY:           c.String("tty"),
+			SSHConnection: os.Getenv("SSH_CONNECTION"),
+			TermProgram:   os.Getenv("TERM_PROGRAM"),
+			Cwd:           c.String("cwd"),
+		}
+		if epoch := c.String("time"); epoch != "" {
+			t, err := parseShellEpoch(epoch)
+			if err != nil {
+				slog.Warn("ignoring invalid shell timing", slog.Any("err", err))
+			} else {
+				ev.Time = t
+			}
+		}
+
+		return dispatchSessionEvent(ctx, ev)
+	}
+}
+
+// dispatchSessionEvent hands a session event to the daemon, or stores it in
+// the local store when no daemon is running. Like the command events, it
+// is synced with the next batch of commands.
+func dispatchSessionEvent(ctx context.Context, ev model.SessionEvent) error {
+	if daemon.IsSocketReady(ctx, model.DefaultSocketPath) {
+		return daemon.SendSessionEvent(ctx, model.DefaultSocketPath, ev, time.Now())
+	}
+
+	config, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		slog.Error("failed to read config file", slog.Any("err", err))
+		return err
+	}
+	if config.SocketPath != model.DefaultSocketPath && daemon.IsSocketReady(ctx, config.SocketPath) {
+		return daemon.SendSessionEvent(ctx, config.SocketPath, ev, time.Now())
+	}
+
+	// The daemon resolves the terminal itself; without one do it here, while
+	// the shell's process tree is still alive.
+	if ev.Type == model.SessionEventStart && ev.PPID > 0 {
+		ev.Terminal, ev.Multiplexer = daemon.ResolveTerminal(ev.PPID)
+	}
+	return model.NewLocalStore(config).SaveSessionEvent(ctx, ev, time.Now())
+}
@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestSessionNewCommand(t *testing.T) {
+	app := &cli.App{Name: "t", Commands: []*cli.Command{SessionCommand}}
+
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "session", "new", "--pid", "321"}))
+	})
+	id, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	require.NoError(t, err)
+	require.Equal(t, int64(321), id>>11&0x3FF)
+}
+
+func TestSessionStartCommand_PersistsLocally(t *testing.T) {
+	_, mc := x3SetupTrack(t)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		SocketPath: x3UnreadySocket(t),
+	}, nil)
+	t.Setenv("SSH_CONNECTION", "10.0.0.1 5000 10.0.0.2 22")
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{SessionCommand}}
+	require.NoError(t, app.Run([]string{"t", "session", "start", "--shell", "bash", "--id", "42", "--tty", "/dev/pts/3", "--time", "1700000000.5"}))
+	require.NoError(t, app.Run([]string{"t", "session", "end", "--shell", "bash", "--id", "42"}))
+
+	events, err := model.NewFileStore().GetSessionEvents(context.Background())
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, model.SessionEventStart, events[0].Type)
+	require.Equal(t, int64(42), events[0].SessionID)
+	require.Equal(t, "/dev/pts/3", events[0].TTY)
+	require.Equal(t, "10.0.0.1 5000 10.0.0.2 22", events[0].SSHConnection)
+	require.Equal(t, int64(1700000000500000000), events[0].Time.UnixNano())
+	require.Equal(t, model.SessionEventEnd, events[1].Type)
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package commands
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestSessionEndCommand_WaitsForShell(t *testing.T) {
+	_, mc := x3SetupTrack(t)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		SocketPath: x3UnreadySocket(t),
+	}, nil)
+	orig := sessionEndCheckInterval
+	sessionEndCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sessionEndCheckInterval = orig })
+
+	start := time.Now()
+	shell := exec.Command("sleep", "0.2")
+	require.NoError(t, shell.Start())
+	go shell.Wait()
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{SessionCommand}}
+	require.NoError(t, app.Run([]string{"t", "session", "end", "--shell", "nushell", "--id", "42", "--shell-pid", "0"}))
+	require.NoError(t, app.Run([]string{"t", "session", "end", "--shell", "nushell", "--id", "43", "--shell-pid", strconv.Itoa(shell.Process.Pid)}))
+
+	events, err := model.NewFileStore().GetSessionEvents(context.Background())
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, int64(43), events[1].SessionID)
+	require.GreaterOrEqual(t, events[1].Time.Sub(start), 200*time.Millisecond)
+}
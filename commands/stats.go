@@ -0,0 +1,226 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/malamtime/cli/model"
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/renderer"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var StatsCommand *cli.Command = &cli.Command{
+	Name:  "stats",
+	Usage: "show analytics of the commands stored on this machine",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Value:   "table",
+			Usage:   "output format (table/json/markdown)",
+		},
+		&cli.DurationFlag{
+			Name:  "since",
+			Usage: "only count commands that finished within this duration (e.g. 720h)",
+		},
+		&cli.IntFlag{
+			Name:  "top",
+			Value: 10,
+			Usage: "number of entries in the ranked lists",
+		},
+	},
+	Action: commandStats,
+	OnUsageError: func(cCtx *cli.Context, err error, isSubcommand bool) error {
+		color.Red.Println(err.Error())
+		return nil
+	},
+}
+
+func commandStats(c *cli.Context) error {
+	ctx, span := commandTracer.Start(c.Context, "stats", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	format := c.String("format")
+	if format != "table" && format != "json" && format != "markdown" {
+		return fmt.Errorf("unsupported format: %s. Use 'table', 'json' or 'markdown'", format)
+	}
+
+	config, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := model.ArchiveQuery{}
+	if since := c.Duration("since"); since > 0 {
+		query.Since = time.Now().Add(-since)
+	}
+	commands, err := loadLocalCommands(ctx, config, query)
+	if err != nil {
+		return err
+	}
+	filtered := make([]model.ListedCommand, 0, len(commands))
+	for _, cmd := range commands {
+		if query.Matches(cmd) {
+			filtered = append(filtered, cmd)
+		}
+	}
+
+	stats := model.ComputeStats(filtered, model.StatsOptions{Top: c.Int("top")})
+
+	switch format {
+	case "json":
+		return outputJSON(stats)
+	case "markdown":
+		renderStatsMarkdown(os.Stdout, stats)
+	default:
+		if !config.ArchiveEnabled() {
+			color.Yellow.Println("⚠️ Note: only the commands not yet cleaned up locally are counted. Enable storage.archive in config to keep a longer local history")
+		}
+		renderStatsTable(os.Stdout, stats)
+	}
+	return nil
+}
+
+// statsSection is one titled table of the stats report, shared by the table
+// and markdown renderers.
+type statsSection struct {
+	title  string
+	header []string
+	rows   [][]string
+}
+
+var heatmapWeekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+func buildStatsSections(stats model.CommandStats, shaded bool) []statsSection {
+	ms := func(v int64) string { return formatCommandDuration(time.Duration(v) * time.Millisecond) }
+	rate := func(v float64) string { return strconv.FormatFloat(v*100, 'f', 1, 64) + "%" }
+
+	summary := statsSection{title: "Summary", header: []string{"COMMANDS", "FAILED", "TOTAL TIME", "FROM", "TO", "CURRENT STREAK", "LONGEST STREAK"}}
+	from, to := "-", "-"
+	if stats.Total > 0 {
+		from, to = stats.From.Local().Format("2006-01-02"), stats.To.Local().Format("2006-01-02")
+	}
+	summary.rows = append(summary.rows, []string{
+		strconv.Itoa(stats.Total),
+		strconv.Itoa(stats.Failed),
+		ms(stats.TotalDurationMs),
+		from,
+		to,
+		fmt.Sprintf("%d days", stats.Streak.Current),
+		fmt.Sprintf("%d days", stats.Streak.Longest),
+	})
+
+	byCount := statsSection{title: "Top commands by count", header: []string{"COMMAND", "COUNT", "FAILURE RATE", "TOTAL TIME"}}
+	for _, m := range stats.TopByCount {
+		byCount.rows = append(byCount.rows, []string{m.Main, strconv.Itoa(m.Count), rate(m.FailureRate), ms(m.TotalDurationMs)})
+	}
+
+	byDuration := statsSection{title: "Top commands by total time", header: []string{"COMMAND", "TOTAL TIME", "COUNT", "AVERAGE"}}
+	for _, m := range stats.TopByDuration {
+		byDuration.rows = append(byDuration.rows, []string{m.Main, ms(m.TotalDurationMs), strconv.Itoa(m.Count), ms(m.TotalDurationMs / int64(m.Count))})
+	}
+
+	failures := statsSection{title: "Failure rate", header: []string{"COMMAND", "FAILURE RATE", "FAILED", "COUNT"}}
+	for _, m := range stats.TopFailures {
+		failures.rows = append(failures.rows, []string{m.Main, rate(m.FailureRate), strconv.Itoa(m.Failed), strconv.Itoa(m.Count)})
+	}
+
+	longest := statsSection{title: "Longest-running commands", header: []string{"COMMAND", "DURATION", "STATUS", "HOST", "END TIME"}}
+	for _, l := range stats.Longest {
+		longest.rows = append(longest.rows, []string{l.Command, ms(l.DurationMs), strconv.Itoa(l.Result), l.Hostname, l.EndTime.Local().Format(time.RFC3339)})
+	}
+
+	breakdown := func(title, name string, items []model.BreakdownStats) statsSection {
+		s := statsSection{title: title, header: []string{name, "COUNT", "SHARE", "TOTAL TIME"}}
+		for _, b := range items {
+			s.rows = append(s.rows, []string{b.Name, strconv.Itoa(b.Count), rate(float64(b.Count) / float64(stats.Total)), ms(b.TotalDurationMs)})
+		}
+		return s
+	}
+
+	heatmap := statsSection{title: "Activity by weekday and hour", header: []string{"DAY"}}
+	peak := 0
+	for _, day := range stats.Heatmap {
+		for _, n := range day {
+			peak = max(peak, n)
+		}
+	}
+	for h := 0; h < 24; h++ {
+		heatmap.header = append(heatmap.header, strconv.Itoa(h))
+	}
+	for d, day := range stats.Heatmap {
+		row := []string{heatmapWeekdays[d]}
+		for _, n := range day {
+			if shaded {
+				row = append(row, heatmapShade(n, peak))
+			} else {
+				row = append(row, strconv.Itoa(n))
+			}
+		}
+		heatmap.rows = append(heatmap.rows, row)
+	}
+
+	return []statsSection{
+		summary,
+		byCount,
+		byDuration,
+		failures,
+		longest,
+		breakdown("Shells", "SHELL", stats.Shells),
+		breakdown("Hosts", "HOST", stats.Hosts),
+		heatmap,
+	}
+}
+
+// heatmapShade maps a count to a block character relative to the busiest
+// hour, so the heatmap fits in a terminal.
+func heatmapShade(n, peak int) string {
+	shades := []string{" ", "░", "▒", "▓", "█"}
+	if n == 0 || peak == 0 {
+		return shades[0]
+	}
+	return shades[(n*(len(shades)-1)+peak-1)/peak]
+}
+
+func renderStatsTable(w io.Writer, stats model.CommandStats) {
+	for _, s := range buildStatsSections(stats, true) {
+		if len(s.rows) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, color.Bold.Sprint(s.title))
+		t := tablewriter.NewWriter(w)
+		t.Header(s.header)
+		t.Bulk(s.rows)
+		t.Render()
+		fmt.Fprintln(w)
+	}
+}
+
+func renderStatsMarkdown(w io.Writer, stats model.CommandStats) {
+	fmt.Fprintln(w, "# Shell command stats")
+	for _, s := range buildStatsSections(stats, false) {
+		if len(s.rows) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n## %s\n\n", s.title)
+		t := tablewriter.NewTable(w, tablewriter.WithRenderer(renderer.NewMarkdown()))
+		t.Header(s.header)
+		for _, row := range s.rows {
+			escaped := make([]string, len(row))
+			for i, cell := range row {
+				escaped[i] = strings.ReplaceAll(cell, "|", `\|`)
+			}
+			t.Append(escaped)
+		}
+		t.Render()
+	}
+}
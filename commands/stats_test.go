@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func setupStatsTest(t *testing.T) {
+	t.Helper()
+	mc := setupGrepActionTest(t)
+	t.Setenv("HOME", t.TempDir())
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{}, nil)
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+	base := time.Now().Add(-time.Hour)
+	for i, command := range []string{"git status", "git status", "make | tee out"} {
+		cmd := model.Command{Shell: "zsh", SessionID: 1, Command: command, Username: "u", Hostname: "h", Time: base.Add(time.Duration(i) * time.Minute)}
+		require.NoError(t, store.SavePre(ctx, cmd, cmd.Time))
+		end := cmd
+		end.Time = cmd.Time.Add(time.Duration(i+1) * time.Second)
+		require.NoError(t, store.SavePost(ctx, end, i, end.Time))
+	}
+}
+
+func TestStatsCommand_JSON(t *testing.T) {
+	setupStatsTest(t)
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{StatsCommand}}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "stats", "-f", "json"}))
+	})
+
+	var stats model.CommandStats
+	require.NoError(t, json.Unmarshal([]byte(out), &stats))
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, 2, stats.Failed)
+	require.NotEmpty(t, stats.TopByCount)
+	assert.Equal(t, "git status", stats.TopByCount[0].Main)
+	assert.Equal(t, int64(3000), stats.Longest[0].DurationMs)
+	assert.Equal(t, 1, stats.Streak.Current)
+}
+
+func TestStatsCommand_Markdown(t *testing.T) {
+	setupStatsTest(t)
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{StatsCommand}}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "stats", "--format", "markdown"}))
+	})
+
+	assert.Contains(t, out, "## Top commands by count")
+	assert.Contains(t, out, "## Activity by weekday and hour")
+	assert.Contains(t, out, "git status")
+	// pipes in commands don't break the markdown table
+	assert.Contains(t, out, `make \| tee out`)
+}
+
+func TestStatsCommand_InvalidFormat(t *testing.T) {
+	setupGrepActionTest(t)
+	app := &cli.App{Name: "t", Commands: []*cli.Command{StatsCommand}}
+	err := app.Run([]string{"t", "stats", "-f", "csv"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported format")
+}
+
+func TestHeatmapShade(t *testing.T) {
+	assert.Equal(t, " ", heatmapShade(0, 10))
+	assert.Equal(t, "░", heatmapShade(1, 10))
+	assert.Equal(t, "█", heatmapShade(10, 10))
+}
@@ -0,0 +1,325 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/malamtime/cli/daemon"
+	"github.com/malamtime/cli/model"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var StorageCommand *cli.Command = &cli.Command{
+	Name:  "storage",
+	Usage: "manage the local command storage",
+	Subcommands: []*cli.Command{
+		StorageMigrateCommand,
+		StorageFsckCommand,
+		StorageRotateKeyCommand,
+	},
+}
+
+var StorageMigrateCommand *cli.Command = &cli.Command{
+	Name:  "migrate",
+	Usage: "move the unsynced commands and the sync cursor to another storage engine",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "engine to migrate from: file, bolt or segment",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "engine to migrate to (default: storage.engine from the config)",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only report what would be migrated",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-source",
+			Usage: "leave the source data in place instead of retiring it",
+		},
+	},
+	Action: commandStorageMigrate,
+}
+
+var StorageFsckCommand *cli.Command = &cli.Command{
+	Name:  "fsck",
+	Usage: "check the local command storage for malformed, duplicated and orphaned records and a bad sync cursor",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "engine",
+			Usage: "engine to check (default: storage.engine from the config)",
+		},
+		&cli.BoolFlag{
+			Name:  "repair",
+			Usage: "quarantine bad records, drop duplicates and rebuild the cursor",
+		},
+	},
+	Action: commandStorageFsck,
+}
+
+var StorageRotateKeyCommand *cli.Command = &cli.Command{
+	Name:  "rotate-key",
+	Usage: "re-encrypt the local command storage with a new key",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "new-key-command",
+			Usage: "command printing the new key, required when storage.encryption.keyCommand is set",
+		},
+	},
+	Action: commandStorageRotateKey,
+}
+
+func validStorageEngine(engine string) bool {
+	switch engine {
+	case model.StorageEngineFile, model.StorageEngineBolt, model.StorageEngineSegment:
+		return true
+	}
+	return false
+}
+
+func commandStorageMigrate(c *cli.Context) error {
+	ctx, span := commandTracer.Start(c.Context, "storage.migrate", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	cfg, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	configured := model.StorageEngineFile
+	if cfg.Storage != nil && cfg.Storage.Engine != "" {
+		configured = cfg.Storage.Engine
+	}
+	from, to := c.String("from"), c.String("to")
+	if to == "" {
+		to = configured
+	}
+	if !validStorageEngine(from) || !validStorageEngine(to) {
+		return fmt.Errorf("unsupported storage engine: use %s, %s or %s", model.StorageEngineFile, model.StorageEngineBolt, model.StorageEngineSegment)
+	}
+	if from == to {
+		return fmt.Errorf("--from and --to are both %s", from)
+	}
+	if to != configured && !c.Bool("dry-run") {
+		// the CLI and the daemon pick the engine from the config, so a migration
+		// to another engine would leave them writing to the old one
+		return fmt.Errorf("storage.engine is %s in your config, set it to %s before migrating", configured, to)
+	}
+
+	req := daemon.StorageMigrateRequest{From: from, To: to, DryRun: c.Bool("dry-run"), KeepSource: c.Bool("keep-source")}
+
+	var report *model.MigrationReport
+	if daemon.IsSocketReady(ctx, cfg.SocketPath) {
+		// the daemon may hold the bolt DB; let it migrate and switch over
+		resp, err := daemon.RequestStorageMigrate(cfg.SocketPath, req, daemon.StorageRequestTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to reach the daemon: %w", err)
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("daemon migration failed: %s", resp.Error)
+		}
+		report = resp.Report
+	} else {
+		model.ConfigureStorageEncryption(cfg)
+		report, err = migrateStorageLocally(c, req)
+		if err != nil {
+			return err
+		}
+	}
+
+	printMigrationReport(report)
+	return nil
+}
+
+// migrateStorageLocally opens both stores in this process. Without a daemon
+// nothing else writes the bolt DB; a held lock makes the open fail.
+func migrateStorageLocally(c *cli.Context, req daemon.StorageMigrateRequest) (*model.MigrationReport, error) {
+	open := func(engine string) (model.CommandStore, error) {
+		return model.NewCommandStore(model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: engine}})
+	}
+	from, err := open(req.From)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s store: %w", req.From, err)
+	}
+	defer from.Close()
+	to, err := open(req.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s store: %w", req.To, err)
+	}
+	defer to.Close()
+
+	report, err := model.MigrateStore(c.Context, from, to, model.MigrateOptions{DryRun: req.DryRun, KeepSource: req.KeepSource})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func printMigrationReport(report *model.MigrationReport) {
+	if report.DryRun {
+		fmt.Printf("Dry run: %s -> %s\n", report.From, report.To)
+	} else {
+		fmt.Printf("Migrated %s -> %s\n", report.From, report.To)
+	}
+	fmt.Printf("  pre:      %d\n", report.Pre)
+	fmt.Printf("  post:     %d\n", report.Post)
+	fmt.Printf("  sessions: %d\n", report.Sessions)
+	if !report.DryRun {
+		fmt.Printf("  copied:   %d (already present: %d)\n", report.Copied, report.Skipped)
+		fmt.Printf("  archived: %d\n", report.Archived)
+	}
+	if !report.Cursor.IsZero() {
+		fmt.Printf("  cursor:   %s\n", report.Cursor.Format(time.RFC3339))
+	}
+	fmt.Printf("  digest:   %s\n", report.Digest)
+	if report.RetiredTo != "" {
+		fmt.Printf("Old data moved to %s\n", report.RetiredTo)
+	}
+}
+
+func commandStorageFsck(c *cli.Context) error {
+	ctx, span := commandTracer.Start(c.Context, "storage.fsck", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	cfg, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	engine := c.String("engine")
+	if engine == "" {
+		engine = model.StorageEngineFile
+		if cfg.Storage != nil && cfg.Storage.Engine != "" {
+			engine = cfg.Storage.Engine
+		}
+	}
+	if !validStorageEngine(engine) {
+		return fmt.Errorf("unsupported storage engine: use %s, %s or %s", model.StorageEngineFile, model.StorageEngineBolt, model.StorageEngineSegment)
+	}
+
+	req := daemon.StorageFsckRequest{Engine: engine, Repair: c.Bool("repair")}
+
+	var report *model.FsckReport
+	if daemon.IsSocketReady(ctx, cfg.SocketPath) {
+		resp, err := daemon.RequestStorageFsck(cfg.SocketPath, req, daemon.StorageRequestTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to reach the daemon: %w", err)
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("daemon storage check failed: %s", resp.Error)
+		}
+		report = resp.Report
+	} else {
+		model.ConfigureStorageEncryption(cfg)
+		store, err := model.NewCommandStore(model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: engine}})
+		if err != nil {
+			return fmt.Errorf("failed to open %s store: %w", engine, err)
+		}
+		defer store.Close()
+		r, err := model.FsckStore(ctx, store, model.FsckOptions{Repair: req.Repair})
+		if err != nil {
+			return err
+		}
+		report = &r
+	}
+
+	printFsckReport(report)
+	if len(report.Issues) > 0 && !report.Repaired {
+		return fmt.Errorf("found %d problems, run `shelltime storage fsck --repair` to fix them", len(report.Issues))
+	}
+	return nil
+}
+
+func printFsckReport(report *model.FsckReport) {
+	fmt.Printf("Checked %d %s records\n", report.Records, report.Engine)
+	for _, issue := range report.Issues {
+		fmt.Printf("  %-14s %-8s %s", issue.Problem, issue.Kind, issue.Location)
+		if issue.Detail != "" {
+			fmt.Printf(" (%s)", issue.Detail)
+		}
+		fmt.Println()
+	}
+	if len(report.Issues) == 0 {
+		color.Green.Println("✅ No problems found")
+		return
+	}
+
+	fmt.Println()
+	for _, problem := range []string{model.FsckMalformed, model.FsckDuplicate, model.FsckOrphanedPre, model.FsckCursorAhead, model.FsckCursorInvalid} {
+		if n := report.Count(problem); n > 0 {
+			fmt.Printf("  %s: %d\n", problem, n)
+		}
+	}
+	if !report.Repaired {
+		return
+	}
+	if report.QuarantinedTo != "" {
+		fmt.Printf("Removed records saved to %s\n", report.QuarantinedTo)
+	}
+	if !report.Cursor.IsZero() {
+		fmt.Printf("Cursor rebuilt to %s\n", report.Cursor.Format(time.RFC3339))
+	}
+	color.Green.Println("✅ Repaired")
+}
+
+func commandStorageRotateKey(c *cli.Context) error {
+	ctx, span := commandTracer.Start(c.Context, "storage.rotateKey", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	cfg, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	req := daemon.StorageRotateKeyRequest{NewKeyCommand: c.String("new-key-command")}
+
+	var rotation *model.StorageKeyRotation
+	if daemon.IsSocketReady(ctx, cfg.SocketPath) {
+		// the daemon may hold the bolt DB, and pauses the track events
+		resp, err := daemon.RequestStorageRotateKey(cfg.SocketPath, req, daemon.StorageRequestTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to reach the daemon: %w", err)
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("daemon key rotation failed: %s", resp.Error)
+		}
+		rotation = resp.Rotation
+	} else {
+		model.ConfigureStorageEncryption(cfg)
+		stores := []model.CommandStore{model.NewFileStore()}
+		if cfg.Storage != nil && cfg.Storage.Engine != "" && cfg.Storage.Engine != model.StorageEngineFile {
+			store, err := model.NewCommandStore(model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: cfg.Storage.Engine}})
+			if err != nil {
+				return fmt.Errorf("failed to open %s store: %w", cfg.Storage.Engine, err)
+			}
+			defer store.Close()
+			stores = append(stores, store)
+		}
+		r, err := model.RotateStorageKey(ctx, stores, req.NewKeyCommand)
+		if err != nil {
+			return err
+		}
+		rotation = &r
+	}
+
+	fmt.Printf("Re-encrypted %d records: key %s -> %s\n", rotation.Resealed, rotation.OldKeyID, rotation.NewKeyID)
+	if rotation.KeyFile != "" {
+		fmt.Printf("New key saved to %s, the old one to %s.old\n", rotation.KeyFile, rotation.KeyFile)
+	} else {
+		color.Yellow.Printf("⚠️ set storage.encryption.keyCommand to %q in your config, the stored commands can only be read with the new key\n", req.NewKeyCommand)
+	}
+	color.Green.Println("✅ Key rotated")
+	return nil
+}
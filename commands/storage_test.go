@@ -0,0 +1,155 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestStorageMigrateCommand_WithoutDaemon(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		SocketPath: filepath.Join(home, "missing.sock"),
+		Storage:    &model.StorageConfig{Engine: model.StorageEngineSegment},
+	}, nil)
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+	cmd := model.Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Hostname: "h", Time: time.Now()}
+	require.NoError(t, store.SavePre(ctx, cmd, cmd.Time))
+	require.NoError(t, store.SavePost(ctx, cmd, 0, cmd.Time.Add(time.Second)))
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{StorageCommand}}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "storage", "migrate", "--from", "file"}))
+	})
+	assert.Contains(t, out, "Migrated file -> segment")
+	assert.Contains(t, out, "Old data moved to")
+
+	segment, err := model.NewCommandStore(model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: model.StorageEngineSegment}})
+	require.NoError(t, err)
+	posts, err := segment.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "make", posts[0].Command)
+}
+
+func TestStorageMigrateCommand_RejectsSameEngine(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{}, nil)
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{StorageCommand}}
+	err := app.Run([]string{"t", "storage", "migrate", "--from", "file", "--to", "file"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "both file")
+
+	err = app.Run([]string{"t", "storage", "migrate", "--from", "sqlite"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported storage engine")
+}
+
+func TestStorageMigrateCommand_RefusesEngineNotInConfig(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{SocketPath: filepath.Join(home, "missing.sock")}, nil)
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{StorageCommand}}
+	err := app.Run([]string{"t", "storage", "migrate", "--from", "file", "--to", "bolt"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "set it to bolt before migrating")
+	_, err = os.Stat(model.GetBoltDBPath())
+	assert.True(t, os.IsNotExist(err), "nothing is migrated")
+
+	// a dry run only reports
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "storage", "migrate", "--from", "file", "--to", "bolt", "--dry-run"}))
+	})
+	assert.Contains(t, out, "Dry run: file -> bolt")
+}
+
+func TestStorageFsckCommand_ReportsAndRepairs(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{SocketPath: filepath.Join(home, "missing.sock")}, nil)
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+	cmd := model.Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Hostname: "h", Time: time.Now()}
+	require.NoError(t, store.SavePost(ctx, cmd, 0, cmd.Time))
+	require.NoError(t, store.SavePost(ctx, model.Command{}, 0, cmd.Time))
+	f, err := os.OpenFile(model.GetPostCommandFilePath(), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("garbage\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{StorageCommand}}
+	var runErr error
+	out := captureStdout(t, func() {
+		runErr = app.Run([]string{"t", "storage", "fsck"})
+	})
+	require.Error(t, runErr)
+	assert.Contains(t, runErr.Error(), "--repair")
+	assert.Contains(t, out, "malformed")
+	assert.Contains(t, out, "post.txt:3")
+
+	out = captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "storage", "fsck", "--repair"}))
+	})
+	assert.Contains(t, out, "Removed records saved to")
+
+	out = captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "storage", "fsck"}))
+	})
+	assert.Contains(t, out, "Checked 2 file records")
+}
+
+func TestStorageRotateKeyCommand_WithoutDaemon(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	enabled := true
+	encryption := &model.StorageEncryptionConfig{Enabled: &enabled}
+	cfg := model.ShellTimeConfig{
+		SocketPath: filepath.Join(home, "missing.sock"),
+		Storage:    &model.StorageConfig{Encryption: encryption},
+	}
+	mc.On("ReadConfigFile", mock.Anything).Return(cfg, nil)
+	// the real config service applies the settings when it reads the config
+	model.ConfigureStorageEncryption(cfg)
+	t.Cleanup(func() { model.ConfigureStorageEncryption(model.ShellTimeConfig{}) })
+
+	ctx := context.Background()
+	cmd := model.Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Hostname: "h", Time: time.Now()}
+	require.NoError(t, model.NewFileStore().SavePost(ctx, cmd, 0, cmd.Time))
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{StorageCommand}}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "storage", "rotate-key"}))
+	})
+	assert.Contains(t, out, "Re-encrypted 1 records")
+	assert.Contains(t, out, "New key saved to "+model.GetStorageKeyFilePath())
+
+	posts, err := model.NewFileStore().GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "make", posts[0].Command)
+
+	// a key command needs the command printing the new key
+	encryption.KeyCommand = "cat " + model.GetStorageKeyFilePath()
+	model.ConfigureStorageEncryption(cfg)
+	err = app.Run([]string{"t", "storage", "rotate-key"})
+	assert.ErrorContains(t, err, "pass the command printing the new key")
+}
@@ -13,6 +13,9 @@ var SyncCommand *cli.Command = &cli.Command{
 	Name:   "sync",
 	Usage:  "manually sync local commands to server",
 	Action: commandSync,
+	Subcommands: []*cli.Command{
+		SyncPendingCommand,
+	},
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
 			Name:        "dry-run",
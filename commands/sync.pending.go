@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/malamtime/cli/daemon"
+	"github.com/malamtime/cli/model"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var SyncPendingCommand *cli.Command = &cli.Command{
+	Name:  "pending",
+	Usage: "inspect the payloads that failed to sync and wait for a retry",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "list the pending payloads with their age, type and size",
+			Action: commandSyncPendingList,
+		},
+		{
+			Name:   "retry",
+			Usage:  "make the daemon replay the pending payloads now",
+			Action: commandSyncPendingRetry,
+		},
+		{
+			Name:  "drop",
+			Usage: "discard the pending payloads older than a duration",
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:     "older-than",
+					Usage:    "age from which payloads are discarded, e.g. 72h",
+					Required: true,
+				},
+			},
+			Action: commandSyncPendingDrop,
+		},
+		{
+			Name:      "show",
+			Usage:     "print the pending payload with the given number from list",
+			ArgsUsage: "<n>",
+			Action:    commandSyncPendingShow,
+		},
+	},
+}
+
+// requestSyncPending runs req in the daemon when it is running, since it
+// appends to and replays the pending files, and on the files otherwise.
+func requestSyncPending(c *cli.Context, action string, req daemon.SyncPendingRequest) (*daemon.SyncPendingResponse, model.ShellTimeConfig, error) {
+	ctx, span := commandTracer.Start(c.Context, "sync.pending."+action, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	cfg, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return nil, cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	req.Action = action
+	if daemon.IsSocketReady(ctx, cfg.SocketPath) {
+		resp, err := daemon.RequestSyncPending(cfg.SocketPath, req, daemon.SyncPendingRequestTimeout)
+		if err != nil {
+			return nil, cfg, fmt.Errorf("failed to reach the daemon: %w", err)
+		}
+		if resp.Error != "" {
+			return nil, cfg, errors.New(resp.Error)
+		}
+		return resp, cfg, nil
+	}
+
+	model.ConfigureStorageEncryption(cfg)
+	resp := &daemon.SyncPendingResponse{}
+	switch action {
+	case daemon.SyncPendingList:
+		resp.Payloads, err = model.ListPendingPayloads()
+	case daemon.SyncPendingShow:
+		var payload model.PendingPayload
+		payload, resp.Payload, err = model.ReadPendingPayload(req.Index)
+		resp.Payloads = []model.PendingPayload{payload}
+	case daemon.SyncPendingDrop:
+		resp.Dropped, err = model.DropPendingPayloads(req.OlderThan)
+	case daemon.SyncPendingRetry:
+		err = errors.New("the daemon is not running: start it with `shelltime daemon install`, or run `shelltime sync` to sync the local commands")
+	}
+	return resp, cfg, err
+}
+
+func commandSyncPendingList(c *cli.Context) error {
+	resp, cfg, err := requestSyncPending(c, daemon.SyncPendingList, daemon.SyncPendingRequest{})
+	if err != nil {
+		return err
+	}
+	if len(resp.Payloads) == 0 {
+		fmt.Println("No pending payloads")
+		return nil
+	}
+
+	endpoints := pendingEndpointNames(cfg)
+	t := tablewriter.NewWriter(os.Stdout)
+	t.Header([]string{"#", "Type", "Endpoint", "Items", "Size", "Age", "File"})
+	for _, p := range resp.Payloads {
+		t.Append([]string{
+			strconv.Itoa(p.Index),
+			p.Kind,
+			pendingEndpointName(endpoints, p),
+			strconv.Itoa(p.Items),
+			formatPendingSize(p.Size),
+			formatPendingAge(p.At),
+			p.File,
+		})
+	}
+	t.Render()
+	return nil
+}
+
+func commandSyncPendingRetry(c *cli.Context) error {
+	resp, _, err := requestSyncPending(c, daemon.SyncPendingRetry, daemon.SyncPendingRequest{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Replaying %d pending payloads, see `shelltime daemon status` for the result\n", resp.Retried)
+	return nil
+}
+
+func commandSyncPendingDrop(c *cli.Context) error {
+	olderThan := c.Duration("older-than")
+	if olderThan <= 0 {
+		return errors.New("--older-than must be positive")
+	}
+	resp, _, err := requestSyncPending(c, daemon.SyncPendingDrop, daemon.SyncPendingRequest{OlderThan: olderThan})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dropped %d pending payloads older than %s\n", resp.Dropped, olderThan)
+	return nil
+}
+
+func commandSyncPendingShow(c *cli.Context) error {
+	index, err := strconv.Atoi(c.Args().First())
+	if err != nil || index <= 0 {
+		return errors.New("usage: shelltime sync pending show <n>, with n from `shelltime sync pending list`")
+	}
+	resp, cfg, err := requestSyncPending(c, daemon.SyncPendingShow, daemon.SyncPendingRequest{Index: index})
+	if err != nil {
+		return err
+	}
+
+	p := resp.Payloads[0]
+	fmt.Printf("#%d %s, %d items, %s, %s old\n", p.Index, p.Kind, p.Items, formatPendingSize(p.Size), formatPendingAge(p.At))
+	fmt.Printf("File:     %s\n", p.File)
+	if p.Kind == model.PendingKindSync {
+		fmt.Printf("Endpoint: %s\n", pendingEndpointName(pendingEndpointNames(cfg), p))
+	}
+	var out bytes.Buffer
+	if json.Indent(&out, resp.Payload, "", "  ") != nil {
+		// an invalid line is printed as it is
+		out.Reset()
+		out.Write(resp.Payload)
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// pendingEndpointNames maps the endpoint keys of the config to their URLs.
+func pendingEndpointNames(cfg model.ShellTimeConfig) map[string]string {
+	names := make(map[string]string)
+	for _, e := range model.SyncEndpoints(cfg) {
+		names[model.EndpointKey(e)] = e.APIEndpoint
+	}
+	return names
+}
+
+func pendingEndpointName(names map[string]string, p model.PendingPayload) string {
+	switch {
+	case p.Kind != model.PendingKindSync:
+		return "-"
+	case p.Endpoint == "":
+		return "all"
+	case names[p.Endpoint] != "":
+		return names[p.Endpoint]
+	}
+	// an endpoint removed from the config since
+	return p.Endpoint
+}
+
+func formatPendingSize(size int) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+func formatPendingAge(at time.Time) string {
+	age := time.Since(at)
+	switch {
+	case age >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(age/(24*time.Hour)))
+	case age >= time.Minute:
+		return age.Truncate(time.Minute).String()
+	}
+	return age.Truncate(time.Second).String()
+}
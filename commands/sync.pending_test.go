@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestSyncPendingCommand_WithoutDaemon(t *testing.T) {
+	mc := setupGrepActionTest(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	model.InitFolder("")
+	mirror := model.Endpoint{Token: "t2", APIEndpoint: "https://mirror"}
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		SocketPath:  filepath.Join(home, "missing.sock"),
+		Token:       "t",
+		APIEndpoint: "https://main",
+		Endpoints:   []model.Endpoint{mirror},
+	}, nil)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(home, model.COMMAND_BASE_STORAGE_FOLDER), 0755))
+	old := time.Now().Add(-72 * time.Hour)
+	line, err := json.Marshal(map[string]interface{}{
+		"type": "sync",
+		"payload": map[string]interface{}{
+			"cursorId": old.UnixNano(),
+			"data":     []model.TrackingData{{Command: "make deploy", EndTimeNano: old.UnixNano()}},
+			"endpoint": model.EndpointKey(mirror),
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, model.AppendPendingLine(filepath.Join(home, model.SyncPendingFileFor(model.EndpointKey(mirror))), line))
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{SyncCommand}}
+	out := captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "sync", "pending", "list"}))
+	})
+	assert.Contains(t, out, "https://mirror")
+	assert.Contains(t, out, "3d")
+
+	out = captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "sync", "pending", "show", "1"}))
+	})
+	assert.Contains(t, out, "Endpoint: https://mirror")
+	assert.Contains(t, out, `"command": "make deploy"`)
+
+	err = app.Run([]string{"t", "sync", "pending", "retry"})
+	assert.ErrorContains(t, err, "daemon is not running")
+
+	out = captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "sync", "pending", "drop", "--older-than", "48h"}))
+	})
+	assert.Contains(t, out, "Dropped 1 pending payloads")
+	out = captureStdout(t, func() {
+		require.NoError(t, app.Run([]string{"t", "sync", "pending", "list"}))
+	})
+	assert.Contains(t, out, "No pending payloads")
+}
+
+func TestSyncPendingCommand_ShowNeedsIndex(t *testing.T) {
+	setupGrepActionTest(t)
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{SyncCommand}}
+	err := app.Run([]string{"t", "sync", "pending", "show", "first"})
+	assert.ErrorContains(t, err, "usage: shelltime sync pending show <n>")
+}
+
+func TestFormatPendingSizeAndAge(t *testing.T) {
+	assert.Equal(t, "512 B", formatPendingSize(512))
+	assert.Equal(t, "1.5 KB", formatPendingSize(1536))
+	assert.Equal(t, "2.0 MB", formatPendingSize(2<<20))
+	assert.Equal(t, "5m0s", formatPendingAge(time.Now().Add(-5*time.Minute-time.Second)))
+	assert.Equal(t, "3d", formatPendingAge(time.Now().Add(-73*time.Hour)))
+}
@@ -2,8 +2,11 @@ package commands
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/malamtime/cli/daemon"
@@ -50,6 +53,31 @@ var TrackCommand *cli.Command = &cli.Command{
 			Value: 0,
 			Usage: "Parent process ID of the shell (for terminal detection)",
 		},
+		&cli.StringFlag{
+			Name:  "cwd",
+			Value: "",
+			Usage: "working directory the command ran in",
+		},
+		&cli.StringFlag{
+			Name:  "time",
+			Usage: "event time measured by the shell, as epoch seconds with a fractional part ($EPOCHREALTIME)",
+		},
+		&cli.Int64Flag{
+			Name:  "duration",
+			Usage: "command duration in milliseconds measured by the shell (fish $CMD_DURATION)",
+		},
+		&cli.StringFlag{
+			Name:  "pipestatus",
+			Usage: "space separated exit codes of every pipeline stage ($pipestatus / $PIPESTATUS)",
+		},
+		&cli.BoolFlag{
+			Name:  "stream",
+			Usage: "keep running and read newline-delimited track events from stdin (one process per shell session)",
+		},
+		&cli.IntFlag{
+			Name:  "shell-pid",
+			Usage: "with --stream, the pid of the shell writing the events; the stream stops once it exits",
+		},
 	},
 	Action: commandTrack,
 	OnUsageError: func(cCtx *cli.Context, err error, isSubcommand bool) error {
@@ -58,6 +86,10 @@ var TrackCommand *cli.Command = &cli.Command{
 }
 
 func commandTrack(c *cli.Context) error {
+	if c.Bool("stream") {
+		return commandTrackStream(c)
+	}
+
 	ctx, span := commandTracer.Start(c.Context, "track", trace.WithSpanKind(trace.SpanKindClient))
 	defer span.End()
 	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
@@ -78,27 +110,116 @@ func commandTrack(c *cli.Context) error {
 	cmdPhase := c.String("phase")
 	result := c.Int("result")
 	ppid := c.Int("ppid")
+	cwd := c.String("cwd")
 
 	instance := &model.Command{
 		Shell:     shell,
 		SessionID: sessionId,
 		Command:   cmdCommand,
+		Main:      model.MainCommand(cmdCommand),
 		Hostname:  hostname,
 		Username:  username,
 		Time:      time.Now(),
 		Phase:     model.CommandPhasePre,
 		PPID:      ppid,
+		Cwd:       cwd,
+		Duration:  time.Duration(c.Int64("duration")) * time.Millisecond,
+	}
+	if err := applyShellTiming(instance, c.String("time"), c.String("pipestatus")); err != nil {
+		slog.Warn("ignoring invalid shell timing", slog.Any("err", err))
+	}
+
+	return dispatchTrackEvent(ctx, span, cmdPhase, instance, result)
+}
+
+// parseShellEpoch parses an epoch timestamp with a fractional part as printed
+// by $EPOCHREALTIME. Bash formats it with the locale's decimal separator, so a
+// comma is accepted too.
+func parseShellEpoch(s string) (time.Time, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", ".", 1)
+	secPart, fracPart, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secPart, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid epoch time %q: %w", s, err)
+	}
+	var nsec int64
+	if fracPart != "" {
+		if len(fracPart) > 9 {
+			fracPart = fracPart[:9]
+		}
+		frac, err := strconv.ParseInt(fracPart, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch time %q: %w", s, err)
+		}
+		for i := len(fracPart); i < 9; i++ {
+			frac *= 10
+		}
+		nsec = frac
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// parsePipeStatus parses a space separated list of exit codes.
+func parsePipeStatus(s string) ([]int, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	codes := make([]int, 0, len(fields))
+	for _, f := range fields {
+		code, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pipestatus %q: %w", s, err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// applyShellTiming overrides the process clock with the time the shell
+// measured, since the hook's own startup would otherwise skew it, and records
+// the per-stage exit codes. Empty values leave the command untouched.
+func applyShellTiming(instance *model.Command, epoch, pipestatus string) error {
+	if epoch != "" {
+		t, err := parseShellEpoch(epoch)
+		if err != nil {
+			return err
+		}
+		instance.Time = t
+	}
+	codes, err := parsePipeStatus(pipestatus)
+	if err != nil {
+		return err
 	}
+	instance.PipeStatus = codes
+	return nil
+}
+
+// dispatchTrackEvent hands a single pre/post event to the daemon, or persists
+// it locally and syncs when no daemon is running. It is shared by the one-shot
+// `track` invocation and the long-lived `track --stream` session process.
+func dispatchTrackEvent(ctx context.Context, span trace.Span, cmdPhase string, instance *model.Command, result int) error {
+	cmdCommand := instance.Command
 
 	// Fast path: `track` runs inside the shell hook on every command, so it must
 	// stay cheap. A fresh `shelltime track` process is spawned per command, which
 	// means the in-memory config cache never helps and reading the config would add
 	// two TOML file reads to every command. Instead, if a daemon is listening on the
-	// default socket, hand it the raw event (fire-and-forget) and return. The daemon
-	// is a long-lived process: it reads config once (cached) and owns the
-	// storage-engine decision (bolt vs txt), exclude filtering, sync and pruning.
+	// default socket, hand it the raw event; it acknowledges the event as soon as
+	// it is queued. The daemon is a long-lived process: it reads config once
+	// (cached) and owns the storage-engine decision (bolt vs txt), exclude
+	// filtering, sync and pruning.
 	if daemon.IsSocketReady(ctx, model.DefaultSocketPath) {
-		return sendTrackEventToDaemon(ctx, span, model.DefaultSocketPath, cmdPhase, instance, result)
+		err := sendTrackEventToDaemon(ctx, span, model.DefaultSocketPath, cmdPhase, instance, result)
+		if err == nil {
+			return nil
+		}
+		config, cfgErr := configService.ReadConfigFile(ctx)
+		if cfgErr != nil {
+			slog.Error("failed to read config file", slog.Any("err", cfgErr))
+			return err
+		}
+		return saveTrackEventAfterDaemonError(ctx, span, config, cmdPhase, instance, result, err)
 	}
 
 	// Slow path: no daemon on the default socket. We can now afford to read config —
@@ -118,29 +239,57 @@ func commandTrack(c *cli.Context) error {
 
 	// A daemon may still be listening on a non-default socket path.
 	if config.SocketPath != model.DefaultSocketPath && daemon.IsSocketReady(ctx, config.SocketPath) {
-		return sendTrackEventToDaemon(ctx, span, config.SocketPath, cmdPhase, instance, result)
+		err := sendTrackEventToDaemon(ctx, span, config.SocketPath, cmdPhase, instance, result)
+		if err == nil {
+			return nil
+		}
+		return saveTrackEventAfterDaemonError(ctx, span, config, cmdPhase, instance, result, err)
+	}
+
+	// No daemon at all: persist to the local store and sync directly over HTTP.
+	if err := saveTrackEvent(ctx, span, config, cmdPhase, instance, result); err != nil {
+		return err
+	}
+	if cmdPhase == "post" {
+		return trySyncLocalToServer(ctx, config, syncOptions{
+			isDryRun:    false,
+			isForceSync: false,
+		})
+	}
+	return nil
+}
+
+// saveTrackEventAfterDaemonError keeps an event the daemon didn't take (it
+// timed out, rejected it or speaks another protocol) in the local store rather
+// than dropping it. The daemon shares the txt and segment stores, so it syncs
+// the event with the others; with the bolt engine the event waits in the txt
+// files for a sync without the daemon. An event the daemon queued but didn't
+// acknowledge in time may be stored twice.
+func saveTrackEventAfterDaemonError(ctx context.Context, span trace.Span, config model.ShellTimeConfig, cmdPhase string, instance *model.Command, result int, daemonErr error) error {
+	slog.Warn("failed to send the command to the daemon, saving it locally", slog.String("phase", cmdPhase), slog.Any("err", daemonErr))
+	if model.ShouldExcludeCommand(instance.Command, config.Exclude) {
+		return nil
 	}
+	return saveTrackEvent(ctx, span, config, cmdPhase, instance, result)
+}
 
-	// No daemon at all: persist to the local txt store and sync directly over HTTP.
+// saveTrackEvent persists a pre/post event to the store the CLI writes to
+// without a daemon.
+func saveTrackEvent(ctx context.Context, span trace.Span, config model.ShellTimeConfig, cmdPhase string, instance *model.Command, result int) error {
+	var err error
+	store := model.NewLocalStore(config)
 	if cmdPhase == "pre" {
 		span.SetAttributes(attribute.Int("phase", 0))
-		err = instance.DoSavePre()
+		err = store.SavePre(ctx, *instance, time.Now())
 	}
 	if cmdPhase == "post" {
 		span.SetAttributes(attribute.Int("phase", 1))
-		err = instance.DoUpdate(result)
+		err = store.SavePost(ctx, *instance, result, time.Now())
 	}
 	if err != nil {
 		slog.Error("failed to save/update command", slog.Any("err", err))
 		return err
 	}
-
-	if cmdPhase == "post" {
-		return trySyncLocalToServer(ctx, config, syncOptions{
-			isDryRun:    false,
-			isForceSync: false,
-		})
-	}
 	return nil
 }
 
@@ -173,9 +322,9 @@ func trySyncLocalToServer(
 	isForceSync := options.isForceSync
 	isDryRun := options.isDryRun
 
-	// The local sync path always uses the txt file store. It must never open the
-	// bolt DB, which the daemon owns exclusively.
-	store := model.NewFileStore()
+	// The local sync path uses the store the CLI writes to without a daemon. It
+	// must never open the bolt DB, which the daemon owns exclusively.
+	store := model.NewLocalStore(config)
 
 	result, err := model.BuildTrackingData(ctx, store, config)
 	if err != nil {
@@ -196,16 +345,34 @@ func trySyncLocalToServer(
 		}
 	}
 
-	err = DoSyncData(ctx, config, result.LatestRecordingTime, result.Data, result.Meta)
+	// every endpoint advances its cursor with each chunk it acknowledged, so
+	// a failed sync resumes after the last one that endpoint accepted; the
+	// store's cursor trails the slowest endpoint
+	var opts model.SyncOptions
+	var cursors *model.EndpointCursors
+	if !isDryRun {
+		cursors, err = model.LoadEndpointCursors(ctx, store, config, result.Cursor)
+		if err != nil {
+			return err
+		}
+		opts = model.SyncOptions{Cursors: cursors.Cursors(), OnAck: cursors.Ack}
+	}
+	err = DoSyncData(ctx, config, result.LatestRecordingTime, result.Data, result.Sessions, result.Meta, opts)
+	if cursors != nil {
+		if synced := cursors.Synced(); synced.After(result.Cursor) {
+			if cursorErr := store.SetCursor(ctx, synced); cursorErr != nil {
+				slog.Error("Failed to advance cursor", slog.Any("err", cursorErr))
+				if err == nil {
+					err = cursorErr
+				}
+			}
+		}
+	}
 	if err != nil {
 		slog.Error("Failed to send data to server", slog.Any("err", err))
 		return err
 	}
-
-	if isDryRun {
-		return nil
-	}
-	return store.SetCursor(ctx, result.LatestRecordingTime)
+	return nil
 }
 
 func DoSyncData(
@@ -213,7 +380,9 @@ func DoSyncData(
 	config model.ShellTimeConfig,
 	cursor time.Time,
 	trackingData []model.TrackingData,
+	sessions []model.TrackingSessionData,
 	meta model.TrackingMetaData,
+	opts model.SyncOptions,
 ) error {
 	socketPath := config.SocketPath
 	isSocketReady := daemon.IsSocketReady(ctx, socketPath)
@@ -226,9 +395,20 @@ func DoSyncData(
 			CursorID: cursor.UnixNano(),
 			Data:     trackingData,
 			Meta:     meta,
-		})
+			Sessions: sessions,
+		}, opts)
 	}
 
-	// send to socket if the socket is ready
-	return daemon.SendLocalDataToSocket(ctx, socketPath, config, cursor, trackingData, meta)
+	// send to socket if the socket is ready; the daemon owns the upload from here
+	if err := daemon.SendLocalDataToSocket(ctx, socketPath, config, cursor, trackingData, sessions, meta); err != nil {
+		return err
+	}
+	if opts.OnAck != nil {
+		for _, endpoint := range model.SyncEndpoints(config) {
+			if err := opts.OnAck(ctx, endpoint, cursor); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
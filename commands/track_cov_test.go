@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"net"
@@ -94,6 +95,7 @@ func TestX3CommandTrack_PrePersistsLocally(t *testing.T) {
 	data, err := os.ReadFile(filepath.Join(model.GetCommandsStoragePath(), "pre.txt"))
 	require.NoError(t, err)
 	assert.Contains(t, string(data), "echo hi")
+	assert.Contains(t, string(data), `"main":"echo"`)
 }
 
 // TestX3CommandTrack_PostSavesAndSyncs covers the post branch end-to-end:
@@ -240,6 +242,61 @@ func TestX3TrySyncLocalToServer_SyncErrorPropagates(t *testing.T) {
 	require.Error(t, err)
 }
 
+// TestTrySyncLocalToServer_ResumesAfterAckedChunk covers the chunked upload: a
+// failure on the second chunk keeps the cursor of the first, and the next sync
+// only sends the rest.
+func TestTrySyncLocalToServer_ResumesAfterAckedChunk(t *testing.T) {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	SKIP_LOGGER_SETTINGS = true
+	t.Setenv("HOME", t.TempDir())
+
+	ctx := context.Background()
+	store := model.NewFileStore()
+	start := time.Now().Add(-time.Hour)
+	total := model.SyncChunkMaxCommands + 5
+	for i := 0; i < total; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		cmd := model.Command{Shell: "bash", SessionID: 1, Command: "make", Username: "u", Hostname: "h", Time: at}
+		require.NoError(t, store.SavePre(ctx, cmd, at))
+		require.NoError(t, store.SavePost(ctx, cmd, 0, at))
+	}
+
+	var (
+		calls int32
+		sent  []int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var p model.PostTrackArgs
+		require.NoError(t, json.NewDecoder(body).Decode(&p))
+		sent = append(sent, len(p.Data))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := model.ShellTimeConfig{
+		Token:       "tok",
+		APIEndpoint: srv.URL,
+		SocketPath:  filepath.Join(t.TempDir(), "absent.sock"),
+		FlushCount:  1,
+	}
+	require.Error(t, trySyncLocalToServer(ctx, cfg, syncOptions{}))
+	cursor, _, err := store.GetLastCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(time.Duration(model.SyncChunkMaxCommands-1)*time.Second).UnixNano(), cursor.UnixNano())
+
+	require.NoError(t, trySyncLocalToServer(ctx, cfg, syncOptions{}))
+	assert.Equal(t, []int{model.SyncChunkMaxCommands, 5}, sent)
+	cursor, _, err = store.GetLastCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(time.Duration(total-1)*time.Second).UnixNano(), cursor.UnixNano())
+}
+
 // --- DoSyncData direct ---------------------------------------------------------
 
 // TestX3DoSyncData_HTTPWhenSocketUnready covers the HTTP branch of DoSyncData
@@ -262,7 +319,7 @@ func TestX3DoSyncData_HTTPWhenSocketUnready(t *testing.T) {
 	}
 	data := []model.TrackingData{{Command: "ls", Result: 0}}
 	meta := model.TrackingMetaData{OS: "linux", Shell: "bash"}
-	require.NoError(t, DoSyncData(context.Background(), cfg, time.Now(), data, meta))
+	require.NoError(t, DoSyncData(context.Background(), cfg, time.Now(), data, nil, meta, model.SyncOptions{}))
 	assert.NotEmpty(t, gotPath, "HTTP sync endpoint should have been called")
 }
 
@@ -294,7 +351,7 @@ func TestX3DoSyncData_SocketWhenReady(t *testing.T) {
 	cfg := model.ShellTimeConfig{Token: "tok", SocketPath: socketPath}
 	data := []model.TrackingData{{Command: "ls", Result: 0}}
 	meta := model.TrackingMetaData{OS: "linux", Shell: "bash"}
-	require.NoError(t, DoSyncData(context.Background(), cfg, time.Now(), data, meta))
+	require.NoError(t, DoSyncData(context.Background(), cfg, time.Now(), data, nil, meta, model.SyncOptions{}))
 
 	select {
 	case msg := <-got:
@@ -303,3 +360,115 @@ func TestX3DoSyncData_SocketWhenReady(t *testing.T) {
 		t.Fatal("sync message not delivered to socket")
 	}
 }
+
+// TestCommandTrack_PersistsCwd checks that --cwd lands on the stored pre record.
+func TestCommandTrack_PersistsCwd(t *testing.T) {
+	_, mc := x3SetupTrack(t)
+	model.InitFolder("")
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		SocketPath: x3UnreadySocket(t),
+	}, nil)
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{TrackCommand}}
+	require.NoError(t, app.Run([]string{"t", "track", "--phase", "pre", "--shell", "zsh", "--command", "make", "--id", "1", "--cwd", "/src/project"}))
+
+	pres, err := model.NewFileStore().GetPreCommands(context.Background())
+	require.NoError(t, err)
+	require.Len(t, pres, 1)
+	assert.Equal(t, "/src/project", pres[0].Cwd)
+}
+
+func TestParseShellEpoch(t *testing.T) {
+	got, err := parseShellEpoch("1712345678.123456")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1712345678, 123456000), got)
+
+	// bash uses the locale's decimal separator
+	got, err = parseShellEpoch("1712345678,5")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1712345678, 500000000), got)
+
+	got, err = parseShellEpoch("1712345678")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1712345678, 0), got)
+
+	_, err = parseShellEpoch("now")
+	assert.Error(t, err)
+	_, err = parseShellEpoch("1712345678.x")
+	assert.Error(t, err)
+}
+
+func TestParsePipeStatus(t *testing.T) {
+	codes, err := parsePipeStatus("0 1  141")
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 141}, codes)
+
+	codes, err = parsePipeStatus("")
+	require.NoError(t, err)
+	assert.Nil(t, codes)
+
+	_, err = parsePipeStatus("0 x")
+	assert.Error(t, err)
+}
+
+// TestCommandTrack_PersistsShellTiming checks that --time and --pipestatus
+// land on the stored post record.
+func TestCommandTrack_PersistsShellTiming(t *testing.T) {
+	_, mc := x3SetupTrack(t)
+	model.InitFolder("")
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		SocketPath: x3UnreadySocket(t),
+		FlushCount: 100,
+	}, nil)
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{TrackCommand}}
+	require.NoError(t, app.Run([]string{"t", "track", "--phase", "pre", "--shell", "zsh", "--command", "a | b", "--id", "1", "--time", "1712345678"}))
+	require.NoError(t, app.Run([]string{"t", "track", "--phase", "post", "--shell", "zsh", "--command", "a | b", "--id", "1", "-r", "1", "--time", "1712345678.25", "--pipestatus", "0 1"}))
+
+	posts, err := model.NewFileStore().GetPostCommands(context.Background())
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, time.Unix(1712345678, 250000000).UnixNano(), posts[0].Time.UnixNano())
+	assert.Equal(t, []int{0, 1}, posts[0].PipeStatus)
+}
+
+// startRejectingDaemon serves a socket whose daemon fails every request.
+func startRejectingDaemon(t *testing.T) string {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "daemon.sock")
+	ln, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, aerr := ln.Accept()
+			if aerr != nil {
+				return
+			}
+			var req daemon.SocketRequest
+			if json.NewDecoder(conn).Decode(&req) == nil {
+				json.NewEncoder(conn).Encode(daemon.SocketReply{Protocol: daemon.SocketProtocolVersion, ID: req.ID, Error: "queue closed"})
+			}
+			conn.Close()
+		}
+	}()
+	return socketPath
+}
+
+// TestCommandTrack_DaemonErrorFallsBackToLocalStore checks that an event the
+// daemon rejects is kept in the local store instead of being dropped.
+func TestCommandTrack_DaemonErrorFallsBackToLocalStore(t *testing.T) {
+	_, mc := x3SetupTrack(t)
+	model.InitFolder("")
+
+	socketPath := startRejectingDaemon(t)
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{SocketPath: socketPath}, nil)
+
+	app := &cli.App{Name: "t", Commands: []*cli.Command{TrackCommand}}
+	require.NoError(t, app.Run([]string{"t", "track", "--phase", "pre", "--shell", "zsh", "--command", "make", "--id", "1"}))
+
+	pres, err := model.NewFileStore().GetPreCommands(context.Background())
+	require.NoError(t, err)
+	require.Len(t, pres, 1)
+	assert.Equal(t, "make", pres[0].Command)
+}
@@ -0,0 +1,261 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// trackStreamEvent is one line written by a shell hook to `track --stream`.
+//
+// The wire format is a tab-separated list of key=value fields, e.g.
+//
+//	p=post<TAB>t=1712345678.123456<TAB>r=1<TAB>ps=0 1<TAB>cwd=/src/cli<TAB>cmd=git status | grep x
+//
+// Values escape backslash, tab and newline as \\, \t and \n so a hook can
+// build a line with parameter expansion alone, without forking. Unknown keys
+// are ignored so newer hooks keep working against older binaries.
+type trackStreamEvent struct {
+	Phase     string
+	Command   string
+	Result    int
+	Cwd       string
+	SessionID int64
+	PPID      int
+	// Time and PipeStatus stay raw for applyShellTiming, like the --time and
+	// --pipestatus flags.
+	Time       string
+	Duration   time.Duration
+	PipeStatus string
+}
+
+func unescapeTrackStreamValue(v string) string {
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+	var b strings.Builder
+	b.Grow(len(v))
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' || i == len(v)-1 {
+			b.WriteByte(v[i])
+			continue
+		}
+		i++
+		switch v[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String()
+}
+
+func parseTrackStreamEvent(line string) (trackStreamEvent, error) {
+	var ev trackStreamEvent
+	for _, field := range strings.Split(line, "\t") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		value = unescapeTrackStreamValue(value)
+		switch key {
+		case "p":
+			ev.Phase = value
+		case "cmd":
+			ev.Command = value
+		case "cwd":
+			ev.Cwd = value
+		case "r":
+			r, err := strconv.Atoi(value)
+			if err != nil {
+				return ev, fmt.Errorf("invalid result %q: %w", value, err)
+			}
+			ev.Result = r
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ev, fmt.Errorf("invalid session id %q: %w", value, err)
+			}
+			ev.SessionID = id
+		case "t":
+			ev.Time = value
+		case "ps":
+			ev.PipeStatus = value
+		case "dur":
+			ms, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ev, fmt.Errorf("invalid duration %q: %w", value, err)
+			}
+			ev.Duration = time.Duration(ms) * time.Millisecond
+		case "ppid":
+			ppid, err := strconv.Atoi(value)
+			if err != nil {
+				return ev, fmt.Errorf("invalid ppid %q: %w", value, err)
+			}
+			ev.PPID = ppid
+		}
+	}
+	if ev.Phase != "pre" && ev.Phase != "post" {
+		return ev, fmt.Errorf("invalid phase %q", ev.Phase)
+	}
+	return ev, nil
+}
+
+// commandTrackStream is the long-lived, per-shell-session variant of `track`.
+// The hook starts it once and writes one event per line; it runs until the
+// shell closes the pipe. This saves two process spawns (plus uptrace, cli and
+// logger setup) on every prompt.
+func commandTrackStream(c *cli.Context) error {
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	// The stream process shares the terminal's process group, so Ctrl-C and
+	// Ctrl-\ aimed at the foreground command would otherwise kill it.
+	signal.Ignore(syscall.SIGINT, syscall.SIGQUIT)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		slog.Error("failed to get hostname", slog.Any("err", err))
+		return err
+	}
+
+	base := model.Command{
+		Shell:     c.String("shell"),
+		SessionID: c.Int64("sessionId"),
+		Hostname:  hostname,
+		Username:  os.Getenv("USER"),
+		PPID:      c.Int("ppid"),
+	}
+
+	var in io.Reader = os.Stdin
+	if pid := c.Int("shell-pid"); pid > 0 {
+		in = untilShellExits(os.Stdin, pid, trackStreamShellCheckInterval)
+	}
+
+	slog.Debug("track stream started", slog.String("shell", base.Shell), slog.Int64("sessionId", base.SessionID))
+	err = runTrackStream(c.Context, in, base)
+	slog.Debug("track stream stopped", slog.Any("err", err))
+	return err
+}
+
+// trackStreamShellCheckInterval is how often `track --stream` checks that the
+// shell it reads from is still running.
+const trackStreamShellCheckInterval = 2 * time.Second
+
+// untilShellExits returns a reader of r that ends once the process with the
+// given pid has exited. Every child of the shell inherits the write end of
+// the pipe, so a job left running in the background (nohup, &) would keep
+// the stream from ever seeing EOF after the shell is gone. What was read from
+// r before the shell exited is still returned.
+func untilShellExits(r io.Reader, pid int, interval time.Duration) io.Reader {
+	pr, pw := io.Pipe()
+	var mu sync.Mutex
+	closed := false
+
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			mu.Lock()
+			if closed {
+				mu.Unlock()
+				return
+			}
+			if n > 0 {
+				if _, werr := pw.Write(buf[:n]); werr != nil {
+					mu.Unlock()
+					return
+				}
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				closed = true
+				mu.Unlock()
+				return
+			}
+			mu.Unlock()
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if processAlive(pid) {
+				continue
+			}
+			mu.Lock()
+			if !closed {
+				pw.Close()
+				closed = true
+			}
+			mu.Unlock()
+			return
+		}
+	}()
+	return pr
+}
+
+func runTrackStream(ctx context.Context, r io.Reader, base model.Command) error {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, model.MAX_BUFFER_SIZE)
+	scanner.Buffer(buf, model.MAX_BUFFER_SIZE)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		ev, err := parseTrackStreamEvent(line)
+		if err != nil {
+			// the line holds the command, which must not reach the log
+			// unmasked, so only the error is logged
+			slog.Warn("invalid track stream event", slog.String("phase", ev.Phase), slog.Any("err", err))
+			continue
+		}
+
+		instance := base
+		instance.Command = ev.Command
+		instance.Main = model.MainCommand(ev.Command)
+		instance.Cwd = ev.Cwd
+		instance.Time = time.Now()
+		instance.Phase = model.CommandPhasePre
+		instance.Duration = ev.Duration
+		if err := applyShellTiming(&instance, ev.Time, ev.PipeStatus); err != nil {
+			slog.Warn("ignoring invalid shell timing", slog.Any("err", err))
+		}
+		if ev.SessionID != 0 {
+			instance.SessionID = ev.SessionID
+		}
+		if ev.PPID != 0 {
+			instance.PPID = ev.PPID
+		}
+
+		evCtx, span := commandTracer.Start(ctx, "track.stream", trace.WithSpanKind(trace.SpanKindClient))
+		if err := dispatchTrackEvent(evCtx, span, ev.Phase, &instance, ev.Result); err != nil {
+			// dispatchTrackEvent already fell back to the local store, so the
+			// event is lost only if that failed too. Either way a failed event
+			// must not end the session's stream.
+			slog.Error("failed to track stream event", slog.Any("err", err))
+		}
+		span.End()
+	}
+	return scanner.Err()
+}
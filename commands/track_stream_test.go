@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrackStreamEvent(t *testing.T) {
+	ev, err := parseTrackStreamEvent("p=post\tr=130\tcwd=/src/a\\tb\tid=9\tppid=77\tfuture=x\tcmd=echo \"a\\tb\"\\\\\\nnext")
+	require.NoError(t, err)
+	assert.Equal(t, "post", ev.Phase)
+	assert.Equal(t, 130, ev.Result)
+	assert.Equal(t, "/src/a\tb", ev.Cwd)
+	assert.Equal(t, int64(9), ev.SessionID)
+	assert.Equal(t, 77, ev.PPID)
+	assert.Equal(t, "echo \"a\tb\"\\\nnext", ev.Command)
+
+	// a command may itself contain '='
+	ev, err = parseTrackStreamEvent("p=pre\tcmd=FOO=1 make")
+	require.NoError(t, err)
+	assert.Equal(t, "FOO=1 make", ev.Command)
+
+	ev, err = parseTrackStreamEvent("p=post\tt=1712345678.5\tps=0 1\tdur=250\tcmd=ls")
+	require.NoError(t, err)
+	assert.Equal(t, "1712345678.5", ev.Time)
+	assert.Equal(t, "0 1", ev.PipeStatus)
+	assert.Equal(t, 250*time.Millisecond, ev.Duration)
+
+	_, err = parseTrackStreamEvent("p=middle\tcmd=ls")
+	assert.Error(t, err)
+	_, err = parseTrackStreamEvent("p=post\tr=abc\tcmd=ls")
+	assert.Error(t, err)
+}
+
+func TestRunTrackStreamPersistsEvents(t *testing.T) {
+	_, mc := x3SetupTrack(t)
+	model.InitFolder("")
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		SocketPath: x3UnreadySocket(t),
+		FlushCount: 100,
+	}, nil)
+
+	input := strings.Join([]string{
+		"p=pre\tcwd=/src\tcmd=make build",
+		"garbage line",
+		"",
+		"p=post\tr=2\tcwd=/src\tcmd=make build",
+	}, "\n")
+	base := model.Command{Shell: "bash", SessionID: 5, Username: "tester", Hostname: "h"}
+	require.NoError(t, runTrackStream(context.Background(), strings.NewReader(input), base))
+
+	store := model.NewFileStore()
+	pres, err := store.GetPreCommands(context.Background())
+	require.NoError(t, err)
+	require.Len(t, pres, 1)
+	assert.Equal(t, "make build", pres[0].Command)
+	assert.Equal(t, int64(5), pres[0].SessionID)
+	assert.Equal(t, "/src", pres[0].Cwd)
+
+	posts, err := store.GetPostCommands(context.Background())
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, 2, posts[0].Result)
+	assert.Equal(t, "bash", posts[0].Shell)
+}
+
+func TestRunTrackStreamFallsBackWhenDaemonFails(t *testing.T) {
+	_, mc := x3SetupTrack(t)
+	model.InitFolder("")
+	mc.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{
+		SocketPath: startRejectingDaemon(t),
+		FlushCount: 100,
+	}, nil)
+
+	input := "p=pre\tcwd=/src\tcmd=make\np=post\tr=0\tcwd=/src\tcmd=make\n"
+	base := model.Command{Shell: "bash", SessionID: 6, Username: "tester", Hostname: "h"}
+	require.NoError(t, runTrackStream(context.Background(), strings.NewReader(input), base))
+
+	store := model.NewFileStore()
+	pres, err := store.GetPreCommands(context.Background())
+	require.NoError(t, err)
+	require.Len(t, pres, 1, "the rejected pre event is kept locally")
+	posts, err := store.GetPostCommands(context.Background())
+	require.NoError(t, err)
+	require.Len(t, posts, 1, "the rejected post event is kept locally")
+}
+
+func TestRunTrackStream_InvalidEventKeepsCommandOutOfLog(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	input := "p=post\tr=oops\tcmd=curl -H 'Authorization: Bearer s3cret'\n"
+	require.NoError(t, runTrackStream(context.Background(), strings.NewReader(input), model.Command{Shell: "bash"}))
+
+	assert.Contains(t, logs.String(), "invalid track stream event")
+	assert.NotContains(t, logs.String(), "s3cret")
+}
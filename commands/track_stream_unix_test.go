@@ -0,0 +1,60 @@
+//go:build !windows
+
+package commands
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUntilShellExits(t *testing.T) {
+	shell := exec.Command("true")
+	require.NoError(t, shell.Run())
+
+	// a background job still holds the write end after the shell exited
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+	_, err = w.WriteString("p=post\tcmd=exit\n")
+	require.NoError(t, err)
+
+	done := make(chan []byte, 1)
+	go func() {
+		content, _ := io.ReadAll(untilShellExits(r, shell.Process.Pid, 10*time.Millisecond))
+		done <- content
+	}()
+	select {
+	case content := <-done:
+		assert.Equal(t, "p=post\tcmd=exit\n", string(content))
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream didn't end with the shell")
+	}
+}
+
+func TestUntilShellExits_RunningShell(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+
+	done := make(chan []byte, 1)
+	go func() {
+		content, _ := io.ReadAll(untilShellExits(r, os.Getpid(), 10*time.Millisecond))
+		done <- content
+	}()
+	_, err = w.WriteString("p=pre\tcmd=ls\n")
+	require.NoError(t, err)
+	select {
+	case <-done:
+		t.Fatal("the stream ended while the shell runs")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, w.Close())
+	assert.Equal(t, "p=pre\tcmd=ls\n", string(<-done))
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package commands
+
+// processAlive reports whether a process with the given pid exists. The
+// shells that stream on Windows (Git Bash, MSYS2) pass their own pids, which
+// aren't Windows pids, so the shell is assumed to be running; the stream
+// then ends when the pipe closes.
+func processAlive(pid int) bool {
+	return true
+}
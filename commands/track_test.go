@@ -3,6 +3,7 @@ package commands
 // Basic imports
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -103,7 +104,9 @@ func (s *trackTestSuite) TestTrackWithSendData() {
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authorizationHeader := r.Header.Get("Authorization")
-		body, err := io.ReadAll(r.Body)
+		zr, err := gzip.NewReader(r.Body)
+		assert.Nil(s.T(), err)
+		body, err := io.ReadAll(zr)
 		assert.Nil(s.T(), err)
 		defer r.Body.Close()
 
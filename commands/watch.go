@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/gookit/color"
+	"github.com/malamtime/cli/daemon"
+	"github.com/malamtime/cli/model"
+	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var WatchCommand *cli.Command = &cli.Command{
+	Name:  "watch",
+	Usage: "tail the events of the daemon: tracked commands, syncs, heartbeats, OTEL and circuit breakers",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "type",
+			Aliases: []string{"t"},
+			Usage:   "only show these event types, e.g. track, sync_failure, circuit (repeatable)",
+		},
+		&cli.Int64Flag{
+			Name:  "session",
+			Usage: "only show the track and session events of this shell session",
+		},
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Value:   "text",
+			Usage:   "output format (text/json); json prints one event per line",
+		},
+	},
+	Action: commandWatch,
+	OnUsageError: func(cCtx *cli.Context, err error, isSubcommand bool) error {
+		color.Red.Println(err.Error())
+		return nil
+	},
+}
+
+// watchEventTypes are the values --type accepts: the event types and the
+// prefixes standing for several of them.
+var watchEventTypes = []daemon.EventType{
+	"track", daemon.EventTrackPre, daemon.EventTrackPost,
+	"session", daemon.EventSessionStart, daemon.EventSessionEnd,
+	"sync", daemon.EventSyncSuccess, daemon.EventSyncFailure,
+	daemon.EventHeartbeat, daemon.EventOtel, daemon.EventCircuit,
+}
+
+func commandWatch(c *cli.Context) error {
+	ctx, span := commandTracer.Start(c.Context, "watch", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	SetupLogger(os.ExpandEnv("$HOME/" + model.COMMAND_BASE_STORAGE_FOLDER))
+
+	format := c.String("format")
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unsupported format: %s. Use 'text' or 'json'", format)
+	}
+	req := daemon.SubscribeRequest{SessionID: c.Int64("session")}
+	for _, t := range c.StringSlice("type") {
+		eventType, err := parseWatchEventType(t)
+		if err != nil {
+			return err
+		}
+		req.Types = append(req.Types, eventType)
+	}
+
+	cfg, err := configService.ReadConfigFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	if !daemon.IsSocketReady(ctx, cfg.SocketPath) {
+		return errors.New("the daemon is not running: start it with `shelltime daemon install`")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return daemon.Subscribe(ctx, cfg.SocketPath, req, func(ev daemon.Event) error {
+		return printWatchEvent(os.Stdout, format, ev)
+	})
+}
+
+func parseWatchEventType(s string) (daemon.EventType, error) {
+	for _, t := range watchEventTypes {
+		if strings.EqualFold(s, string(t)) {
+			return t, nil
+		}
+	}
+	names := make([]string, len(watchEventTypes))
+	for i, t := range watchEventTypes {
+		names[i] = string(t)
+	}
+	return "", fmt.Errorf("unknown event type %q, use one of %s", s, strings.Join(names, ", "))
+}
+
+func printWatchEvent(w io.Writer, format string, ev daemon.Event) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(ev)
+	}
+	_, err := fmt.Fprintf(w, "%s  %-13s  %s\n", ev.Time.Local().Format("15:04:05"), ev.Type, formatWatchEvent(ev))
+	return err
+}
+
+// formatWatchEvent describes an event on one line.
+func formatWatchEvent(ev daemon.Event) string {
+	var s string
+	switch ev.Type {
+	case daemon.EventTrackPre, daemon.EventTrackPost:
+		s = fmt.Sprintf("%s #%d  %s", ev.Shell, ev.SessionID, ev.Command)
+		if ev.Result != nil {
+			s += fmt.Sprintf("  (exit %d)", *ev.Result)
+		}
+	case daemon.EventSessionStart, daemon.EventSessionEnd:
+		s = fmt.Sprintf("%s #%d", ev.Shell, ev.SessionID)
+	case daemon.EventSyncSuccess, daemon.EventSyncFailure:
+		s = fmt.Sprintf("%s  %d commands", ev.Endpoint, ev.Count)
+	case daemon.EventHeartbeat:
+		s = fmt.Sprintf("%d heartbeats", ev.Count)
+	case daemon.EventOtel:
+		s = fmt.Sprintf("%s  %d %s", ev.Source, ev.Count, ev.Kind)
+	case daemon.EventCircuit:
+		s = fmt.Sprintf("%s  %s", ev.Endpoint, formatCircuit(ev.Circuit))
+	}
+	if ev.Error != "" {
+		s += "  error: " + ev.Error
+	}
+	return s
+}
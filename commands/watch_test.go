@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/daemon"
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWatchEventType(t *testing.T) {
+	eventType, err := parseWatchEventType("SYNC_failure")
+	require.NoError(t, err)
+	assert.Equal(t, daemon.EventSyncFailure, eventType)
+
+	eventType, err = parseWatchEventType("track")
+	require.NoError(t, err)
+	assert.Equal(t, daemon.EventType("track"), eventType)
+
+	_, err = parseWatchEventType("bogus")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "circuit")
+}
+
+func TestFormatWatchEvent(t *testing.T) {
+	result := 1
+	assert.Equal(t, "bash #42  go test ./...  (exit 1)", formatWatchEvent(daemon.Event{
+		Type: daemon.EventTrackPost, Shell: "bash", SessionID: 42, Command: "go test ./...", Result: &result,
+	}))
+	assert.Equal(t, "https://api.shelltime.xyz  12 commands  error: timeout", formatWatchEvent(daemon.Event{
+		Type: daemon.EventSyncFailure, Endpoint: "https://api.shelltime.xyz", Count: 12, Error: "timeout",
+	}))
+	assert.Equal(t, "claude-code  3 events", formatWatchEvent(daemon.Event{
+		Type: daemon.EventOtel, Source: "claude-code", Kind: "events", Count: 3,
+	}))
+	assert.Equal(t, "https://api.shelltime.xyz  circuit half-open, probing", formatWatchEvent(daemon.Event{
+		Type: daemon.EventCircuit, Endpoint: "https://api.shelltime.xyz", Circuit: &model.CircuitBreakerStatus{State: model.CircuitHalfOpen},
+	}))
+}
+
+func TestPrintWatchEvent_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	ev := daemon.Event{Seq: 5, Type: daemon.EventHeartbeat, Time: time.Now(), Count: 2}
+	require.NoError(t, printWatchEvent(&buf, "json", ev))
+
+	var decoded daemon.Event
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, uint64(5), decoded.Seq)
+	assert.Equal(t, 2, decoded.Count)
+	assert.Equal(t, byte('\n'), buf.Bytes()[buf.Len()-1])
+}
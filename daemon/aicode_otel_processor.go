@@ -122,6 +122,8 @@ func (p *AICodeOtelProcessor) ProcessMetrics(ctx context.Context, req *collmetri
 		}
 
 		resp, err := model.SendAICodeOtelData(ctx, aiCodeReq, p.endpoint)
+		emitEvent(Event{Type: EventOtel, Source: source, Kind: "metrics", Count: len(metrics), Error: errorString(err)})
+		metricOtelForwarded.Add(float64(len(metrics)), source, "metrics", resultLabel(err))
 		if err != nil {
 			slog.Error("AICodeOtel: Failed to send metrics to backend", "error", err)
 			// Continue processing - passthrough mode, we don't retry
@@ -179,6 +181,8 @@ func (p *AICodeOtelProcessor) ProcessLogs(ctx context.Context, req *collogsv1.Ex
 		}
 
 		resp, err := model.SendAICodeOtelData(ctx, aiCodeReq, p.endpoint)
+		emitEvent(Event{Type: EventOtel, Source: source, Kind: "events", Count: len(events), Error: errorString(err)})
+		metricOtelForwarded.Add(float64(len(events)), source, "events", resultLabel(err))
 		if err != nil {
 			slog.Error("AICodeOtel: Failed to send events to backend", "error", err)
 			// Continue processing - passthrough mode, we don't retry
@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"sync"
 	"time"
 
 	"github.com/malamtime/cli/model"
@@ -10,19 +11,28 @@ var stConfig model.ConfigService
 var version string
 var startedAt time.Time
 
-// commandStore is the daemon-owned bolt CommandStore, set when the bolt storage
-// engine is enabled. It is nil when running with the default file engine.
+// commandStore is the daemon's CommandStore, set when the bolt or segment
+// storage engine is enabled. It is nil when running with the default file
+// engine.
 var commandStore model.CommandStore
 
-// InitCommandStore registers the daemon-owned command store used by the bolt
-// track handlers. The daemon owns the store for its lifetime (bbolt holds an
-// exclusive file lock).
+// commandStoreMu guards commandStore. Handlers hold the read lock for a whole
+// event, so a storage migration (write lock) never misses a write.
+var commandStoreMu sync.RWMutex
+
+// InitCommandStore registers the command store used by the track handlers.
+// The daemon owns the store for its lifetime (bbolt holds an exclusive file
+// lock).
 func InitCommandStore(store model.CommandStore) {
+	commandStoreMu.Lock()
+	defer commandStoreMu.Unlock()
 	commandStore = store
 }
 
 const (
 	PubSubTopic = "socket"
+	// EventTopic carries the events streamed to subscribe requests.
+	EventTopic = "events"
 )
 
 func Init(cs model.ConfigService, vs string) {
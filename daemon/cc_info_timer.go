@@ -35,7 +35,8 @@ type GitCacheEntry struct {
 
 // CCInfoTimerService manages lazy-fetching of CC info data
 type CCInfoTimerService struct {
-	config *model.ShellTimeConfig
+	configMu sync.RWMutex
+	config   *model.ShellTimeConfig
 
 	mu           sync.RWMutex
 	cache        map[CCInfoTimeRange]CCInfoCache
@@ -77,6 +78,19 @@ func NewCCInfoTimerService(config *model.ShellTimeConfig) *CCInfoTimerService {
 	}
 }
 
+// SetConfig replaces the config the next fetches use.
+func (s *CCInfoTimerService) SetConfig(config *model.ShellTimeConfig) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.config = config
+}
+
+func (s *CCInfoTimerService) currentConfig() *model.ShellTimeConfig {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config
+}
+
 // GetCachedCost returns the cached cost for the given time range
 // It also marks the range as active and starts the timer if not running
 func (s *CCInfoTimerService) GetCachedCost(timeRange CCInfoTimeRange) CCInfoCache {
@@ -84,6 +98,7 @@ func (s *CCInfoTimerService) GetCachedCost(timeRange CCInfoTimeRange) CCInfoCach
 	s.activeRanges[timeRange] = true
 	cache := s.cache[timeRange]
 	s.mu.Unlock()
+	metricCCInfoCache.Inc("cost", cacheResult(!cache.FetchedAt.IsZero()))
 
 	return cache
 }
@@ -216,7 +231,7 @@ func (s *CCInfoTimerService) checkInactivity() bool {
 
 // fetchActiveRanges fetches data for all active time ranges
 func (s *CCInfoTimerService) fetchActiveRanges(ctx context.Context) {
-	if s.config.Token == "" {
+	if s.currentConfig().Token == "" {
 		return
 	}
 
@@ -261,6 +276,7 @@ type ccInfoFetchResult struct {
 
 // fetchCCInfo fetches the CC info for a specific time range
 func (s *CCInfoTimerService) fetchCCInfo(ctx context.Context, timeRange CCInfoTimeRange) (ccInfoFetchResult, error) {
+	config := s.currentConfig()
 	now := time.Now()
 	var since time.Time
 
@@ -298,8 +314,8 @@ func (s *CCInfoTimerService) fetchCCInfo(ctx context.Context, timeRange CCInfoTi
 	err := model.SendGraphQLRequest(model.GraphQLRequestOptions[model.GraphQLResponse[model.CCStatuslineDailyCostResponse]]{
 		Context: ctx,
 		Endpoint: model.Endpoint{
-			Token:       s.config.Token,
-			APIEndpoint: s.config.APIEndpoint,
+			Token:       config.Token,
+			APIEndpoint: config.APIEndpoint,
 		},
 		Query:     model.CCStatuslineDailyCostQuery,
 		Variables: variables,
@@ -343,6 +359,7 @@ func (s *CCInfoTimerService) GetCachedGitInfo(workingDir string) GitInfo {
 	}
 	info := entry.Info
 	s.mu.Unlock()
+	metricCCInfoCache.Inc("git", cacheResult(exists))
 
 	return info
 }
@@ -526,7 +543,8 @@ func (s *CCInfoTimerService) GetClaudeCodeVersion() string {
 
 // fetchUserProfile fetches the current user's login once per daemon lifetime.
 func (s *CCInfoTimerService) fetchUserProfile(ctx context.Context) {
-	if s.config.Token == "" {
+	config := s.currentConfig()
+	if config.Token == "" {
 		return
 	}
 
@@ -538,7 +556,7 @@ func (s *CCInfoTimerService) fetchUserProfile(ctx context.Context) {
 		return
 	}
 
-	profile, err := model.FetchCurrentUserProfile(ctx, *s.config)
+	profile, err := model.FetchCurrentUserProfile(ctx, *config)
 	if err != nil {
 		slog.Warn("Failed to fetch user profile", slog.Any("err", err))
 		return
@@ -555,7 +573,8 @@ func (s *CCInfoTimerService) fetchUserProfile(ctx context.Context) {
 // sendAnthropicUsageToServer sends the Anthropic usage data to the ShellTime server
 // for scheduling push notifications when rate limits reset.
 func (s *CCInfoTimerService) sendAnthropicUsageToServer(ctx context.Context, usage *AnthropicRateLimitData) {
-	if s.config.Token == "" {
+	config := s.currentConfig()
+	if config.Token == "" {
 		return
 	}
 
@@ -582,8 +601,8 @@ func (s *CCInfoTimerService) sendAnthropicUsageToServer(ctx context.Context, usa
 	err := model.SendHTTPRequestJSON(model.HTTPRequestOptions[usagePayload, any]{
 		Context: ctx,
 		Endpoint: model.Endpoint{
-			Token:       s.config.Token,
-			APIEndpoint: s.config.APIEndpoint,
+			Token:       config.Token,
+			APIEndpoint: config.APIEndpoint,
 		},
 		Method:  "POST",
 		Path:    "/api/v1/anthropic-usage",
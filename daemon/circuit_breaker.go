@@ -3,6 +3,11 @@ package daemon
 import (
 	"context"
 	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -15,33 +20,155 @@ type DaemonCircuitBreaker interface {
 	RecordSuccess()
 	RecordFailure()
 	SaveForRetry(ctx context.Context, payload interface{}) error
+	PendingCount() int
+	Status() model.CircuitBreakerStatus
 }
 
-// Global instance
-var syncCircuitBreaker DaemonCircuitBreaker
+// syncCircuitBreakerFor returns the circuit breaker of the sync endpoint with
+// the given key. It is nil until NewSyncCircuitBreakerService is called.
+var syncCircuitBreakerFor func(key string) DaemonCircuitBreaker
+
+// syncRetryNow replays the retry queues of all endpoints right away. It is nil
+// until NewSyncCircuitBreakerService is called.
+var syncRetryNow func()
+
+// circuitBreakerFor returns the circuit breaker of endpoint, or nil when the
+// daemon runs without circuit breakers.
+func circuitBreakerFor(endpoint model.Endpoint) DaemonCircuitBreaker {
+	if syncCircuitBreakerFor == nil {
+		return nil
+	}
+	return syncCircuitBreakerFor(model.EndpointKey(endpoint))
+}
 
 // SyncCircuitBreakerWrapper wraps model.CircuitBreakerService with daemon-specific logic
+// for one sync endpoint
 type SyncCircuitBreakerWrapper struct {
 	*model.CircuitBreakerService
+	endpointKey string
 }
 
-// NewSyncCircuitBreakerService creates a new daemon-specific circuit breaker service
-func NewSyncCircuitBreakerService(publisher message.Publisher) *SyncCircuitBreakerWrapper {
-	republishFn := func(data []byte) error {
-		msg := message.NewMessage(watermill.NewUUID(), data)
-		return publisher.Publish(PubSubTopic, msg)
+// SyncCircuitBreakers keeps a circuit breaker and a retry queue per sync
+// endpoint, so an endpoint that is down doesn't hold back the others.
+type SyncCircuitBreakers struct {
+	mu        sync.Mutex
+	publisher message.Publisher
+	breakers  map[string]*SyncCircuitBreakerWrapper
+	// legacy drains the pending file of daemons that had a single queue
+	legacy *model.CircuitBreakerService
+	ctx    context.Context
+}
+
+// NewSyncCircuitBreakerService creates the daemon-specific circuit breakers of
+// the sync endpoints
+func NewSyncCircuitBreakerService(publisher message.Publisher) *SyncCircuitBreakers {
+	s := &SyncCircuitBreakers{
+		publisher: publisher,
+		breakers:  make(map[string]*SyncCircuitBreakerWrapper),
 	}
+	s.legacy = model.NewCircuitBreakerService(model.CircuitBreakerConfig{}, s.republish)
+	syncCircuitBreakerFor = func(key string) DaemonCircuitBreaker {
+		return s.For(key)
+	}
+	syncRetryNow = s.RetryNow
+	return s
+}
 
-	svc := model.NewCircuitBreakerService(model.CircuitBreakerConfig{}, republishFn)
-	wrapper := &SyncCircuitBreakerWrapper{
-		CircuitBreakerService: svc,
+func (s *SyncCircuitBreakers) republish(data []byte) error {
+	msg := message.NewMessage(watermill.NewUUID(), data)
+	return s.publisher.Publish(PubSubTopic, msg)
+}
+
+// pendingFileFor returns the retry queue of an endpoint, relative to $HOME.
+func pendingFileFor(key string) string {
+	return model.SyncPendingFileFor(key)
+}
+
+// stateFileFor returns where the circuit breaker of an endpoint keeps its
+// state across restarts, relative to $HOME.
+func stateFileFor(key string) string {
+	return strings.TrimSuffix(model.SYNC_PENDING_FILE, ".jsonl") + "-" + key + ".state.json"
+}
+
+// For returns the circuit breaker of the endpoint with the given key,
+// creating it on first use.
+func (s *SyncCircuitBreakers) For(key string) *SyncCircuitBreakerWrapper {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.breakers[key]; ok {
+		return w
+	}
+	w := &SyncCircuitBreakerWrapper{
+		CircuitBreakerService: model.NewCircuitBreakerService(model.CircuitBreakerConfig{
+			PendingFile: pendingFileFor(key),
+			StateFile:   stateFileFor(key),
+			OnStateChange: func(status model.CircuitBreakerStatus) {
+				emitEvent(Event{Type: EventCircuit, Endpoint: endpointForKey(key), Circuit: &status})
+			},
+		}, s.republish),
+		endpointKey: key,
+	}
+	s.breakers[key] = w
+	if s.ctx != nil {
+		w.Start(s.ctx)
 	}
-	syncCircuitBreaker = wrapper
-	return wrapper
+	return w
 }
 
-// SaveForRetry wraps payload in SocketMessage before saving
+// Start starts the retry timers. The endpoints with payloads queued by an
+// earlier run get their circuit breaker right away, so the payloads are
+// retried even before the endpoint is synced to again.
+func (s *SyncCircuitBreakers) Start(ctx context.Context) error {
+	pattern := os.ExpandEnv("$HOME/" + pendingFileFor("*"))
+	files, _ := filepath.Glob(pattern)
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+
+	s.mu.Lock()
+	s.ctx = ctx
+	for _, w := range s.breakers {
+		w.Start(ctx)
+	}
+	s.mu.Unlock()
+
+	for _, file := range files {
+		key := strings.TrimSuffix(strings.TrimPrefix(file, prefix), suffix)
+		if key != "" {
+			s.For(key)
+		}
+	}
+	return s.legacy.Start(ctx)
+}
+
+// Stop stops the retry timers.
+func (s *SyncCircuitBreakers) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil {
+		for _, w := range s.breakers {
+			w.Stop()
+		}
+	}
+	s.legacy.Stop()
+	s.ctx = nil
+}
+
+// RetryNow replays the retry queues of all endpoints right away. The open
+// circuits go half-open, so a single payload probes each of their endpoints.
+func (s *SyncCircuitBreakers) RetryNow() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.breakers {
+		w.RetryNow()
+	}
+	s.legacy.RetryNow()
+}
+
+// SaveForRetry wraps payload in SocketMessage before saving. A sync payload is
+// bound to the endpoint, so the retry is only sent there.
 func (w *SyncCircuitBreakerWrapper) SaveForRetry(ctx context.Context, payload interface{}) error {
+	if args, ok := payload.(model.PostTrackArgs); ok {
+		payload = syncRetryPayload{PostTrackArgs: args, Endpoint: w.endpointKey}
+	}
 	socketMsg := SocketMessage{
 		Type:    SocketMessageTypeSync,
 		Payload: payload,
@@ -50,5 +177,9 @@ func (w *SyncCircuitBreakerWrapper) SaveForRetry(ctx context.Context, payload in
 	if err != nil {
 		return err
 	}
-	return w.CircuitBreakerService.SaveForRetry(ctx, jsonData)
+	if err := w.CircuitBreakerService.SaveForRetry(ctx, jsonData); err != nil {
+		return err
+	}
+	slog.Debug("Queued sync payload", slog.String("endpoint", w.endpointKey))
+	return nil
 }
@@ -29,27 +29,102 @@ func (m *mockPublisher) Close() error {
 	return nil
 }
 
+// newTestCircuitBreakers creates the circuit breakers and restores the global
+// lookup when the test ends.
+func newTestCircuitBreakers(t *testing.T, publisher message.Publisher) *SyncCircuitBreakers {
+	t.Helper()
+	prev := syncCircuitBreakerFor
+	prevRetry := syncRetryNow
+	t.Cleanup(func() { syncCircuitBreakerFor, syncRetryNow = prev, prevRetry })
+	return NewSyncCircuitBreakerService(publisher)
+}
+
 func TestNewSyncCircuitBreakerService(t *testing.T) {
 	publisher := &mockPublisher{}
 
-	wrapper := NewSyncCircuitBreakerService(publisher)
-	if wrapper == nil {
+	breakers := newTestCircuitBreakers(t, publisher)
+	if breakers == nil {
 		t.Fatal("NewSyncCircuitBreakerService returned nil")
 	}
 
+	wrapper := breakers.For("test")
 	if wrapper.CircuitBreakerService == nil {
 		t.Error("CircuitBreakerService should be initialized")
 	}
+	if breakers.For("test") != wrapper {
+		t.Error("For should return the same breaker for an endpoint")
+	}
+
+	// Check global lookup was set
+	if syncCircuitBreakerFor == nil {
+		t.Fatal("Global syncCircuitBreakerFor should be set")
+	}
+	if syncCircuitBreakerFor("test") != DaemonCircuitBreaker(wrapper) {
+		t.Error("Global lookup should return the endpoint's breaker")
+	}
+}
+
+func TestSyncCircuitBreakers_SeparateEndpoints(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(home, pendingFileFor("mirror"))), 0755); err != nil {
+		t.Fatal(err)
+	}
+	breakers := newTestCircuitBreakers(t, &mockPublisher{})
+
+	main, mirror := breakers.For("main"), breakers.For("mirror")
+	for i := 0; i < model.DefaultMaxConsecutiveFailures; i++ {
+		mirror.RecordFailure()
+	}
+	if !mirror.IsOpen() || main.IsOpen() {
+		t.Fatal("Only the failing endpoint's circuit should open")
+	}
 
-	// Check global variable was set
-	if syncCircuitBreaker == nil {
-		t.Error("Global syncCircuitBreaker should be set")
+	payload := model.PostTrackArgs{CursorID: 1, Data: []model.TrackingData{{Command: "ls"}}}
+	if err := mirror.SaveForRetry(context.Background(), payload); err != nil {
+		t.Fatalf("SaveForRetry failed: %v", err)
+	}
+	if mirror.PendingCount() != 1 || main.PendingCount() != 0 {
+		t.Fatalf("Retry queues should be separate, got main=%d mirror=%d", main.PendingCount(), mirror.PendingCount())
+	}
+
+	content, err := os.ReadFile(filepath.Join(home, pendingFileFor("mirror")))
+	if err != nil {
+		t.Fatalf("Failed to read the retry queue: %v", err)
+	}
+	var msg struct {
+		Type    SocketMessageType `json:"type"`
+		Payload syncRetryPayload  `json:"payload"`
+	}
+	if err := json.Unmarshal(content, &msg); err != nil {
+		t.Fatalf("Failed to parse the queued payload: %v", err)
+	}
+	if msg.Type != SocketMessageTypeSync || msg.Payload.Endpoint != "mirror" || len(msg.Payload.Data) != 1 {
+		t.Errorf("Unexpected queued payload: %+v", msg)
+	}
+
+	// a restarted daemon picks the queue up again
+	restarted := newTestCircuitBreakers(t, &mockPublisher{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := restarted.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer restarted.Stop()
+	restarted.mu.Lock()
+	_, ok := restarted.breakers["mirror"]
+	restarted.mu.Unlock()
+	if !ok {
+		t.Error("Start should create the breakers of queued endpoints")
+	}
+	if !restarted.For("mirror").IsOpen() || restarted.For("main").IsOpen() {
+		t.Error("The circuit state should survive a restart")
 	}
 }
 
 func TestSyncCircuitBreakerWrapper_IsOpen(t *testing.T) {
 	publisher := &mockPublisher{}
-	wrapper := NewSyncCircuitBreakerService(publisher)
+	wrapper := newTestCircuitBreakers(t, publisher).For("test")
 
 	// Initially should be closed (not open)
 	if wrapper.IsOpen() {
@@ -59,7 +134,7 @@ func TestSyncCircuitBreakerWrapper_IsOpen(t *testing.T) {
 
 func TestSyncCircuitBreakerWrapper_RecordSuccess(t *testing.T) {
 	publisher := &mockPublisher{}
-	wrapper := NewSyncCircuitBreakerService(publisher)
+	wrapper := newTestCircuitBreakers(t, publisher).For("test")
 
 	// Should not panic
 	wrapper.RecordSuccess()
@@ -72,7 +147,7 @@ func TestSyncCircuitBreakerWrapper_RecordSuccess(t *testing.T) {
 
 func TestSyncCircuitBreakerWrapper_RecordFailure(t *testing.T) {
 	publisher := &mockPublisher{}
-	wrapper := NewSyncCircuitBreakerService(publisher)
+	wrapper := newTestCircuitBreakers(t, publisher).For("test")
 
 	// Should not panic
 	wrapper.RecordFailure()
@@ -97,7 +172,7 @@ func TestSyncCircuitBreakerWrapper_SaveForRetry(t *testing.T) {
 	defer os.Setenv("HOME", origHome)
 
 	publisher := &mockPublisher{}
-	wrapper := NewSyncCircuitBreakerService(publisher)
+	wrapper := newTestCircuitBreakers(t, publisher).For("test")
 
 	ctx := context.Background()
 	payload := map[string]string{"key": "value"}
@@ -127,7 +202,7 @@ func TestSyncCircuitBreakerWrapper_SaveForRetry_WrapsInSocketMessage(t *testing.
 	defer os.Setenv("HOME", origHome)
 
 	publisher := &mockPublisher{}
-	wrapper := NewSyncCircuitBreakerService(publisher)
+	wrapper := newTestCircuitBreakers(t, publisher).For("test")
 
 	ctx := context.Background()
 	payload := map[string]string{"test": "data"}
@@ -157,7 +232,7 @@ func TestDaemonCircuitBreaker_Interface(t *testing.T) {
 
 func TestSyncCircuitBreakerWrapper_MultipleFailures(t *testing.T) {
 	publisher := &mockPublisher{}
-	wrapper := NewSyncCircuitBreakerService(publisher)
+	wrapper := newTestCircuitBreakers(t, publisher).For("test")
 
 	// Record multiple failures
 	for i := 0; i < 10; i++ {
@@ -172,7 +247,7 @@ func TestSyncCircuitBreakerWrapper_MultipleFailures(t *testing.T) {
 
 func TestSyncCircuitBreakerWrapper_ConcurrentAccess(t *testing.T) {
 	publisher := &mockPublisher{}
-	wrapper := NewSyncCircuitBreakerService(publisher)
+	wrapper := newTestCircuitBreakers(t, publisher).For("test")
 
 	done := make(chan bool, 10)
 
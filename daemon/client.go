@@ -3,6 +3,9 @@ package daemon
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"os"
 	"time"
@@ -10,6 +13,15 @@ import (
 	"github.com/malamtime/cli/model"
 )
 
+const (
+	// socketAckTimeout bounds the messages the daemon only acknowledges: it
+	// replies as soon as they are queued, before storing or syncing them.
+	socketAckTimeout = 5 * time.Second
+	// sessionProjectTimeout keeps SendSessionProject off the prompt's
+	// critical path.
+	sessionProjectTimeout = 100 * time.Millisecond
+)
+
 func IsSocketReady(ctx context.Context, socketPath string) bool {
 	_, err := os.Stat(socketPath)
 	return err == nil
@@ -21,39 +33,21 @@ func SendLocalDataToSocket(
 	config model.ShellTimeConfig,
 	cursor time.Time,
 	trackingData []model.TrackingData,
+	sessions []model.TrackingSessionData,
 	meta model.TrackingMetaData,
 ) error {
-	conn, err := net.Dial("unix", socketPath)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	data := SocketMessage{
-		Type: SocketMessageTypeSync,
-		Payload: model.PostTrackArgs{
-			CursorID: cursor.UnixNano(),
-			Data:     trackingData,
-			Meta:     meta,
-		},
-	}
-
-	encoded, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-
-	_, err = conn.Write(encoded)
-	if err != nil {
-		return err
+	payload := model.PostTrackArgs{
+		CursorID: cursor.UnixNano(),
+		Data:     trackingData,
+		Meta:     meta,
+		Sessions: sessions,
 	}
-
-	return nil
+	return requestDaemon(socketPath, SocketMessageTypeSync, payload, nil, socketAckTimeout)
 }
 
 // SendTrackEvent sends a single raw command event (pre or post) to the daemon
-// for persistence in its bolt store. Fire-and-forget, mirroring the latency
-// profile of the txt-file append it replaces.
+// for persistence in its bolt store. The daemon acknowledges it once queued,
+// mirroring the latency profile of the txt-file append it replaces.
 func SendTrackEvent(
 	ctx context.Context,
 	socketPath string,
@@ -61,66 +55,55 @@ func SendTrackEvent(
 	cmd model.Command,
 	recordingTime time.Time,
 ) error {
-	conn, err := net.Dial("unix", socketPath)
-	if err != nil {
-		return err
+	payload := TrackEventPayload{
+		Command:           cmd,
+		RecordingTimeNano: recordingTime.UnixNano(),
 	}
-	defer conn.Close()
+	return requestDaemon(socketPath, msgType, payload, nil, socketAckTimeout)
+}
 
-	data := SocketMessage{
-		Type: msgType,
-		Payload: TrackEventPayload{
-			Command:           cmd,
-			RecordingTimeNano: recordingTime.UnixNano(),
-		},
+// SendSessionEvent sends a shell session start/end event to the daemon,
+// acknowledged like SendTrackEvent.
+func SendSessionEvent(
+	ctx context.Context,
+	socketPath string,
+	ev model.SessionEvent,
+	recordingTime time.Time,
+) error {
+	msgType := SocketMessageTypeSessionStart
+	if ev.Type == model.SessionEventEnd {
+		msgType = SocketMessageTypeSessionEnd
 	}
-
-	encoded, err := json.Marshal(data)
-	if err != nil {
-		return err
+	payload := SessionEventPayload{
+		Event:             ev,
+		RecordingTimeNano: recordingTime.UnixNano(),
 	}
-
-	_, err = conn.Write(encoded)
-	return err
+	return requestDaemon(socketPath, msgType, payload, nil, socketAckTimeout)
 }
 
 // SendSessionProject sends a session-to-project mapping to the daemon (fire-and-forget)
 func SendSessionProject(socketPath string, sessionID, projectPath string) {
-	conn, err := net.DialTimeout("unix", socketPath, 10*time.Millisecond)
-	if err != nil {
-		return
+	payload := SessionProjectRequest{
+		SessionID:   sessionID,
+		ProjectPath: projectPath,
 	}
-	defer conn.Close()
+	requestDaemon(socketPath, SocketMessageTypeSessionProject, payload, nil, sessionProjectTimeout)
+}
 
-	msg := SocketMessage{
-		Type: SocketMessageTypeSessionProject,
-		Payload: SessionProjectRequest{
-			SessionID:   sessionID,
-			ProjectPath: projectPath,
-		},
+// RequestStatus asks the daemon for its version, uptime and sync state.
+func RequestStatus(socketPath string, timeout time.Duration) (*StatusResponse, error) {
+	var response StatusResponse
+	if err := requestDaemon(socketPath, SocketMessageTypeStatus, nil, &response, timeout); err != nil {
+		return nil, err
 	}
-
-	json.NewEncoder(conn).Encode(msg)
+	return &response, nil
 }
 
 // RequestListCommands asks the daemon for the locally buffered commands (used
 // by `shelltime ls` in bolt mode, since the CLI can't open the locked DB).
-func RequestListCommands(socketPath string, timeout time.Duration) (*ListCommandsResponse, error) {
-	conn, err := net.DialTimeout("unix", socketPath, timeout)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-
-	conn.SetDeadline(time.Now().Add(timeout))
-
-	msg := SocketMessage{Type: SocketMessageTypeListCommands}
-	if err := json.NewEncoder(conn).Encode(msg); err != nil {
-		return nil, err
-	}
-
+func RequestListCommands(socketPath string, req ListCommandsRequest, timeout time.Duration) (*ListCommandsResponse, error) {
 	var response ListCommandsResponse
-	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+	if err := requestDaemon(socketPath, SocketMessageTypeListCommands, req, &response, timeout); err != nil {
 		return nil, err
 	}
 	return &response, nil
@@ -129,36 +112,111 @@ func RequestListCommands(socketPath string, timeout time.Duration) (*ListCommand
 // RequestCCInfo requests CC info (cost data and git info) from the daemon.
 // claudeCodeVersion is forwarded so the daemon can use it in the Anthropic usage User-Agent.
 func RequestCCInfo(socketPath string, timeRange CCInfoTimeRange, workingDir, claudeCodeVersion string, timeout time.Duration) (*CCInfoResponse, error) {
-	conn, err := net.DialTimeout("unix", socketPath, timeout)
-	if err != nil {
+	req := CCInfoRequest{
+		TimeRange:         timeRange,
+		WorkingDirectory:  workingDir,
+		ClaudeCodeVersion: claudeCodeVersion,
+	}
+	var response CCInfoResponse
+	if err := requestDaemon(socketPath, SocketMessageTypeCCInfo, req, &response, timeout); err != nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	// Set read/write deadline
-	conn.SetDeadline(time.Now().Add(timeout))
+	return &response, nil
+}
 
-	// Send request
-	msg := SocketMessage{
-		Type: SocketMessageTypeCCInfo,
-		Payload: CCInfoRequest{
-			TimeRange:         timeRange,
-			WorkingDirectory:  workingDir,
-			ClaudeCodeVersion: claudeCodeVersion,
-		},
+// RequestStorageMigrate asks the daemon to migrate its command storage. The
+// daemon pauses track events while it copies, so the timeout should be generous.
+func RequestStorageMigrate(socketPath string, req StorageMigrateRequest, timeout time.Duration) (*StorageMigrateResponse, error) {
+	var response StorageMigrateResponse
+	err := requestDaemon(socketPath, SocketMessageTypeStorageMigrate, req, &response, timeout)
+	if err := replyErrorInto(err, &response.Error); err != nil {
+		return nil, err
 	}
+	return &response, nil
+}
 
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(msg); err != nil {
+// RequestStorageFsck asks the daemon to check, and optionally repair, its
+// command storage.
+func RequestStorageFsck(socketPath string, req StorageFsckRequest, timeout time.Duration) (*StorageFsckResponse, error) {
+	var response StorageFsckResponse
+	err := requestDaemon(socketPath, SocketMessageTypeStorageFsck, req, &response, timeout)
+	if err := replyErrorInto(err, &response.Error); err != nil {
 		return nil, err
 	}
+	return &response, nil
+}
 
-	// Read response
-	var response CCInfoResponse
-	decoder := json.NewDecoder(conn)
-	if err := decoder.Decode(&response); err != nil {
+// RequestStorageRotateKey asks the daemon to re-encrypt its command storage
+// with a new key.
+func RequestStorageRotateKey(socketPath string, req StorageRotateKeyRequest, timeout time.Duration) (*StorageRotateKeyResponse, error) {
+	var response StorageRotateKeyResponse
+	err := requestDaemon(socketPath, SocketMessageTypeStorageRotateKey, req, &response, timeout)
+	if err := replyErrorInto(err, &response.Error); err != nil {
 		return nil, err
 	}
+	return &response, nil
+}
 
+// RequestSyncPending asks the daemon to list, show, drop or retry the payloads
+// waiting in the pending files.
+func RequestSyncPending(socketPath string, req SyncPendingRequest, timeout time.Duration) (*SyncPendingResponse, error) {
+	var response SyncPendingResponse
+	err := requestDaemon(socketPath, SocketMessageTypeSyncPending, req, &response, timeout)
+	if err := replyErrorInto(err, &response.Error); err != nil {
+		return nil, err
+	}
 	return &response, nil
 }
+
+// Subscribe streams the daemon's events matching req to fn until ctx is done,
+// fn returns an error or the daemon stops.
+func Subscribe(ctx context.Context, socketPath string, req SubscribeRequest, fn func(Event) error) error {
+	sub, err := newSocketRequest(SocketMessageTypeSubscribe, req)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("unix", socketPath, socketAckTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(socketAckTimeout))
+	if err := json.NewEncoder(conn).Encode(sub); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(conn)
+	var reply SocketReply
+	if err := decoder.Decode(&reply); err != nil {
+		if errors.Is(err, io.EOF) {
+			// an unversioned daemon drops the message it doesn't know
+			return &ProtocolError{Daemon: 1, CLI: SocketProtocolVersion, Type: SocketMessageTypeSubscribe}
+		}
+		return err
+	}
+	if reply.Protocol != SocketProtocolVersion && !reply.OK {
+		return &ProtocolError{Daemon: reply.Protocol, CLI: SocketProtocolVersion, Type: SocketMessageTypeSubscribe}
+	}
+	if !reply.OK {
+		return &ReplyError{Type: SocketMessageTypeSubscribe, Message: reply.Error}
+	}
+	conn.SetDeadline(time.Time{})
+
+	for {
+		var ev Event
+		if err := decoder.Decode(&ev); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return errors.New("the daemon closed the event stream")
+			}
+			return fmt.Errorf("failed to read the event stream: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
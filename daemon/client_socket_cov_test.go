@@ -106,6 +106,7 @@ func TestX3SendLocalDataToSocket_DeliversSyncMessage(t *testing.T) {
 		model.ShellTimeConfig{},
 		time.Now(),
 		[]model.TrackingData{{Command: "ls", Result: 0}},
+		nil,
 		model.TrackingMetaData{OS: "linux", Shell: "bash"},
 	)
 	require.NoError(t, err)
@@ -85,7 +85,7 @@ func TestSendLocalDataToSocket(t *testing.T) {
 		OS: "linux",
 	}
 
-	err = SendLocalDataToSocket(ctx, socketPath, config, cursor, trackingData, meta)
+	err = SendLocalDataToSocket(ctx, socketPath, config, cursor, trackingData, nil, meta)
 	if err != nil {
 		t.Fatalf("SendLocalDataToSocket failed: %v", err)
 	}
@@ -106,7 +106,7 @@ func TestSendLocalDataToSocket_SocketNotExists(t *testing.T) {
 	config := model.ShellTimeConfig{}
 	cursor := time.Now()
 
-	err := SendLocalDataToSocket(ctx, "/nonexistent/socket.sock", config, cursor, nil, model.TrackingMetaData{})
+	err := SendLocalDataToSocket(ctx, "/nonexistent/socket.sock", config, cursor, nil, nil, model.TrackingMetaData{})
 	if err == nil {
 		t.Error("Expected error when socket doesn't exist")
 	}
@@ -0,0 +1,211 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/malamtime/cli/model"
+)
+
+// EventType is the type of an event the daemon streams to subscribers.
+type EventType string
+
+const (
+	EventTrackPre     EventType = "track_pre"
+	EventTrackPost    EventType = "track_post"
+	EventSessionStart EventType = "session_start"
+	EventSessionEnd   EventType = "session_end"
+	// EventSyncSuccess / EventSyncFailure report a sync to one endpoint.
+	EventSyncSuccess EventType = "sync_success"
+	EventSyncFailure EventType = "sync_failure"
+	// EventHeartbeat reports a batch of editor heartbeats sent, or saved for
+	// a retry.
+	EventHeartbeat EventType = "heartbeat"
+	// EventOtel summarizes a batch of Claude Code or Codex OTEL events or
+	// metrics forwarded.
+	EventOtel EventType = "otel"
+	// EventCircuit reports a circuit breaker that opened, went half-open or
+	// closed.
+	EventCircuit EventType = "circuit"
+)
+
+// subscribeWriteTimeout drops a subscriber that stops reading.
+const subscribeWriteTimeout = 5 * time.Second
+
+// Event is a line of the subscribe stream. Only the fields of its type are
+// set.
+type Event struct {
+	// Seq increases with every event the daemon emits, so a consumer can
+	// restore their order.
+	Seq  uint64    `json:"seq"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	// track and session events
+	Command   string `json:"command,omitempty"`
+	Shell     string `json:"shell,omitempty"`
+	SessionID int64  `json:"sessionId,omitempty"`
+	Result    *int   `json:"result,omitempty"`
+
+	// sync and circuit events
+	Endpoint string                      `json:"endpoint,omitempty"`
+	Circuit  *model.CircuitBreakerStatus `json:"circuit,omitempty"`
+
+	// Source is claude-code or codex for otel events, Kind "events" or
+	// "metrics".
+	Source string `json:"source,omitempty"`
+	Kind   string `json:"kind,omitempty"`
+
+	// Count is the commands synced, heartbeats or OTEL records in the batch.
+	Count int    `json:"count,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// SubscribeRequest is the payload of a subscribe request.
+type SubscribeRequest struct {
+	// Types keeps the events of these types; a prefix such as "sync" or
+	// "track" stands for all its types. Empty keeps them all.
+	Types []EventType `json:"types,omitempty"`
+	// SessionID drops the track and session events of other shell sessions.
+	SessionID int64 `json:"sessionId,omitempty"`
+}
+
+func (r SubscribeRequest) matches(ev Event) bool {
+	if r.SessionID != 0 && ev.SessionID != 0 && ev.SessionID != r.SessionID {
+		return false
+	}
+	if len(r.Types) == 0 {
+		return true
+	}
+	for _, t := range r.Types {
+		if ev.Type == t || strings.HasPrefix(string(ev.Type), string(t)+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// eventChannel carries the events to the subscribers. It is nil until
+// NewSocketHandler is called.
+var eventChannel *GoChannel
+
+var (
+	eventSeq         atomic.Uint64
+	eventSubscribers atomic.Int32
+)
+
+// emitEvent publishes ev to the subscribers, if there are any.
+func emitEvent(ev Event) {
+	if eventChannel == nil || eventSubscribers.Load() == 0 {
+		return
+	}
+	ev.Seq = eventSeq.Add(1)
+	ev.Time = time.Now()
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("Failed to encode event", slog.String("type", string(ev.Type)), slog.Any("err", err))
+		return
+	}
+	if err := eventChannel.Publish(EventTopic, message.NewMessage(watermill.NewUUID(), buf)); err != nil {
+		slog.Debug("Failed to publish event", slog.String("type", string(ev.Type)), slog.Any("err", err))
+	}
+}
+
+func commandEvent(t EventType, cmd model.Command) Event {
+	ev := Event{Type: t, Command: cmd.Command, Shell: cmd.Shell, SessionID: cmd.SessionID}
+	if t == EventTrackPost {
+		result := cmd.Result
+		ev.Result = &result
+	}
+	return ev
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// endpointForKey returns the API endpoint with the given key, or the key when
+// it isn't configured anymore.
+func endpointForKey(key string) string {
+	if stConfig == nil {
+		return key
+	}
+	cfg, err := stConfig.ReadConfigFile(context.Background())
+	if err != nil {
+		return key
+	}
+	for _, endpoint := range model.SyncEndpoints(cfg) {
+		if model.EndpointKey(endpoint) == key {
+			return endpoint.APIEndpoint
+		}
+	}
+	return key
+}
+
+// serveSubscribe acknowledges a subscribe request, then streams the matching
+// events as NDJSON until the client goes away or the daemon stops.
+func (p *SocketHandler) serveSubscribe(conn net.Conn, req SocketRequest) {
+	reply := SocketReply{Protocol: SocketProtocolVersion, ID: req.ID}
+	var payload SubscribeRequest
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		reply.Error = err.Error()
+		json.NewEncoder(conn).Encode(reply)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := p.channel.Subscribe(ctx, EventTopic)
+	if err != nil {
+		reply.Error = err.Error()
+		json.NewEncoder(conn).Encode(reply)
+		return
+	}
+	eventSubscribers.Add(1)
+	defer eventSubscribers.Add(-1)
+
+	encoder := json.NewEncoder(conn)
+	reply.OK = true
+	if err := encoder.Encode(reply); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	// the client only reads, so a read returns once it closes the stream
+	go func() {
+		io.Copy(io.Discard, conn)
+		cancel()
+	}()
+	slog.Debug("Event subscriber connected", slog.String("id", req.ID))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			msg.Ack()
+			var ev Event
+			if err := json.Unmarshal(msg.Payload, &ev); err != nil || !payload.matches(ev) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(subscribeWriteTimeout))
+			if err := encoder.Encode(ev); err != nil {
+				slog.Debug("Event subscriber gone", slog.String("id", req.ID), slog.Any("err", err))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeRequest_Matches(t *testing.T) {
+	all := SubscribeRequest{}
+	assert.True(t, all.matches(Event{Type: EventCircuit}))
+
+	syncOnly := SubscribeRequest{Types: []EventType{"sync"}}
+	assert.True(t, syncOnly.matches(Event{Type: EventSyncSuccess}))
+	assert.True(t, syncOnly.matches(Event{Type: EventSyncFailure}))
+	assert.False(t, syncOnly.matches(Event{Type: EventTrackPost}))
+
+	exact := SubscribeRequest{Types: []EventType{EventTrackPost}}
+	assert.True(t, exact.matches(Event{Type: EventTrackPost}))
+	assert.False(t, exact.matches(Event{Type: EventTrackPre}))
+
+	session := SubscribeRequest{SessionID: 7}
+	assert.True(t, session.matches(Event{Type: EventTrackPost, SessionID: 7}))
+	assert.False(t, session.matches(Event{Type: EventTrackPost, SessionID: 8}))
+	assert.True(t, session.matches(Event{Type: EventSyncSuccess}), "events of no session pass")
+}
+
+func TestSubscribe_StreamsMatchingEvents(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan Event, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- Subscribe(ctx, socketPath, SubscribeRequest{Types: []EventType{"track"}}, func(ev Event) error {
+			got <- ev
+			return nil
+		})
+	}()
+	require.Eventually(t, func() bool { return eventSubscribers.Load() > 0 }, time.Second, 5*time.Millisecond)
+
+	emitEvent(Event{Type: EventSyncSuccess, Endpoint: "https://api.shelltime.xyz", Count: 3})
+	emitEvent(commandEvent(EventTrackPost, model.Command{Shell: "zsh", SessionID: 9, Command: "make test", Result: 2}))
+
+	select {
+	case ev := <-got:
+		assert.Equal(t, EventTrackPost, ev.Type)
+		assert.Equal(t, "make test", ev.Command)
+		require.NotNil(t, ev.Result)
+		assert.Equal(t, 2, *ev.Result)
+		assert.NotZero(t, ev.Seq)
+		assert.False(t, ev.Time.IsZero())
+	case <-time.After(2 * time.Second):
+		t.Fatal("track_post event not streamed")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Subscribe did not return after cancel")
+	}
+	require.Eventually(t, func() bool { return eventSubscribers.Load() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestSubscribe_CallbackErrorEndsStream(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	stopErr := errors.New("enough")
+	done := make(chan error, 1)
+	go func() {
+		done <- Subscribe(context.Background(), socketPath, SubscribeRequest{}, func(ev Event) error {
+			return stopErr
+		})
+	}()
+	require.Eventually(t, func() bool { return eventSubscribers.Load() > 0 }, time.Second, 5*time.Millisecond)
+	emitEvent(Event{Type: EventHeartbeat, Count: 1})
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, stopErr)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Subscribe did not return")
+	}
+}
+
+func TestSubscribe_InvalidTypesPayload(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	reply := exchange(t, socketPath, map[string]interface{}{
+		"protocol": SocketProtocolVersion,
+		"id":       "sub",
+		"type":     SocketMessageTypeSubscribe,
+		"payload":  map[string]interface{}{"types": "sync"},
+	})
+	assert.False(t, reply.OK)
+	assert.Contains(t, reply.Error, "invalid subscribe payload")
+}
+
+func TestSubscribe_LegacyDaemon(t *testing.T) {
+	socketPath := fakeDaemon(t, func(conn net.Conn, req SocketRequest) {})
+
+	err := Subscribe(context.Background(), socketPath, SubscribeRequest{}, func(Event) error { return nil })
+	var protoErr *ProtocolError
+	require.ErrorAs(t, err, &protoErr)
+	assert.Equal(t, 1, protoErr.Daemon)
+}
+
+func TestEmitEvent_NoSubscribers(t *testing.T) {
+	before := eventSeq.Load()
+	emitEvent(Event{Type: EventHeartbeat})
+	assert.Equal(t, before, eventSeq.Load(), "events aren't published without subscribers")
+}
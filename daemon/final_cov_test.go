@@ -16,7 +16,7 @@ import (
 // branch of SyncCircuitBreakerWrapper.SaveForRetry: a channel value cannot be
 // marshaled, so the wrapper returns the marshal error before persisting.
 func TestX3CircuitBreaker_SaveForRetryMarshalError(t *testing.T) {
-	wrapper := NewSyncCircuitBreakerService(&mockPublisher{})
+	wrapper := newTestCircuitBreakers(t, &mockPublisher{}).For("test")
 	// A chan cannot be JSON-marshaled -> error from the wrapper's Marshal.
 	err := wrapper.SaveForRetry(context.Background(), make(chan int))
 	require.Error(t, err)
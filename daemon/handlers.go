@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"log/slog"
@@ -14,8 +15,11 @@ func SocketTopicProcessor(messages <-chan *message.Message) {
 		slog.InfoContext(ctx, "received message: ", slog.String("msg.uuid", msg.UUID))
 
 		var socketMsg SocketMessage
-		if err := json.Unmarshal(msg.Payload, &socketMsg); err != nil {
+		decoder := json.NewDecoder(bytes.NewReader(msg.Payload))
+		decoder.UseNumber()
+		if err := decoder.Decode(&socketMsg); err != nil {
 			slog.ErrorContext(ctx, "failed to parse socket message", slog.Any("err", err))
+			metricMessagesProcessed.Inc("invalid", "failure")
 			msg.Nack()
 			continue
 		}
@@ -28,14 +32,18 @@ func SocketTopicProcessor(messages <-chan *message.Message) {
 			err = handlePubSubTrackPre(ctx, socketMsg.Payload)
 		case SocketMessageTypeTrackPost:
 			err = handlePubSubTrackPost(ctx, socketMsg.Payload)
+		case SocketMessageTypeSessionStart, SocketMessageTypeSessionEnd:
+			err = handlePubSubSessionEvent(ctx, socketMsg.Payload)
 		case SocketMessageTypeHeartbeat:
 			err = handlePubSubHeartbeat(ctx, socketMsg.Payload)
 		default:
 			slog.ErrorContext(ctx, "unknown socket message type", slog.String("type", string(socketMsg.Type)))
+			metricMessagesProcessed.Inc("unknown", "failure")
 			msg.Nack()
 			continue
 		}
 
+		metricMessagesProcessed.Inc(string(socketMsg.Type), resultLabel(err))
 		if err != nil {
 			slog.ErrorContext(ctx, "failed to handle socket message", slog.Any("err", err), slog.String("type", string(socketMsg.Type)))
 			msg.Nack()
@@ -39,16 +39,21 @@ func handlePubSubHeartbeat(ctx context.Context, socketMsgPayload interface{}) er
 	err = model.SendHeartbeatsToServer(ctx, cfg, heartbeatPayload)
 	if err != nil {
 		slog.Warn("Failed to send heartbeats to server, saving to local file", slog.Any("err", err))
+		emitEvent(Event{Type: EventHeartbeat, Count: len(heartbeatPayload.Heartbeats), Error: err.Error()})
 		// On failure, save to local file
 		if saveErr := saveHeartbeatToFile(heartbeatPayload); saveErr != nil {
 			slog.Error("Failed to save heartbeat to local file", slog.Any("err", saveErr))
+			metricHeartbeats.Add(float64(len(heartbeatPayload.Heartbeats)), "dropped")
 			return saveErr
 		}
+		metricHeartbeats.Add(float64(len(heartbeatPayload.Heartbeats)), "spooled")
 		// Return nil because we saved the data locally - don't nack the message
 		return nil
 	}
 
 	slog.Info("Successfully sent heartbeats to server", slog.Int("count", len(heartbeatPayload.Heartbeats)))
+	emitEvent(Event{Type: EventHeartbeat, Count: len(heartbeatPayload.Heartbeats)})
+	metricHeartbeats.Add(float64(len(heartbeatPayload.Heartbeats)), "sent")
 	return nil
 }
 
@@ -56,23 +61,15 @@ func handlePubSubHeartbeat(ctx context.Context, socketMsgPayload interface{}) er
 func saveHeartbeatToFile(payload model.HeartbeatPayload) error {
 	logFilePath := os.ExpandEnv(fmt.Sprintf("%s/%s", "$HOME", model.HEARTBEAT_LOG_FILE))
 
-	// Open file for appending, create if not exists
-	file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open heartbeat log file: %w", err)
-	}
-	defer file.Close()
-
 	// Marshal payload to JSON
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal heartbeat payload: %w", err)
 	}
 
-	// Write as single line with newline
-	_, err = file.Write(append(data, '\n'))
-	if err != nil {
-		return fmt.Errorf("failed to write heartbeat to file: %w", err)
+	// Append as single line, serialized with `sync pending drop`
+	if err := model.AppendPendingLine(logFilePath, data); err != nil {
+		return fmt.Errorf("failed to append to heartbeat log file: %w", err)
 	}
 
 	slog.Debug("Saved heartbeat to local file", slog.String("path", logFilePath), slog.Int("count", len(payload.Heartbeats)))
@@ -0,0 +1,47 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/malamtime/cli/model"
+)
+
+// resolveTerminal is a var so tests can skip walking the real process tree.
+var resolveTerminal = ResolveTerminal
+
+// handlePubSubSessionEvent persists a shell session start/end event to the
+// active store. It is synced along with the next batch of commands.
+func handlePubSubSessionEvent(ctx context.Context, payload interface{}) error {
+	commandStoreMu.RLock()
+	defer commandStoreMu.RUnlock()
+
+	pb, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var msg SessionEventPayload
+	if err := json.Unmarshal(pb, &msg); err != nil {
+		slog.Error("Failed to parse session event payload", slog.Any("err", err))
+		return err
+	}
+
+	ev := msg.Event
+	// Resolve on start only: by the time a session ends its terminal may
+	// already be gone from the process tree.
+	if ev.Type == model.SessionEventStart && ev.Terminal == "" && ev.PPID > 0 {
+		ev.Terminal, ev.Multiplexer = resolveTerminal(ev.PPID)
+	}
+
+	if err := trackStore().SaveSessionEvent(ctx, ev, time.Unix(0, msg.RecordingTimeNano)); err != nil {
+		return err
+	}
+	eventType := EventSessionStart
+	if ev.Type == model.SessionEventEnd {
+		eventType = EventSessionEnd
+	}
+	emitEvent(Event{Type: eventType, Shell: ev.Shell, SessionID: ev.SessionID})
+	return nil
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlePubSubSessionEvent(t *testing.T) {
+	prevStore, prevResolve := commandStore, resolveTerminal
+	t.Cleanup(func() {
+		commandStore = prevStore
+		resolveTerminal = prevResolve
+	})
+
+	store := &fakeCommandStore{}
+	commandStore = store
+	var resolved []int
+	resolveTerminal = func(ppid int) (string, string) {
+		resolved = append(resolved, ppid)
+		return "ghostty", "tmux"
+	}
+
+	now := time.Now()
+	start := SessionEventPayload{
+		Event:             model.SessionEvent{Type: model.SessionEventStart, Shell: "zsh", SessionID: 7, PPID: 42, TTY: "/dev/ttys003"},
+		RecordingTimeNano: now.UnixNano(),
+	}
+	end := SessionEventPayload{
+		Event:             model.SessionEvent{Type: model.SessionEventEnd, Shell: "zsh", SessionID: 7, PPID: 42},
+		RecordingTimeNano: now.Add(time.Minute).UnixNano(),
+	}
+	require.NoError(t, handlePubSubSessionEvent(context.Background(), start))
+	require.NoError(t, handlePubSubSessionEvent(context.Background(), end))
+
+	require.Len(t, store.sessions, 2)
+	assert.Equal(t, model.SessionEventStart, store.sessions[0].Type)
+	assert.Equal(t, "ghostty", store.sessions[0].Terminal)
+	assert.Equal(t, "tmux", store.sessions[0].Multiplexer)
+	assert.Equal(t, "/dev/ttys003", store.sessions[0].TTY)
+	assert.Equal(t, now.UnixNano(), store.sessions[0].RecordingTime.UnixNano())
+
+	assert.Equal(t, model.SessionEventEnd, store.sessions[1].Type)
+	assert.Empty(t, store.sessions[1].Terminal, "end events are not resolved")
+	assert.Equal(t, []int{42}, resolved)
+}
+
+func TestHandlePubSubSessionEvent_InvalidPayload(t *testing.T) {
+	assert.Error(t, handlePubSubSessionEvent(context.Background(), "not an object"))
+}
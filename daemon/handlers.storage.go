@@ -0,0 +1,253 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/malamtime/cli/model"
+)
+
+// StorageRequestTimeout bounds the storage requests, which rewrite the whole
+// store. Clients use the same timeout.
+const StorageRequestTimeout = time.Minute
+
+// StorageMigrateRequest is the payload of a storage_migrate request.
+type StorageMigrateRequest struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	DryRun     bool   `json:"dryRun,omitempty"`
+	KeepSource bool   `json:"keepSource,omitempty"`
+}
+
+// StorageMigrateResponse is the daemon's reply to a storage_migrate request.
+type StorageMigrateResponse struct {
+	Report *model.MigrationReport `json:"report,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// StorageFsckRequest is the payload of a storage_fsck request.
+type StorageFsckRequest struct {
+	// Engine defaults to the engine the daemon writes to.
+	Engine string `json:"engine,omitempty"`
+	Repair bool   `json:"repair,omitempty"`
+}
+
+// StorageFsckResponse is the daemon's reply to a storage_fsck request.
+type StorageFsckResponse struct {
+	Report *model.FsckReport `json:"report,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// StorageRotateKeyRequest is the payload of a storage_rotate_key request.
+type StorageRotateKeyRequest struct {
+	// NewKeyCommand prints the new key when the key comes from
+	// storage.encryption.keyCommand.
+	NewKeyCommand string `json:"newKeyCommand,omitempty"`
+}
+
+// StorageRotateKeyResponse is the daemon's reply to a storage_rotate_key
+// request.
+type StorageRotateKeyResponse struct {
+	Rotation *model.StorageKeyRotation `json:"rotation,omitempty"`
+	Error    string                    `json:"error,omitempty"`
+}
+
+// openEngineStore returns a store for engine, reusing the one the daemon
+// already holds since bolt can't be opened twice. opened reports whether the
+// caller must close it. Callers hold commandStoreMu.
+func openEngineStore(engine string) (store model.CommandStore, opened bool, err error) {
+	if commandStore != nil && commandStore.Engine() == engine {
+		return commandStore, false, nil
+	}
+	if commandStore == nil && engine == model.StorageEngineFile {
+		return newFallbackStore(), true, nil
+	}
+	store, err = model.NewCommandStore(model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: engine}})
+	return store, true, err
+}
+
+// migrateStorage runs a storage migration inside the daemon. Track handlers
+// wait for it, and afterwards the daemon writes to the new engine right
+// away, without a restart. The config must already name the destination:
+// otherwise the daemon would write to one engine until its next restart and
+// the daemon-less CLI to the other.
+func migrateStorage(ctx context.Context, req StorageMigrateRequest) (*model.MigrationReport, error) {
+	if !req.DryRun {
+		cfg, err := stConfig.ReadConfigFile(ctx, model.WithSkipCache())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if configured := storageEngine(cfg); configured != req.To {
+			return nil, fmt.Errorf("storage.engine is %s in the config, set it to %s before migrating", configured, req.To)
+		}
+	}
+
+	commandStoreMu.Lock()
+	defer commandStoreMu.Unlock()
+
+	from, fromOpened, err := openEngineStore(req.From)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s store: %w", req.From, err)
+	}
+	to, toOpened, err := openEngineStore(req.To)
+	if err != nil {
+		if fromOpened {
+			from.Close()
+		}
+		return nil, fmt.Errorf("failed to open %s store: %w", req.To, err)
+	}
+
+	report, err := model.MigrateStore(ctx, from, to, model.MigrateOptions{DryRun: req.DryRun, KeepSource: req.KeepSource})
+	if !fromOpened && errors.Is(err, model.ErrRetireSource) {
+		// the live store is closed now; reopen it so the track handlers
+		// don't write to a closed store
+		commandStore = nil
+		if reopened, _, openErr := openEngineStore(req.From); openErr != nil {
+			slog.Error("Failed to reopen the store after a failed migration", slog.String("engine", req.From), slog.Any("err", openErr))
+		} else {
+			commandStore = reopened
+		}
+	}
+	if err != nil || req.DryRun {
+		if fromOpened {
+			from.Close()
+		}
+		if toOpened {
+			to.Close()
+		}
+		return &report, err
+	}
+
+	// switch the live store over to the destination
+	previous := commandStore
+	switch {
+	case report.To == model.StorageEngineFile:
+		commandStore = nil
+		to.Close()
+	default:
+		commandStore = to
+	}
+	if previous != nil && previous != commandStore {
+		previous.Close()
+	}
+	if fromOpened {
+		from.Close()
+	}
+	slog.Info("Storage migrated", slog.String("from", report.From), slog.String("to", report.To),
+		slog.Int("copied", report.Copied), slog.String("retiredTo", report.RetiredTo))
+	return &report, nil
+}
+
+func (p *SocketHandler) handleStorageMigrate(conn net.Conn, msg SocketMessage) {
+	// copying a large store can outlast the default connection deadline
+	conn.SetDeadline(time.Now().Add(StorageRequestTimeout))
+	var req StorageMigrateRequest
+	response := StorageMigrateResponse{}
+	if err := decodePayload(msg.Payload, &req); err != nil {
+		response.Error = fmt.Sprintf("invalid storage_migrate payload: %v", err)
+	} else {
+		report, err := migrateStorage(context.Background(), req)
+		response.Report = report
+		if err != nil {
+			slog.Error("Storage migration failed", slog.Any("err", err))
+			response.Error = err.Error()
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		slog.Error("Error encoding storage_migrate response", slog.Any("err", err))
+	}
+}
+
+// fsckStorage checks a store inside the daemon. A repair pauses the track
+// handlers so nothing is appended while records are rewritten.
+func fsckStorage(ctx context.Context, req StorageFsckRequest) (*model.FsckReport, error) {
+	if req.Repair {
+		commandStoreMu.Lock()
+		defer commandStoreMu.Unlock()
+	} else {
+		commandStoreMu.RLock()
+		defer commandStoreMu.RUnlock()
+	}
+
+	engine := req.Engine
+	if engine == "" {
+		engine = model.StorageEngineFile
+		if commandStore != nil {
+			engine = commandStore.Engine()
+		}
+	}
+	store, opened, err := openEngineStore(engine)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s store: %w", engine, err)
+	}
+	if opened {
+		defer store.Close()
+	}
+
+	report, err := model.FsckStore(ctx, store, model.FsckOptions{Repair: req.Repair})
+	return &report, err
+}
+
+func (p *SocketHandler) handleStorageFsck(conn net.Conn, msg SocketMessage) {
+	// checking and repairing a large store can outlast the default connection
+	// deadline
+	conn.SetDeadline(time.Now().Add(StorageRequestTimeout))
+	var req StorageFsckRequest
+	response := StorageFsckResponse{}
+	if err := decodePayload(msg.Payload, &req); err != nil {
+		response.Error = fmt.Sprintf("invalid storage_fsck payload: %v", err)
+	} else {
+		report, err := fsckStorage(context.Background(), req)
+		response.Report = report
+		if err != nil {
+			slog.Error("Storage check failed", slog.Any("err", err))
+			response.Error = err.Error()
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		slog.Error("Error encoding storage_fsck response", slog.Any("err", err))
+	}
+}
+
+// rotateStorageKey re-encrypts the txt files and the store the daemon writes
+// to with a new key. Track handlers wait, so no record is written with the
+// old key meanwhile.
+func rotateStorageKey(ctx context.Context, req StorageRotateKeyRequest) (*model.StorageKeyRotation, error) {
+	commandStoreMu.Lock()
+	defer commandStoreMu.Unlock()
+
+	stores := []model.CommandStore{model.NewFileStore()}
+	if commandStore != nil && commandStore.Engine() != model.StorageEngineFile {
+		stores = append(stores, commandStore)
+	}
+	rotation, err := model.RotateStorageKey(ctx, stores, req.NewKeyCommand)
+	return &rotation, err
+}
+
+func (p *SocketHandler) handleStorageRotateKey(conn net.Conn, msg SocketMessage) {
+	// re-encrypting a large store can outlast the default connection deadline
+	conn.SetDeadline(time.Now().Add(StorageRequestTimeout))
+	var req StorageRotateKeyRequest
+	response := StorageRotateKeyResponse{}
+	if err := decodePayload(msg.Payload, &req); err != nil {
+		response.Error = fmt.Sprintf("invalid storage_rotate_key payload: %v", err)
+	} else {
+		rotation, err := rotateStorageKey(context.Background(), req)
+		response.Rotation = rotation
+		if err != nil {
+			slog.Error("Storage key rotation failed", slog.Any("err", err))
+			response.Error = err.Error()
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		slog.Error("Error encoding storage_rotate_key response", slog.Any("err", err))
+	}
+}
@@ -0,0 +1,167 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateStorage_SwitchesLiveStore(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	prev := commandStore
+	t.Cleanup(func() { commandStore = prev })
+	commandStore = nil
+
+	ctx := context.Background()
+	now := time.Now()
+	cmd := model.Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Time: now}
+	file := model.NewFileStore()
+	require.NoError(t, file.SavePre(ctx, cmd, now))
+	require.NoError(t, file.SavePost(ctx, cmd, 0, now.Add(time.Second)))
+
+	mockCS := model.NewMockConfigService(t)
+	withStConfig(t, mockCS)
+	engine := func(engine string) model.ShellTimeConfig {
+		return model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: engine}}
+	}
+	mockCS.On("ReadConfigFile", mock.Anything, mock.Anything).Return(engine(model.StorageEngineSegment), nil).Once()
+	mockCS.On("ReadConfigFile", mock.Anything, mock.Anything).Return(engine(model.StorageEngineFile), nil).Once()
+
+	// a dry run leaves the daemon on the file store
+	report, err := migrateStorage(ctx, StorageMigrateRequest{From: model.StorageEngineFile, To: model.StorageEngineSegment, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Post)
+	assert.Nil(t, commandStore)
+
+	report, err = migrateStorage(ctx, StorageMigrateRequest{From: model.StorageEngineFile, To: model.StorageEngineSegment})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Copied)
+	require.NotNil(t, commandStore)
+	assert.Equal(t, model.StorageEngineSegment, commandStore.Engine())
+
+	// and back: the daemon returns to the fallback file store
+	report, err = migrateStorage(ctx, StorageMigrateRequest{From: model.StorageEngineSegment, To: model.StorageEngineFile})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Copied)
+	assert.Nil(t, commandStore)
+	posts, err := model.NewFileStore().GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Len(t, posts, 1)
+}
+
+func TestMigrateStorage_ReopensSourceWhenRetireFails(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	prev := commandStore
+	t.Cleanup(func() { commandStore = prev })
+
+	ctx := context.Background()
+	bolt, err := model.NewCommandStore(model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: model.StorageEngineBolt}})
+	require.NoError(t, err)
+	commandStore = bolt
+	now := time.Now()
+	cmd := model.Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Time: now}
+	require.NoError(t, bolt.SavePost(ctx, cmd, 0, now))
+
+	// a file where the retired folder goes makes retiring fail
+	require.NoError(t, os.WriteFile(model.GetStoragePath("commands", "retired"), nil, 0600))
+
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything, mock.Anything).Return(model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: model.StorageEngineSegment}}, nil)
+	withStConfig(t, mockCS)
+
+	_, err = migrateStorage(ctx, StorageMigrateRequest{From: model.StorageEngineBolt, To: model.StorageEngineSegment})
+	require.ErrorIs(t, err, model.ErrRetireSource)
+	require.NotNil(t, commandStore)
+	assert.NotSame(t, bolt, commandStore)
+	assert.Equal(t, model.StorageEngineBolt, commandStore.Engine())
+	require.NoError(t, commandStore.SavePost(ctx, cmd, 0, now.Add(time.Second)))
+	posts, err := commandStore.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Len(t, posts, 2)
+	require.NoError(t, commandStore.Close())
+}
+
+func TestMigrateStorage_RefusesEngineNotInConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	prev := commandStore
+	t.Cleanup(func() { commandStore = prev })
+	commandStore = nil
+
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything, mock.Anything).Return(model.ShellTimeConfig{}, nil)
+	withStConfig(t, mockCS)
+
+	_, err := migrateStorage(context.Background(), StorageMigrateRequest{From: model.StorageEngineFile, To: model.StorageEngineBolt})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "set it to bolt")
+	assert.Nil(t, commandStore)
+}
+
+func TestFsckStorage_UsesLiveStore(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	prev := commandStore
+	t.Cleanup(func() { commandStore = prev })
+	commandStore = nil
+
+	ctx := context.Background()
+	now := time.Now()
+	cmd := model.Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Time: now}
+	require.NoError(t, model.NewFileStore().SavePost(ctx, cmd, 0, now))
+	require.NoError(t, model.NewFileStore().SetCursor(ctx, now.Add(48*time.Hour)))
+
+	report, err := fsckStorage(ctx, StorageFsckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, model.StorageEngineFile, report.Engine)
+	assert.Equal(t, 1, report.Count(model.FsckCursorAhead))
+
+	report, err = fsckStorage(ctx, StorageFsckRequest{Repair: true})
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+	cursor, _, err := model.NewFileStore().GetLastCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, now.UnixNano(), cursor.UnixNano())
+}
+
+func TestSocketHandler_StorageRotateKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	prev := commandStore
+	t.Cleanup(func() { commandStore = prev })
+	t.Cleanup(func() { model.ConfigureStorageEncryption(model.ShellTimeConfig{}) })
+
+	ctx := context.Background()
+	segment, err := model.NewCommandStore(model.ShellTimeConfig{Storage: &model.StorageConfig{Engine: model.StorageEngineSegment}})
+	require.NoError(t, err)
+	commandStore = segment
+
+	enabled := true
+	model.ConfigureStorageEncryption(model.ShellTimeConfig{Storage: &model.StorageConfig{
+		Encryption: &model.StorageEncryptionConfig{Enabled: &enabled},
+	}})
+	now := time.Now()
+	cmd := model.Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Time: now}
+	require.NoError(t, model.NewFileStore().SavePre(ctx, cmd, now))
+	require.NoError(t, segment.SavePost(ctx, cmd, 0, now))
+
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+	resp, err := RequestStorageRotateKey(socketPath, StorageRotateKeyRequest{}, time.Second)
+	require.NoError(t, err)
+	require.Empty(t, resp.Error)
+	assert.Equal(t, 2, resp.Rotation.Resealed, "the txt files and the live store")
+	assert.FileExists(t, model.GetStorageKeyFilePath()+".old")
+
+	posts, err := segment.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "make", posts[0].Command)
+}
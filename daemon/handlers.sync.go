@@ -4,43 +4,83 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/malamtime/cli/model"
 )
 
-func handlePubSubSync(ctx context.Context, socketMsgPayload interface{}) error {
-	// Check circuit breaker first
-	if syncCircuitBreaker != nil && syncCircuitBreaker.IsOpen() {
-		slog.Error("Circuit breaker is open, saving sync data locally for later retry")
-		if err := syncCircuitBreaker.SaveForRetry(ctx, socketMsgPayload); err != nil {
-			slog.Error("Failed to save sync data for retry", slog.Any("err", err))
-			return err
-		}
-		return nil // Return nil to ack the message
-	}
+// syncRetryPayload is a sync payload queued for retry. It is bound to the
+// endpoint that failed it; payloads queued before there was a queue per
+// endpoint have none and are sent to all of them.
+type syncRetryPayload struct {
+	model.PostTrackArgs
+	Endpoint string `json:"endpoint,omitempty"`
+}
 
+// errCircuitOpen skips an endpoint whose circuit breaker is open.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+func handlePubSubSync(ctx context.Context, socketMsgPayload interface{}) error {
 	pb, err := json.Marshal(socketMsgPayload)
 	if err != nil {
 		slog.Error("Failed to marshal the sync payload again for unmarshal", slog.Any("payload", socketMsgPayload))
 		return err
 	}
 
-	var syncMsg model.PostTrackArgs
+	var syncMsg syncRetryPayload
 	err = json.Unmarshal(pb, &syncMsg)
 	if err != nil {
 		slog.Error("Failed to parse sync payload", slog.Any("payload", socketMsgPayload))
 		return err
 	}
 
-	return sendTrackArgsToServer(ctx, syncMsg)
+	// chunks an endpoint acknowledged are not sent to it again: when an
+	// endpoint fails, or its circuit breaker is open, the rest of the payload
+	// is queued for that endpoint alone and the message is acked
+	var mu sync.Mutex
+	acked := make(map[string]int64)
+	err = sendTrackArgsToServer(ctx, syncMsg.PostTrackArgs, model.SyncOptions{
+		Only: syncMsg.Endpoint,
+		OnAck: func(ctx context.Context, endpoint model.Endpoint, cursor time.Time) error {
+			mu.Lock()
+			defer mu.Unlock()
+			acked[model.EndpointKey(endpoint)] = cursor.UnixNano()
+			return nil
+		},
+	})
+	failed := model.EndpointErrors(err)
+	if err == nil || len(failed) == 0 {
+		return err
+	}
+	for _, endpointErr := range failed {
+		breaker := circuitBreakerFor(endpointErr.Endpoint)
+		if breaker == nil {
+			return err
+		}
+		rest := syncMsg.PostTrackArgs
+		if cursor, ok := acked[model.EndpointKey(endpointErr.Endpoint)]; ok {
+			rest = rest.After(cursor)
+		}
+		if errors.Is(endpointErr, errCircuitOpen) {
+			slog.Error("Circuit breaker is open, saving sync data locally for later retry", slog.String("endpoint", endpointErr.Endpoint.APIEndpoint))
+		}
+		if saveErr := breaker.SaveForRetry(ctx, rest); saveErr != nil {
+			slog.Error("Failed to save sync data for retry", slog.Any("err", saveErr))
+			return saveErr
+		}
+	}
+	return nil
 }
 
 // sendTrackArgsToServer enriches a tracking payload (terminal resolution, daemon
-// source, optional encryption) and sends it to the server, recording circuit
-// breaker state. It is shared by the sync handler and the bolt track handler.
-func sendTrackArgsToServer(ctx context.Context, syncMsg model.PostTrackArgs) error {
+// source, optional encryption) and sends it to every endpoint chunk by chunk,
+// recording the circuit breaker state of each. Endpoints with an open circuit
+// breaker are skipped with errCircuitOpen. It is shared by the sync handler
+// and the store-backed track handler.
+func sendTrackArgsToServer(ctx context.Context, syncMsg model.PostTrackArgs, opts model.SyncOptions) error {
 	// Resolve terminal from PPID (use first data item's PPID)
 	if len(syncMsg.Data) > 0 && syncMsg.Data[0].PPID > 0 {
 		terminal, multiplexer := ResolveTerminal(syncMsg.Data[0].PPID)
@@ -62,10 +102,11 @@ func sendTrackArgsToServer(ctx context.Context, syncMsg model.PostTrackArgs) err
 		CursorID: time.Unix(0, syncMsg.CursorID).UnixNano(), // Convert nano timestamp to time.Time
 		Data:     syncMsg.Data,
 		Meta:     syncMsg.Meta,
+		Sessions: syncMsg.Sessions,
 	}
 
 	// only daemon service can enable the encryption mode
-	var realPayload model.PostTrackArgs
+	var publicKey string
 	if cfg.Encrypted != nil && *cfg.Encrypted == true {
 		ot, err := model.GetOpenTokenPublicKey(ctx, model.Endpoint{
 			Token:       cfg.Token,
@@ -80,63 +121,106 @@ func sendTrackArgsToServer(ctx context.Context, syncMsg model.PostTrackArgs) err
 			// configured intent.
 			return err
 		}
-		if len(ot.PublicKey) > 0 {
-			rs := model.NewRSAService()
-			as := model.NewAESGCMService()
-
-			k, _, err := as.GenerateKeys()
-
-			if err != nil {
-				slog.Error("Failed to generate aes-gcm key", slog.Any("err", err))
-			}
+		publicKey = ot.PublicKey
+	}
 
-			encodedKey, _, err := rs.Encrypt(ot.PublicKey, k)
+	// the commands of the chunk in flight per endpoint, remembered once acked
+	var mu sync.Mutex
+	inFlight := make(map[string][]model.TrackingData)
 
-			if err != nil {
-				slog.Error("Failed to encrypt key", slog.Any("err", err))
-			}
+	opts.Check = func(endpoint model.Endpoint) error {
+		if breaker := circuitBreakerFor(endpoint); breaker != nil && breaker.IsOpen() {
+			return errCircuitOpen
+		}
+		return nil
+	}
+	// encrypted payloads can't be split, so chunks are encrypted one by one
+	opts.Prepare = func(endpoint model.Endpoint, chunk model.PostTrackArgs) (model.PostTrackArgs, error) {
+		key := model.EndpointKey(endpoint)
+		// drop the commands this endpoint already acknowledged, by ID, so a
+		// replayed payload isn't counted twice
+		chunk.Data = sentRecords.filter(key, chunk.Data)
+		mu.Lock()
+		inFlight[key] = chunk.Data
+		mu.Unlock()
+		if len(chunk.Data) == 0 && len(chunk.Sessions) == 0 {
+			// a replay of commands the endpoint already acknowledged
+			return chunk, nil
+		}
+		if len(publicKey) > 0 {
+			return encryptTrackArgs(publicKey, chunk)
+		}
+		return chunk, nil
+	}
+	onAck := opts.OnAck
+	opts.OnAck = func(ctx context.Context, endpoint model.Endpoint, cursor time.Time) error {
+		key := model.EndpointKey(endpoint)
+		if breaker := circuitBreakerFor(endpoint); breaker != nil {
+			breaker.RecordSuccess()
+		}
+		mu.Lock()
+		sent := len(inFlight[key])
+		sentRecords.add(key, inFlight[key])
+		delete(inFlight, key)
+		mu.Unlock()
+		emitEvent(Event{Type: EventSyncSuccess, Endpoint: endpoint.APIEndpoint, Count: sent})
+		metricSyncs.Inc(endpoint.APIEndpoint, resultLabel(nil))
+		metricSyncBatchSize.Observe(float64(sent), endpoint.APIEndpoint)
+		if onAck != nil {
+			return onAck(ctx, endpoint, cursor)
+		}
+		return nil
+	}
 
-			buf, err := json.Marshal(payload)
+	err = model.SendLocalDataToServer(ctx, cfg, payload, opts)
+	for _, endpointErr := range model.EndpointErrors(err) {
+		if errors.Is(endpointErr, errCircuitOpen) {
+			continue
+		}
+		if breaker := circuitBreakerFor(endpointErr.Endpoint); breaker != nil {
+			breaker.RecordFailure()
+		}
+		emitEvent(Event{Type: EventSyncFailure, Endpoint: endpointErr.Endpoint.APIEndpoint, Count: len(payload.Data), Error: errorString(endpointErr.Err)})
+		metricSyncs.Inc(endpointErr.Endpoint.APIEndpoint, resultLabel(endpointErr.Err))
+		slog.Error("Failed to sync data to server", slog.String("endpoint", endpointErr.Endpoint.APIEndpoint), slog.Any("err", endpointErr.Err))
+	}
+	return err
+}
 
-			if err != nil {
-				slog.Error("Failed to marshal payload", slog.Any("err", err))
-				return err
-			}
+// encryptTrackArgs encrypts the payload with a new AES-GCM key, which is
+// itself encrypted with the open token's public key.
+func encryptTrackArgs(publicKey string, payload model.PostTrackArgs) (model.PostTrackArgs, error) {
+	rs := model.NewRSAService()
+	as := model.NewAESGCMService()
 
-			encryptedData, nonce, err := as.Encrypt(string(k), buf)
-			if err != nil {
-				slog.Error("Failed to encrypt data", slog.Any("err", err))
-				return err
-			}
+	k, _, err := as.GenerateKeys()
 
-			realPayload = model.PostTrackArgs{
-				Encrypted: base64.StdEncoding.EncodeToString(encryptedData),
-				AesKey:    base64.StdEncoding.EncodeToString(encodedKey),
-				Nonce:     base64.StdEncoding.EncodeToString(nonce),
-			}
-		}
+	if err != nil {
+		slog.Error("Failed to generate aes-gcm key", slog.Any("err", err))
 	}
 
-	if len(realPayload.Encrypted) == 0 {
-		realPayload = payload
+	encodedKey, _, err := rs.Encrypt(publicKey, k)
+
+	if err != nil {
+		slog.Error("Failed to encrypt key", slog.Any("err", err))
 	}
 
-	err = model.SendLocalDataToServer(
-		ctx,
-		cfg,
-		realPayload,
-	)
+	buf, err := json.Marshal(payload)
 
 	if err != nil {
-		if syncCircuitBreaker != nil {
-			syncCircuitBreaker.RecordFailure()
-		}
-		slog.Error("Failed to sync data to server", slog.Any("err", err))
-		return err
+		slog.Error("Failed to marshal payload", slog.Any("err", err))
+		return payload, err
 	}
 
-	if syncCircuitBreaker != nil {
-		syncCircuitBreaker.RecordSuccess()
+	encryptedData, nonce, err := as.Encrypt(string(k), buf)
+	if err != nil {
+		slog.Error("Failed to encrypt data", slog.Any("err", err))
+		return payload, err
 	}
-	return nil
+
+	return model.PostTrackArgs{
+		Encrypted: base64.StdEncoding.EncodeToString(encryptedData),
+		AesKey:    base64.StdEncoding.EncodeToString(encodedKey),
+		Nonce:     base64.StdEncoding.EncodeToString(nonce),
+	}, nil
 }
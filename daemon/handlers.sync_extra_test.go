@@ -31,30 +31,51 @@ func (f *fakeDaemonCB) SaveForRetry(ctx context.Context, payload interface{}) er
 	f.savedPayloads = append(f.savedPayloads, payload)
 	return f.saveErr
 }
+func (f *fakeDaemonCB) PendingCount() int { return len(f.savedPayloads) }
+func (f *fakeDaemonCB) Status() model.CircuitBreakerStatus {
+	if f.open {
+		return model.CircuitBreakerStatus{State: model.CircuitOpen, ConsecutiveFailures: int(f.failureCount.Load())}
+	}
+	return model.CircuitBreakerStatus{State: model.CircuitClosed}
+}
 
+// withCircuitBreaker makes cb the circuit breaker of every endpoint.
 func withCircuitBreaker(t *testing.T, cb DaemonCircuitBreaker) {
 	t.Helper()
-	prev := syncCircuitBreaker
-	syncCircuitBreaker = cb
-	t.Cleanup(func() { syncCircuitBreaker = prev })
+	prev := syncCircuitBreakerFor
+	syncCircuitBreakerFor = func(string) DaemonCircuitBreaker { return cb }
+	t.Cleanup(func() { syncCircuitBreakerFor = prev })
+}
+
+// unreachableSyncConfig returns a config whose server fails the test when
+// anything is sent to it.
+func unreachableSyncConfig(t *testing.T) *model.MockConfigService {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("nothing should be sent while the circuit breaker is open")
+	}))
+	t.Cleanup(server.Close)
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{Token: "tok", APIEndpoint: server.URL}, nil)
+	return mockCS
 }
 
 func TestHandlePubSubSync_CircuitBreakerOpen_SavesAndAcks(t *testing.T) {
 	cb := &fakeDaemonCB{open: true}
 	withCircuitBreaker(t, cb)
-	// stConfig must NOT be consulted when the breaker is open.
-	withStConfig(t, model.NewMockConfigService(t))
+	withStConfig(t, unreachableSyncConfig(t))
 
 	payload := model.PostTrackArgs{CursorID: time.Now().UnixNano(), Data: []model.TrackingData{{Command: "ls"}}}
 	err := handlePubSubSync(context.Background(), payload)
 	require.NoError(t, err) // nil -> message acked
 	require.Len(t, cb.savedPayloads, 1)
+	assert.Equal(t, int32(0), cb.failureCount.Load(), "a skipped endpoint is no new failure")
 }
 
 func TestHandlePubSubSync_CircuitBreakerOpen_SaveError(t *testing.T) {
 	cb := &fakeDaemonCB{open: true, saveErr: errors.New("disk full")}
 	withCircuitBreaker(t, cb)
-	withStConfig(t, model.NewMockConfigService(t))
+	withStConfig(t, unreachableSyncConfig(t))
 
 	payload := model.PostTrackArgs{CursorID: time.Now().UnixNano()}
 	err := handlePubSubSync(context.Background(), payload)
@@ -62,6 +83,79 @@ func TestHandlePubSubSync_CircuitBreakerOpen_SaveError(t *testing.T) {
 	assert.Contains(t, err.Error(), "disk full")
 }
 
+func TestHandlePubSubSync_PartialFailure_SavesRemainder(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) > 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cb := &fakeDaemonCB{}
+	withCircuitBreaker(t, cb)
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{Token: "tok", APIEndpoint: server.URL}, nil)
+	withStConfig(t, mockCS)
+
+	payload := model.PostTrackArgs{CursorID: time.Now().UnixNano()}
+	for i := 1; i <= model.SyncChunkMaxCommands+3; i++ {
+		payload.Data = append(payload.Data, model.TrackingData{Command: "ls", EndTimeNano: int64(i)})
+	}
+
+	// the acknowledged chunk is not sent again: the rest is queued and the
+	// message acked
+	require.NoError(t, handlePubSubSync(context.Background(), payload))
+	require.Len(t, cb.savedPayloads, 1)
+	rest, ok := cb.savedPayloads[0].(model.PostTrackArgs)
+	require.True(t, ok)
+	assert.Len(t, rest.Data, 3)
+	assert.Equal(t, int64(model.SyncChunkMaxCommands+1), rest.Data[0].EndTimeNano)
+	assert.Equal(t, int32(1), cb.failureCount.Load())
+}
+
+func TestHandlePubSubSync_QueuesPerEndpoint(t *testing.T) {
+	var mainHits, mirrorHits atomic.Int32
+	mainServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mainHits.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer mainServer.Close()
+	mirrorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHits.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer mirrorServer.Close()
+
+	cfg := model.ShellTimeConfig{Token: "tok", APIEndpoint: mainServer.URL, Endpoints: []model.Endpoint{{Token: "tok2", APIEndpoint: mirrorServer.URL}}}
+	mainKey, mirrorKey := model.EndpointKey(model.SyncEndpoints(cfg)[0]), model.EndpointKey(cfg.Endpoints[0])
+	breakers := map[string]*fakeDaemonCB{mainKey: {}, mirrorKey: {}}
+	prev := syncCircuitBreakerFor
+	syncCircuitBreakerFor = func(key string) DaemonCircuitBreaker { return breakers[key] }
+	t.Cleanup(func() { syncCircuitBreakerFor = prev })
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything).Return(cfg, nil)
+	withStConfig(t, mockCS)
+
+	payload := model.PostTrackArgs{CursorID: time.Now().UnixNano(), Data: []model.TrackingData{{Command: "ls", EndTimeNano: 1}}}
+	require.NoError(t, handlePubSubSync(context.Background(), payload))
+	assert.Empty(t, breakers[mainKey].savedPayloads)
+	require.Len(t, breakers[mirrorKey].savedPayloads, 1)
+	assert.Equal(t, int32(1), breakers[mirrorKey].failureCount.Load())
+	assert.Equal(t, int32(1), breakers[mainKey].successCount.Load())
+	assert.Equal(t, 1.0, metricSyncs.Value(mainServer.URL, "success"))
+	assert.Equal(t, 1.0, metricSyncs.Value(mirrorServer.URL, "failure"))
+
+	// the retry only goes to the endpoint that failed it
+	retry := syncRetryPayload{PostTrackArgs: payload, Endpoint: mirrorKey}
+	require.NoError(t, handlePubSubSync(context.Background(), retry))
+	assert.Equal(t, int32(1), mainHits.Load())
+	assert.Equal(t, int32(2), mirrorHits.Load())
+	assert.Len(t, breakers[mirrorKey].savedPayloads, 2)
+}
+
 func TestSendTrackArgsToServer_SuccessRecordsSuccessAndResolvesTerminal(t *testing.T) {
 	var hits atomic.Int32
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -89,7 +183,7 @@ func TestSendTrackArgsToServer_SuccessRecordsSuccessAndResolvesTerminal(t *testi
 		Meta:     model.TrackingMetaData{OS: "linux", Shell: "bash"},
 	}
 
-	err := sendTrackArgsToServer(context.Background(), msg)
+	err := sendTrackArgsToServer(context.Background(), msg, model.SyncOptions{})
 	require.NoError(t, err)
 	assert.Equal(t, int32(1), hits.Load())
 	assert.Equal(t, int32(1), cb.successCount.Load())
@@ -118,7 +212,7 @@ func TestSendTrackArgsToServer_FailureRecordsFailure(t *testing.T) {
 		Meta:     model.TrackingMetaData{OS: "linux"},
 	}
 
-	err := sendTrackArgsToServer(context.Background(), msg)
+	err := sendTrackArgsToServer(context.Background(), msg, model.SyncOptions{})
 	require.Error(t, err)
 	assert.Equal(t, int32(1), cb.failureCount.Load())
 	assert.Equal(t, int32(0), cb.successCount.Load())
@@ -133,7 +227,7 @@ func TestSendTrackArgsToServer_ConfigError(t *testing.T) {
 	withStConfig(t, mockCS)
 
 	msg := model.PostTrackArgs{CursorID: time.Now().UnixNano(), Data: []model.TrackingData{{Command: "x"}}}
-	err := sendTrackArgsToServer(context.Background(), msg)
+	err := sendTrackArgsToServer(context.Background(), msg, model.SyncOptions{})
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "cfg boom")
 	// Neither success nor failure recorded; we never reached the send.
@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/malamtime/cli/model"
+)
+
+// Actions of a sync_pending request.
+const (
+	SyncPendingList  = "list"
+	SyncPendingShow  = "show"
+	SyncPendingDrop  = "drop"
+	SyncPendingRetry = "retry"
+)
+
+// SyncPendingRequestTimeout bounds the sync_pending requests, which read the
+// pending files whole. Clients use the same timeout.
+const SyncPendingRequestTimeout = 30 * time.Second
+
+// SyncPendingRequest is the payload of a sync_pending request.
+type SyncPendingRequest struct {
+	Action string `json:"action"`
+	// Index is the payload to show, as numbered by list.
+	Index int `json:"index,omitempty"`
+	// OlderThan is the age from which drop discards payloads.
+	OlderThan time.Duration `json:"olderThan,omitempty"`
+}
+
+// SyncPendingResponse is the daemon's reply to a sync_pending request.
+type SyncPendingResponse struct {
+	// Payloads describes the pending payloads for list, and the shown one
+	// for show.
+	Payloads []model.PendingPayload `json:"payloads,omitempty"`
+	// Payload is the content of the shown payload.
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Dropped int             `json:"dropped,omitempty"`
+	// Retried counts the payloads a retry replays.
+	Retried int    `json:"retried,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// syncPending runs a sync_pending request on the pending files. A retry
+// wakes the circuit breakers and the heartbeat resync, which replay their
+// files in the background.
+func syncPending(req SyncPendingRequest) (SyncPendingResponse, error) {
+	var response SyncPendingResponse
+	switch req.Action {
+	case SyncPendingList:
+		payloads, err := model.ListPendingPayloads()
+		response.Payloads = payloads
+		return response, err
+	case SyncPendingShow:
+		payload, content, err := model.ReadPendingPayload(req.Index)
+		if err != nil {
+			return response, err
+		}
+		response.Payloads = []model.PendingPayload{payload}
+		response.Payload = content
+		return response, nil
+	case SyncPendingDrop:
+		if req.OlderThan <= 0 {
+			return response, errors.New("olderThan must be positive")
+		}
+		dropped, err := model.DropPendingPayloads(req.OlderThan)
+		response.Dropped = dropped
+		if dropped > 0 {
+			slog.Info("Dropped pending payloads", slog.Int("dropped", dropped), slog.Duration("olderThan", req.OlderThan))
+		}
+		return response, err
+	case SyncPendingRetry:
+		if syncRetryNow == nil && heartbeatRetryNow == nil {
+			return response, errors.New("the daemon runs no retry service")
+		}
+		payloads, err := model.ListPendingPayloads()
+		if err != nil {
+			return response, err
+		}
+		for _, p := range payloads {
+			switch {
+			case p.Kind == model.PendingKindSync && syncRetryNow != nil,
+				p.Kind == model.PendingKindHeartbeat && heartbeatRetryNow != nil:
+				response.Retried++
+			}
+		}
+		if syncRetryNow != nil {
+			syncRetryNow()
+		}
+		if heartbeatRetryNow != nil {
+			heartbeatRetryNow()
+		}
+		slog.Info("Retrying pending payloads", slog.Int("count", response.Retried))
+		return response, nil
+	}
+	return response, fmt.Errorf("unknown sync_pending action %q", req.Action)
+}
+
+func (p *SocketHandler) handleSyncPending(conn net.Conn, msg SocketMessage) {
+	conn.SetDeadline(time.Now().Add(SyncPendingRequestTimeout))
+	var req SyncPendingRequest
+	response := SyncPendingResponse{}
+	if err := decodePayload(msg.Payload, &req); err != nil {
+		response.Error = fmt.Sprintf("invalid sync_pending payload: %v", err)
+	} else {
+		var err error
+		response, err = syncPending(req)
+		if err != nil {
+			response.Error = err.Error()
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		slog.Error("Error encoding sync_pending response", slog.Any("err", err))
+	}
+}
@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queuePendingSync saves a sync payload for the endpoint with the given key
+// the way its circuit breaker does.
+func queuePendingSync(t *testing.T, key string, at time.Time) {
+	t.Helper()
+	line, err := json.Marshal(SocketMessage{Type: SocketMessageTypeSync, Payload: syncRetryPayload{
+		PostTrackArgs: model.PostTrackArgs{CursorID: at.UnixNano(), Data: []model.TrackingData{{Command: "make", EndTimeNano: at.UnixNano()}}},
+		Endpoint:      key,
+	}})
+	require.NoError(t, err)
+	require.NoError(t, model.AppendPendingLine(filepath.Join(os.Getenv("HOME"), pendingFileFor(key)), line))
+}
+
+// chanPublisher hands the published payloads to a channel.
+type chanPublisher chan []byte
+
+func (c chanPublisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		c <- msg.Payload
+	}
+	return nil
+}
+
+func (c chanPublisher) Close() error {
+	return nil
+}
+
+func TestSyncPending(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	require.NoError(t, os.MkdirAll(filepath.Join(os.Getenv("HOME"), model.COMMAND_BASE_STORAGE_FOLDER), 0755))
+	queuePendingSync(t, "abc", time.Now().Add(-72*time.Hour))
+	queuePendingSync(t, "abc", time.Now())
+
+	resp, err := syncPending(SyncPendingRequest{Action: SyncPendingList})
+	require.NoError(t, err)
+	require.Len(t, resp.Payloads, 2)
+	assert.Equal(t, "abc", resp.Payloads[0].Endpoint)
+
+	resp, err = syncPending(SyncPendingRequest{Action: SyncPendingShow, Index: 2})
+	require.NoError(t, err)
+	var args model.PostTrackArgs
+	require.NoError(t, json.Unmarshal(resp.Payload, &args))
+	assert.Equal(t, "make", args.Data[0].Command)
+
+	_, err = syncPending(SyncPendingRequest{Action: SyncPendingDrop})
+	assert.Error(t, err, "drop needs an age")
+	resp, err = syncPending(SyncPendingRequest{Action: SyncPendingDrop, OlderThan: 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Dropped)
+
+	_, err = syncPending(SyncPendingRequest{Action: "flush"})
+	assert.ErrorContains(t, err, "unknown sync_pending action")
+}
+
+func TestSyncPending_Retry(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	require.NoError(t, os.MkdirAll(filepath.Join(os.Getenv("HOME"), model.COMMAND_BASE_STORAGE_FOLDER), 0755))
+	queuePendingSync(t, "abc", time.Now())
+
+	prevSync, prevHeartbeat := syncRetryNow, heartbeatRetryNow
+	t.Cleanup(func() { syncRetryNow, heartbeatRetryNow = prevSync, prevHeartbeat })
+	syncRetryNow, heartbeatRetryNow = nil, nil
+
+	_, err := syncPending(SyncPendingRequest{Action: SyncPendingRetry})
+	assert.Error(t, err, "nothing can replay the payloads")
+
+	republished := make(chan []byte, 1)
+	breakers := newTestCircuitBreakers(t, chanPublisher(republished))
+	ctx := t.Context()
+	require.NoError(t, breakers.Start(ctx))
+	defer breakers.Stop()
+
+	resp, err := syncPending(SyncPendingRequest{Action: SyncPendingRetry})
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Retried)
+	select {
+	case data := <-republished:
+		assert.Contains(t, string(data), `"endpoint":"abc"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued payload wasn't replayed")
+	}
+}
+
+func TestSocketHandler_SyncPending(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	require.NoError(t, os.MkdirAll(filepath.Join(os.Getenv("HOME"), model.COMMAND_BASE_STORAGE_FOLDER), 0755))
+	queuePendingSync(t, "abc", time.Now())
+
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	resp, err := RequestSyncPending(socketPath, SyncPendingRequest{Action: SyncPendingList}, time.Second)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Error)
+	require.Len(t, resp.Payloads, 1)
+	assert.Equal(t, model.PendingKindSync, resp.Payloads[0].Kind)
+
+	resp, err = RequestSyncPending(socketPath, SyncPendingRequest{Action: SyncPendingShow, Index: 7}, time.Second)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Error, "no pending payload #7")
+}
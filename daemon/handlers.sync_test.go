@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"net/http"
@@ -69,8 +70,13 @@ func (s *SyncHandlerTestSuite) handleTrackEndpoint(w http.ResponseWriter, r *htt
 	}
 
 	// Decode request body
+	body, err := gzip.NewReader(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 	var trackArgs model.PostTrackArgs
-	if err := json.NewDecoder(r.Body).Decode(&trackArgs); err != nil {
+	if err := json.NewDecoder(body).Decode(&trackArgs); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
@@ -3,21 +3,22 @@ package daemon
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"time"
 
 	"github.com/malamtime/cli/model"
 )
 
-// newFallbackStore builds the store used for track events when the bolt engine
-// is disabled (commandStore is nil). It is a var so tests can substitute an
+// newFallbackStore builds the store used for track events when no store was
+// opened at startup (commandStore is nil). It is a var so tests can substitute an
 // in-memory store without touching the filesystem.
 var newFallbackStore = model.NewFileStore
 
 // trackStore returns the active command store for the daemon. The CLI forwards
 // every track event to the daemon and lets it decide where to persist: the
-// daemon-owned bolt store when the bolt engine is enabled, otherwise the txt
-// file store (both satisfy model.CommandStore).
+// store opened for the bolt or segment engine, otherwise the txt file store
+// (all satisfy model.CommandStore).
 func trackStore() model.CommandStore {
 	if commandStore != nil {
 		return commandStore
@@ -41,6 +42,9 @@ func parseTrackEvent(payload interface{}) (model.Command, time.Time, error) {
 // handlePubSubTrackPre persists a pre-execution command to the active bucket,
 // unless config marks the command as excluded.
 func handlePubSubTrackPre(ctx context.Context, payload interface{}) error {
+	commandStoreMu.RLock()
+	defer commandStoreMu.RUnlock()
+
 	cmd, recordingTime, err := parseTrackEvent(payload)
 	if err != nil {
 		slog.Error("Failed to parse track_pre payload", slog.Any("err", err))
@@ -57,13 +61,20 @@ func handlePubSubTrackPre(ctx context.Context, payload interface{}) error {
 		return nil
 	}
 
-	return trackStore().SavePre(ctx, cmd, recordingTime)
+	if err := trackStore().SavePre(ctx, cmd, recordingTime); err != nil {
+		return err
+	}
+	emitEvent(commandEvent(EventTrackPre, cmd))
+	return nil
 }
 
 // handlePubSubTrackPost persists a post-execution command, then runs the
 // flush/sync/cursor/prune cycle against the active store — the daemon-side
 // equivalent of commands.trySyncLocalToServer.
 func handlePubSubTrackPost(ctx context.Context, payload interface{}) error {
+	commandStoreMu.RLock()
+	defer commandStoreMu.RUnlock()
+
 	cmd, recordingTime, err := parseTrackEvent(payload)
 	if err != nil {
 		slog.Error("Failed to parse track_post payload", slog.Any("err", err))
@@ -84,6 +95,7 @@ func handlePubSubTrackPost(ctx context.Context, payload interface{}) error {
 	if err := store.SavePost(ctx, cmd, cmd.Result, recordingTime); err != nil {
 		return err
 	}
+	emitEvent(commandEvent(EventTrackPost, cmd))
 
 	result, err := model.BuildTrackingData(ctx, store, cfg)
 	if err != nil {
@@ -103,20 +115,65 @@ func handlePubSubTrackPost(ctx context.Context, payload interface{}) error {
 		CursorID: result.LatestRecordingTime.UnixNano(),
 		Data:     result.Data,
 		Meta:     result.Meta,
+		Sessions: result.Sessions,
 	}
 
-	if err := sendTrackArgsToServer(ctx, args); err != nil {
-		slog.Error("Failed to send tracking data from command store", slog.Any("err", err))
-		// Leave the data in the store; a later post will retry. Do not advance cursor.
+	// Every endpoint resumes from its own cursor, which advances with each
+	// chunk it acknowledged, so one that is down neither blocks the others
+	// nor is skipped past. The store's cursor trails the slowest endpoint.
+	cursors, err := model.LoadEndpointCursors(ctx, store, cfg, result.Cursor)
+	if err != nil {
 		return err
 	}
+	sendErr := sendTrackArgsToServer(ctx, args, model.SyncOptions{
+		Cursors: cursors.Cursors(),
+		OnAck: func(ctx context.Context, endpoint model.Endpoint, cursor time.Time) error {
+			if err := cursors.Ack(ctx, endpoint, cursor); err != nil {
+				slog.Error("Failed to advance cursor", slog.String("endpoint", endpoint.APIEndpoint), slog.Any("err", err))
+				return err
+			}
+			return nil
+		},
+	})
+	for _, endpointErr := range model.EndpointErrors(sendErr) {
+		if !errors.Is(endpointErr, errCircuitOpen) {
+			slog.Error("Failed to send tracking data from command store", slog.Any("err", endpointErr))
+		}
+	}
+
+	synced := cursors.Synced()
+	if synced.After(result.Cursor) {
+		if err := store.SetCursor(ctx, synced); err != nil {
+			slog.Error("Failed to advance cursor", slog.Any("err", err))
+			return err
+		}
+		if err := model.ArchiveSynced(ctx, cfg, store, synced); err != nil {
+			// Keep the synced rows in the store rather than lose them; the next
+			// prune archives them again.
+			slog.Warn("Failed to archive synced commands, skipping prune", slog.Any("err", err))
+			return syncFailure(sendErr)
+		}
+		if err := store.Prune(ctx, synced); err != nil {
+			slog.Warn("Failed to prune synced commands", slog.Any("err", err))
+		}
+	}
+	// Leave the unsent data in the store; a later post will retry. An endpoint
+	// with an open circuit breaker catches up once it closes.
+	return syncFailure(sendErr)
+}
 
-	if err := store.SetCursor(ctx, result.LatestRecordingTime); err != nil {
-		slog.Error("Failed to advance cursor", slog.Any("err", err))
+// syncFailure returns err without the endpoints skipped for an open circuit
+// breaker, or nil when those are all that failed.
+func syncFailure(err error) error {
+	failed := model.EndpointErrors(err)
+	if len(failed) == 0 {
 		return err
 	}
-	if err := store.Prune(ctx, result.LatestRecordingTime); err != nil {
-		slog.Warn("Failed to prune synced commands", slog.Any("err", err))
+	var errs []error
+	for _, endpointErr := range failed {
+		if !errors.Is(endpointErr, errCircuitOpen) {
+			errs = append(errs, endpointErr)
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -1,10 +1,12 @@
 package daemon
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,11 +18,13 @@ import (
 
 // fakeCommandStore is an in-memory CommandStore for handler tests.
 type fakeCommandStore struct {
-	pre  []*model.Command
-	post []*model.Command
+	pre      []*model.Command
+	post     []*model.Command
+	sessions []*model.SessionEvent
 
-	cursor        time.Time
-	noCursorExist bool
+	cursor          time.Time
+	noCursorExist   bool
+	endpointCursors map[string]time.Time
 
 	cursorSetCalls int
 	pruneCalls     int
@@ -60,6 +64,17 @@ func (f *fakeCommandStore) GetPostCommands(ctx context.Context) ([]*model.Comman
 	return f.post, nil
 }
 
+func (f *fakeCommandStore) SaveSessionEvent(ctx context.Context, ev model.SessionEvent, rt time.Time) error {
+	e := ev
+	e.RecordingTime = rt
+	f.sessions = append(f.sessions, &e)
+	return nil
+}
+
+func (f *fakeCommandStore) GetSessionEvents(ctx context.Context) ([]*model.SessionEvent, error) {
+	return f.sessions, nil
+}
+
 func (f *fakeCommandStore) GetLastCursor(ctx context.Context) (time.Time, bool, error) {
 	return f.cursor, f.noCursorExist, nil
 }
@@ -70,6 +85,22 @@ func (f *fakeCommandStore) SetCursor(ctx context.Context, cursor time.Time) erro
 	return nil
 }
 
+func (f *fakeCommandStore) GetEndpointCursors(ctx context.Context) (map[string]time.Time, error) {
+	result := make(map[string]time.Time, len(f.endpointCursors))
+	for key, cursor := range f.endpointCursors {
+		result[key] = cursor
+	}
+	return result, nil
+}
+
+func (f *fakeCommandStore) SetEndpointCursor(ctx context.Context, key string, cursor time.Time) error {
+	if f.endpointCursors == nil {
+		f.endpointCursors = make(map[string]time.Time)
+	}
+	f.endpointCursors[key] = cursor
+	return nil
+}
+
 func (f *fakeCommandStore) Prune(ctx context.Context, cursor time.Time) error {
 	f.pruneCalls++
 	return nil
@@ -196,7 +227,9 @@ func (s *TrackHandlerTestSuite) TestTrackPostNotEnoughToFlush() {
 func (s *TrackHandlerTestSuite) TestTrackPostFlushSyncsAndPrunes() {
 	var sentPayload model.PostTrackArgs
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_ = json.NewDecoder(r.Body).Decode(&sentPayload)
+		body, err := gzip.NewReader(r.Body)
+		require.NoError(s.T(), err)
+		_ = json.NewDecoder(body).Decode(&sentPayload)
 		w.WriteHeader(http.StatusNoContent)
 	}))
 	defer server.Close()
@@ -221,6 +254,46 @@ func (s *TrackHandlerTestSuite) TestTrackPostFlushSyncsAndPrunes() {
 	assert.Equal(s.T(), 1, sentPayload.Meta.Source, "daemon path must mark source as daemon")
 }
 
+func (s *TrackHandlerTestSuite) TestTrackPostResumesAfterAckedChunk() {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	store := &fakeCommandStore{noCursorExist: true, engine: model.StorageEngineBolt}
+	commandStore = store
+	stConfig = fakeConfigService{cfg: model.ShellTimeConfig{Token: "t", APIEndpoint: server.URL, FlushCount: 1}}
+
+	start := time.Now().Add(-time.Hour)
+	total := model.SyncChunkMaxCommands + 10
+	for i := 0; i < total-1; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		cmd := model.Command{Shell: "bash", SessionID: 1, Command: "ls", Username: "u", Time: at}
+		require.NoError(s.T(), store.SavePre(context.Background(), cmd, at))
+		require.NoError(s.T(), store.SavePost(context.Background(), cmd, 0, at))
+	}
+	last := start.Add(time.Duration(total-1) * time.Second)
+	cmd := model.Command{Shell: "bash", SessionID: 1, Command: "ls", Username: "u", Time: last}
+	require.NoError(s.T(), store.SavePre(context.Background(), cmd, last))
+
+	payload := TrackEventPayload{Command: cmd, RecordingTimeNano: last.UnixNano()}
+	require.Error(s.T(), handlePubSubTrackPost(context.Background(), payload))
+
+	// the first chunk was acknowledged, the second one failed
+	assert.Equal(s.T(), 1, store.cursorSetCalls)
+	assert.Equal(s.T(), start.Add(time.Duration(model.SyncChunkMaxCommands-1)*time.Second).UnixNano(), store.cursor.UnixNano())
+	assert.Equal(s.T(), 1, store.pruneCalls, "the acknowledged chunk is pruned")
+
+	result, err := model.BuildTrackingData(context.Background(), store, model.ShellTimeConfig{})
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), result.Data, 10, "only the unacknowledged commands are left to send")
+}
+
 func (s *TrackHandlerTestSuite) TestTrackPostFallsBackToFileStore() {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
@@ -40,9 +40,9 @@ func TestSendTrackArgsToServer_PublicKeyFetchErrorFailsClosed(t *testing.T) {
 	}, nil)
 	x3SwapStConfig(t, mc)
 
-	prevCB := syncCircuitBreaker
-	syncCircuitBreaker = nil
-	t.Cleanup(func() { syncCircuitBreaker = prevCB })
+	prevCB := syncCircuitBreakerFor
+	syncCircuitBreakerFor = nil
+	t.Cleanup(func() { syncCircuitBreakerFor = prevCB })
 
 	msg := model.PostTrackArgs{
 		CursorID: 1234567890,
@@ -52,6 +52,6 @@ func TestSendTrackArgsToServer_PublicKeyFetchErrorFailsClosed(t *testing.T) {
 
 	// Must return an error without panicking, and must NOT reach the sync
 	// endpoint (no unencrypted send).
-	require.Error(t, sendTrackArgsToServer(context.Background(), msg))
+	require.Error(t, sendTrackArgsToServer(context.Background(), msg, model.SyncOptions{}))
 	require.False(t, syncHit, "must not send data when the encryption public key cannot be fetched")
 }
@@ -109,12 +109,13 @@ func TestSocketTopicProcessor_TrackPreAndPostRouted(t *testing.T) {
 }
 
 func TestSocketTopicProcessor_SyncFailureNacks(t *testing.T) {
-	// A sync whose backend fails -> handler returns error -> message nacked.
+	// A sync whose backend fails with no retry queue to keep it -> handler
+	// returns error -> message nacked.
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}))
 	defer server.Close()
-	withCircuitBreaker(t, &fakeDaemonCB{open: false})
+	withCircuitBreaker(t, nil)
 
 	mockCS := model.NewMockConfigService(t)
 	mockCS.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{Token: "t", APIEndpoint: server.URL}, nil)
@@ -21,8 +21,9 @@ type x3TrackStore struct {
 	pre  []*model.Command
 	post []*model.Command
 
-	cursor        time.Time
-	noCursorExist bool
+	cursor          time.Time
+	noCursorExist   bool
+	endpointCursors map[string]time.Time
 
 	savePreErr   error
 	savePostErr  error
@@ -75,6 +76,14 @@ func (s *x3TrackStore) GetPostCommands(ctx context.Context) ([]*model.Command, e
 	return s.post, nil
 }
 
+func (s *x3TrackStore) SaveSessionEvent(ctx context.Context, ev model.SessionEvent, rt time.Time) error {
+	return nil
+}
+
+func (s *x3TrackStore) GetSessionEvents(ctx context.Context) ([]*model.SessionEvent, error) {
+	return nil, nil
+}
+
 func (s *x3TrackStore) GetLastCursor(ctx context.Context) (time.Time, bool, error) {
 	return s.cursor, s.noCursorExist, nil
 }
@@ -88,6 +97,22 @@ func (s *x3TrackStore) SetCursor(ctx context.Context, cursor time.Time) error {
 	return nil
 }
 
+func (s *x3TrackStore) GetEndpointCursors(ctx context.Context) (map[string]time.Time, error) {
+	result := make(map[string]time.Time, len(s.endpointCursors))
+	for key, cursor := range s.endpointCursors {
+		result[key] = cursor
+	}
+	return result, nil
+}
+
+func (s *x3TrackStore) SetEndpointCursor(ctx context.Context, key string, cursor time.Time) error {
+	if s.endpointCursors == nil {
+		s.endpointCursors = make(map[string]time.Time)
+	}
+	s.endpointCursors[key] = cursor
+	return nil
+}
+
 func (s *x3TrackStore) Prune(ctx context.Context, cursor time.Time) error {
 	s.pruneCalls++
 	return s.pruneErr
@@ -18,10 +18,15 @@ const (
 	HeartbeatResyncInterval = 30 * time.Minute
 )
 
+// heartbeatRetryNow resyncs the failed heartbeats right away. It is nil while
+// no HeartbeatResyncService runs.
+var heartbeatRetryNow func()
+
 // HeartbeatResyncService handles periodic resync of failed heartbeats
 type HeartbeatResyncService struct {
 	config   model.ShellTimeConfig
 	ticker   *time.Ticker
+	retry    chan struct{}
 	stopChan chan struct{}
 	wg       sync.WaitGroup
 }
@@ -30,6 +35,7 @@ type HeartbeatResyncService struct {
 func NewHeartbeatResyncService(config model.ShellTimeConfig) *HeartbeatResyncService {
 	return &HeartbeatResyncService{
 		config:   config,
+		retry:    make(chan struct{}, 1),
 		stopChan: make(chan struct{}),
 	}
 }
@@ -49,6 +55,8 @@ func (s *HeartbeatResyncService) Start(ctx context.Context) error {
 			select {
 			case <-s.ticker.C:
 				s.resync(ctx)
+			case <-s.retry:
+				s.resync(ctx)
 			case <-s.stopChan:
 				return
 			case <-ctx.Done():
@@ -57,6 +65,7 @@ func (s *HeartbeatResyncService) Start(ctx context.Context) error {
 		}
 	}()
 
+	heartbeatRetryNow = s.RetryNow
 	slog.Info("Heartbeat resync service started", slog.Duration("interval", HeartbeatResyncInterval))
 	return nil
 }
@@ -66,11 +75,21 @@ func (s *HeartbeatResyncService) Stop() {
 	if s.ticker != nil {
 		s.ticker.Stop()
 	}
+	heartbeatRetryNow = nil
 	close(s.stopChan)
 	s.wg.Wait()
 	slog.Info("Heartbeat resync service stopped")
 }
 
+// RetryNow resyncs the failed heartbeats right away instead of on the next
+// tick.
+func (s *HeartbeatResyncService) RetryNow() {
+	select {
+	case s.retry <- struct{}{}:
+	default:
+	}
+}
+
 // resync reads failed heartbeats from the log file and attempts to send them
 func (s *HeartbeatResyncService) resync(ctx context.Context) {
 	logFilePath := os.ExpandEnv(fmt.Sprintf("%s/%s", "$HOME", model.HEARTBEAT_LOG_FILE))
@@ -111,71 +130,39 @@ func (s *HeartbeatResyncService) resync(ctx context.Context) {
 	slog.Info("Starting heartbeat resync", slog.Int("pendingCount", len(lines)))
 
 	// Process each line
-	var failedLines []string
+	var doneLines []string
 	successCount := 0
 
 	for _, line := range lines {
+		data, err := model.OpenPendingLine([]byte(line))
+		if err != nil {
+			slog.Warn("Failed to decrypt heartbeat line, keeping for next retry", slog.Any("err", err))
+			continue
+		}
 		var payload model.HeartbeatPayload
-		if err := json.Unmarshal([]byte(line), &payload); err != nil {
+		if err := json.Unmarshal(data, &payload); err != nil {
 			slog.Error("Failed to parse heartbeat line, discarding", slog.Any("err", err), slog.String("line", line))
+			doneLines = append(doneLines, line)
 			continue
 		}
 
 		// Try to send to server
 		if err := model.SendHeartbeatsToServer(ctx, s.config, payload); err != nil {
 			slog.Warn("Failed to resync heartbeat, keeping for next retry", slog.Any("err", err))
-			failedLines = append(failedLines, line)
 		} else {
+			doneLines = append(doneLines, line)
 			successCount++
 		}
 	}
 
-	// Rewrite the file with only failed lines
-	if err := s.rewriteLogFile(logFilePath, failedLines); err != nil {
+	// Remove only the sent and discarded lines; the heartbeats saved meanwhile
+	// are kept, and those dropped stay dropped
+	if err := model.RemovePendingLines(logFilePath, doneLines); err != nil {
 		slog.Error("Failed to update heartbeat log file", slog.Any("err", err))
 		return
 	}
 
 	slog.Info("Heartbeat resync completed",
 		slog.Int("success", successCount),
-		slog.Int("remaining", len(failedLines)))
-}
-
-// rewriteLogFile atomically rewrites the log file with the given lines
-func (s *HeartbeatResyncService) rewriteLogFile(logFilePath string, lines []string) error {
-	// If no lines remaining, remove the file
-	if len(lines) == 0 {
-		if err := os.Remove(logFilePath); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove empty log file: %w", err)
-		}
-		return nil
-	}
-
-	// Write to temp file first
-	tempFile := logFilePath + ".tmp"
-	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-
-	for _, line := range lines {
-		if _, err := file.WriteString(line + "\n"); err != nil {
-			file.Close()
-			os.Remove(tempFile)
-			return fmt.Errorf("failed to write to temp file: %w", err)
-		}
-	}
-
-	if err := file.Close(); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("failed to close temp file: %w", err)
-	}
-
-	// Atomic rename
-	if err := os.Rename(tempFile, logFilePath); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("failed to rename temp file: %w", err)
-	}
-
-	return nil
+		slog.Int("remaining", len(lines)-len(doneLines)))
 }
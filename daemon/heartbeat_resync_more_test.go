@@ -115,11 +115,25 @@ func TestResync_MixedValidAndInvalidLines(t *testing.T) {
 	assert.NotContains(t, string(content), "also-bad")
 }
 
-// TestRewriteLogFile_RemoveNonexistentIsNoError covers the empty-lines branch
-// where the target file does not exist: os.Remove returns ErrNotExist which the
-// function must treat as success.
-func TestRewriteLogFile_RemoveNonexistentIsNoError(t *testing.T) {
-	svc := NewHeartbeatResyncService(model.ShellTimeConfig{})
-	missing := filepath.Join(t.TempDir(), "never-created.log")
-	assert.NoError(t, svc.rewriteLogFile(missing, nil))
+// TestResync_KeepsHeartbeatsSavedMeanwhile: a heartbeat that fails while the
+// resync sends the old ones is saved to the log, and the rewrite keeps it.
+func TestResync_KeepsHeartbeatsSavedMeanwhile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, saveHeartbeatToFile(model.HeartbeatPayload{Heartbeats: []model.HeartbeatData{{HeartbeatID: "new-1"}}}))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	logFile := d2writeHeartbeatLog(t, []string{
+		`{"heartbeats":[{"heartbeatId":"old-1","entity":"/a.go","time":1,"project":"p"}]}`,
+	})
+
+	svc := NewHeartbeatResyncService(model.ShellTimeConfig{Token: "tok", APIEndpoint: server.URL})
+	svc.resync(context.Background())
+
+	content, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), `"old-1"`)
+	assert.Contains(t, string(content), `"new-1"`)
 }
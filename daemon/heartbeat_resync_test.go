@@ -86,94 +86,6 @@ func TestHeartbeatResyncInterval_Constant(t *testing.T) {
 	}
 }
 
-func TestHeartbeatResyncService_RewriteLogFile_EmptyLines(t *testing.T) {
-	// Create a temp directory
-	tempDir, err := os.MkdirTemp("", "shelltime-resync-test-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	logFile := filepath.Join(tempDir, "heartbeat.log")
-
-	config := model.ShellTimeConfig{}
-	service := NewHeartbeatResyncService(config)
-
-	// Test with empty lines - should remove file
-	err = service.rewriteLogFile(logFile, []string{})
-	if err != nil {
-		t.Fatalf("rewriteLogFile failed: %v", err)
-	}
-
-	// File should not exist
-	if _, err := os.Stat(logFile); !os.IsNotExist(err) {
-		t.Error("File should be removed when lines are empty")
-	}
-}
-
-func TestHeartbeatResyncService_RewriteLogFile_WithLines(t *testing.T) {
-	// Create a temp directory
-	tempDir, err := os.MkdirTemp("", "shelltime-resync-test-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	logFile := filepath.Join(tempDir, "heartbeat.log")
-
-	config := model.ShellTimeConfig{}
-	service := NewHeartbeatResyncService(config)
-
-	lines := []string{
-		`{"heartbeats":[{"heartbeatId":"1"}]}`,
-		`{"heartbeats":[{"heartbeatId":"2"}]}`,
-	}
-
-	err = service.rewriteLogFile(logFile, lines)
-	if err != nil {
-		t.Fatalf("rewriteLogFile failed: %v", err)
-	}
-
-	// Verify file content
-	content, err := os.ReadFile(logFile)
-	if err != nil {
-		t.Fatalf("Failed to read log file: %v", err)
-	}
-
-	for _, line := range lines {
-		if !contains(string(content), line) {
-			t.Errorf("Expected file to contain: %s", line)
-		}
-	}
-}
-
-func TestHeartbeatResyncService_RewriteLogFile_AtomicRename(t *testing.T) {
-	// Create a temp directory
-	tempDir, err := os.MkdirTemp("", "shelltime-resync-test-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	logFile := filepath.Join(tempDir, "heartbeat.log")
-
-	config := model.ShellTimeConfig{}
-	service := NewHeartbeatResyncService(config)
-
-	lines := []string{`{"test":"data"}`}
-
-	err = service.rewriteLogFile(logFile, lines)
-	if err != nil {
-		t.Fatalf("rewriteLogFile failed: %v", err)
-	}
-
-	// Temp file should not exist after atomic rename
-	tempFile := logFile + ".tmp"
-	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
-		t.Error("Temp file should be removed after atomic rename")
-	}
-}
-
 func TestHeartbeatResyncService_ResyncNoFile(t *testing.T) {
 	// Create a temp directory
 	tempDir, err := os.MkdirTemp("", "shelltime-resync-test-*")
@@ -0,0 +1,420 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/malamtime/cli/model"
+)
+
+// metricsContentType is the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// The daemon's counters. They count from the daemon's start, whether or not
+// the metrics endpoint is enabled, so turning it on by a reload doesn't reset
+// them.
+var (
+	metricMessagesProcessed = newCounterVec("shelltime_daemon_messages_processed_total",
+		"Socket messages processed from the queue, by type and result.", "type", "result")
+	metricSyncs = newCounterVec("shelltime_sync_total",
+		"Sync requests to an endpoint, by result.", "endpoint", "result")
+	metricSyncBatchSize = newHistogramVec("shelltime_sync_batch_size",
+		"Commands per batch an endpoint acknowledged.",
+		[]float64{1, 5, 10, 25, 50, 100, 250, 500, 1000}, "endpoint")
+	metricOtelForwarded = newCounterVec("shelltime_otel_forwarded_total",
+		"OTEL events and metrics forwarded to the server, by source, kind and result.", "source", "kind", "result")
+	metricHeartbeats = newCounterVec("shelltime_heartbeats_total",
+		"Editor heartbeats sent to the server, spooled for a retry when that failed, or dropped when spooling failed too.", "result")
+	metricCCInfoCache = newCounterVec("shelltime_cc_info_cache_lookups_total",
+		"Lookups of the cc_info cost and git caches, by result.", "cache", "result")
+)
+
+// resultLabel is the result label of an operation that failed with err.
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+// counterVec is a Prometheus counter with labels.
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+}
+
+// Add adds v to the counter with the given label values.
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[key]
+	if !ok {
+		value = &counterValue{labels: labelValues}
+		c.values[key] = value
+	}
+	value.value += v
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the counter with the given label values.
+func (c *counterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return value.value
+	}
+	return 0
+}
+
+func (c *counterVec) write(w *metricsWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.header(c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		value := c.values[key]
+		w.sample(c.name, c.labels, value.labels, value.value)
+	}
+}
+
+// histogramVec is a Prometheus histogram with labels.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mu         sync.Mutex
+	values     map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+}
+
+// Observe records v in the histogram with the given label values.
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			value.counts[i]++
+			break
+		}
+	}
+	value.sum += v
+	value.count++
+}
+
+func (h *histogramVec) write(w *metricsWriter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.header(h.name, h.help, "histogram")
+	labels := append(append([]string{}, h.labels...), "le")
+	for _, key := range sortedKeys(h.values) {
+		value := h.values[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += value.counts[i]
+			w.sample(h.name+"_bucket", labels, append(append([]string{}, value.labels...), formatFloat(upper)), float64(cumulative))
+		}
+		w.sample(h.name+"_bucket", labels, append(append([]string{}, value.labels...), "+Inf"), float64(value.count))
+		w.sample(h.name+"_sum", h.labels, value.labels, value.sum)
+		w.sample(h.name+"_count", h.labels, value.labels, float64(value.count))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter struct {
+	*bufio.Writer
+}
+
+func (w *metricsWriter) header(name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *metricsWriter) sample(name string, labels, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabelValue(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(value))
+}
+
+// gauge writes a gauge without labels.
+func (w *metricsWriter) gauge(name, help string, value float64) {
+	w.header(name, help, "gauge")
+	w.sample(name, nil, nil, value)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// circuitStateValue maps a circuit breaker state to the gauge value.
+func circuitStateValue(state model.CircuitState) float64 {
+	switch state {
+	case model.CircuitHalfOpen:
+		return 1
+	case model.CircuitOpen:
+		return 2
+	}
+	return 0
+}
+
+// fileSize returns the size of the file at path relative to $HOME, zero when
+// it doesn't exist.
+func fileSize(path string) float64 {
+	info, err := os.Stat(os.ExpandEnv("$HOME/" + path))
+	if err != nil {
+		return 0
+	}
+	return float64(info.Size())
+}
+
+// WriteMetrics writes the daemon's metrics in the Prometheus text format.
+func WriteMetrics(ctx context.Context, out io.Writer) error {
+	w := &metricsWriter{bufio.NewWriter(out)}
+
+	w.header("shelltime_daemon_info", "The version of the daemon.", "gauge")
+	w.sample("shelltime_daemon_info", []string{"version", "go_version"}, []string{version, runtime.Version()}, 1)
+	w.gauge("shelltime_daemon_uptime_seconds", "Seconds since the daemon started.", time.Since(startedAt).Seconds())
+
+	metricMessagesProcessed.write(w)
+	metricSyncs.write(w)
+	metricSyncBatchSize.write(w)
+
+	// the state of the sync endpoints is read when scraped
+	var endpoints []model.Endpoint
+	if stConfig != nil {
+		if cfg, err := stConfig.ReadConfigFile(ctx); err == nil && cfg.Token != "" {
+			endpoints = model.SyncEndpoints(cfg)
+		}
+	}
+	endpointLabel := []string{"endpoint"}
+	w.header("shelltime_circuit_breaker_state", "The circuit breaker of a sync endpoint: 0 closed, 1 half-open, 2 open.", "gauge")
+	for _, endpoint := range endpoints {
+		if breaker := circuitBreakerFor(endpoint); breaker != nil {
+			w.sample("shelltime_circuit_breaker_state", endpointLabel, []string{endpoint.APIEndpoint}, circuitStateValue(breaker.Status().State))
+		}
+	}
+	w.header("shelltime_sync_retry_queued", "Payloads in the retry queue of a sync endpoint.", "gauge")
+	for _, endpoint := range endpoints {
+		if breaker := circuitBreakerFor(endpoint); breaker != nil {
+			w.sample("shelltime_sync_retry_queued", endpointLabel, []string{endpoint.APIEndpoint}, float64(breaker.PendingCount()))
+		}
+	}
+	w.header("shelltime_sync_pending_file_bytes", "Size of the retry queue file of a sync endpoint.", "gauge")
+	for _, endpoint := range endpoints {
+		w.sample("shelltime_sync_pending_file_bytes", endpointLabel, []string{endpoint.APIEndpoint}, fileSize(pendingFileFor(model.EndpointKey(endpoint))))
+	}
+
+	metricOtelForwarded.write(w)
+	metricHeartbeats.write(w)
+	w.gauge("shelltime_heartbeat_spool_bytes", "Size of the file of heartbeats waiting for a retry.", fileSize(model.HEARTBEAT_LOG_FILE))
+	metricCCInfoCache.write(w)
+
+	return w.Flush()
+}
+
+// MetricsServer serves the daemon's metrics over HTTP at /metrics.
+type MetricsServer struct {
+	listen     string
+	socketPath string
+	server     *http.Server
+	listener   net.Listener
+}
+
+// NewMetricsServer creates the metrics server. listen is a loopback
+// host:port, or unix:<path> for a unix socket. socketPath is the daemon's
+// own socket, which the metrics endpoint must not take over.
+func NewMetricsServer(listen, socketPath string) *MetricsServer {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+	return &MetricsServer{
+		listen:     listen,
+		socketPath: socketPath,
+		server:     &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second},
+	}
+}
+
+// Start listens and serves in the background. It refuses TCP addresses that
+// aren't loopback, as the metrics reveal the sync endpoints.
+func (s *MetricsServer) Start() error {
+	listener, err := listenMetrics(s.listen, s.socketPath)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", slog.Any("err", err))
+		}
+	}()
+	slog.Info("Metrics endpoint started", slog.String("listen", s.listen))
+	return nil
+}
+
+// Addr returns the address the server listens on.
+func (s *MetricsServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop shuts the server down.
+func (s *MetricsServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to stop the metrics server", slog.Any("err", err))
+	}
+	if path, ok := strings.CutPrefix(s.listen, "unix:"); ok && s.listener != nil {
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+	}
+	slog.Info("Metrics endpoint stopped")
+}
+
+func listenMetrics(listen, socketPath string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		if path == "" {
+			return nil, errors.New("metrics endpoint: missing unix socket path")
+		}
+		if socketPath != "" && filepath.Clean(path) == filepath.Clean(socketPath) {
+			return nil, fmt.Errorf("metrics endpoint: %s is the daemon socket", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("metrics endpoint: %w", err)
+	}
+	if !isLoopbackHost(host) {
+		return nil, fmt.Errorf("metrics endpoint: %s is not a loopback address", listen)
+	}
+	return net.Listen("tcp", listen)
+}
+
+// removeStaleSocket removes a socket left at path by a daemon that didn't
+// stop cleanly. It refuses to remove anything else, or a socket another
+// process still listens on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("metrics endpoint: %s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("metrics endpoint: %s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	if err := WriteMetrics(r.Context(), w); err != nil {
+		slog.Debug("Failed to write metrics", slog.Any("err", err))
+	}
+}
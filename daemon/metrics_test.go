@@ -0,0 +1,206 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTo(t *testing.T, write func(w *metricsWriter)) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := &metricsWriter{bufio.NewWriter(&buf)}
+	write(w)
+	require.NoError(t, w.Flush())
+	return buf.String()
+}
+
+func TestCounterVec_Write(t *testing.T) {
+	c := newCounterVec("test_total", "A test counter.", "type", "result")
+	c.Inc("track", "success")
+	c.Add(2, "track", "success")
+	c.Inc("sync", "failure")
+	assert.Equal(t, 3.0, c.Value("track", "success"))
+	assert.Equal(t, 0.0, c.Value("track", "failure"))
+
+	assert.Equal(t, `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{type="sync",result="failure"} 1
+test_total{type="track",result="success"} 3
+`, writeTo(t, c.write))
+}
+
+func TestHistogramVec_Write(t *testing.T) {
+	h := newHistogramVec("test_size", "A test histogram.", []float64{1, 10}, "endpoint")
+	h.Observe(1, "a")
+	h.Observe(7, "a")
+	h.Observe(50, "a")
+
+	assert.Equal(t, `# HELP test_size A test histogram.
+# TYPE test_size histogram
+test_size_bucket{endpoint="a",le="1"} 1
+test_size_bucket{endpoint="a",le="10"} 2
+test_size_bucket{endpoint="a",le="+Inf"} 3
+test_size_sum{endpoint="a"} 58
+test_size_count{endpoint="a"} 3
+`, writeTo(t, h.write))
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `a\\b\"c\nd`, escapeLabelValue("a\\b\"c\nd"))
+}
+
+func TestWriteMetrics_Endpoints(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	cfg := model.ShellTimeConfig{Token: "tok", APIEndpoint: "https://api.example.com"}
+	endpoint := model.SyncEndpoints(cfg)[0]
+	pending := filepath.Join(home, pendingFileFor(model.EndpointKey(endpoint)))
+	require.NoError(t, os.MkdirAll(filepath.Dir(pending), 0o755))
+	require.NoError(t, os.WriteFile(pending, []byte("0123456789"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(home, model.HEARTBEAT_LOG_FILE), []byte("{}\n"), 0o644))
+
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything).Return(cfg, nil)
+	withStConfig(t, mockCS)
+	withCircuitBreaker(t, &fakeDaemonCB{open: true, savedPayloads: []interface{}{"a", "b"}})
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteMetrics(context.Background(), &buf))
+	out := buf.String()
+
+	assert.Contains(t, out, `shelltime_circuit_breaker_state{endpoint="https://api.example.com"} 2`)
+	assert.Contains(t, out, `shelltime_sync_retry_queued{endpoint="https://api.example.com"} 2`)
+	assert.Contains(t, out, `shelltime_sync_pending_file_bytes{endpoint="https://api.example.com"} 10`)
+	assert.Contains(t, out, "shelltime_heartbeat_spool_bytes 3\n")
+	assert.Contains(t, out, "# TYPE shelltime_daemon_uptime_seconds gauge")
+	assert.Contains(t, out, "# TYPE shelltime_sync_batch_size histogram")
+}
+
+func TestSocketTopicProcessor_CountsMessages(t *testing.T) {
+	before := metricMessagesProcessed.Value("unknown", "failure")
+
+	messages := make(chan *message.Message, 1)
+	msg := message.NewMessage(watermill.NewUUID(), []byte(`{"type":"no_such_type"}`))
+	messages <- msg
+	close(messages)
+	SocketTopicProcessor(messages)
+
+	assert.Equal(t, before+1, metricMessagesProcessed.Value("unknown", "failure"))
+}
+
+func TestCCInfoTimer_CountsCacheLookups(t *testing.T) {
+	service := NewCCInfoTimerService(&model.ShellTimeConfig{})
+	costHits, costMisses := metricCCInfoCache.Value("cost", "hit"), metricCCInfoCache.Value("cost", "miss")
+	gitHits, gitMisses := metricCCInfoCache.Value("git", "hit"), metricCCInfoCache.Value("git", "miss")
+
+	service.GetCachedCost(CCInfoTimeRangeToday)
+	service.mu.Lock()
+	service.cache[CCInfoTimeRangeToday] = CCInfoCache{TotalCostUSD: 1, FetchedAt: time.Now()}
+	service.mu.Unlock()
+	service.GetCachedCost(CCInfoTimeRangeToday)
+	service.GetCachedGitInfo("/repo")
+	service.GetCachedGitInfo("/repo")
+
+	assert.Equal(t, costMisses+1, metricCCInfoCache.Value("cost", "miss"))
+	assert.Equal(t, costHits+1, metricCCInfoCache.Value("cost", "hit"))
+	assert.Equal(t, gitMisses+1, metricCCInfoCache.Value("git", "miss"))
+	assert.Equal(t, gitHits+1, metricCCInfoCache.Value("git", "hit"))
+}
+
+func TestMetricsServer_Loopback(t *testing.T) {
+	server := NewMetricsServer("127.0.0.1:0", "")
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Stop)
+
+	resp, err := http.Get("http://" + server.Addr().String() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, metricsContentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "shelltime_daemon_info{")
+}
+
+func TestMetricsServer_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	server := NewMetricsServer("unix:"+path, "")
+	require.NoError(t, server.Start())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "# HELP shelltime_daemon_info"))
+
+	server.Stop()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "the socket is removed on stop")
+}
+
+func TestMetricsServer_RefusesNonLoopback(t *testing.T) {
+	for _, listen := range []string{"0.0.0.0:0", ":0", "192.0.2.1:9000", "not-an-address", "unix:"} {
+		err := NewMetricsServer(listen, "").Start()
+		assert.Error(t, err, listen)
+	}
+}
+
+func TestMetricsServer_UnixSocketSafety(t *testing.T) {
+	dir := t.TempDir()
+
+	// the daemon socket is never taken over
+	daemonSocket := filepath.Join(dir, "shelltime.sock")
+	err := NewMetricsServer("unix:"+daemonSocket, daemonSocket).Start()
+	assert.ErrorContains(t, err, "is the daemon socket")
+
+	// a regular file at the path is left alone
+	file := filepath.Join(dir, "metrics.txt")
+	require.NoError(t, os.WriteFile(file, []byte("keep"), 0o600))
+	err = NewMetricsServer("unix:"+file, daemonSocket).Start()
+	assert.ErrorContains(t, err, "not a socket")
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "keep", string(content))
+
+	// a socket another process listens on is left alone
+	live := filepath.Join(dir, "live.sock")
+	listener, err := net.Listen("unix", live)
+	require.NoError(t, err)
+	defer listener.Close()
+	err = NewMetricsServer("unix:"+live, daemonSocket).Start()
+	assert.ErrorContains(t, err, "in use")
+
+	// a stale socket is replaced
+	stale := filepath.Join(dir, "stale.sock")
+	staleListener, err := net.Listen("unix", stale)
+	require.NoError(t, err)
+	staleListener.(*net.UnixListener).SetUnlinkOnClose(false)
+	staleListener.Close()
+	server := NewMetricsServer("unix:"+stale, daemonSocket)
+	require.NoError(t, server.Start())
+	server.Stop()
+}
@@ -3,7 +3,6 @@ package daemon
 import (
 	"os"
 	"path/filepath"
-	"strings"
 	"testing"
 
 	"github.com/malamtime/cli/model"
@@ -11,29 +10,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-// TestX3RewriteLogFile_WritesRemainingLines covers the non-empty success path of
-// HeartbeatResyncService.rewriteLogFile: lines are written to a temp file and
-// atomically renamed over the target.
-func TestX3RewriteLogFile_WritesRemainingLines(t *testing.T) {
-	svc := NewHeartbeatResyncService(model.ShellTimeConfig{})
-	logPath := filepath.Join(t.TempDir(), "heartbeat_failed.log")
-
-	lines := []string{`{"id":"a"}`, `{"id":"b"}`}
-	require.NoError(t, svc.rewriteLogFile(logPath, lines))
-
-	data, err := os.ReadFile(logPath)
-	require.NoError(t, err)
-	got := string(data)
-	assert.Contains(t, got, `{"id":"a"}`)
-	assert.Contains(t, got, `{"id":"b"}`)
-	// Two lines, each newline-terminated.
-	assert.Equal(t, 2, strings.Count(got, "\n"))
-
-	// The temp file must not linger after the atomic rename.
-	_, statErr := os.Stat(logPath + ".tmp")
-	assert.True(t, os.IsNotExist(statErr), "temp file should be renamed away")
-}
-
 // TestX3WriteDebugFile_AppendsJSON covers the success path of
 // AICodeOtelProcessor.writeDebugFile: the debug dir is created and the
 // JSON-marshaled payload is appended with a timestamp header.
@@ -0,0 +1,167 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// SocketProtocolVersion is the version of the socket protocol this binary
+// speaks. Messages without a version are the unversioned protocol 1: the
+// daemon still serves them for older CLIs and editor plugins, replying only
+// to some types and in each type's own shape.
+const SocketProtocolVersion = 2
+
+// SocketRequest is a versioned request: the protocol version, an ID the
+// reply echoes, the message type and its typed payload.
+type SocketRequest struct {
+	Protocol int               `json:"protocol"`
+	ID       string            `json:"id"`
+	Type     SocketMessageType `json:"type"`
+	Payload  json.RawMessage   `json:"payload,omitempty"`
+}
+
+// SocketReply answers every versioned request. Protocol is the version the
+// daemon replied with, Data the type's response when OK, Error the reason
+// otherwise.
+type SocketReply struct {
+	Protocol int             `json:"protocol"`
+	ID       string          `json:"id"`
+	OK       bool            `json:"ok"`
+	Error    string          `json:"error,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// ProtocolError reports a daemon that doesn't speak the CLI's protocol
+// version, which happens while a daemon started by an older or newer
+// release is still running.
+type ProtocolError struct {
+	Daemon int
+	CLI    int
+	Type   SocketMessageType
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Daemon < e.CLI {
+		return fmt.Sprintf("the running daemon speaks socket protocol v%d and can't serve %s from this CLI (v%d): restart it with `shelltime daemon reinstall`", e.Daemon, e.Type, e.CLI)
+	}
+	return fmt.Sprintf("the running daemon speaks socket protocol v%d, newer than this CLI (v%d): update the CLI", e.Daemon, e.CLI)
+}
+
+// ReplyError is an error the daemon reported for a request.
+type ReplyError struct {
+	Type    SocketMessageType
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("daemon %s failed: %s", e.Type, e.Message)
+}
+
+// legacyReplies are the types the unversioned protocol answers. An old
+// daemon closes the connection without a reply for the others, and for the
+// types it doesn't know.
+var legacyReplies = map[SocketMessageType]bool{
+	SocketMessageTypeStatus:           true,
+	SocketMessageTypeHeartbeat:        true,
+	SocketMessageTypeCCInfo:           true,
+	SocketMessageTypeListCommands:     true,
+	SocketMessageTypeStorageMigrate:   true,
+	SocketMessageTypeStorageFsck:      true,
+	SocketMessageTypeStorageRotateKey: true,
+	SocketMessageTypeSyncPending:      true,
+}
+
+var socketRequestSeq atomic.Uint64
+
+// newSocketRequest wraps a payload in a versioned request with a new ID.
+func newSocketRequest(msgType SocketMessageType, payload interface{}) (SocketRequest, error) {
+	req := SocketRequest{
+		Protocol: SocketProtocolVersion,
+		ID:       strconv.Itoa(os.Getpid()) + "-" + strconv.FormatUint(socketRequestSeq.Add(1), 10),
+		Type:     msgType,
+	}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return req, fmt.Errorf("failed to encode %s payload: %w", msgType, err)
+		}
+		req.Payload = raw
+	}
+	return req, nil
+}
+
+// requestDaemon sends a versioned request and decodes the reply's data into
+// out, which may be nil. A daemon error comes back as a *ReplyError. Replies
+// of an unversioned daemon are taken as they are, so a CLI keeps working
+// with the daemon of the previous release until it restarts.
+func requestDaemon(socketPath string, msgType SocketMessageType, payload, out interface{}, timeout time.Duration) error {
+	req, err := newSocketRequest(msgType, payload)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(conn).Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			if legacyReplies[msgType] {
+				return &ProtocolError{Daemon: 1, CLI: SocketProtocolVersion, Type: msgType}
+			}
+			// an unversioned daemon accepted it without a reply
+			return nil
+		}
+		return err
+	}
+
+	var reply SocketReply
+	if err := json.Unmarshal(raw, &reply); err != nil || reply.Protocol == 0 {
+		if out == nil || bytes.Equal(raw, []byte("null")) {
+			return nil
+		}
+		return json.Unmarshal(raw, out)
+	}
+	if reply.Protocol != SocketProtocolVersion && !reply.OK {
+		return &ProtocolError{Daemon: reply.Protocol, CLI: SocketProtocolVersion, Type: msgType}
+	}
+	if reply.ID != req.ID {
+		return fmt.Errorf("daemon replied to request %s instead of %s", reply.ID, req.ID)
+	}
+	// a failed request may still carry data, e.g. a partial report
+	if out != nil && len(reply.Data) > 0 {
+		if err := json.Unmarshal(reply.Data, out); err != nil {
+			return fmt.Errorf("failed to decode %s reply: %w", msgType, err)
+		}
+	}
+	if !reply.OK {
+		return &ReplyError{Type: msgType, Message: reply.Error}
+	}
+	return nil
+}
+
+// replyErrorInto moves a daemon error into the Error field of a response
+// that has one, and returns the other errors.
+func replyErrorInto(err error, field *string) error {
+	var replyErr *ReplyError
+	if errors.As(err, &replyErr) {
+		*field = replyErr.Message
+		return nil
+	}
+	return err
+}
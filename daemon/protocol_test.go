@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exchange sends one raw request to the socket and decodes one reply.
+func exchange(t *testing.T, socketPath string, req interface{}) SocketReply {
+	t.Helper()
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	require.NoError(t, json.NewEncoder(conn).Encode(req))
+	var reply SocketReply
+	require.NoError(t, json.NewDecoder(conn).Decode(&reply))
+	return reply
+}
+
+// fakeDaemon serves every connection with serve until the test ends.
+func fakeDaemon(t *testing.T, serve func(conn net.Conn, req SocketRequest)) string {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "fake.sock")
+	ln, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req SocketRequest
+			if err := json.NewDecoder(conn).Decode(&req); err == nil {
+				serve(conn, req)
+			}
+			conn.Close()
+		}
+	}()
+	return socketPath
+}
+
+func TestProtocol_StatusRoundTrip(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	status, err := RequestStatus(socketPath, 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, SocketProtocolVersion, status.Protocol)
+	assert.NotEmpty(t, status.Platform)
+}
+
+func TestProtocol_ReplyEchoesID(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	reply := exchange(t, socketPath, SocketRequest{Protocol: SocketProtocolVersion, ID: "req-7", Type: SocketMessageTypeListCommands})
+	assert.True(t, reply.OK)
+	assert.Equal(t, "req-7", reply.ID)
+	assert.Equal(t, SocketProtocolVersion, reply.Protocol)
+
+	var list ListCommandsResponse
+	require.NoError(t, json.Unmarshal(reply.Data, &list))
+	assert.NotNil(t, list.Commands)
+}
+
+func TestProtocol_AcksFireAndForgetTypes(t *testing.T) {
+	handler, socketPath := startHandler(t, &model.ShellTimeConfig{})
+	msgs, err := handler.channel.Subscribe(context.Background(), PubSubTopic)
+	require.NoError(t, err)
+
+	cmd := model.Command{Shell: "bash", SessionID: 42, Command: "ls", Username: "me"}
+	require.NoError(t, SendTrackEvent(context.Background(), socketPath, SocketMessageTypeTrackPre, cmd, time.Now()))
+
+	select {
+	case m := <-msgs:
+		m.Ack()
+		var msg SocketMessage
+		require.NoError(t, json.Unmarshal(m.Payload, &msg))
+		assert.Equal(t, SocketMessageTypeTrackPre, msg.Type)
+	case <-time.After(time.Second):
+		t.Fatal("track_pre was not published")
+	}
+}
+
+func TestProtocol_IgnoresUnknownPayloadFields(t *testing.T) {
+	handler, socketPath := startHandler(t, &model.ShellTimeConfig{})
+	msgs, err := handler.channel.Subscribe(context.Background(), PubSubTopic)
+	require.NoError(t, err)
+
+	// a newer CLI may send fields this daemon doesn't know
+	reply := exchange(t, socketPath, map[string]interface{}{
+		"protocol": SocketProtocolVersion,
+		"id":       "new",
+		"type":     SocketMessageTypeTrackPre,
+		"payload": map[string]interface{}{
+			"command":           map[string]interface{}{"shell": "bash", "command": "ls", "futureField": true},
+			"recordingTimeNano": time.Now().UnixNano(),
+			"futureField":       1,
+		},
+	})
+	assert.True(t, reply.OK, reply.Error)
+
+	select {
+	case m := <-msgs:
+		m.Ack()
+	case <-time.After(time.Second):
+		t.Fatal("track_pre was not published")
+	}
+}
+
+func TestProtocol_RejectsInvalidPayload(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	reply := exchange(t, socketPath, map[string]interface{}{
+		"protocol": SocketProtocolVersion,
+		"id":       "bad",
+		"type":     SocketMessageTypeListCommands,
+		"payload":  map[string]interface{}{"sinceNano": "yesterday"},
+	})
+	assert.False(t, reply.OK)
+	assert.Contains(t, reply.Error, "invalid list_commands payload")
+
+	reply = exchange(t, socketPath, SocketRequest{Protocol: SocketProtocolVersion, ID: "sp", Type: SocketMessageTypeSessionProject})
+	assert.False(t, reply.OK)
+	assert.Contains(t, reply.Error, "required")
+}
+
+func TestProtocol_UnknownType(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	err := requestDaemon(socketPath, SocketMessageType("no_such_type"), nil, nil, 2*time.Second)
+	var replyErr *ReplyError
+	require.ErrorAs(t, err, &replyErr)
+	assert.Contains(t, replyErr.Message, "unknown message type")
+}
+
+func TestProtocol_NewerClientGetsDaemonVersion(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	reply := exchange(t, socketPath, SocketRequest{Protocol: SocketProtocolVersion + 1, ID: "future", Type: SocketMessageTypeStatus})
+	assert.False(t, reply.OK)
+	assert.Equal(t, SocketProtocolVersion, reply.Protocol)
+	assert.Equal(t, "future", reply.ID)
+	assert.Contains(t, reply.Error, "unsupported socket protocol")
+}
+
+func TestProtocol_OlderDaemonIsReported(t *testing.T) {
+	socketPath := fakeDaemon(t, func(conn net.Conn, req SocketRequest) {
+		json.NewEncoder(conn).Encode(SocketReply{Protocol: 1, ID: req.ID, Error: "unsupported"})
+	})
+
+	_, err := RequestStatus(socketPath, 2*time.Second)
+	var protoErr *ProtocolError
+	require.ErrorAs(t, err, &protoErr)
+	assert.Equal(t, 1, protoErr.Daemon)
+	assert.Contains(t, err.Error(), "shelltime daemon reinstall")
+}
+
+func TestProtocol_NewerDaemonIsReported(t *testing.T) {
+	socketPath := fakeDaemon(t, func(conn net.Conn, req SocketRequest) {
+		json.NewEncoder(conn).Encode(SocketReply{Protocol: SocketProtocolVersion + 1, ID: req.ID, Error: "unsupported"})
+	})
+
+	_, err := RequestListCommands(socketPath, ListCommandsRequest{}, 2*time.Second)
+	var protoErr *ProtocolError
+	require.ErrorAs(t, err, &protoErr)
+	assert.Contains(t, err.Error(), "update the CLI")
+}
+
+func TestProtocol_LegacyDaemon(t *testing.T) {
+	// an unversioned daemon answers in the type's own shape, and closes the
+	// connection without a reply for the types it doesn't answer or know
+	socketPath := fakeDaemon(t, func(conn net.Conn, req SocketRequest) {
+		if req.Type == SocketMessageTypeCCInfo {
+			json.NewEncoder(conn).Encode(CCInfoResponse{TotalCostUSD: 1.5, TimeRange: "today"})
+		}
+	})
+
+	info, err := RequestCCInfo(socketPath, CCInfoTimeRangeToday, "", "", 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, info.TotalCostUSD)
+
+	cmd := model.Command{Shell: "bash", SessionID: 1, Command: "ls"}
+	assert.NoError(t, SendTrackEvent(context.Background(), socketPath, SocketMessageTypeTrackPost, cmd, time.Now()))
+
+	_, err = RequestSyncPending(socketPath, SyncPendingRequest{Action: SyncPendingList}, 2*time.Second)
+	var protoErr *ProtocolError
+	require.ErrorAs(t, err, &protoErr)
+	assert.Equal(t, 1, protoErr.Daemon)
+}
+
+func TestProtocol_ReplyErrorFillsResponse(t *testing.T) {
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+
+	resp, err := RequestSyncPending(socketPath, SyncPendingRequest{Action: "bogus"}, 2*time.Second)
+	require.NoError(t, err)
+	assert.Contains(t, resp.Error, "unknown sync_pending action")
+}
@@ -0,0 +1,264 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/malamtime/cli/model"
+)
+
+// ConfigPollInterval is how often the daemon checks the config files for
+// changes.
+const ConfigPollInterval = 5 * time.Second
+
+// Reload triggers
+const (
+	ReloadTriggerStartup      = "startup"
+	ReloadTriggerSignal       = "SIGHUP"
+	ReloadTriggerConfigChange = "config change"
+)
+
+// ManagedService is an optional daemon service the config turns on and off.
+type ManagedService struct {
+	Name string
+	// Enabled reports whether cfg turns the service on.
+	Enabled func(cfg model.ShellTimeConfig) bool
+	// Settings are the parts of cfg the service reads when it starts; the
+	// running service is restarted when they change. Nil means none.
+	Settings func(cfg model.ShellTimeConfig) interface{}
+	// Start starts the service and returns how to stop it.
+	Start func(ctx context.Context, cfg model.ShellTimeConfig) (stop func(), err error)
+}
+
+type runningService struct {
+	stop     func()
+	settings string
+}
+
+// ReloadStatus is the result of applying the config to the services.
+type ReloadStatus struct {
+	At        time.Time `json:"at"`
+	Trigger   string    `json:"trigger"`
+	Started   []string  `json:"started,omitempty"`
+	Stopped   []string  `json:"stopped,omitempty"`
+	Restarted []string  `json:"restarted,omitempty"`
+	// Failed maps the services that didn't start to the reason.
+	Failed map[string]string `json:"failed,omitempty"`
+	// NeedsRestart lists the changed settings that only apply once the
+	// daemon restarts.
+	NeedsRestart []string `json:"needsRestart,omitempty"`
+	// Error is why the config couldn't be read; the services are left as
+	// they were.
+	Error string `json:"error,omitempty"`
+}
+
+// ServiceSupervisor starts and stops the managed services to match the
+// config, at startup and on every reload.
+type ServiceSupervisor struct {
+	mu        sync.Mutex
+	ctx       context.Context
+	services  []ManagedService
+	running   map[string]runningService
+	config    *model.ShellTimeConfig
+	last      *ReloadStatus
+	listeners []func(model.ShellTimeConfig)
+}
+
+// serviceSupervisor is the daemon's supervisor, reported in the status. It
+// is nil until NewServiceSupervisor is called.
+var serviceSupervisor *ServiceSupervisor
+
+// NewServiceSupervisor creates the supervisor of services. They run with ctx
+// until Stop.
+func NewServiceSupervisor(ctx context.Context, services ...ManagedService) *ServiceSupervisor {
+	s := &ServiceSupervisor{
+		ctx:      ctx,
+		services: services,
+		running:  make(map[string]runningService),
+	}
+	serviceSupervisor = s
+	return s
+}
+
+// OnConfig registers fn to receive the config of every reload.
+func (s *ServiceSupervisor) OnConfig(fn func(model.ShellTimeConfig)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Apply starts the services cfg enables, stops those it disables and
+// restarts those whose settings changed.
+func (s *ServiceSupervisor) Apply(cfg model.ShellTimeConfig, trigger string) ReloadStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := ReloadStatus{At: time.Now(), Trigger: trigger}
+	if s.config != nil {
+		status.NeedsRestart = restartOnlySettings(*s.config, cfg)
+	}
+	for _, svc := range s.services {
+		settings := settingsOf(svc, cfg)
+		current, running := s.running[svc.Name]
+		enabled := svc.Enabled(cfg)
+		switch {
+		case running && !enabled:
+			current.stop()
+			delete(s.running, svc.Name)
+			status.Stopped = append(status.Stopped, svc.Name)
+			slog.Info("Service stopped", slog.String("service", svc.Name))
+			continue
+		case running && current.settings == settings:
+			continue
+		case running:
+			current.stop()
+			delete(s.running, svc.Name)
+		case !enabled:
+			continue
+		}
+
+		stop, err := svc.Start(s.ctx, cfg)
+		if err != nil {
+			slog.Error("Failed to start service", slog.String("service", svc.Name), slog.Any("err", err))
+			if status.Failed == nil {
+				status.Failed = make(map[string]string)
+			}
+			status.Failed[svc.Name] = err.Error()
+			continue
+		}
+		s.running[svc.Name] = runningService{stop: stop, settings: settings}
+		slog.Info("Service started", slog.String("service", svc.Name), slog.Bool("restart", running))
+		if running {
+			status.Restarted = append(status.Restarted, svc.Name)
+		} else {
+			status.Started = append(status.Started, svc.Name)
+		}
+	}
+	s.config = &cfg
+	s.last = &status
+	for _, fn := range s.listeners {
+		fn(cfg)
+	}
+	return status
+}
+
+// Reload reads the config files again, bypassing the cache, and applies them.
+func (s *ServiceSupervisor) Reload(ctx context.Context, trigger string) ReloadStatus {
+	cfg, err := stConfig.ReadConfigFile(ctx, model.WithSkipCache())
+	if err != nil {
+		status := ReloadStatus{At: time.Now(), Trigger: trigger, Error: err.Error()}
+		slog.Error("Failed to reload the config, keeping the running services", slog.String("trigger", trigger), slog.Any("err", err))
+		s.mu.Lock()
+		s.last = &status
+		s.mu.Unlock()
+		return status
+	}
+
+	status := s.Apply(cfg, trigger)
+	slog.Info("Config reloaded",
+		slog.String("trigger", trigger),
+		slog.Any("started", status.Started),
+		slog.Any("stopped", status.Stopped),
+		slog.Any("restarted", status.Restarted),
+		slog.Any("failed", status.Failed))
+	if len(status.NeedsRestart) > 0 {
+		slog.Warn("Some changed settings only apply after a daemon restart", slog.Any("settings", status.NeedsRestart))
+	}
+	return status
+}
+
+// Running returns the names of the running services.
+func (s *ServiceSupervisor) Running() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.running))
+	for name := range s.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LastReload returns the result of the last startup or reload.
+func (s *ServiceSupervisor) LastReload() *ReloadStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// Stop stops the running services, the last started first.
+func (s *ServiceSupervisor) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.services) - 1; i >= 0; i-- {
+		if current, ok := s.running[s.services[i].Name]; ok {
+			current.stop()
+			delete(s.running, s.services[i].Name)
+		}
+	}
+}
+
+// WatchConfig reloads the config whenever the config files in configDir
+// change, checking every interval until ctx is done.
+func (s *ServiceSupervisor) WatchConfig(ctx context.Context, configDir string, interval time.Duration) {
+	stamp := model.ConfigFileStamp(configDir)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := model.ConfigFileStamp(configDir)
+			if current == stamp {
+				continue
+			}
+			stamp = current
+			if current == "" {
+				// removed, or in the middle of being replaced
+				continue
+			}
+			s.Reload(ctx, ReloadTriggerConfigChange)
+		}
+	}
+}
+
+func settingsOf(svc ManagedService, cfg model.ShellTimeConfig) string {
+	if svc.Settings == nil {
+		return ""
+	}
+	buf, err := json.Marshal(svc.Settings(cfg))
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+// restartOnlySettings lists the settings that changed from old to cfg but
+// are only read when the daemon starts. storage.engine is not one of them:
+// `shelltime storage migrate` switches the daemon's store live.
+func restartOnlySettings(old, cfg model.ShellTimeConfig) []string {
+	var changed []string
+	if old.SocketPath != cfg.SocketPath {
+		changed = append(changed, "socketPath")
+	}
+	if !equalBoolPtr(old.EnableMetrics, cfg.EnableMetrics) {
+		changed = append(changed, "enableMetrics")
+	}
+	return changed
+}
+
+func storageEngine(cfg model.ShellTimeConfig) string {
+	if cfg.Storage == nil || cfg.Storage.Engine == "" {
+		return model.StorageEngineFile
+	}
+	return cfg.Storage.Engine
+}
+
+func equalBoolPtr(a, b *bool) bool {
+	return (a != nil && *a) == (b != nil && *b)
+}
@@ -0,0 +1,247 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeService records the starts and stops of a managed service.
+type fakeService struct {
+	mu      sync.Mutex
+	starts  int
+	stops   int
+	running bool
+	fail    error
+}
+
+func (f *fakeService) managed(name string, enabled func(cfg model.ShellTimeConfig) bool) ManagedService {
+	return ManagedService{
+		Name:     name,
+		Enabled:  enabled,
+		Settings: func(cfg model.ShellTimeConfig) interface{} { return cfg.Token },
+		Start: func(ctx context.Context, cfg model.ShellTimeConfig) (func(), error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.fail != nil {
+				return nil, f.fail
+			}
+			f.starts++
+			f.running = true
+			return func() {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				f.stops++
+				f.running = false
+			}, nil
+		},
+	}
+}
+
+func (f *fakeService) counts() (starts, stops int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.starts, f.stops
+}
+
+func newTestSupervisor(t *testing.T, services ...ManagedService) *ServiceSupervisor {
+	t.Helper()
+	prev := serviceSupervisor
+	s := NewServiceSupervisor(context.Background(), services...)
+	t.Cleanup(func() {
+		s.Stop()
+		serviceSupervisor = prev
+	})
+	return s
+}
+
+func codeTrackingOn(cfg model.ShellTimeConfig) bool {
+	return cfg.CodeTracking != nil && cfg.CodeTracking.Enabled != nil && *cfg.CodeTracking.Enabled
+}
+
+func withCodeTracking(cfg model.ShellTimeConfig, enabled bool) model.ShellTimeConfig {
+	cfg.CodeTracking = &model.CodeTracking{Enabled: &enabled}
+	return cfg
+}
+
+func TestServiceSupervisor_Apply(t *testing.T) {
+	always := &fakeService{}
+	tracking := &fakeService{}
+	s := newTestSupervisor(t,
+		always.managed("always", func(model.ShellTimeConfig) bool { return true }),
+		tracking.managed("tracking", codeTrackingOn),
+	)
+
+	status := s.Apply(withCodeTracking(model.ShellTimeConfig{Token: "a"}, false), ReloadTriggerStartup)
+	assert.Equal(t, []string{"always"}, status.Started)
+	assert.Equal(t, []string{"always"}, s.Running())
+
+	// turning a service on starts only that one
+	status = s.Apply(withCodeTracking(model.ShellTimeConfig{Token: "a"}, true), ReloadTriggerSignal)
+	assert.Equal(t, []string{"tracking"}, status.Started)
+	assert.Empty(t, status.Restarted)
+	starts, stops := always.counts()
+	assert.Equal(t, 1, starts)
+	assert.Equal(t, 0, stops)
+
+	// a changed setting restarts the running services that read it
+	status = s.Apply(withCodeTracking(model.ShellTimeConfig{Token: "b"}, true), ReloadTriggerConfigChange)
+	assert.Equal(t, []string{"always", "tracking"}, status.Restarted)
+	starts, stops = always.counts()
+	assert.Equal(t, 2, starts)
+	assert.Equal(t, 1, stops)
+
+	// turning a service off stops it
+	status = s.Apply(withCodeTracking(model.ShellTimeConfig{Token: "b"}, false), ReloadTriggerConfigChange)
+	assert.Equal(t, []string{"tracking"}, status.Stopped)
+	assert.Equal(t, []string{"always"}, s.Running())
+	assert.False(t, tracking.running)
+	assert.Same(t, s.last, s.LastReload())
+
+	s.Stop()
+	assert.Empty(t, s.Running())
+	assert.False(t, always.running)
+}
+
+func TestServiceSupervisor_ApplyReportsFailedStarts(t *testing.T) {
+	broken := &fakeService{fail: errors.New("port in use")}
+	s := newTestSupervisor(t, broken.managed("broken", func(model.ShellTimeConfig) bool { return true }))
+
+	status := s.Apply(model.ShellTimeConfig{}, ReloadTriggerStartup)
+	assert.Empty(t, status.Started)
+	assert.Equal(t, map[string]string{"broken": "port in use"}, status.Failed)
+	assert.Empty(t, s.Running())
+
+	// the next reload tries again
+	broken.fail = nil
+	status = s.Apply(model.ShellTimeConfig{}, ReloadTriggerSignal)
+	assert.Equal(t, []string{"broken"}, status.Started)
+	assert.Empty(t, status.Failed)
+}
+
+func TestServiceSupervisor_ApplyNeedsRestart(t *testing.T) {
+	s := newTestSupervisor(t)
+	enabled := true
+
+	status := s.Apply(model.ShellTimeConfig{SocketPath: "/tmp/a.sock"}, ReloadTriggerStartup)
+	assert.Empty(t, status.NeedsRestart)
+
+	status = s.Apply(model.ShellTimeConfig{
+		SocketPath:    "/tmp/b.sock",
+		Storage:       &model.StorageConfig{Engine: model.StorageEngineBolt},
+		EnableMetrics: &enabled,
+	}, ReloadTriggerSignal)
+	// a migration switches the storage engine live
+	assert.Equal(t, []string{"socketPath", "enableMetrics"}, status.NeedsRestart)
+}
+
+func TestServiceSupervisor_OnConfig(t *testing.T) {
+	s := newTestSupervisor(t)
+	var got []string
+	s.OnConfig(func(cfg model.ShellTimeConfig) { got = append(got, cfg.Token) })
+
+	s.Apply(model.ShellTimeConfig{Token: "a"}, ReloadTriggerStartup)
+	s.Apply(model.ShellTimeConfig{Token: "b"}, ReloadTriggerSignal)
+	assert.Equal(t, []string{"a", "b"}, got)
+}
+
+func TestServiceSupervisor_ReloadSkipsCache(t *testing.T) {
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything, mock.Anything).Return(withCodeTracking(model.ShellTimeConfig{}, true), nil).Once()
+	withStConfig(t, mockCS)
+
+	tracking := &fakeService{}
+	s := newTestSupervisor(t, tracking.managed("tracking", codeTrackingOn))
+	s.Apply(model.ShellTimeConfig{}, ReloadTriggerStartup)
+
+	status := s.Reload(context.Background(), ReloadTriggerSignal)
+	assert.Equal(t, ReloadTriggerSignal, status.Trigger)
+	assert.Equal(t, []string{"tracking"}, status.Started)
+	assert.Empty(t, status.Error)
+}
+
+func TestServiceSupervisor_ReloadKeepsServicesOnReadError(t *testing.T) {
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything, mock.Anything).Return(model.ShellTimeConfig{}, errors.New("yaml: line 3: bad indentation")).Once()
+	withStConfig(t, mockCS)
+
+	tracking := &fakeService{}
+	s := newTestSupervisor(t, tracking.managed("tracking", codeTrackingOn))
+	s.Apply(withCodeTracking(model.ShellTimeConfig{}, true), ReloadTriggerStartup)
+
+	status := s.Reload(context.Background(), ReloadTriggerConfigChange)
+	assert.Contains(t, status.Error, "bad indentation")
+	assert.Equal(t, []string{"tracking"}, s.Running())
+	assert.Equal(t, status.Error, s.LastReload().Error)
+	_, stops := tracking.counts()
+	assert.Equal(t, 0, stops)
+}
+
+func TestServiceSupervisor_WatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("token: tok\n"), 0o644))
+	cs := model.NewConfigService(dir)
+	withStConfig(t, cs)
+
+	tracking := &fakeService{}
+	s := newTestSupervisor(t, tracking.managed("tracking", codeTrackingOn))
+	cfg, err := cs.ReadConfigFile(context.Background())
+	require.NoError(t, err)
+	s.Apply(cfg, ReloadTriggerStartup)
+	require.Empty(t, s.Running())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.WatchConfig(ctx, dir, 10*time.Millisecond)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// give the watcher time to take its first stamp
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, os.WriteFile(configFile, []byte("token: tok\ncodeTracking:\n  enabled: true\n"), 0o644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(configFile, later, later))
+
+	require.Eventually(t, func() bool {
+		return len(s.Running()) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, ReloadTriggerConfigChange, s.LastReload().Trigger)
+}
+
+func TestSocketHandler_StatusReportsServices(t *testing.T) {
+	always := &fakeService{}
+	s := newTestSupervisor(t, always.managed("always", func(model.ShellTimeConfig) bool { return true }))
+	s.Apply(model.ShellTimeConfig{}, ReloadTriggerStartup)
+
+	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
+	status, err := RequestStatus(socketPath, 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"always"}, status.Services)
+	require.NotNil(t, status.LastReload)
+	assert.Equal(t, ReloadTriggerStartup, status.LastReload.Trigger)
+	assert.Equal(t, []string{"always"}, status.LastReload.Started)
+}
+
+func TestSocketHandler_SetConfigKeepsSocketPath(t *testing.T) {
+	handler, socketPath := startHandler(t, &model.ShellTimeConfig{})
+	handler.SetConfig(model.ShellTimeConfig{Token: "new", SocketPath: "/elsewhere.sock"})
+
+	cfg := handler.currentConfig()
+	assert.Equal(t, "new", cfg.Token)
+	assert.Equal(t, socketPath, cfg.SocketPath)
+}
@@ -1,13 +1,16 @@
 package daemon
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
@@ -28,12 +31,42 @@ const (
 	// engine is enabled).
 	SocketMessageTypeTrackPre  SocketMessageType = "track_pre"
 	SocketMessageTypeTrackPost SocketMessageType = "track_post"
+	// SocketMessageTypeSessionStart / SessionEnd carry a shell session
+	// lifecycle event, stored next to the commands and synced with them.
+	SocketMessageTypeSessionStart SocketMessageType = "session_start"
+	SocketMessageTypeSessionEnd   SocketMessageType = "session_end"
 	// SocketMessageTypeListCommands requests the locally buffered commands from
 	// the daemon (request/response), used by `shelltime ls` when the bolt store
 	// is enabled and the CLI cannot open the daemon-locked DB.
 	SocketMessageTypeListCommands SocketMessageType = "list_commands"
+	// SocketMessageTypeStorageMigrate asks the daemon to migrate the buffered
+	// commands between storage engines (request/response), since the daemon
+	// holds the bolt DB and the live track events.
+	SocketMessageTypeStorageMigrate SocketMessageType = "storage_migrate"
+	// SocketMessageTypeStorageFsck asks the daemon to check (and repair) the
+	// store it writes to (request/response).
+	SocketMessageTypeStorageFsck SocketMessageType = "storage_fsck"
+	// SocketMessageTypeStorageRotateKey asks the daemon to re-encrypt the
+	// stored commands with a new key (request/response), pausing the track
+	// events meanwhile.
+	SocketMessageTypeStorageRotateKey SocketMessageType = "storage_rotate_key"
+	// SocketMessageTypeSyncPending lists, shows, drops or retries the
+	// payloads waiting in the pending files (request/response), since the
+	// daemon appends to and replays them.
+	SocketMessageTypeSyncPending SocketMessageType = "sync_pending"
+	// SocketMessageTypeSubscribe keeps the connection open and streams the
+	// daemon's events as NDJSON after the reply (protocol 2 only).
+	SocketMessageTypeSubscribe SocketMessageType = "subscribe"
 )
 
+// ListCommandsRequest is the optional payload of a list_commands request. It
+// narrows the archived commands the daemon adds to the reply when the local
+// archive is enabled.
+type ListCommandsRequest struct {
+	SinceNano int64 `json:"sinceNano,omitempty"`
+	SessionID int64 `json:"sessionId,omitempty"`
+}
+
 // ListCommandsResponse is the daemon's reply to a list_commands request.
 type ListCommandsResponse struct {
 	Commands []model.ListedCommand `json:"commands"`
@@ -46,6 +79,27 @@ type TrackEventPayload struct {
 	RecordingTimeNano int64         `json:"recordingTimeNano"`
 }
 
+// SessionEventPayload is the payload for session_start / session_end messages.
+type SessionEventPayload struct {
+	Event             model.SessionEvent `json:"event"`
+	RecordingTimeNano int64              `json:"recordingTimeNano"`
+}
+
+// HeartbeatStatus acknowledges a heartbeat message.
+type HeartbeatStatus string
+
+const (
+	HeartbeatStatusOK HeartbeatStatus = "ok"
+	// HeartbeatStatusDisabled means code tracking is off and the heartbeat
+	// was dropped.
+	HeartbeatStatusDisabled HeartbeatStatus = "disabled"
+)
+
+// HeartbeatResponse is the daemon's reply to a heartbeat message.
+type HeartbeatResponse struct {
+	Status HeartbeatStatus `json:"status"`
+}
+
 type SessionProjectRequest struct {
 	SessionID   string `json:"sessionId"`
 	ProjectPath string `json:"projectPath"`
@@ -85,6 +139,25 @@ type StatusResponse struct {
 	Uptime    string    `json:"uptime"`
 	GoVersion string    `json:"goVersion"`
 	Platform  string    `json:"platform"`
+	// Protocol is the socket protocol version the daemon speaks
+	Protocol int `json:"protocol"`
+	// Services are the optional services running
+	Services []string `json:"services,omitempty"`
+	// LastReload is the result of the startup or the last config reload
+	LastReload *ReloadStatus `json:"lastReload,omitempty"`
+	// Endpoints is the sync state of every endpoint, the main one first
+	Endpoints []EndpointStatus `json:"endpoints,omitempty"`
+}
+
+// EndpointStatus is the sync state of one endpoint
+type EndpointStatus struct {
+	APIEndpoint string `json:"apiEndpoint"`
+	// Pending counts the commands in the store not yet synced to it
+	Pending int `json:"pending"`
+	// RetryQueued counts the payloads in its retry queue
+	RetryQueued int `json:"retryQueued"`
+	// Circuit is the state of its circuit breaker
+	Circuit *model.CircuitBreakerStatus `json:"circuit,omitempty"`
 }
 
 type SocketMessage struct {
@@ -94,6 +167,7 @@ type SocketMessage struct {
 }
 
 type SocketHandler struct {
+	configMu sync.RWMutex
 	config   *model.ShellTimeConfig
 	listener net.Listener
 
@@ -103,6 +177,7 @@ type SocketHandler struct {
 }
 
 func NewSocketHandler(config *model.ShellTimeConfig, ch *GoChannel) *SocketHandler {
+	eventChannel = ch
 	return &SocketHandler{
 		config:      config,
 		channel:     ch,
@@ -111,19 +186,38 @@ func NewSocketHandler(config *model.ShellTimeConfig, ch *GoChannel) *SocketHandl
 	}
 }
 
+// SetConfig applies a reloaded config to the requests served from now on.
+// The socket path stays the one the daemon listens on.
+func (p *SocketHandler) SetConfig(cfg model.ShellTimeConfig) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	cfg.SocketPath = p.config.SocketPath
+	p.config = &cfg
+	if p.ccInfoTimer != nil {
+		p.ccInfoTimer.SetConfig(p.config)
+	}
+}
+
+func (p *SocketHandler) currentConfig() *model.ShellTimeConfig {
+	p.configMu.RLock()
+	defer p.configMu.RUnlock()
+	return p.config
+}
+
 func (p *SocketHandler) Start() error {
+	socketPath := p.currentConfig().SocketPath
 	// Remove existing socket file if it exists
-	if err := os.RemoveAll(p.config.SocketPath); err != nil {
+	if err := os.RemoveAll(socketPath); err != nil {
 		return err
 	}
 
 	// Create Unix domain socket
-	listener, err := net.Listen("unix", p.config.SocketPath)
+	listener, err := net.Listen("unix", socketPath)
 	if err != nil {
 		return err
 	}
-	if err := os.Chmod(p.config.SocketPath, 0777); err != nil {
-		slog.Error("Failed to change the socket permission to 0755", slog.String("socketPath", p.config.SocketPath))
+	if err := os.Chmod(socketPath, 0777); err != nil {
+		slog.Error("Failed to change the socket permission to 0755", slog.String("socketPath", socketPath))
 		return err
 	}
 	p.listener = listener
@@ -131,11 +225,12 @@ func (p *SocketHandler) Start() error {
 	// Start accepting connections
 	go p.acceptConnections()
 
-	slog.Info("Daemon started, listening on: ", slog.String("socketPath", p.config.SocketPath))
+	slog.Info("Daemon started, listening on: ", slog.String("socketPath", socketPath))
 	return nil
 }
 
 func (p *SocketHandler) Stop() {
+	socketPath := p.currentConfig().SocketPath
 	p.channel.Close()
 	close(p.stopChan)
 	if p.ccInfoTimer != nil {
@@ -144,7 +239,7 @@ func (p *SocketHandler) Stop() {
 	if p.listener != nil {
 		p.listener.Close()
 	}
-	os.RemoveAll(p.config.SocketPath)
+	os.RemoveAll(socketPath)
 	slog.Info("Daemon stopped")
 }
 
@@ -166,13 +261,220 @@ func (p *SocketHandler) acceptConnections() {
 func (p *SocketHandler) handleConnection(conn net.Conn) {
 	defer conn.Close()
 	conn.SetDeadline(time.Now().Add(5 * time.Second))
-	decoder := json.NewDecoder(conn)
-	var msg SocketMessage
-	if err := decoder.Decode(&msg); err != nil {
+	var req SocketRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
 		slog.Error("Error decoding message", slog.Any("err", err))
 		return
 	}
 
+	if req.Protocol <= 1 {
+		msg := SocketMessage{Type: req.Type}
+		if len(req.Payload) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(req.Payload))
+			// keep int64 ids (session ids, nano timestamps) exact in the untyped payload
+			decoder.UseNumber()
+			if err := decoder.Decode(&msg.Payload); err != nil {
+				slog.Error("Error decoding message payload", slog.Any("err", err))
+				return
+			}
+		}
+		p.handleLegacyMessage(conn, msg)
+		return
+	}
+
+	if req.Type == SocketMessageTypeSubscribe && req.Protocol == SocketProtocolVersion {
+		p.serveSubscribe(conn, req)
+		return
+	}
+
+	reply := p.serveRequest(conn, req)
+	if err := json.NewEncoder(conn).Encode(reply); err != nil {
+		slog.Error("Error encoding reply", slog.String("type", string(req.Type)), slog.String("id", req.ID), slog.Any("err", err))
+	}
+}
+
+// serveRequest answers a versioned request. A client newer than the daemon
+// gets the daemon's version back, so it can tell the user to restart it.
+func (p *SocketHandler) serveRequest(conn net.Conn, req SocketRequest) SocketReply {
+	reply := SocketReply{Protocol: SocketProtocolVersion, ID: req.ID}
+	if req.Protocol > SocketProtocolVersion {
+		reply.Error = fmt.Sprintf("unsupported socket protocol v%d, the daemon speaks v%d", req.Protocol, SocketProtocolVersion)
+		return reply
+	}
+	route, ok := socketRoutes[req.Type]
+	if !ok {
+		reply.Error = fmt.Sprintf("unknown message type %q, the daemon (%s) may be older than the CLI", req.Type, version)
+		return reply
+	}
+
+	data, err := route(p, conn, req)
+	if err != nil {
+		slog.Error("Socket request failed", slog.String("type", string(req.Type)), slog.String("id", req.ID), slog.Any("err", err))
+		reply.Error = err.Error()
+	}
+	if data != nil {
+		raw, encodeErr := json.Marshal(data)
+		if encodeErr != nil {
+			reply.Error = fmt.Sprintf("failed to encode %s reply: %v", req.Type, encodeErr)
+			return reply
+		}
+		reply.Data = raw
+	}
+	reply.OK = reply.Error == ""
+	return reply
+}
+
+// socketRoute serves one message type of the versioned protocol: it decodes
+// the typed payload and returns the data of the reply.
+type socketRoute func(p *SocketHandler, conn net.Conn, req SocketRequest) (interface{}, error)
+
+var socketRoutes = map[SocketMessageType]socketRoute{
+	SocketMessageTypeStatus:           (*SocketHandler).serveStatus,
+	SocketMessageTypeSync:             publishRoute(func() interface{} { return &model.PostTrackArgs{} }),
+	SocketMessageTypeTrackPre:         publishRoute(func() interface{} { return &TrackEventPayload{} }),
+	SocketMessageTypeTrackPost:        publishRoute(func() interface{} { return &TrackEventPayload{} }),
+	SocketMessageTypeSessionStart:     publishRoute(func() interface{} { return &SessionEventPayload{} }),
+	SocketMessageTypeSessionEnd:       publishRoute(func() interface{} { return &SessionEventPayload{} }),
+	SocketMessageTypeHeartbeat:        (*SocketHandler).serveHeartbeat,
+	SocketMessageTypeSessionProject:   (*SocketHandler).serveSessionProject,
+	SocketMessageTypeListCommands:     (*SocketHandler).serveListCommands,
+	SocketMessageTypeCCInfo:           (*SocketHandler).serveCCInfo,
+	SocketMessageTypeStorageMigrate:   (*SocketHandler).serveStorageMigrate,
+	SocketMessageTypeStorageFsck:      (*SocketHandler).serveStorageFsck,
+	SocketMessageTypeStorageRotateKey: (*SocketHandler).serveStorageRotateKey,
+	SocketMessageTypeSyncPending:      (*SocketHandler).serveSyncPending,
+}
+
+// decodeRequestPayload decodes the payload of a versioned request into v.
+// Fields the type doesn't have are ignored: a newer CLI may send fields this
+// daemon doesn't know yet, and only the protocol number marks a request as
+// incompatible.
+func decodeRequestPayload(req SocketRequest, v interface{}) error {
+	if len(req.Payload) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Payload, v); err != nil {
+		return fmt.Errorf("invalid %s payload: %w", req.Type, err)
+	}
+	return nil
+}
+
+// publishRoute hands the request to the pubsub handlers. The reply only
+// acknowledges it; the handlers store and sync it afterwards.
+func publishRoute(newPayload func() interface{}) socketRoute {
+	return func(p *SocketHandler, conn net.Conn, req SocketRequest) (interface{}, error) {
+		payload := newPayload()
+		if err := decodeRequestPayload(req, payload); err != nil {
+			return nil, err
+		}
+		return nil, p.publish(SocketMessage{Type: req.Type, Payload: payload})
+	}
+}
+
+func (p *SocketHandler) publish(msg SocketMessage) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", msg.Type, err)
+	}
+	chMsg := message.NewMessage(watermill.NewUUID(), buf)
+	if err := p.channel.Publish(PubSubTopic, chMsg); err != nil {
+		return fmt.Errorf("failed to publish %s message: %w", msg.Type, err)
+	}
+	return nil
+}
+
+func (p *SocketHandler) codeTrackingEnabled() bool {
+	cfg := p.currentConfig()
+	return cfg.CodeTracking != nil && cfg.CodeTracking.Enabled != nil && *cfg.CodeTracking.Enabled
+}
+
+func (p *SocketHandler) serveStatus(conn net.Conn, req SocketRequest) (interface{}, error) {
+	return p.status(), nil
+}
+
+func (p *SocketHandler) serveHeartbeat(conn net.Conn, req SocketRequest) (interface{}, error) {
+	var payload model.HeartbeatPayload
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if !p.codeTrackingEnabled() {
+		return HeartbeatResponse{Status: HeartbeatStatusDisabled}, nil
+	}
+	if err := p.publish(SocketMessage{Type: req.Type, Payload: payload}); err != nil {
+		return nil, err
+	}
+	return HeartbeatResponse{Status: HeartbeatStatusOK}, nil
+}
+
+func (p *SocketHandler) serveSessionProject(conn net.Conn, req SocketRequest) (interface{}, error) {
+	var payload SessionProjectRequest
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.SessionID == "" || payload.ProjectPath == "" {
+		return nil, errors.New("sessionId and projectPath are required")
+	}
+	go model.SendSessionProjectUpdate(context.Background(), *p.currentConfig(), payload.SessionID, payload.ProjectPath)
+	return nil, nil
+}
+
+func (p *SocketHandler) serveListCommands(conn net.Conn, req SocketRequest) (interface{}, error) {
+	var payload ListCommandsRequest
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		return nil, err
+	}
+	return p.listCommands(payload), nil
+}
+
+func (p *SocketHandler) serveCCInfo(conn net.Conn, req SocketRequest) (interface{}, error) {
+	var payload CCInfoRequest
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		return nil, err
+	}
+	return p.ccInfo(payload), nil
+}
+
+func (p *SocketHandler) serveStorageMigrate(conn net.Conn, req SocketRequest) (interface{}, error) {
+	conn.SetDeadline(time.Now().Add(StorageRequestTimeout))
+	var payload StorageMigrateRequest
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		return nil, err
+	}
+	report, err := migrateStorage(context.Background(), payload)
+	return StorageMigrateResponse{Report: report}, err
+}
+
+func (p *SocketHandler) serveStorageFsck(conn net.Conn, req SocketRequest) (interface{}, error) {
+	conn.SetDeadline(time.Now().Add(StorageRequestTimeout))
+	var payload StorageFsckRequest
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		return nil, err
+	}
+	report, err := fsckStorage(context.Background(), payload)
+	return StorageFsckResponse{Report: report}, err
+}
+
+func (p *SocketHandler) serveStorageRotateKey(conn net.Conn, req SocketRequest) (interface{}, error) {
+	conn.SetDeadline(time.Now().Add(StorageRequestTimeout))
+	var payload StorageRotateKeyRequest
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		return nil, err
+	}
+	rotation, err := rotateStorageKey(context.Background(), payload)
+	return StorageRotateKeyResponse{Rotation: rotation}, err
+}
+
+func (p *SocketHandler) serveSyncPending(conn net.Conn, req SocketRequest) (interface{}, error) {
+	conn.SetDeadline(time.Now().Add(SyncPendingRequestTimeout))
+	var payload SyncPendingRequest
+	if err := decodeRequestPayload(req, &payload); err != nil {
+		return nil, err
+	}
+	return syncPending(payload)
+}
+
+// handleLegacyMessage serves the unversioned protocol.
+func (p *SocketHandler) handleLegacyMessage(conn net.Conn, msg SocketMessage) {
 	switch msg.Type {
 	case SocketMessageTypeStatus:
 		p.handleStatus(conn)
@@ -186,7 +488,7 @@ func (p *SocketHandler) handleConnection(conn net.Conn) {
 		if err := p.channel.Publish(PubSubTopic, chMsg); err != nil {
 			slog.Error("Error to publish topic", slog.Any("err", err))
 		}
-	case SocketMessageTypeTrackPre, SocketMessageTypeTrackPost:
+	case SocketMessageTypeTrackPre, SocketMessageTypeTrackPost, SocketMessageTypeSessionStart, SocketMessageTypeSessionEnd:
 		buf, err := json.Marshal(msg)
 		if err != nil {
 			slog.Error("Error encoding track message", slog.Any("err", err))
@@ -198,10 +500,10 @@ func (p *SocketHandler) handleConnection(conn net.Conn) {
 		}
 	case SocketMessageTypeHeartbeat:
 		// Only process heartbeat if codeTracking is enabled
-		if p.config.CodeTracking == nil || p.config.CodeTracking.Enabled == nil || !*p.config.CodeTracking.Enabled {
+		if !p.codeTrackingEnabled() {
 			slog.Debug("Heartbeat message received but codeTracking is disabled, ignoring")
 			encoder := json.NewEncoder(conn)
-			encoder.Encode(map[string]string{"status": "disabled"})
+			encoder.Encode(HeartbeatResponse{Status: HeartbeatStatusDisabled})
 			return
 		}
 		buf, err := json.Marshal(msg)
@@ -217,9 +519,17 @@ func (p *SocketHandler) handleConnection(conn net.Conn) {
 
 		// Send acknowledgment to client
 		encoder := json.NewEncoder(conn)
-		encoder.Encode(map[string]string{"status": "ok"})
+		encoder.Encode(HeartbeatResponse{Status: HeartbeatStatusOK})
 	case SocketMessageTypeListCommands:
-		p.handleListCommands(conn)
+		p.handleListCommands(conn, msg)
+	case SocketMessageTypeStorageMigrate:
+		p.handleStorageMigrate(conn, msg)
+	case SocketMessageTypeStorageFsck:
+		p.handleStorageFsck(conn, msg)
+	case SocketMessageTypeStorageRotateKey:
+		p.handleStorageRotateKey(conn, msg)
+	case SocketMessageTypeSyncPending:
+		p.handleSyncPending(conn, msg)
 	case SocketMessageTypeCCInfo:
 		p.handleCCInfo(conn, msg)
 	case SocketMessageTypeSessionProject:
@@ -227,7 +537,7 @@ func (p *SocketHandler) handleConnection(conn net.Conn) {
 			sessionID, _ := payload["sessionId"].(string)
 			projectPath, _ := payload["projectPath"].(string)
 			if sessionID != "" && projectPath != "" {
-				go model.SendSessionProjectUpdate(context.Background(), *p.config, sessionID, projectPath)
+				go model.SendSessionProjectUpdate(context.Background(), *p.currentConfig(), sessionID, projectPath)
 				slog.Debug("session_project update dispatched", slog.String("sessionId", sessionID))
 			}
 		}
@@ -237,62 +547,150 @@ func (p *SocketHandler) handleConnection(conn net.Conn) {
 }
 
 func (p *SocketHandler) handleStatus(conn net.Conn) {
-	uptime := time.Since(startedAt)
-	response := StatusResponse{
+	encoder := json.NewEncoder(conn)
+	if err := encoder.Encode(p.status()); err != nil {
+		slog.Error("Error encoding status response", slog.Any("err", err))
+	}
+}
+
+func (p *SocketHandler) status() StatusResponse {
+	status := StatusResponse{
 		Version:   version,
 		StartedAt: startedAt,
-		Uptime:    formatDuration(uptime),
+		Uptime:    formatDuration(time.Since(startedAt)),
 		GoVersion: runtime.Version(),
 		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Protocol:  SocketProtocolVersion,
+		Endpoints: p.endpointStatus(context.Background()),
+	}
+	if serviceSupervisor != nil {
+		status.Services = serviceSupervisor.Running()
+		status.LastReload = serviceSupervisor.LastReload()
+	}
+	return status
+}
+
+func (p *SocketHandler) endpointStatus(ctx context.Context) []EndpointStatus {
+	cfg := p.currentConfig()
+	if cfg == nil || cfg.Token == "" {
+		return nil
+	}
+	commandStoreMu.RLock()
+	pending, err := model.PendingCounts(ctx, trackStore(), *cfg)
+	commandStoreMu.RUnlock()
+	if err != nil {
+		slog.Warn("Failed to count the unsynced commands", slog.Any("err", err))
+	}
+
+	endpoints := model.SyncEndpoints(*cfg)
+	result := make([]EndpointStatus, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		status := EndpointStatus{
+			APIEndpoint: endpoint.APIEndpoint,
+			Pending:     pending[model.EndpointKey(endpoint)],
+		}
+		if breaker := circuitBreakerFor(endpoint); breaker != nil {
+			circuit := breaker.Status()
+			status.RetryQueued = breaker.PendingCount()
+			status.Circuit = &circuit
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+func (p *SocketHandler) handleListCommands(conn net.Conn, msg SocketMessage) {
+	var req ListCommandsRequest
+	if msg.Payload != nil {
+		if err := decodePayload(msg.Payload, &req); err != nil {
+			slog.Warn("Invalid list_commands payload", slog.Any("err", err))
+		}
 	}
 
 	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(response); err != nil {
-		slog.Error("Error encoding status response", slog.Any("err", err))
+	if err := encoder.Encode(p.listCommands(req)); err != nil {
+		slog.Error("Error encoding list_commands response", slog.Any("err", err))
 	}
 }
 
-func (p *SocketHandler) handleListCommands(conn net.Conn) {
+func (p *SocketHandler) listCommands(req ListCommandsRequest) ListCommandsResponse {
+	commandStoreMu.RLock()
+	defer commandStoreMu.RUnlock()
+
 	response := ListCommandsResponse{Commands: []model.ListedCommand{}}
-	if commandStore != nil {
-		commands, err := model.BuildListedCommands(context.Background(), commandStore)
+	if commandStore == nil {
+		return response
+	}
+	ctx := context.Background()
+	if cfg := p.currentConfig(); cfg != nil && cfg.ArchiveEnabled() {
+		q := model.ArchiveQuery{SessionID: req.SessionID}
+		if req.SinceNano > 0 {
+			q.Since = time.Unix(0, req.SinceNano)
+		}
+		archived, err := model.ListArchived(ctx, commandStore, q)
 		if err != nil {
-			slog.Error("Failed to build listed commands", slog.Any("err", err))
+			slog.Error("Failed to query archived commands", slog.Any("err", err))
 		} else {
-			response.Commands = commands
+			response.Commands = append(response.Commands, archived...)
 		}
 	}
 
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(response); err != nil {
-		slog.Error("Error encoding list_commands response", slog.Any("err", err))
+	commands, err := model.BuildListedCommands(ctx, commandStore)
+	if err != nil {
+		slog.Error("Failed to build listed commands", slog.Any("err", err))
+	} else {
+		response.Commands = model.MergeListedCommands(response.Commands, commands)
 	}
+	return response
+}
+
+// decodePayload converts an untyped message payload into a typed request.
+func decodePayload(payload interface{}, v interface{}) error {
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
 }
 
 func (p *SocketHandler) handleCCInfo(conn net.Conn, msg SocketMessage) {
 	slog.Debug("cc_info socket event received")
 
 	// Parse time range, working directory, and Claude Code version from payload
-	timeRange := CCInfoTimeRangeToday
-	var workingDir string
+	req := CCInfoRequest{TimeRange: CCInfoTimeRangeToday}
 	if payload, ok := msg.Payload.(map[string]interface{}); ok {
 		if tr, ok := payload["timeRange"].(string); ok {
-			timeRange = CCInfoTimeRange(tr)
+			req.TimeRange = CCInfoTimeRange(tr)
 		}
 		if wd, ok := payload["workingDirectory"].(string); ok {
-			workingDir = wd
+			req.WorkingDirectory = wd
 		}
 		if v, ok := payload["claudeCodeVersion"].(string); ok {
-			p.ccInfoTimer.SetClaudeCodeVersion(v)
+			req.ClaudeCodeVersion = v
 		}
 	}
 
+	encoder := json.NewEncoder(conn)
+	if err := encoder.Encode(p.ccInfo(req)); err != nil {
+		slog.Error("Error encoding cc_info response", slog.Any("err", err))
+	}
+}
+
+func (p *SocketHandler) ccInfo(req CCInfoRequest) CCInfoResponse {
+	timeRange := req.TimeRange
+	if timeRange == "" {
+		timeRange = CCInfoTimeRangeToday
+	}
+	if req.ClaudeCodeVersion != "" {
+		p.ccInfoTimer.SetClaudeCodeVersion(req.ClaudeCodeVersion)
+	}
+
 	// Get cached cost first (marks range as active), then notify activity (starts timer)
 	cache := p.ccInfoTimer.GetCachedCost(timeRange)
 	p.ccInfoTimer.NotifyActivity()
 
 	// Get git info (cached to avoid slow worktree.Status() on large repos)
-	gitInfo := p.ccInfoTimer.GetCachedGitInfo(workingDir)
+	gitInfo := p.ccInfoTimer.GetCachedGitInfo(req.WorkingDirectory)
 
 	response := CCInfoResponse{
 		TotalCostUSD:        cache.TotalCostUSD,
@@ -311,11 +709,7 @@ func (p *SocketHandler) handleCCInfo(conn net.Conn, msg SocketMessage) {
 	} else {
 		response.QuotaError = p.ccInfoTimer.GetCachedRateLimitError()
 	}
-
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(response); err != nil {
-		slog.Error("Error encoding cc_info response", slog.Any("err", err))
-	}
+	return response
 }
 
 func formatDuration(d time.Duration) string {
@@ -104,6 +104,7 @@ func TestSocketHandler_SyncPublishesToTopic(t *testing.T) {
 		model.ShellTimeConfig{},
 		time.Now(),
 		[]model.TrackingData{{Command: "ls", Result: 0}},
+		nil,
 		model.TrackingMetaData{OS: "linux", Shell: "bash"},
 	)
 	require.NoError(t, err)
@@ -147,7 +148,7 @@ func TestSocketHandler_ListCommands_NilStore(t *testing.T) {
 
 	_, socketPath := startHandler(t, &model.ShellTimeConfig{})
 
-	resp, err := RequestListCommands(socketPath, 2*time.Second)
+	resp, err := RequestListCommands(socketPath, ListCommandsRequest{}, 2*time.Second)
 	require.NoError(t, err)
 	require.NotNil(t, resp)
 	assert.Empty(t, resp.Commands)
@@ -183,7 +184,7 @@ func TestSocketHandler_UnknownMessageType(t *testing.T) {
 }
 
 func TestRequestListCommands_NoSocket(t *testing.T) {
-	_, err := RequestListCommands(filepath.Join(t.TempDir(), "missing.sock"), 100*time.Millisecond)
+	_, err := RequestListCommands(filepath.Join(t.TempDir(), "missing.sock"), ListCommandsRequest{}, 100*time.Millisecond)
 	assert.Error(t, err)
 }
 
@@ -197,3 +198,33 @@ func TestRequestCCInfo_OverRealHandler(t *testing.T) {
 	require.NotNil(t, resp)
 	assert.Equal(t, "week", resp.TimeRange)
 }
+
+func TestSocketHandler_StatusReportsEndpoints(t *testing.T) {
+	prev := commandStore
+	t.Cleanup(func() { commandStore = prev })
+	now := time.Now()
+	store := &fakeCommandStore{cursor: now.Add(-time.Minute)}
+	require.NoError(t, store.SavePost(context.Background(), model.Command{Command: "ls", Time: now}, 0, now))
+	commandStore = store
+	withCircuitBreaker(t, &fakeDaemonCB{open: true, savedPayloads: []interface{}{"queued"}})
+
+	config := &model.ShellTimeConfig{Token: "t", APIEndpoint: "https://main", Endpoints: []model.Endpoint{{Token: "t2", APIEndpoint: "https://mirror"}}}
+	_, socketPath := startHandler(t, config)
+
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, json.NewEncoder(conn).Encode(SocketMessage{Type: SocketMessageTypeStatus}))
+
+	var resp StatusResponse
+	require.NoError(t, json.NewDecoder(conn).Decode(&resp))
+	require.Len(t, resp.Endpoints, 2)
+	assert.Equal(t, "https://main", resp.Endpoints[0].APIEndpoint)
+	assert.Equal(t, "https://mirror", resp.Endpoints[1].APIEndpoint)
+	for _, endpoint := range resp.Endpoints {
+		assert.Equal(t, 1, endpoint.Pending)
+		assert.Equal(t, 1, endpoint.RetryQueued)
+		require.NotNil(t, endpoint.Circuit)
+		assert.Equal(t, model.CircuitOpen, endpoint.Circuit.State)
+	}
+}
@@ -170,7 +170,7 @@ func TestSocketHandler_ListCommandsAndTrackEvent(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 
 	// list_commands request/response
-	resp, err := RequestListCommands(socketPath, 2*time.Second)
+	resp, err := RequestListCommands(socketPath, ListCommandsRequest{}, 2*time.Second)
 	if err != nil {
 		t.Fatalf("RequestListCommands failed: %v", err)
 	}
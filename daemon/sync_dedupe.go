@@ -0,0 +1,214 @@
+package daemon
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/malamtime/cli/model"
+)
+
+// sentRecordsLimit is how many acknowledged command IDs the daemon remembers.
+// It comfortably covers the payloads the circuit breaker replays.
+const sentRecordsLimit = 20000
+
+// sentRecordSet remembers the IDs of the commands each endpoint acknowledged,
+// oldest evicted first, so a replayed payload doesn't send them again.
+//
+// With fileFor set, the IDs of each endpoint are also appended to a file,
+// read back on the endpoint's first sync after a restart. An ID is written
+// right after the endpoint acknowledged it, so only a crash in between sends
+// a command twice; the server drops such a duplicate by its TrackingData.ID.
+type sentRecordSet struct {
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	order []string
+	limit int
+
+	// fileFor returns the file of an endpoint's IDs, relative to $HOME. Nil
+	// keeps them in memory only.
+	fileFor func(key string) string
+	// lines counts the lines of each endpoint file read or written; an
+	// endpoint without an entry isn't loaded yet.
+	lines map[string]int
+}
+
+var sentRecords = newSentRecordSet(sentRecordsLimit)
+
+func newSentRecordSet(limit int) *sentRecordSet {
+	return &sentRecordSet{ids: make(map[string]struct{}), limit: limit, lines: make(map[string]int)}
+}
+
+// sentFileFor returns where the acknowledged IDs of an endpoint are kept,
+// relative to $HOME.
+func sentFileFor(key string) string {
+	return strings.TrimSuffix(model.SYNC_PENDING_FILE, ".jsonl") + "-" + key + ".sent"
+}
+
+// PersistSentRecords keeps the IDs of the acknowledged commands across
+// restarts, next to the endpoints' retry queues, so the payloads the circuit
+// breaker replays after a restart aren't sent again.
+func PersistSentRecords() {
+	sentRecords.mu.Lock()
+	defer sentRecords.mu.Unlock()
+	sentRecords.fileFor = sentFileFor
+}
+
+// filter drops the commands the endpoint with the given key already
+// acknowledged and those appearing twice in data. Commands without an ID,
+// from clients older than IDs, are kept.
+func (s *sentRecordSet) filter(endpointKey string, data []model.TrackingData) []model.TrackingData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadLocked(endpointKey)
+
+	result := make([]model.TrackingData, 0, len(data))
+	seen := make(map[string]struct{}, len(data))
+	for _, td := range data {
+		if td.ID != "" {
+			if _, ok := s.ids[endpointKey+"|"+td.ID]; ok {
+				continue
+			}
+			if _, ok := seen[td.ID]; ok {
+				continue
+			}
+			seen[td.ID] = struct{}{}
+		}
+		result = append(result, td)
+	}
+	return result
+}
+
+func (s *sentRecordSet) add(endpointKey string, data []model.TrackingData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadLocked(endpointKey)
+
+	var added []string
+	for _, td := range data {
+		if td.ID == "" {
+			continue
+		}
+		if s.rememberLocked(endpointKey + "|" + td.ID) {
+			added = append(added, td.ID)
+		}
+	}
+	s.evictLocked()
+	s.saveLocked(endpointKey, added)
+}
+
+// rememberLocked adds one "endpointKey|id" entry and reports whether it is
+// new.
+func (s *sentRecordSet) rememberLocked(id string) bool {
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = struct{}{}
+	s.order = append(s.order, id)
+	return true
+}
+
+func (s *sentRecordSet) evictLocked() {
+	if over := len(s.order) - s.limit; over > 0 {
+		for _, id := range s.order[:over] {
+			delete(s.ids, id)
+		}
+		s.order = append([]string(nil), s.order[over:]...)
+	}
+}
+
+// loadLocked reads the IDs an endpoint acknowledged before a restart, once.
+func (s *sentRecordSet) loadLocked(endpointKey string) {
+	if s.fileFor == nil {
+		return
+	}
+	if _, ok := s.lines[endpointKey]; ok {
+		return
+	}
+	s.lines[endpointKey] = 0
+
+	file, err := os.Open(os.ExpandEnv("$HOME/" + s.fileFor(endpointKey)))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read the acknowledged command IDs", slog.String("endpoint", endpointKey), slog.Any("err", err))
+		}
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id := scanner.Text(); id != "" {
+			s.rememberLocked(endpointKey + "|" + id)
+			s.lines[endpointKey]++
+		}
+	}
+	s.evictLocked()
+}
+
+// saveLocked appends the newly acknowledged IDs to the endpoint's file. The
+// file is rewritten with the IDs still remembered once it holds twice the
+// limit.
+func (s *sentRecordSet) saveLocked(endpointKey string, added []string) {
+	if s.fileFor == nil || len(added) == 0 {
+		return
+	}
+	path := os.ExpandEnv("$HOME/" + s.fileFor(endpointKey))
+
+	if s.lines[endpointKey]+len(added) > 2*s.limit {
+		prefix := endpointKey + "|"
+		var kept []string
+		for _, id := range s.order {
+			if strings.HasPrefix(id, prefix) {
+				kept = append(kept, strings.TrimPrefix(id, prefix))
+			}
+		}
+		if err := rewriteSentIDs(path, kept); err != nil {
+			slog.Warn("Failed to rewrite the acknowledged command IDs", slog.String("endpoint", endpointKey), slog.Any("err", err))
+			return
+		}
+		s.lines[endpointKey] = len(kept)
+		return
+	}
+
+	if err := appendSentIDs(path, added); err != nil {
+		slog.Warn("Failed to save the acknowledged command IDs", slog.String("endpoint", endpointKey), slog.Any("err", err))
+		return
+	}
+	s.lines[endpointKey] += len(added)
+}
+
+// appendSentIDs appends ids to the file at path, one per line.
+func appendSentIDs(path string, ids []string) error {
+	return writeSentIDs(path, ids, os.O_APPEND)
+}
+
+// rewriteSentIDs replaces the file at path with ids, through a rename so a
+// crash never leaves it half written.
+func rewriteSentIDs(path string, ids []string) error {
+	tempFile := path + ".tmp"
+	if err := writeSentIDs(tempFile, ids, os.O_TRUNC); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return os.Rename(tempFile, path)
+}
+
+func writeSentIDs(path string, ids []string, flag int) error {
+	file, err := os.OpenFile(path, flag|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	var buf strings.Builder
+	for _, id := range ids {
+		buf.WriteString(id)
+		buf.WriteByte('\n')
+	}
+	if _, err := file.WriteString(buf.String()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
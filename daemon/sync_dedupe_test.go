@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/malamtime/cli/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSentRecordSet(t *testing.T) {
+	s := newSentRecordSet(2)
+	data := []model.TrackingData{{ID: "a"}, {ID: "b"}, {ID: "a"}, {Command: "legacy"}}
+	assert.Len(t, s.filter("main", data), 3, "duplicates in one payload are dropped")
+
+	s.add("main", []model.TrackingData{{ID: "a"}, {ID: "b"}})
+	rest := s.filter("main", data)
+	require.Len(t, rest, 1)
+	assert.Equal(t, "legacy", rest[0].Command)
+	assert.Len(t, s.filter("mirror", data), 3, "every endpoint acknowledges on its own")
+
+	// the oldest ID is evicted past the limit
+	s.add("main", []model.TrackingData{{ID: "c"}})
+	rest = s.filter("main", data)
+	require.Len(t, rest, 2)
+	assert.Equal(t, "a", rest[0].ID)
+}
+
+func TestSentRecordSet_Persisted(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	model.InitFolder("")
+	require.NoError(t, os.MkdirAll(filepath.Join(os.Getenv("HOME"), model.COMMAND_BASE_STORAGE_FOLDER), 0o755))
+
+	s := newSentRecordSet(2)
+	s.fileFor = sentFileFor
+	s.add("main", []model.TrackingData{{ID: "a"}, {ID: "b"}})
+	s.add("main", []model.TrackingData{{ID: "b"}, {ID: "c"}})
+
+	// a restarted daemon still knows the IDs the endpoint acknowledged
+	restarted := newSentRecordSet(2)
+	restarted.fileFor = sentFileFor
+	data := []model.TrackingData{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	rest := restarted.filter("main", data)
+	require.Len(t, rest, 1, "the two newest IDs are kept")
+	assert.Equal(t, "a", rest[0].ID)
+	assert.Len(t, restarted.filter("mirror", data), 3)
+
+	// past twice the limit the file is rewritten with the remembered IDs
+	restarted.add("main", []model.TrackingData{{ID: "d"}, {ID: "e"}})
+	content, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), sentFileFor("main")))
+	require.NoError(t, err)
+	assert.Equal(t, "d\ne\n", string(content))
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(os.Getenv("HOME"), sentFileFor("main")))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+}
+
+func TestSendTrackArgsToServer_SkipsReplayedCommands(t *testing.T) {
+	prev := sentRecords
+	sentRecords = newSentRecordSet(sentRecordsLimit)
+	t.Cleanup(func() { sentRecords = prev })
+
+	var sent atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	withCircuitBreaker(t, nil)
+	mockCS := model.NewMockConfigService(t)
+	mockCS.On("ReadConfigFile", mock.Anything).Return(model.ShellTimeConfig{Token: "tok", APIEndpoint: server.URL}, nil)
+	withStConfig(t, mockCS)
+
+	cursor := time.Now()
+	msg := model.PostTrackArgs{
+		CursorID: cursor.UnixNano(),
+		Data:     []model.TrackingData{{ID: model.CommandID(1, cursor, "ls"), Command: "ls"}},
+	}
+	require.NoError(t, sendTrackArgsToServer(context.Background(), msg, model.SyncOptions{}))
+
+	// the circuit breaker replays the same payload
+	var acked time.Time
+	require.NoError(t, sendTrackArgsToServer(context.Background(), msg, model.SyncOptions{
+		OnAck: func(ctx context.Context, endpoint model.Endpoint, c time.Time) error {
+			acked = c
+			return nil
+		},
+	}))
+	assert.Equal(t, int32(1), sent.Load(), "a replayed command is not sent again")
+	assert.Equal(t, cursor.UnixNano(), acked.UnixNano(), "the replay still acknowledges its cursor")
+}
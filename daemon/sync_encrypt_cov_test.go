@@ -1,8 +1,10 @@
 package daemon
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -44,8 +46,9 @@ func TestX3SendTrackArgsToServer_EncryptedHappyPath(t *testing.T) {
 			})
 		default:
 			syncHit = true
-			b := make([]byte, r.ContentLength)
-			_, _ = r.Body.Read(b)
+			body, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			b, _ := io.ReadAll(body)
 			sentBody = string(b)
 			w.WriteHeader(http.StatusNoContent)
 		}
@@ -62,16 +65,16 @@ func TestX3SendTrackArgsToServer_EncryptedHappyPath(t *testing.T) {
 	x3SwapStConfig(t, mc)
 
 	// No circuit breaker for this test.
-	prevCB := syncCircuitBreaker
-	syncCircuitBreaker = nil
-	t.Cleanup(func() { syncCircuitBreaker = prevCB })
+	prevCB := syncCircuitBreakerFor
+	syncCircuitBreakerFor = nil
+	t.Cleanup(func() { syncCircuitBreakerFor = prevCB })
 
 	msg := model.PostTrackArgs{
 		CursorID: 1234567890,
 		Data:     []model.TrackingData{{Command: "super-secret-command", Result: 0}},
 		Meta:     model.TrackingMetaData{OS: "linux", Shell: "bash"},
 	}
-	require.NoError(t, sendTrackArgsToServer(context.Background(), msg))
+	require.NoError(t, sendTrackArgsToServer(context.Background(), msg, model.SyncOptions{}))
 
 	assert.True(t, publicKeyHit, "public key endpoint should be queried for encryption")
 	assert.True(t, syncHit, "sync endpoint should receive the payload")
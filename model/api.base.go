@@ -2,6 +2,7 @@ package model
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -24,6 +25,7 @@ type HTTPRequestOptions[T any, R any] struct {
 	Response    *R
 	ContentType string        // Optional, defaults to "application/json"
 	Timeout     time.Duration // Optional, defaults to 10 seconds
+	Gzip        bool          // Optional, gzip the request body
 }
 
 // SendHTTPRequestJSON is a generic HTTP request function that sends JSON data and unmarshals the response
@@ -37,6 +39,18 @@ func SendHTTPRequestJSON[T any, R any](opts HTTPRequestOptions[T, R]) error {
 		return err
 	}
 
+	if opts.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(jsonData); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		jsonData = buf.Bytes()
+	}
+
 	timeout := time.Second * 10
 	if opts.Timeout > 0 {
 		timeout = opts.Timeout
@@ -59,6 +73,9 @@ func SendHTTPRequestJSON[T any, R any](opts HTTPRequestOptions[T, R]) error {
 	}
 
 	req.Header.Set("Content-Type", contentType)
+	if opts.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	req.Header.Set("User-Agent", fmt.Sprintf("shelltimeCLI@%s", commitID))
 	req.Header.Set("Authorization", "CLI "+opts.Endpoint.Token)
 
@@ -2,9 +2,11 @@ package model
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"sync"
+	"time"
 )
 
 type errorResponse struct {
@@ -13,6 +15,9 @@ type errorResponse struct {
 }
 
 type TrackingData struct {
+	// ID is the command's stable ID (see CommandID), so the server and the
+	// daemon can drop a command that is sent twice.
+	ID            string `json:"id,omitempty"`
 	SessionID     int64  `json:"sessionId"`
 	Command       string `json:"command"`
 	StartTime     int64  `json:"startTime"`
@@ -21,6 +26,31 @@ type TrackingData struct {
 	EndTimeNano   int64  `json:"endTimeNano"`
 	Result        int    `json:"result"`
 	PPID          int    `json:"ppid,omitempty"`
+	Cwd           string `json:"cwd,omitempty"`
+	PipeStatus    []int  `json:"pipeStatus,omitempty"`
+
+	// recordingTime is when the store saved the command, in nanoseconds. It
+	// is the cursor a chunk ending at this command is acknowledged up to.
+	recordingTime int64
+}
+
+// TrackingSessionData is a shell session start or end event in the sync
+// payload.
+type TrackingSessionData struct {
+	SessionID     int64  `json:"sessionId"`
+	Type          string `json:"type"`
+	Shell         string `json:"shell"`
+	Time          int64  `json:"time"`
+	TimeNano      int64  `json:"timeNano"`
+	PPID          int    `json:"ppid,omitempty"`
+	TTY           string `json:"tty,omitempty"`
+	SSHConnection string `json:"sshConnection,omitempty"`
+	TermProgram   string `json:"termProgram,omitempty"`
+	Cwd           string `json:"cwd,omitempty"`
+	Terminal      string `json:"terminal,omitempty"`
+	Multiplexer   string `json:"multiplexer,omitempty"`
+
+	recordingTime int64
 }
 
 type TrackingMetaData struct {
@@ -33,7 +63,7 @@ type TrackingMetaData struct {
 	Multiplexer string `json:"multiplexer,omitempty"`
 
 	// CliEngine is the storage engine that buffered these commands:
-	// StorageEngineFile or StorageEngineBolt.
+	// StorageEngineFile, StorageEngineBolt or StorageEngineSegment.
 	CliEngine string `json:"cliEngine,omitempty"`
 
 	// 0: cli, 1: daemon
@@ -45,6 +75,8 @@ type PostTrackArgs struct {
 	CursorID int64            `json:"cursorId"`
 	Data     []TrackingData   `json:"data"`
 	Meta     TrackingMetaData `json:"meta"`
+	// Sessions are the shell session lifecycle events recorded up to CursorID
+	Sessions []TrackingSessionData `json:"sessions,omitempty"`
 
 	Encrypted string `json:"encrypted"`
 	// a base64 encoded AES-GCM key that encrypted by PublicKey from open token
@@ -61,6 +93,7 @@ func doSendData(ctx context.Context, endpoint Endpoint, data PostTrackArgs) erro
 		Path:     "/api/v1/track",
 		Payload:  data,
 		Response: nil,
+		Gzip:     true,
 	})
 	slog.Debug("http track request", slog.String("path", "/api/v1/track"), slog.Int("dataLen", len(data.Data)))
 
@@ -71,8 +104,12 @@ func doSendData(ctx context.Context, endpoint Endpoint, data PostTrackArgs) erro
 	return nil
 }
 
-// func SendLocalDataToServer(ctx context.Context, config ShellTimeConfig, cursor time.Time, trackingData []TrackingData, meta TrackingMetaData) error {
-func SendLocalDataToServer(ctx context.Context, config ShellTimeConfig, data PostTrackArgs) error {
+// SendLocalDataToServer sends the payload to the main and the additional
+// endpoints. Every endpoint is synced on its own, from its cursor in
+// opts.Cursors, in chunks (see SplitTrackArgs): opts.OnAck is called after
+// each chunk it acknowledged, and its first failing chunk stops only its
+// sync. The failures are returned joined, one EndpointError per endpoint.
+func SendLocalDataToServer(ctx context.Context, config ShellTimeConfig, data PostTrackArgs, opts SyncOptions) error {
 	ctx, span := modelTracer.Start(ctx, "sync.local")
 	defer span.End()
 	if config.Token == "" {
@@ -81,36 +118,59 @@ func SendLocalDataToServer(ctx context.Context, config ShellTimeConfig, data Pos
 	}
 
 	var wg sync.WaitGroup
+	endpoints := SyncEndpoints(config)
+	errs := make([]error, len(endpoints))
 
-	wg.Add(len(config.Endpoints) + 1)
-
-	authPair := make([]Endpoint, len(config.Endpoints)+1)
-
-	authPair[0] = Endpoint{
-		Token:       config.Token,
-		APIEndpoint: config.APIEndpoint,
-	}
-
-	copy(authPair[1:], config.Endpoints)
-
-	errs := make(chan error, len(authPair))
-
-	for _, pair := range authPair {
-		go func(pair Endpoint) {
+	for i, endpoint := range endpoints {
+		key := EndpointKey(endpoint)
+		if opts.Only != "" && opts.Only != key {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, endpoint Endpoint) {
 			defer wg.Done()
-			err := doSendData(ctx, pair, data)
-			errs <- err
-		}(pair)
+			if err := sendToEndpoint(ctx, endpoint, data, opts); err != nil {
+				errs[i] = &EndpointError{Endpoint: endpoint, Err: err}
+			}
+		}(i, endpoint)
 	}
 
 	wg.Wait()
-	close(errs)
+	return errors.Join(errs...)
+}
 
-	for err := range errs {
-		if err != nil {
+func sendToEndpoint(ctx context.Context, endpoint Endpoint, data PostTrackArgs, opts SyncOptions) error {
+	if opts.Check != nil {
+		if err := opts.Check(endpoint); err != nil {
 			return err
 		}
 	}
+	if cursor, ok := opts.Cursors[EndpointKey(endpoint)]; ok && data.Encrypted == "" {
+		data = data.After(cursor.UnixNano())
+	}
 
+	chunks := SplitTrackArgs(data, SyncChunkMaxCommands, SyncChunkMaxBytes)
+	for i, chunk := range chunks {
+		payload := chunk
+		if opts.Prepare != nil {
+			var err error
+			if payload, err = opts.Prepare(endpoint, chunk); err != nil {
+				return err
+			}
+		}
+		if payload.Encrypted != "" || len(payload.Data) > 0 || len(payload.Sessions) > 0 {
+			if err := doSendData(ctx, endpoint, payload); err != nil {
+				if i > 0 {
+					slog.Warn("sync stopped at chunk", slog.String("endpoint", endpoint.APIEndpoint), slog.Int("chunk", i+1), slog.Int("chunks", len(chunks)))
+				}
+				return err
+			}
+		}
+		if opts.OnAck != nil {
+			if err := opts.OnAck(ctx, endpoint, time.Unix(0, chunk.CursorID)); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"net/http"
@@ -22,12 +23,15 @@ func (s *apiTestSuite) TestDoSendData() {
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Verify headers
 			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
 			assert.Contains(t, r.Header.Get("User-Agent"), "shelltimeCLI@")
 			assert.Equal(t, "CLI testToken", r.Header.Get("Authorization"))
 
 			// Decode request body
+			body, err := gzip.NewReader(r.Body)
+			assert.NoError(t, err)
 			var payload PostTrackArgs
-			err := json.NewDecoder(r.Body).Decode(&payload)
+			err = json.NewDecoder(body).Decode(&payload)
 			assert.NoError(t, err)
 
 			// Verify payload
@@ -118,7 +122,7 @@ func (s *apiTestSuite) TestSendLocalDataToServer() {
 			CursorID: time.Now().UnixNano(),
 			Data:     nil,
 			Meta:     TrackingMetaData{},
-		})
+		}, SyncOptions{})
 		assert.NoError(t, err)
 	})
 
@@ -165,7 +169,7 @@ func (s *apiTestSuite) TestSendLocalDataToServer() {
 			CursorID: time.Now().UnixNano(),
 			Data:     trackingData,
 			Meta:     meta,
-		})
+		}, SyncOptions{})
 		assert.NoError(t, err)
 		assert.Equal(t, 3, requestCount) // Main endpoint + 2 additional endpoints
 	})
@@ -214,9 +218,13 @@ func (s *apiTestSuite) TestSendLocalDataToServer() {
 			CursorID: time.Now().UnixNano(),
 			Data:     trackingData,
 			Meta:     meta,
-		})
+		}, SyncOptions{})
 		assert.Error(t, err)
-		assert.Equal(t, "test error", err.Error())
+		assert.Contains(t, err.Error(), "test error")
+		failed := EndpointErrors(err)
+		if assert.Len(t, failed, 1) {
+			assert.Equal(t, failureServer.URL, failed[0].Endpoint.APIEndpoint)
+		}
 	})
 }
 
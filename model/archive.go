@@ -0,0 +1,184 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// ArchivedCommand is a paired command kept in the local history archive.
+type ArchivedCommand struct {
+	ListedCommand
+	Main       string `json:"main,omitempty"`
+	PipeStatus []int  `json:"pipestatus,omitempty"`
+	// RecordingTime is the recording time of the post command. The archive is
+	// ordered and indexed by it.
+	RecordingTime time.Time `json:"rt"`
+}
+
+// ArchiveQuery selects archived commands. Zero values don't filter.
+type ArchiveQuery struct {
+	Since     time.Time
+	Until     time.Time
+	SessionID int64
+	// Limit keeps only the newest Limit matches.
+	Limit int
+}
+
+func (q ArchiveQuery) matchTime(t time.Time) bool {
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !t.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Matches reports whether a listed command falls in the query's time range
+// (by end time) and session.
+func (q ArchiveQuery) Matches(cmd ListedCommand) bool {
+	if q.SessionID != 0 && cmd.SessionID != q.SessionID {
+		return false
+	}
+	return q.matchTime(cmd.EndTime)
+}
+
+func (q ArchiveQuery) applyLimit(records []ArchivedCommand) []ArchivedCommand {
+	if q.Limit > 0 && len(records) > q.Limit {
+		return records[len(records)-q.Limit:]
+	}
+	return records
+}
+
+// ArchiveStore is implemented by the command stores that can keep a local
+// long-term history next to the sync buffer. Commands are archived only once
+// synced, right before Prune drops them (see ArchiveSynced), so the commands
+// that never sync stay in the buffer. Prune never touches the archive, only
+// PruneArchive does.
+type ArchiveStore interface {
+	// AppendArchive adds records to the archive. Records at or before the
+	// newest archived recording time are skipped, so re-archiving the same
+	// batch after a failed prune is harmless.
+	AppendArchive(ctx context.Context, records []ArchivedCommand) error
+	// QueryArchive returns the matching records in recording time order.
+	QueryArchive(ctx context.Context, q ArchiveQuery) ([]ArchivedCommand, error)
+	// PruneArchive drops records recorded before the given time and returns
+	// how many were dropped.
+	PruneArchive(ctx context.Context, before time.Time) (int, error)
+}
+
+// ArchiveEnabled reports whether the local history archive is turned on.
+func (c ShellTimeConfig) ArchiveEnabled() bool {
+	return c.Storage != nil && c.Storage.Archive != nil &&
+		c.Storage.Archive.Enabled != nil && *c.Storage.Archive.Enabled
+}
+
+// BuildArchivedCommands pairs the synced post commands (recording time at or
+// before cursor) with their pre commands, ready to be archived before Prune
+// drops them.
+func BuildArchivedCommands(ctx context.Context, store CommandStore, cursor time.Time) ([]ArchivedCommand, error) {
+	postCommands, err := store.GetPostCommands(ctx)
+	if err != nil {
+		return nil, err
+	}
+	preTree, err := store.GetPreTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pres := newPreIndex(preTree)
+	records := make([]ArchivedCommand, 0)
+	for _, post := range postCommands {
+		if post == nil || post.RecordingTime.After(cursor) {
+			continue
+		}
+		listed, ok := pairListedCommand(post, pres)
+		if !ok {
+			continue
+		}
+		records = append(records, ArchivedCommand{
+			ListedCommand: listed,
+			Main:          post.Main,
+			PipeStatus:    post.PipeStatus,
+			RecordingTime: post.RecordingTime,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].RecordingTime.Before(records[j].RecordingTime) })
+	return records, nil
+}
+
+// ArchiveSynced copies the synced commands of store into its archive and
+// applies the retention. Call it right before Prune. It is a no-op unless the
+// archive is enabled in config and the store supports it.
+func ArchiveSynced(ctx context.Context, cfg ShellTimeConfig, store CommandStore, cursor time.Time) error {
+	archive, ok := store.(ArchiveStore)
+	if !ok || !cfg.ArchiveEnabled() {
+		return nil
+	}
+
+	records, err := BuildArchivedCommands(ctx, store, cursor)
+	if err != nil {
+		return err
+	}
+	if err := archive.AppendArchive(ctx, records); err != nil {
+		return err
+	}
+
+	if days := cfg.Storage.Archive.RetentionDays; days > 0 {
+		dropped, err := archive.PruneArchive(ctx, time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			return err
+		}
+		if dropped > 0 {
+			slog.Debug("dropped expired archived commands", slog.Int("count", dropped))
+		}
+	}
+	return nil
+}
+
+// MergeListedCommands appends the buffered rows to the archived ones,
+// leaving out the buffered commands that are archived already: a synced
+// command is archived before it is pruned from the buffer, and the segment
+// engine keeps it in the buffer until its day is over.
+func MergeListedCommands(archived, buffered []ListedCommand) []ListedCommand {
+	if len(archived) == 0 {
+		return buffered
+	}
+	seen := make(map[string]struct{}, len(archived))
+	for _, cmd := range archived {
+		seen[listedCommandKey(cmd)] = struct{}{}
+	}
+	result := archived
+	for _, cmd := range buffered {
+		if _, ok := seen[listedCommandKey(cmd)]; !ok {
+			result = append(result, cmd)
+		}
+	}
+	return result
+}
+
+// listedCommandKey identifies the post command a row was built from.
+func listedCommandKey(cmd ListedCommand) string {
+	return fmt.Sprintf("%s|%d|%s|%s|%d", cmd.Shell, cmd.SessionID, cmd.Command, cmd.Username, cmd.EndTime.UnixNano())
+}
+
+// ListArchived returns the archived commands of store matching q as display
+// rows, oldest first. Stores without an archive return nil.
+func ListArchived(ctx context.Context, store CommandStore, q ArchiveQuery) ([]ListedCommand, error) {
+	archive, ok := store.(ArchiveStore)
+	if !ok {
+		return nil, nil
+	}
+	records, err := archive.QueryArchive(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ListedCommand, 0, len(records))
+	for _, rec := range records {
+		result = append(result, rec.ListedCommand)
+	}
+	return result, nil
+}
@@ -0,0 +1,154 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// archiveBucket holds archived commands keyed by recording time + session id.
+	archiveBucket = "archive"
+	// archiveSessionBucket indexes archiveBucket by session: session id +
+	// recording time, with an empty value.
+	archiveSessionBucket = "archive_sessions"
+)
+
+func archiveSessionKey(sessionID int64, nano int64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[0:8], uint64(sessionID))
+	binary.BigEndian.PutUint64(key[8:16], uint64(nano))
+	return key
+}
+
+func (s *boltStore) AppendArchive(ctx context.Context, records []ArchivedCommand) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		archive := tx.Bucket([]byte(archiveBucket))
+		sessions := tx.Bucket([]byte(archiveSessionBucket))
+		if archive == nil || sessions == nil {
+			return fmt.Errorf("bucket %s not found", archiveBucket)
+		}
+
+		var newest int64
+		if k, _ := archive.Cursor().Last(); k != nil {
+			newest = decodeKeyNano(k)
+		}
+		for _, rec := range records {
+			nano := rec.RecordingTime.UnixNano()
+			if nano <= newest {
+				continue
+			}
+			val, err := marshalStorageRecord(rec)
+			if err != nil {
+				return err
+			}
+			if err := archive.Put(encodeKey(rec.RecordingTime, uint64(rec.SessionID)), val); err != nil {
+				return err
+			}
+			if err := sessions.Put(archiveSessionKey(rec.SessionID, nano), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) QueryArchive(ctx context.Context, q ArchiveQuery) ([]ArchivedCommand, error) {
+	result := make([]ArchivedCommand, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		archive := tx.Bucket([]byte(archiveBucket))
+		sessions := tx.Bucket([]byte(archiveSessionBucket))
+		if archive == nil || sessions == nil {
+			return fmt.Errorf("bucket %s not found", archiveBucket)
+		}
+
+		decode := func(v []byte) error {
+			var rec ArchivedCommand
+			if err := unmarshalStorageRecord(v, &rec); err != nil {
+				if errors.Is(err, ErrStorageKeyUnavailable) {
+					return err
+				}
+				slog.Warn("failed to unmarshal archived command from bolt", slog.Any("err", err))
+				return nil
+			}
+			result = append(result, rec)
+			return nil
+		}
+
+		var since int64
+		if !q.Since.IsZero() {
+			since = q.Since.UnixNano()
+		}
+
+		if q.SessionID != 0 {
+			prefix := archiveSessionKey(q.SessionID, 0)[:8]
+			c := sessions.Cursor()
+			for k, _ := c.Seek(archiveSessionKey(q.SessionID, since)); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				nano := int64(binary.BigEndian.Uint64(k[8:16]))
+				if !q.matchTime(time.Unix(0, nano)) {
+					break
+				}
+				if v := archive.Get(encodeKey(time.Unix(0, nano), uint64(q.SessionID))); v != nil {
+					if err := decode(v); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+
+		c := archive.Cursor()
+		for k, v := c.Seek(encodeKey(time.Unix(0, since), 0)); k != nil; k, v = c.Next() {
+			if !q.matchTime(time.Unix(0, decodeKeyNano(k))) {
+				break
+			}
+			if err := decode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q.applyLimit(result), nil
+}
+
+func (s *boltStore) PruneArchive(ctx context.Context, before time.Time) (int, error) {
+	dropped := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		archive := tx.Bucket([]byte(archiveBucket))
+		sessions := tx.Bucket([]byte(archiveSessionBucket))
+		if archive == nil || sessions == nil {
+			return fmt.Errorf("bucket %s not found", archiveBucket)
+		}
+
+		beforeNano := before.UnixNano()
+		var keys [][]byte
+		c := archive.Cursor()
+		for k, _ := c.First(); k != nil && decodeKeyNano(k) < beforeNano; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			sessionID := int64(binary.BigEndian.Uint64(k[8:16]))
+			if err := sessions.Delete(archiveSessionKey(sessionID, decodeKeyNano(k))); err != nil {
+				return err
+			}
+			if err := archive.Delete(k); err != nil {
+				return err
+			}
+		}
+		dropped = len(keys)
+		return nil
+	})
+	return dropped, err
+}
@@ -0,0 +1,239 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// The file archive is archive.txt, one JSON record per line, plus archive.idx,
+// a compact index of fixed-size entries (recording time, session id, offset
+// of the line in archive.txt) sorted by recording time. Queries binary-search
+// the index and only decode the lines they return.
+const archiveIndexEntrySize = 24
+
+type archiveIndexEntry struct {
+	nano      int64
+	sessionID int64
+	offset    int64
+}
+
+func readArchiveIndex() ([]archiveIndexEntry, error) {
+	content, err := os.ReadFile(GetArchiveIndexFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := make([]archiveIndexEntry, 0, len(content)/archiveIndexEntrySize)
+	for i := 0; i+archiveIndexEntrySize <= len(content); i += archiveIndexEntrySize {
+		entries = append(entries, archiveIndexEntry{
+			nano:      int64(binary.BigEndian.Uint64(content[i : i+8])),
+			sessionID: int64(binary.BigEndian.Uint64(content[i+8 : i+16])),
+			offset:    int64(binary.BigEndian.Uint64(content[i+16 : i+24])),
+		})
+	}
+	return entries, nil
+}
+
+func encodeArchiveIndex(entries []archiveIndexEntry) []byte {
+	buf := make([]byte, len(entries)*archiveIndexEntrySize)
+	for i, e := range entries {
+		b := buf[i*archiveIndexEntrySize:]
+		binary.BigEndian.PutUint64(b[0:8], uint64(e.nano))
+		binary.BigEndian.PutUint64(b[8:16], uint64(e.sessionID))
+		binary.BigEndian.PutUint64(b[16:24], uint64(e.offset))
+	}
+	return buf
+}
+
+// AppendArchive appends records to archive.txt and their entries to
+// archive.idx. It holds the exclusive lock of the txt files, as the daemon
+// and shelltime gc both archive and the offsets come from the file size.
+func (s *fileStore) AppendArchive(ctx context.Context, records []ArchivedCommand) error {
+	if len(records) == 0 {
+		return nil
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index, err := readArchiveIndex()
+	if err != nil {
+		return err
+	}
+	var newest int64
+	if len(index) > 0 {
+		newest = index[len(index)-1].nano
+	}
+
+	f, err := os.OpenFile(GetArchiveFilePath(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+
+	buf := bytes.Buffer{}
+	added := make([]archiveIndexEntry, 0, len(records))
+	for _, rec := range records {
+		nano := rec.RecordingTime.UnixNano()
+		if nano <= newest {
+			continue
+		}
+		line, err := marshalStorageRecord(rec)
+		if err != nil {
+			return err
+		}
+		added = append(added, archiveIndexEntry{nano: nano, sessionID: rec.SessionID, offset: offset + int64(buf.Len())})
+		buf.Write(line)
+		buf.WriteByte('\n')
+		newest = nano
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+
+	idx, err := os.OpenFile(GetArchiveIndexFilePath(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open archive index: %w", err)
+	}
+	defer idx.Close()
+	if _, err := idx.Write(encodeArchiveIndex(added)); err != nil {
+		return fmt.Errorf("failed to write archive index: %w", err)
+	}
+	return nil
+}
+
+func (s *fileStore) QueryArchive(ctx context.Context, q ArchiveQuery) ([]ArchivedCommand, error) {
+	index, err := readArchiveIndex()
+	if err != nil || len(index) == 0 {
+		return nil, err
+	}
+
+	start := 0
+	if !q.Since.IsZero() {
+		since := q.Since.UnixNano()
+		start = sort.Search(len(index), func(i int) bool { return index[i].nano >= since })
+	}
+	end := len(index)
+	if !q.Until.IsZero() {
+		until := q.Until.UnixNano()
+		end = sort.Search(len(index), func(i int) bool { return index[i].nano >= until })
+	}
+	if start >= end {
+		return nil, nil
+	}
+
+	f, err := os.Open(GetArchiveFilePath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// walk backwards so Limit can stop at the newest matches
+	result := make([]ArchivedCommand, 0)
+	for i := end - 1; i >= start; i-- {
+		if q.Limit > 0 && len(result) >= q.Limit {
+			break
+		}
+		e := index[i]
+		if q.SessionID != 0 && e.sessionID != q.SessionID {
+			continue
+		}
+		lineEnd := info.Size()
+		if i+1 < len(index) {
+			lineEnd = index[i+1].offset
+		}
+		if e.offset >= lineEnd {
+			continue
+		}
+		line := make([]byte, lineEnd-e.offset)
+		if _, err := f.ReadAt(line, e.offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if nl := bytes.IndexByte(line, '\n'); nl >= 0 {
+			line = line[:nl]
+		}
+		var rec ArchivedCommand
+		if err := unmarshalStorageRecord(line, &rec); err != nil {
+			if errors.Is(err, ErrStorageKeyUnavailable) {
+				return nil, err
+			}
+			slog.Warn("failed to parse archived command", slog.Int64("offset", e.offset), slog.Any("err", err))
+			continue
+		}
+		result = append(result, rec)
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
+}
+
+// PruneArchive drops the records before the given time from archive.txt and
+// archive.idx, each replaced through a rename under the exclusive lock.
+func (s *fileStore) PruneArchive(ctx context.Context, before time.Time) (int, error) {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	index, err := readArchiveIndex()
+	if err != nil || len(index) == 0 {
+		return 0, err
+	}
+	cut := sort.Search(len(index), func(i int) bool { return index[i].nano >= before.UnixNano() })
+	if cut == 0 {
+		return 0, nil
+	}
+
+	content, err := os.ReadFile(GetArchiveFilePath())
+	if err != nil {
+		return 0, err
+	}
+	var kept []byte
+	remaining := make([]archiveIndexEntry, 0, len(index)-cut)
+	if cut < len(index) {
+		base := index[cut].offset
+		if base > int64(len(content)) {
+			return 0, fmt.Errorf("archive index points past the end of %s", GetArchiveFilePath())
+		}
+		kept = content[base:]
+		for _, e := range index[cut:] {
+			e.offset -= base
+			remaining = append(remaining, e)
+		}
+	}
+
+	if err := ReplaceFile(GetArchiveFilePath(), kept); err != nil {
+		return 0, err
+	}
+	if err := ReplaceFile(GetArchiveIndexFilePath(), encodeArchiveIndex(remaining)); err != nil {
+		return 0, err
+	}
+	return cut, nil
+}
@@ -0,0 +1,183 @@
+package model
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func archiveRecord(cmd string, sessionID int64, rt time.Time) ArchivedCommand {
+	return ArchivedCommand{
+		ListedCommand: ListedCommand{Command: cmd, SessionID: sessionID, StartTime: rt.Add(-time.Second), EndTime: rt},
+		RecordingTime: rt,
+	}
+}
+
+func archiveCommands(records []ArchivedCommand) []string {
+	result := make([]string, 0, len(records))
+	for _, r := range records {
+		result = append(result, r.Command)
+	}
+	return result
+}
+
+func TestArchiveStores(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+
+	bolt, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer bolt.Close()
+
+	for _, store := range []ArchiveStore{newFileStore(), bolt} {
+		t.Run(store.(CommandStore).Engine(), func(t *testing.T) {
+			ctx := context.Background()
+			base := time.Unix(1700000000, 0)
+			records := []ArchivedCommand{
+				archiveRecord("a", 1, base),
+				archiveRecord("b", 2, base.Add(time.Minute)),
+				archiveRecord("c", 1, base.Add(2*time.Minute)),
+			}
+			require.NoError(t, store.AppendArchive(ctx, records))
+			// re-archiving the same batch after a failed prune adds nothing
+			require.NoError(t, store.AppendArchive(ctx, records))
+			require.NoError(t, store.AppendArchive(ctx, []ArchivedCommand{archiveRecord("d", 2, base.Add(3*time.Minute))}))
+
+			all, err := store.QueryArchive(ctx, ArchiveQuery{})
+			require.NoError(t, err)
+			require.Equal(t, []string{"a", "b", "c", "d"}, archiveCommands(all))
+			require.Equal(t, int64(2), all[1].SessionID)
+
+			got, err := store.QueryArchive(ctx, ArchiveQuery{SessionID: 1})
+			require.NoError(t, err)
+			require.Equal(t, []string{"a", "c"}, archiveCommands(got))
+
+			got, err = store.QueryArchive(ctx, ArchiveQuery{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)})
+			require.NoError(t, err)
+			require.Equal(t, []string{"b", "c"}, archiveCommands(got))
+
+			got, err = store.QueryArchive(ctx, ArchiveQuery{Limit: 2})
+			require.NoError(t, err)
+			require.Equal(t, []string{"c", "d"}, archiveCommands(got))
+
+			dropped, err := store.PruneArchive(ctx, base.Add(90*time.Second))
+			require.NoError(t, err)
+			require.Equal(t, 2, dropped)
+
+			got, err = store.QueryArchive(ctx, ArchiveQuery{})
+			require.NoError(t, err)
+			require.Equal(t, []string{"c", "d"}, archiveCommands(got))
+			got, err = store.QueryArchive(ctx, ArchiveQuery{SessionID: 2})
+			require.NoError(t, err)
+			require.Equal(t, []string{"d"}, archiveCommands(got))
+		})
+	}
+}
+
+func TestArchiveSynced(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+	ctx := context.Background()
+	store := newFileStore()
+
+	now := time.Now()
+	cmd := Command{Shell: "bash", SessionID: 3, Command: "make test", Main: "make", Username: "u", Time: now.Add(-time.Second)}
+	require.NoError(t, store.SavePre(ctx, cmd, now.Add(-time.Second)))
+	post := cmd
+	post.Time = now
+	require.NoError(t, store.SavePost(ctx, post, 2, now))
+
+	enabled := true
+	cfg := ShellTimeConfig{Storage: &StorageConfig{Archive: &ArchiveConfig{Enabled: &enabled, RetentionDays: 30}}}
+
+	require.NoError(t, ArchiveSynced(ctx, cfg, store, now))
+	require.NoError(t, store.Prune(ctx, now))
+
+	posts, err := store.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Empty(t, posts)
+
+	listed, err := ListArchived(ctx, store, ArchiveQuery{SessionID: 3})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, "make test", listed[0].Command)
+	require.Equal(t, 2, listed[0].Result)
+	require.Equal(t, now.Add(-time.Second).UnixNano(), listed[0].StartTime.UnixNano())
+
+	records, err := store.QueryArchive(ctx, ArchiveQuery{})
+	require.NoError(t, err)
+	require.Equal(t, "make", records[0].Main)
+}
+
+func TestArchiveSynced_Disabled(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+	ctx := context.Background()
+	store := newFileStore()
+
+	now := time.Now()
+	cmd := Command{Shell: "bash", SessionID: 3, Command: "ls", Username: "u", Time: now}
+	require.NoError(t, store.SavePre(ctx, cmd, now))
+	require.NoError(t, store.SavePost(ctx, cmd, 0, now))
+
+	require.NoError(t, ArchiveSynced(ctx, ShellTimeConfig{}, store, now))
+	records, err := store.QueryArchive(ctx, ArchiveQuery{})
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
+
+func TestMergeListedCommands(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	a := archiveRecord("a", 1, base).ListedCommand
+	b := archiveRecord("b", 1, base.Add(time.Minute)).ListedCommand
+	// the same command run again in another session is another row
+	otherA := archiveRecord("a", 2, base).ListedCommand
+
+	merged := MergeListedCommands([]ListedCommand{a}, []ListedCommand{a, otherA, b})
+	require.Len(t, merged, 3)
+	require.Equal(t, []int64{1, 2, 1}, []int64{merged[0].SessionID, merged[1].SessionID, merged[2].SessionID})
+
+	require.Equal(t, []ListedCommand{b}, MergeListedCommands(nil, []ListedCommand{b}))
+}
+
+func TestFileArchive_LockedAndPrivate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+	ctx := context.Background()
+	s := newFileStore()
+	base := time.Unix(1700000000, 0)
+
+	// archiving waits while a rewrite holds the lock
+	unlock, err := LockCommandStorage()
+	require.NoError(t, err)
+	appended := make(chan error, 1)
+	go func() {
+		appended <- s.AppendArchive(ctx, []ArchivedCommand{archiveRecord("a", 1, base), archiveRecord("b", 1, base.Add(time.Second))})
+	}()
+	select {
+	case <-appended:
+		t.Fatal("the archive append didn't wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	require.NoError(t, <-appended)
+
+	dropped, err := s.PruneArchive(ctx, base.Add(time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 1, dropped)
+	records, err := s.QueryArchive(ctx, ArchiveQuery{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, archiveCommands(records))
+
+	for _, path := range []string{GetArchiveFilePath(), GetArchiveIndexFilePath()} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm(), path)
+		_, err = os.Stat(path + ".tmp")
+		require.True(t, os.IsNotExist(err))
+	}
+}
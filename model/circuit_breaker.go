@@ -3,8 +3,10 @@ package model
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"os"
 	"sync"
 	"time"
@@ -13,6 +15,24 @@ import (
 const (
 	DefaultMaxConsecutiveFailures = 10
 	DefaultCircuitResetInterval   = 1 * time.Hour
+	DefaultCircuitBaseBackoff     = 1 * time.Minute
+
+	// maxPendingLineSize bounds one saved payload. A payload saved after a
+	// partly synced backlog can be far larger than a single chunk.
+	maxPendingLineSize = 64 << 20
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState string
+
+const (
+	// CircuitClosed lets every request through.
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen holds requests back until the backoff expires.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets requests through again, but drains only a single
+	// saved payload until one succeeds.
+	CircuitHalfOpen CircuitState = "half-open"
 )
 
 // CircuitBreaker defines the interface for circuit breaker operations
@@ -26,7 +46,32 @@ type CircuitBreaker interface {
 // CircuitBreakerConfig holds configuration for the circuit breaker service
 type CircuitBreakerConfig struct {
 	MaxConsecutiveFailures int
-	ResetInterval          time.Duration
+	// ResetInterval is the longest backoff, and how often saved payloads are
+	// retried while the circuit is closed.
+	ResetInterval time.Duration
+	// BaseBackoff is how long the circuit first stays open. It doubles every
+	// time the probe after it fails, up to ResetInterval.
+	BaseBackoff time.Duration
+	// PendingFile is where SaveForRetry keeps the payloads, relative to
+	// $HOME. Defaults to SYNC_PENDING_FILE.
+	PendingFile string
+	// StateFile is where the state is kept across restarts, relative to
+	// $HOME. Without one the state is only kept in memory.
+	StateFile string
+	// OnStateChange, if set, is called when the circuit opens, goes
+	// half-open or closes. It runs with the breaker locked and must not call
+	// back into it.
+	OnStateChange func(status CircuitBreakerStatus)
+}
+
+// CircuitBreakerStatus is a snapshot of a circuit breaker.
+type CircuitBreakerStatus struct {
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	// Attempt counts the times the circuit opened since it was last closed.
+	Attempt int `json:"attempt,omitempty"`
+	// NextRetryAt is when an open circuit goes half-open.
+	NextRetryAt time.Time `json:"nextRetryAt,omitempty"`
 }
 
 // RepublishFunc is called when retrying pending data
@@ -36,15 +81,19 @@ type RepublishFunc func(data []byte) error
 type CircuitBreakerService struct {
 	mu                  sync.RWMutex
 	consecutiveFailures int
-	isOpen              bool
+	state               CircuitState
+	attempt             int
+	nextRetryAt         time.Time
 	config              CircuitBreakerConfig
 	republishFn         RepublishFunc
-	ticker              *time.Ticker
+	wake                chan struct{}
+	retry               chan struct{}
 	stopChan            chan struct{}
 	wg                  sync.WaitGroup
 }
 
-// NewCircuitBreakerService creates a new circuit breaker service
+// NewCircuitBreakerService creates a new circuit breaker service. The state
+// saved in config.StateFile, if any, is restored.
 func NewCircuitBreakerService(config CircuitBreakerConfig, republishFn RepublishFunc) *CircuitBreakerService {
 	if config.MaxConsecutiveFailures <= 0 {
 		config.MaxConsecutiveFailures = DefaultMaxConsecutiveFailures
@@ -52,30 +101,58 @@ func NewCircuitBreakerService(config CircuitBreakerConfig, republishFn Republish
 	if config.ResetInterval <= 0 {
 		config.ResetInterval = DefaultCircuitResetInterval
 	}
-	return &CircuitBreakerService{
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = DefaultCircuitBaseBackoff
+	}
+	s := &CircuitBreakerService{
+		state:       CircuitClosed,
 		config:      config,
 		republishFn: republishFn,
+		wake:        make(chan struct{}, 1),
+		retry:       make(chan struct{}, 1),
 		stopChan:    make(chan struct{}),
 	}
+	s.loadState()
+	return s
 }
 
-// Start begins the periodic reset/retry timer
+// Start begins the retry timer: it fires when an open circuit's backoff
+// expires, and every ResetInterval while the circuit is closed
 func (s *CircuitBreakerService) Start(ctx context.Context) error {
-	s.ticker = time.NewTicker(s.config.ResetInterval)
 	s.wg.Add(1)
 
 	go func() {
 		defer s.wg.Done()
 
+		timer := time.NewTimer(s.untilNextRetry())
+		defer timer.Stop()
 		for {
 			select {
-			case <-s.ticker.C:
+			case <-timer.C:
 				s.checkAndRetry(ctx)
+			case <-s.wake:
+				// a probe succeeded: drain what is left right away. When
+				// the circuit opened instead, only the timer is reset.
+				if s.Status().State == CircuitClosed {
+					s.retryPendingData(ctx)
+				}
+			case <-s.retry:
+				// a half-open circuit waits for the probe it sent
+				if s.Status().State != CircuitHalfOpen {
+					s.checkAndRetry(ctx)
+				}
 			case <-s.stopChan:
 				return
 			case <-ctx.Done():
 				return
 			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(s.untilNextRetry())
 		}
 	}()
 
@@ -85,64 +162,231 @@ func (s *CircuitBreakerService) Start(ctx context.Context) error {
 
 // Stop stops the circuit breaker service
 func (s *CircuitBreakerService) Stop() {
-	if s.ticker != nil {
-		s.ticker.Stop()
-	}
 	close(s.stopChan)
 	s.wg.Wait()
 	slog.Info("Circuit breaker service stopped")
 }
 
-// IsOpen returns true if circuit is open
+// IsOpen returns true if circuit is open. A half-open circuit lets requests
+// through: the first result closes or reopens it.
 func (s *CircuitBreakerService) IsOpen() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.isOpen
+	return s.state == CircuitOpen
+}
+
+// Status returns the current state of the circuit.
+func (s *CircuitBreakerService) Status() CircuitBreakerStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.statusLocked()
+}
+
+func (s *CircuitBreakerService) statusLocked() CircuitBreakerStatus {
+	status := CircuitBreakerStatus{State: s.state, ConsecutiveFailures: s.consecutiveFailures, Attempt: s.attempt}
+	if s.state == CircuitOpen {
+		status.NextRetryAt = s.nextRetryAt
+	}
+	return status
+}
+
+// notifyLocked reports a state change to config.OnStateChange.
+func (s *CircuitBreakerService) notifyLocked() {
+	if s.config.OnStateChange != nil {
+		s.config.OnStateChange(s.statusLocked())
+	}
 }
 
-// RecordSuccess resets failure counter and closes circuit
+// RecordSuccess resets failure counter and closes circuit. The payloads saved
+// while it was open are drained.
 func (s *CircuitBreakerService) RecordSuccess() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.state == CircuitClosed && s.consecutiveFailures == 0 {
+		return
+	}
+	wasClosed := s.state == CircuitClosed
 	s.consecutiveFailures = 0
-	s.isOpen = false
+	s.state = CircuitClosed
+	s.attempt = 0
+	s.nextRetryAt = time.Time{}
+	s.saveStateLocked()
+	if !wasClosed {
+		slog.Info("Circuit breaker closed")
+		s.notifyLocked()
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
 }
 
-// RecordFailure increments failure counter, opens circuit at threshold
+// RecordFailure increments failure counter, opens circuit at threshold. A
+// failure while half-open reopens it with twice the backoff.
 func (s *CircuitBreakerService) RecordFailure() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.consecutiveFailures++
-	if s.consecutiveFailures >= s.config.MaxConsecutiveFailures {
-		if !s.isOpen {
-			slog.Error("Circuit breaker opened due to consecutive failures - server may be experiencing issues",
-				slog.Int("failures", s.consecutiveFailures))
+	switch {
+	case s.state == CircuitHalfOpen:
+		s.openLocked()
+		slog.Warn("Circuit breaker probe failed, reopened",
+			slog.Int("attempt", s.attempt), slog.Time("nextRetryAt", s.nextRetryAt))
+	case s.state == CircuitClosed && s.consecutiveFailures >= s.config.MaxConsecutiveFailures:
+		s.openLocked()
+		slog.Error("Circuit breaker opened due to consecutive failures - server may be experiencing issues",
+			slog.Int("failures", s.consecutiveFailures), slog.Time("nextRetryAt", s.nextRetryAt))
+	}
+	s.saveStateLocked()
+}
+
+// RetryNow retries the saved payloads right away instead of when the timer
+// fires. An open circuit skips the rest of its backoff and goes half-open, so
+// only one payload probes the endpoint; a half-open one waits for its probe.
+func (s *CircuitBreakerService) RetryNow() {
+	s.mu.Lock()
+	if s.state == CircuitOpen {
+		s.nextRetryAt = time.Now()
+	}
+	s.mu.Unlock()
+	select {
+	case s.retry <- struct{}{}:
+	default:
+	}
+}
+
+func (s *CircuitBreakerService) openLocked() {
+	s.attempt++
+	s.state = CircuitOpen
+	s.nextRetryAt = time.Now().Add(s.backoff(s.attempt))
+	s.notifyLocked()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// backoff returns how long the circuit stays open the attempt-th time:
+// BaseBackoff doubled per attempt up to ResetInterval, of which the second
+// half is jittered so endpoints that failed together don't retry together.
+func (s *CircuitBreakerService) backoff(attempt int) time.Duration {
+	d := s.config.ResetInterval
+	if attempt < 32 {
+		if exp := s.config.BaseBackoff << (attempt - 1); exp > 0 && exp < d {
+			d = exp
 		}
-		s.isOpen = true
 	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// untilNextRetry returns when the retry timer should fire next.
+func (s *CircuitBreakerService) untilNextRetry() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch s.state {
+	case CircuitOpen:
+		return max(time.Until(s.nextRetryAt), 0)
+	case CircuitHalfOpen:
+		return s.backoff(max(s.attempt, 1))
+	}
+	return s.config.ResetInterval
 }
 
 // SaveForRetry saves payload to file for later retry
 func (s *CircuitBreakerService) SaveForRetry(ctx context.Context, payload []byte) error {
-	filePath := os.ExpandEnv(fmt.Sprintf("%s/%s", "$HOME", SYNC_PENDING_FILE))
+	if err := AppendPendingLine(s.pendingFilePath(), payload); err != nil {
+		return err
+	}
+
+	slog.Info("Saved data for later retry")
+	return nil
+}
+
+func (s *CircuitBreakerService) pendingFilePath() string {
+	pendingFile := s.config.PendingFile
+	if pendingFile == "" {
+		pendingFile = SYNC_PENDING_FILE
+	}
+	return os.ExpandEnv(fmt.Sprintf("%s/%s", "$HOME", pendingFile))
+}
+
+func (s *CircuitBreakerService) stateFilePath() string {
+	if s.config.StateFile == "" {
+		return ""
+	}
+	return os.ExpandEnv(fmt.Sprintf("%s/%s", "$HOME", s.config.StateFile))
+}
 
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// loadState restores the state saved by an earlier run. A circuit that was
+// half-open is open again, its probe lost with the process.
+func (s *CircuitBreakerService) loadState() {
+	path := s.stateFilePath()
+	if path == "" {
+		return
+	}
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read circuit breaker state", slog.Any("err", err))
+		}
+		return
 	}
-	defer file.Close()
+	var status CircuitBreakerStatus
+	if err := json.Unmarshal(content, &status); err != nil {
+		slog.Warn("Ignoring invalid circuit breaker state", slog.String("path", path), slog.Any("err", err))
+		return
+	}
+	s.consecutiveFailures = status.ConsecutiveFailures
+	s.attempt = status.Attempt
+	switch status.State {
+	case CircuitOpen, CircuitHalfOpen:
+		s.state = CircuitOpen
+		s.nextRetryAt = status.NextRetryAt
+	}
+}
 
-	_, err = file.Write(payload)
+// saveStateLocked writes the state to the state file, replacing it through a
+// rename so a crash never leaves it half written.
+func (s *CircuitBreakerService) saveStateLocked() {
+	path := s.stateFilePath()
+	if path == "" {
+		return
+	}
+	content, err := json.Marshal(CircuitBreakerStatus{
+		State:               s.state,
+		ConsecutiveFailures: s.consecutiveFailures,
+		Attempt:             s.attempt,
+		NextRetryAt:         s.nextRetryAt,
+	})
+	if err == nil {
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, content, 0644); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
 	if err != nil {
-		return err
+		slog.Warn("Failed to save circuit breaker state", slog.Any("err", err))
 	}
-	_, err = file.WriteString("\n")
+}
+
+// PendingCount returns how many payloads are saved for retry.
+func (s *CircuitBreakerService) PendingCount() int {
+	file, err := os.Open(s.pendingFilePath())
 	if err != nil {
-		return err
+		return 0
 	}
+	defer file.Close()
 
-	slog.Info("Saved data for later retry")
-	return nil
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxPendingLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			count++
+		}
+	}
+	return count
 }
 
 // GetConsecutiveFailures returns the current failure count (for testing)
@@ -152,20 +396,40 @@ func (s *CircuitBreakerService) GetConsecutiveFailures() int {
 	return s.consecutiveFailures
 }
 
+// checkAndRetry turns an open circuit whose backoff expired half-open and
+// sends a single saved payload as the probe; the rest waits for a success.
+// A closed circuit retries all saved payloads.
 func (s *CircuitBreakerService) checkAndRetry(ctx context.Context) {
 	s.mu.Lock()
-	if s.isOpen {
-		slog.Info("Circuit breaker reset by timer, attempting to retry saved data")
-		s.isOpen = false
-		s.consecutiveFailures = 0
+	limit := 0
+	switch s.state {
+	case CircuitOpen:
+		if time.Now().Before(s.nextRetryAt) {
+			s.mu.Unlock()
+			return
+		}
+		slog.Info("Circuit breaker half-open, probing with one saved payload", slog.Int("attempt", s.attempt))
+		s.state = CircuitHalfOpen
+		s.saveStateLocked()
+		s.notifyLocked()
+		limit = 1
+	case CircuitHalfOpen:
+		limit = 1
 	}
 	s.mu.Unlock()
 
-	s.retryPendingData(ctx)
+	s.retryPending(ctx, limit)
 }
 
+// retryPendingData republishes all saved payloads.
 func (s *CircuitBreakerService) retryPendingData(ctx context.Context) {
-	filePath := os.ExpandEnv(fmt.Sprintf("%s/%s", "$HOME", SYNC_PENDING_FILE))
+	s.retryPending(ctx, 0)
+}
+
+// retryPending republishes up to limit saved payloads, oldest first, or all
+// of them when limit is 0.
+func (s *CircuitBreakerService) retryPending(ctx context.Context, limit int) {
+	filePath := s.pendingFilePath()
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		slog.Debug("No pending sync file found, nothing to retry")
@@ -180,6 +444,7 @@ func (s *CircuitBreakerService) retryPendingData(ctx context.Context) {
 
 	var lines []string
 	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxPendingLineSize)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line != "" {
@@ -200,65 +465,36 @@ func (s *CircuitBreakerService) retryPendingData(ctx context.Context) {
 
 	slog.Info("Starting sync data retry", slog.Int("pendingCount", len(lines)))
 
-	var failedLines []string
-	successCount := 0
-
-	for _, line := range lines {
+	var republished []string
+	for i, line := range lines {
+		if limit > 0 && i >= limit {
+			break
+		}
 		if s.republishFn == nil {
 			slog.Error("No republish function configured")
-			failedLines = append(failedLines, line)
-			continue
+			break
 		}
 
-		if err := s.republishFn([]byte(line)); err != nil {
+		payload, err := OpenPendingLine([]byte(line))
+		if err != nil {
+			slog.Warn("Failed to decrypt saved sync data, keeping for next retry", slog.Any("err", err))
+			continue
+		}
+		if err := s.republishFn(payload); err != nil {
 			slog.Warn("Failed to republish sync data, keeping for next retry", slog.Any("err", err))
-			failedLines = append(failedLines, line)
-		} else {
-			successCount++
+			continue
 		}
+		republished = append(republished, line)
 	}
 
-	if err := s.rewriteLogFile(filePath, failedLines); err != nil {
+	// Only the republished lines are removed: the payloads saved meanwhile,
+	// such as a failed probe, are kept, and those dropped stay dropped.
+	if err := RemovePendingLines(filePath, republished); err != nil {
 		slog.Error("Failed to update pending sync file", slog.Any("err", err))
 		return
 	}
 
 	slog.Info("Sync data retry completed",
-		slog.Int("republished", successCount),
-		slog.Int("remaining", len(failedLines)))
-}
-
-func (s *CircuitBreakerService) rewriteLogFile(logFilePath string, lines []string) error {
-	if len(lines) == 0 {
-		if err := os.Remove(logFilePath); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove empty log file: %w", err)
-		}
-		return nil
-	}
-
-	tempFile := logFilePath + ".tmp"
-	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-
-	for _, line := range lines {
-		if _, err := file.WriteString(line + "\n"); err != nil {
-			file.Close()
-			os.Remove(tempFile)
-			return fmt.Errorf("failed to write to temp file: %w", err)
-		}
-	}
-
-	if err := file.Close(); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("failed to close temp file: %w", err)
-	}
-
-	if err := os.Rename(tempFile, logFilePath); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("failed to rename temp file: %w", err)
-	}
-
-	return nil
+		slog.Int("republished", len(republished)),
+		slog.Int("remaining", len(lines)-len(republished)))
 }
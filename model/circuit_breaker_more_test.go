@@ -49,9 +49,10 @@ func TestCB_StateTransitions(t *testing.T) {
 	assert.Equal(t, 0, cb.GetConsecutiveFailures())
 }
 
-// TestCB_StartTimerResetsAndRetries uses a very short reset interval so the
-// background timer fires, closes the open circuit, and runs retryPendingData.
-// Uses assert.Eventually (no raw sleeps) to observe the half-open->closed reset.
+// TestCB_StartTimerResetsAndRetries uses a very short reset interval, which
+// caps the backoff, so the background timer fires, half-opens the circuit and
+// replays the pending line as the probe. Uses assert.Eventually (no raw
+// sleeps) to observe the open->half-open transition.
 func TestCB_StartTimerResetsAndRetries(t *testing.T) {
 	t.Setenv("HOME", t.TempDir())
 
@@ -74,7 +75,7 @@ func TestCB_StartTimerResetsAndRetries(t *testing.T) {
 	require.NoError(t, cb.Start(context.Background()))
 	defer cb.Stop()
 
-	// Timer should reset the breaker to closed and replay the pending line.
+	// Timer should half-open the breaker and replay the pending line.
 	assert.Eventually(t, func() bool {
 		return !cb.IsOpen() && atomic.LoadInt32(&republished) >= 1
 	}, 2*time.Second, 5*time.Millisecond, "timer should reset circuit and retry")
@@ -128,40 +129,59 @@ func TestCB_RetryPendingData_EmptyFile(t *testing.T) {
 	assert.NotPanics(t, func() { cb.retryPendingData(context.Background()) })
 }
 
-// TestCB_RewriteLogFile_RemovesEmpty exercises rewriteLogFile directly for the
-// empty-lines branch (removes the file) and the missing-file sub-case.
-func TestCB_RewriteLogFile_RemovesEmpty(t *testing.T) {
-	t.Setenv("HOME", t.TempDir())
-	cb := NewCircuitBreakerService(CircuitBreakerConfig{}, nil)
-
+// TestRemovePendingLines_RemovesEmpty covers the removal of every line
+// (removes the file) and the missing-file sub-case.
+func TestRemovePendingLines_RemovesEmpty(t *testing.T) {
 	path := filepath.Join(t.TempDir(), "log.jsonl")
 	require.NoError(t, os.WriteFile(path, []byte("a\n"), 0o644))
 
-	// Empty slice -> file removed.
-	require.NoError(t, cb.rewriteLogFile(path, nil))
+	// Every line removed -> file removed.
+	require.NoError(t, RemovePendingLines(path, []string{"a"}))
 	_, err := os.Stat(path)
 	assert.True(t, os.IsNotExist(err))
 
-	// Removing an already-missing file is not an error.
-	require.NoError(t, cb.rewriteLogFile(path, nil))
+	// A missing file is not an error.
+	require.NoError(t, RemovePendingLines(path, []string{"a"}))
 }
 
-// TestCB_RewriteLogFile_WritesLines covers the non-empty branch: a temp file is
-// written and atomically renamed into place.
-func TestCB_RewriteLogFile_WritesLines(t *testing.T) {
-	cb := NewCircuitBreakerService(CircuitBreakerConfig{}, nil)
+// TestRemovePendingLines_KeepsOtherLines covers the non-empty branch: each
+// given line is removed once, and the rest are written to a temp file that is
+// atomically renamed into place.
+func TestRemovePendingLines_KeepsOtherLines(t *testing.T) {
 	path := filepath.Join(t.TempDir(), "log.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("line1\nline2\nline1\nline3\n"), 0o600))
 
-	require.NoError(t, cb.rewriteLogFile(path, []string{"line1", "line2"}))
+	require.NoError(t, RemovePendingLines(path, []string{"line1", "line3"}))
 
 	data, err := os.ReadFile(path)
 	require.NoError(t, err)
-	assert.Equal(t, "line1\nline2\n", string(data))
+	assert.Equal(t, "line2\nline1\n", string(data))
 	// The temp file must not linger.
 	_, err = os.Stat(path + ".tmp")
 	assert.True(t, os.IsNotExist(err))
 }
 
+// TestCB_RetryPendingData_KeepsLinesSavedMeanwhile verifies that a payload
+// saved while the retry republishes, like a failed half-open probe, survives
+// the rewrite, while the republished one is removed.
+func TestCB_RetryPendingData_KeepsLinesSavedMeanwhile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var cb *CircuitBreakerService
+	cb = NewCircuitBreakerService(CircuitBreakerConfig{}, func(payload []byte) error {
+		return cb.SaveForRetry(context.Background(), []byte(`{"probe":1}`))
+	})
+
+	pending := m2pendingPath(t)
+	require.NoError(t, cb.SaveForRetry(context.Background(), []byte(`{"x":1}`)))
+
+	cb.retryPendingData(context.Background())
+
+	data, err := os.ReadFile(pending)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `{"x":1}`)
+	assert.Contains(t, string(data), `{"probe":1}`)
+}
+
 // TestCB_SaveForRetry_AppendsAndRetries covers SaveForRetry happy path plus a
 // retry that drops a failing line and keeps it for the next pass.
 func TestCB_SaveForRetry_PartialFailureRetained(t *testing.T) {
@@ -315,7 +315,7 @@ func TestCircuitBreakerService_ThreadSafety(t *testing.T) {
 	assert.False(t, svc.IsOpen())
 }
 
-func TestCircuitBreakerService_CheckAndRetry_ResetsCircuit(t *testing.T) {
+func TestCircuitBreakerService_CheckAndRetry_HalfOpensAfterBackoff(t *testing.T) {
 	tempDir := t.TempDir()
 
 	originalFile := SYNC_PENDING_FILE
@@ -326,10 +326,15 @@ func TestCircuitBreakerService_CheckAndRetry_ResetsCircuit(t *testing.T) {
 	os.Setenv("HOME", "")
 	defer os.Setenv("HOME", originalHome)
 
+	require.NoError(t, os.WriteFile(SYNC_PENDING_FILE, []byte("{\"id\":1}\n{\"id\":2}\n"), 0644))
+	var republished []string
 	config := CircuitBreakerConfig{
 		MaxConsecutiveFailures: 3,
 	}
-	svc := NewCircuitBreakerService(config, nil)
+	svc := NewCircuitBreakerService(config, func(data []byte) error {
+		republished = append(republished, string(data))
+		return nil
+	})
 
 	// Open the circuit
 	svc.RecordFailure()
@@ -337,10 +342,146 @@ func TestCircuitBreakerService_CheckAndRetry_ResetsCircuit(t *testing.T) {
 	svc.RecordFailure()
 	assert.True(t, svc.IsOpen())
 
-	// checkAndRetry should reset the circuit
+	// nothing happens before the backoff expires
 	ctx := context.Background()
 	svc.checkAndRetry(ctx)
+	assert.True(t, svc.IsOpen())
+	assert.Empty(t, republished)
 
+	// then a single payload probes the server
+	svc.nextRetryAt = time.Now()
+	svc.checkAndRetry(ctx)
 	assert.False(t, svc.IsOpen())
+	assert.Equal(t, CircuitHalfOpen, svc.Status().State)
+	assert.Equal(t, []string{`{"id":1}`}, republished)
+
+	// its success closes the circuit
+	svc.RecordSuccess()
+	assert.Equal(t, CircuitClosed, svc.Status().State)
 	assert.Equal(t, 0, svc.GetConsecutiveFailures())
+	svc.retryPendingData(ctx)
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`}, republished)
+}
+
+func TestCircuitBreakerService_OnStateChange(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var states []CircuitState
+	svc := NewCircuitBreakerService(CircuitBreakerConfig{
+		MaxConsecutiveFailures: 2,
+		PendingFile:            "pending.jsonl",
+		OnStateChange: func(status CircuitBreakerStatus) {
+			states = append(states, status.State)
+		},
+	}, func(data []byte) error { return nil })
+
+	svc.RecordFailure()
+	assert.Empty(t, states, "a failure below the threshold changes nothing")
+	svc.RecordFailure()
+	svc.nextRetryAt = time.Now()
+	svc.checkAndRetry(context.Background())
+	svc.RecordSuccess()
+	svc.RecordSuccess()
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}, states)
+}
+
+func TestCircuitBreakerService_Backoff(t *testing.T) {
+	svc := NewCircuitBreakerService(CircuitBreakerConfig{
+		MaxConsecutiveFailures: 1,
+		BaseBackoff:            time.Minute,
+		ResetInterval:          10 * time.Minute,
+	}, nil)
+
+	for attempt, base := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 4: 8 * time.Minute, 5: 10 * time.Minute, 40: 10 * time.Minute} {
+		for i := 0; i < 20; i++ {
+			d := svc.backoff(attempt)
+			assert.GreaterOrEqual(t, d, base/2, "attempt %d", attempt)
+			assert.LessOrEqual(t, d, base, "attempt %d", attempt)
+		}
+	}
+
+	// a failed probe reopens the circuit with a longer backoff
+	svc.RecordFailure()
+	first := svc.Status()
+	assert.Equal(t, CircuitOpen, first.State)
+	assert.Equal(t, 1, first.Attempt)
+	assert.WithinDuration(t, time.Now().Add(45*time.Second), first.NextRetryAt, 16*time.Second)
+
+	svc.nextRetryAt = time.Now()
+	svc.checkAndRetry(context.Background())
+	svc.RecordFailure()
+	second := svc.Status()
+	assert.Equal(t, CircuitOpen, second.State)
+	assert.Equal(t, 2, second.Attempt)
+	assert.WithinDuration(t, time.Now().Add(90*time.Second), second.NextRetryAt, 31*time.Second)
+}
+
+func TestCircuitBreakerService_PersistsState(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	require.NoError(t, os.MkdirAll(filepath.Join(os.Getenv("HOME"), COMMAND_BASE_STORAGE_FOLDER), 0755))
+	config := CircuitBreakerConfig{
+		MaxConsecutiveFailures: 2,
+		StateFile:              COMMAND_BASE_STORAGE_FOLDER + "/breaker.state.json",
+	}
+
+	svc := NewCircuitBreakerService(config, nil)
+	svc.RecordFailure()
+	svc.RecordFailure()
+	require.True(t, svc.IsOpen())
+	opened := svc.Status()
+
+	// a restarted daemon keeps the circuit open until the same time
+	restarted := NewCircuitBreakerService(config, nil)
+	assert.True(t, restarted.IsOpen())
+	assert.Equal(t, 2, restarted.GetConsecutiveFailures())
+	assert.True(t, opened.NextRetryAt.Equal(restarted.Status().NextRetryAt))
+
+	// a half-open circuit lost its probe with the process
+	restarted.nextRetryAt = time.Now()
+	restarted.checkAndRetry(context.Background())
+	require.Equal(t, CircuitHalfOpen, restarted.Status().State)
+	assert.True(t, NewCircuitBreakerService(config, nil).IsOpen())
+
+	restarted.RecordSuccess()
+	assert.Equal(t, CircuitClosed, NewCircuitBreakerService(config, nil).Status().State)
+
+	// without a state file nothing is kept
+	assert.False(t, NewCircuitBreakerService(CircuitBreakerConfig{MaxConsecutiveFailures: 2}, nil).IsOpen())
+}
+
+func TestCircuitBreakerService_RetryNow(t *testing.T) {
+	tempDir := t.TempDir()
+
+	originalFile := SYNC_PENDING_FILE
+	SYNC_PENDING_FILE = filepath.Join(tempDir, "test-pending.jsonl")
+	defer func() { SYNC_PENDING_FILE = originalFile }()
+
+	originalHome := os.Getenv("HOME")
+	os.Setenv("HOME", "")
+	defer os.Setenv("HOME", originalHome)
+
+	require.NoError(t, os.WriteFile(SYNC_PENDING_FILE, []byte("{\"id\":1}\n{\"id\":2}\n"), 0644))
+	republished := make(chan string, 2)
+	svc := NewCircuitBreakerService(CircuitBreakerConfig{MaxConsecutiveFailures: 1}, func(data []byte) error {
+		republished <- string(data)
+		return nil
+	})
+	svc.RecordFailure()
+	require.True(t, svc.IsOpen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, svc.Start(ctx))
+	defer svc.Stop()
+
+	// the backoff is skipped, but an open circuit still probes with one payload
+	svc.RetryNow()
+	select {
+	case data := <-republished:
+		assert.Equal(t, `{"id":1}`, data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RetryNow didn't replay the pending payloads")
+	}
+	assert.Eventually(t, func() bool { return svc.Status().State == CircuitHalfOpen }, time.Second, 10*time.Millisecond)
+	assert.Len(t, republished, 0, "the second payload waits for the probe")
 }
@@ -2,6 +2,8 @@ package model
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -37,6 +39,18 @@ type Command struct {
 	Result    int          `json:"result"`
 	Phase     CommandPhase `json:"phase"`
 	PPID      int          `json:"ppid,omitempty"`
+	Cwd       string       `json:"cwd,omitempty"`
+
+	// PipeStatus holds the exit code of every pipeline stage, as reported by
+	// the shell ($pipestatus / $PIPESTATUS). Result is the last stage.
+	PipeStatus []int `json:"pipestatus,omitempty"`
+	// Duration is the command duration measured by the shell itself (fish's
+	// $CMD_DURATION). When set it is more precise than pairing pre/post times.
+	Duration time.Duration `json:"dur,omitempty"`
+
+	// ID identifies the command run across retries, see CommandID. Records
+	// written before IDs existed have none; RecordID derives it for them.
+	ID string `json:"id,omitempty"`
 
 	// Only work in file
 	RecordingTime time.Time `json:"-"`
@@ -64,7 +78,7 @@ func (c Command) DoSavePre() error {
 	}
 
 	preFile := GetPreCommandFilePath()
-	f, err := os.OpenFile(preFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	f, err := os.OpenFile(preFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		slog.Error("failed to open pre-command storage file", slog.Any("err", err))
 		return fmt.Errorf("failed to open pre-command storage file: %v", err)
@@ -92,7 +106,7 @@ func (c Command) DoUpdate(result int) error {
 	}
 
 	postFile := GetPostCommandFilePath()
-	f, err := os.OpenFile(postFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	f, err := os.OpenFile(postFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to open post-command storage file: %v", err)
 	}
@@ -104,6 +118,10 @@ func (c Command) DoUpdate(result int) error {
 	return nil
 }
 
+// preCommandPairWindow is how long a pre command can wait for its post
+// command. One command is not possible to run for 10 days, right?
+const preCommandPairWindow = 10 * 24 * time.Hour
+
 func (c Command) IsPairPreCommand(target Command) bool {
 	if !c.IsSame(target) {
 		return false
@@ -111,8 +129,7 @@ func (c Command) IsPairPreCommand(target Command) bool {
 	if c.Phase != CommandPhasePre {
 		return false
 	}
-	// one command not possible to run for 10 days, right?
-	if c.Time.Before(time.Now().Add(-time.Hour * 24 * 10)) {
+	if c.Time.Before(time.Now().Add(-preCommandPairWindow)) {
 		return false
 	}
 	return true
@@ -150,6 +167,30 @@ func (c Command) IsNil() bool {
 	return true
 }
 
+// CommandID returns the stable ID of command, started at start in the given
+// session. Every retry and replay of a tracked command carries the same ID,
+// so duplicates can be dropped. The command is masked before hashing so the
+// ID never fingerprints a secret.
+func CommandID(sessionID int64, start time.Time, command string) string {
+	commandHash := sha256.Sum256([]byte(MaskSensitiveTokens(command)))
+	id := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%x", sessionID, start.UnixNano(), commandHash)))
+	return hex.EncodeToString(id[:16])
+}
+
+// RecordID returns the command's ID, deriving it when the record has none. A
+// pre command starts at Time; a post command only knows its start when the
+// shell measured the duration.
+func (cmd Command) RecordID() string {
+	if cmd.ID != "" {
+		return cmd.ID
+	}
+	start := cmd.Time
+	if cmd.Phase == CommandPhasePost {
+		start = start.Add(-cmd.Duration)
+	}
+	return CommandID(cmd.SessionID, start, cmd.Command)
+}
+
 func (cmd Command) GetUniqueKey() string {
 	return fmt.Sprintf("%s|%d|%s|%s", cmd.Shell, cmd.SessionID, cmd.Command, cmd.Username)
 }
@@ -163,11 +204,21 @@ func (cmd Command) ToLine(recordingTime time.Time) (buf []byte, err error) {
 	timestampBytes := []byte(fmt.Sprintf("%d", timestamp))
 	buf = append(buf, SEPARATOR)
 	buf = append(buf, timestampBytes...)
+	buf, err = sealStorageRecord(buf)
+	if err != nil {
+		return
+	}
 	buf = append(buf, '\n')
 	return
 }
 
 func (cmd *Command) FromLine(line string) (recordingTime time.Time, err error) {
+	plain, err := openStorageRecord([]byte(line))
+	if err != nil {
+		slog.Error("failed to decrypt command", slog.Any("err", err))
+		return
+	}
+	line = string(plain)
 	parts := strings.Split(line, string(SEPARATOR))
 	if len(parts) != 2 {
 		err = fmt.Errorf("Invalid line format in pre-command file: %s", line)
@@ -194,6 +245,11 @@ func (cmd *Command) FromLine(line string) (recordingTime time.Time, err error) {
 }
 
 func (cmd *Command) FromLineBytes(line []byte) (recordingTime time.Time, err error) {
+	line, err = openStorageRecord(line)
+	if err != nil {
+		slog.Error("failed to decrypt command", slog.Any("err", err))
+		return
+	}
 	parts := bytes.Split(line, []byte{SEPARATOR})
 	if len(parts) != 2 {
 		err = fmt.Errorf("Invalid line format in FromLineBytes: %s", string(line))
@@ -2,7 +2,6 @@ package model
 
 import (
 	"slices"
-	"strings"
 )
 
 // CommandActionType represents the type of action a command performs
@@ -15,36 +14,46 @@ const (
 	ActionOther  CommandActionType = "other"
 )
 
-// ClassifyCommand analyzes a command and determines its action type
+// ClassifyCommand analyzes a command and determines its action type. Every
+// stage of a pipeline or command list is classified and the most destructive
+// action wins, so `ls && rm -rf build` is a delete.
 func ClassifyCommand(command string) CommandActionType {
-	// Normalize the command
-	cmd := strings.TrimSpace(command)
-	if cmd == "" {
-		return ActionOther
+	result := ActionOther
+	for _, stage := range ParseCommandLine(command).Stages {
+		if action := classifyStage(stage); actionRank(action) > actionRank(result) {
+			result = action
+		}
 	}
+	return result
+}
 
-	// Split the command to analyze
-	parts := strings.Fields(cmd)
-	if len(parts) == 0 {
-		return ActionOther
+func actionRank(action CommandActionType) int {
+	switch action {
+	case ActionDelete:
+		return 3
+	case ActionEdit:
+		return 2
+	case ActionView:
+		return 1
+	default:
+		return 0
 	}
+}
 
-	mainCmd := parts[0]
-	
-	// Check for shell redirections and pipes
-	hasOutputRedirection := false
-	hasAppendRedirection := false
-	for i, part := range parts {
-		if part == ">" && i > 0 {
-			hasOutputRedirection = true
-		} else if part == ">>" && i > 0 {
-			hasAppendRedirection = true
-		}
+func classifyStage(stage CommandStage) CommandActionType {
+	mainCmd := stage.Program
+	hasOutputRedirection := stage.RedirectsOutput
+
+	// programs outside the subcommand list still dispatch on their first
+	// argument below (pip3 uninstall...)
+	sub := stage.Subcommand
+	if sub == "" && len(stage.Args) > 0 {
+		sub = stage.Args[0]
 	}
 
 	// Special case: echo with redirection
 	if mainCmd == "echo" {
-		if hasOutputRedirection || hasAppendRedirection {
+		if hasOutputRedirection {
 			return ActionEdit
 		}
 		return ActionView
@@ -65,8 +74,8 @@ func ClassifyCommand(command string) CommandActionType {
 		"systemctl", "service", "journalctl", "dmesg",
 		"git", "docker", "kubectl":
 		// Special handling for some commands that might have subcommands
-		if mainCmd == "git" && len(parts) > 1 {
-			switch parts[1] {
+		if mainCmd == "git" && sub != "" {
+			switch sub {
 			case "rm", "clean":
 				return ActionDelete
 			case "add", "commit", "push", "pull", "merge", "rebase":
@@ -75,8 +84,8 @@ func ClassifyCommand(command string) CommandActionType {
 				return ActionView
 			}
 		}
-		if mainCmd == "docker" && len(parts) > 1 {
-			switch parts[1] {
+		if mainCmd == "docker" && sub != "" {
+			switch sub {
 			case "rm", "rmi", "prune":
 				return ActionDelete
 			case "build", "run", "create", "start", "stop", "restart":
@@ -85,8 +94,8 @@ func ClassifyCommand(command string) CommandActionType {
 				return ActionView
 			}
 		}
-		if mainCmd == "systemctl" && len(parts) > 1 {
-			switch parts[1] {
+		if mainCmd == "systemctl" && sub != "" {
+			switch sub {
 			case "start", "stop", "restart", "enable", "disable":
 				return ActionEdit
 			default:
@@ -105,8 +114,8 @@ func ClassifyCommand(command string) CommandActionType {
 		"npm", "yarn", "pip", "gem", "cargo", "go", "make", "cmake",
 		"gcc", "g++", "clang", "python", "ruby", "node", "java", "javac":
 		// Check if it's a package manager installing/removing
-		if isPackageManager(mainCmd) && len(parts) > 1 {
-			switch parts[1] {
+		if isPackageManager(mainCmd) && sub != "" {
+			switch sub {
 			case "remove", "uninstall", "purge", "autoremove":
 				return ActionDelete
 			default:
@@ -121,17 +130,11 @@ func ClassifyCommand(command string) CommandActionType {
 		return ActionDelete
 
 	default:
-		// Check for command with path that might be an editor
-		if strings.Contains(mainCmd, "/") {
-			baseName := mainCmd[strings.LastIndex(mainCmd, "/")+1:]
-			return ClassifyCommand(baseName + " " + strings.Join(parts[1:], " "))
-		}
-		
 		// If we have output redirection with unknown command, consider it edit
 		if hasOutputRedirection {
 			return ActionEdit
 		}
-		
+
 		return ActionOther
 	}
 }
@@ -142,4 +145,4 @@ func isPackageManager(cmd string) bool {
 		"npm", "yarn", "pip", "pip3", "gem", "cargo",
 	}
 	return slices.Contains(packageManagers, cmd)
-}
\ No newline at end of file
+}
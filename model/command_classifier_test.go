@@ -97,4 +97,27 @@ func TestIsPackageManager(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestClassifyCommandStages(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected CommandActionType
+	}{
+		{"sudo rm -rf /tmp/x", ActionDelete},
+		{"ls && rm -rf build", ActionDelete},
+		{"env FOO=1 git -C repo commit -m x", ActionEdit},
+		{"cat file | grep x", ActionView},
+		{"find . -name '*.o' | xargs rm", ActionDelete},
+		{"time make", ActionEdit},
+		{"echo 'rm -rf /'", ActionView},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := ClassifyCommand(tt.command); got != tt.expected {
+				t.Errorf("ClassifyCommand(%q) = %v, want %v", tt.command, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package model
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// CommandStage is one simple command of a command line: a single stage of a
+// pipeline or one side of a `&&`, `||` or `;` list.
+type CommandStage struct {
+	// Program is the base name of the executed program, after stripping
+	// environment assignments and wrappers such as sudo or time.
+	Program string
+	// Subcommand is the first positional argument for programs that are
+	// driven by subcommands (git commit, docker run, kubectl delete...).
+	Subcommand string
+	Args       []string
+	// Assignments holds the leading NAME=value words.
+	Assignments []string
+	// Wrappers lists the wrapper programs that were stripped, outermost first.
+	Wrappers []string
+	// RedirectsOutput is set when stdout is redirected to a file (> or >>).
+	RedirectsOutput bool
+}
+
+// Main returns the program followed by its subcommand, e.g. "git commit".
+func (s CommandStage) Main() string {
+	if s.Subcommand == "" {
+		return s.Program
+	}
+	return s.Program + " " + s.Subcommand
+}
+
+// Line returns the normalized stage without assignments and wrappers.
+func (s CommandStage) Line() string {
+	return strings.Join(append([]string{s.Program}, s.Args...), " ")
+}
+
+// ParsedCommand is the result of ParseCommandLine.
+type ParsedCommand struct {
+	Stages []CommandStage
+}
+
+// Main returns the main command of the first stage, or "" for an empty line.
+func (p ParsedCommand) Main() string {
+	if len(p.Stages) == 0 {
+		return ""
+	}
+	return p.Stages[0].Main()
+}
+
+// MainCommand parses command and returns its main command, e.g.
+// "sudo -E git -C repo commit -m x" yields "git commit".
+func MainCommand(command string) string {
+	return ParseCommandLine(command).Main()
+}
+
+// subcommandPrograms are the programs whose first positional argument is a
+// subcommand worth keeping in Main.
+var subcommandPrograms = []string{
+	"git", "docker", "podman", "kubectl", "helm", "terraform", "gh", "go",
+	"cargo", "npm", "pnpm", "yarn", "bun", "pip", "pip3", "gem", "brew",
+	"apt", "apt-get", "yum", "dnf", "pacman", "snap", "systemctl",
+	"journalctl", "aws", "gcloud", "az", "nix", "shelltime",
+}
+
+// globalOptionsWithValue lists, per program, the options that come before
+// the subcommand and consume the following word.
+var globalOptionsWithValue = map[string][]string{
+	"git":     {"-C", "-c", "--git-dir", "--work-tree", "--namespace"},
+	"docker":  {"-H", "--host", "--context", "--config", "-l", "--log-level"},
+	"podman":  {"--connection", "--url"},
+	"kubectl": {"-n", "--namespace", "--context", "--cluster", "--kubeconfig", "-s", "--server", "--user"},
+	"helm":    {"-n", "--namespace", "--kube-context", "--kubeconfig"},
+	"gh":      {"-R", "--repo"},
+	"cargo":   {"-Z", "--config", "--color"},
+	"npm":     {"--prefix", "-w", "--workspace"},
+	"pnpm":    {"-C", "--dir", "--filter", "-F"},
+	"yarn":    {"--cwd"},
+	"aws":     {"--profile", "--region", "--output", "--endpoint-url"},
+}
+
+// wrapperOptionsWithValue lists, per wrapper, the options that consume the
+// following word. A wrapper absent from this map takes no valued options.
+var wrapperOptionsWithValue = map[string][]string{
+	"sudo":    {"-u", "-g", "-C", "-D", "-h", "-p", "-r", "-t", "-U", "--user", "--group", "--chdir", "--host", "--prompt"},
+	"doas":    {"-u", "-C"},
+	"nice":    {"-n", "--adjustment"},
+	"ionice":  {"-c", "-n", "-p"},
+	"xargs":   {"-I", "-n", "-P", "-L", "-d", "-E", "-s", "-a", "--max-args", "--max-procs", "--delimiter", "--arg-file"},
+	"env":     {"-u", "--unset", "-C", "--chdir", "-S", "--split-string"},
+	"stdbuf":  {"-i", "-o", "-e"},
+	"timeout": {"-s", "--signal", "-k", "--kill-after"},
+	"time":    {"-f", "--format", "-o", "--output"},
+	"watch":   {"-n", "--interval", "-d"},
+}
+
+var commandWrappers = []string{
+	"sudo", "doas", "time", "nohup", "nice", "ionice", "xargs", "env",
+	"command", "builtin", "exec", "stdbuf", "timeout", "watch", "caffeinate",
+	"noglob", "nocorrect",
+}
+
+// shellKeywords may precede a command without being the program.
+var shellKeywords = []string{"!", "{", "}", "if", "then", "else", "elif", "fi", "do", "done", "while", "until", "esac"}
+
+type shellTokenKind int
+
+const (
+	tokenWord shellTokenKind = iota
+	tokenSeparator
+	tokenRedirect
+)
+
+type shellToken struct {
+	kind  shellTokenKind
+	value string
+}
+
+// ParseCommandLine splits a shell command line into its simple commands. It
+// understands quoting, escapes, pipelines, `&&`/`||`/`;`/`&` lists, subshells,
+// command substitution, redirections, NAME=value prefixes and common wrapper
+// programs. It never fails: malformed input (an unclosed quote, say) is
+// parsed as far as it goes.
+func ParseCommandLine(line string) ParsedCommand {
+	var result ParsedCommand
+	var words []string
+	redirects := false
+
+	flush := func() {
+		if stage, ok := buildCommandStage(words); ok {
+			stage.RedirectsOutput = redirects
+			result.Stages = append(result.Stages, stage)
+		}
+		words = nil
+		redirects = false
+	}
+
+	tokens := tokenizeShell(line)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.kind {
+		case tokenSeparator:
+			flush()
+		case tokenRedirect:
+			if isOutputRedirect(tok.value) {
+				redirects = true
+			}
+			// the redirection target is not an argument; 2>&1 has none
+			if !strings.Contains(tok.value, ">&") && !strings.Contains(tok.value, "<&") {
+				if i+1 < len(tokens) && tokens[i+1].kind == tokenWord {
+					i++
+				}
+			}
+		default:
+			words = append(words, tok.value)
+		}
+	}
+	flush()
+	return result
+}
+
+func buildCommandStage(words []string) (CommandStage, bool) {
+	var stage CommandStage
+	i := 0
+	for i < len(words) && slices.Contains(shellKeywords, words[i]) {
+		i++
+	}
+	for i < len(words) && isShellAssignment(words[i]) {
+		stage.Assignments = append(stage.Assignments, words[i])
+		i++
+	}
+
+	for i < len(words) {
+		name := path.Base(words[i])
+		if !slices.Contains(commandWrappers, name) {
+			break
+		}
+		stage.Wrappers = append(stage.Wrappers, name)
+		i++
+		valued := wrapperOptionsWithValue[name]
+		positional := 0
+		// timeout takes the duration before the command
+		if name == "timeout" {
+			positional = 1
+		}
+		for i < len(words) {
+			w := words[i]
+			if name == "env" && isShellAssignment(w) {
+				stage.Assignments = append(stage.Assignments, w)
+				i++
+				continue
+			}
+			if w == "--" {
+				i++
+				break
+			}
+			if strings.HasPrefix(w, "-") && len(w) > 1 {
+				i++
+				if slices.Contains(valued, w) && i < len(words) {
+					i++
+				}
+				continue
+			}
+			if positional > 0 {
+				positional--
+				i++
+				continue
+			}
+			break
+		}
+	}
+
+	if i >= len(words) {
+		if len(stage.Wrappers) == 0 {
+			return stage, false
+		}
+		// a bare wrapper (`time`, `env`) is the program itself
+		stage.Program = stage.Wrappers[len(stage.Wrappers)-1]
+		stage.Wrappers = stage.Wrappers[:len(stage.Wrappers)-1]
+		return stage, true
+	}
+
+	stage.Program = words[i]
+	if !strings.HasPrefix(stage.Program, "$") {
+		stage.Program = path.Base(stage.Program)
+	}
+	if i+1 < len(words) {
+		stage.Args = words[i+1:]
+	}
+	stage.Subcommand = findSubcommand(stage.Program, stage.Args)
+	return stage, true
+}
+
+func findSubcommand(program string, args []string) string {
+	if !slices.Contains(subcommandPrograms, program) {
+		return ""
+	}
+	valued := globalOptionsWithValue[program]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return ""
+		}
+		if strings.HasPrefix(arg, "-") {
+			if slices.Contains(valued, arg) {
+				i++
+			}
+			continue
+		}
+		return arg
+	}
+	return ""
+}
+
+// isOutputRedirect reports whether a redirection operator sends stdout to a
+// file: >, >>, >|, 1> and &> do; 2>, < and fd duplications like >&2 don't.
+func isOutputRedirect(op string) bool {
+	op = strings.TrimPrefix(op, "1")
+	return strings.HasPrefix(op, ">") && !strings.HasPrefix(op, ">&") ||
+		strings.HasPrefix(op, "&>")
+}
+
+func isShellAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// tokenizeShell splits a command line into words, list/pipeline separators
+// and redirection operators. Parentheses of subshells act as separators so
+// their contents become stages of their own; `$(...)` and backticks stay
+// inside the word they belong to.
+func tokenizeShell(line string) []shellToken {
+	var tokens []shellToken
+	var word strings.Builder
+	inWord := false
+
+	emitWord := func() {
+		if inWord {
+			tokens = append(tokens, shellToken{kind: tokenWord, value: word.String()})
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	rs := []rune(line)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\\':
+			inWord = true
+			if i+1 < len(rs) {
+				i++
+				if rs[i] != '\n' {
+					word.WriteRune(rs[i])
+				}
+			}
+		case r == '\'':
+			inWord = true
+			j := i + 1
+			for j < len(rs) && rs[j] != '\'' {
+				word.WriteRune(rs[j])
+				j++
+			}
+			i = j
+		case r == '"':
+			inWord = true
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				if rs[j] == '\\' && j+1 < len(rs) && strings.ContainsRune("\"\\$`", rs[j+1]) {
+					j++
+				}
+				word.WriteRune(rs[j])
+				j++
+			}
+			i = j
+		case r == '$' && i+1 < len(rs) && rs[i+1] == '(':
+			inWord = true
+			end := matchingParen(rs, i+1)
+			word.WriteString(string(rs[i:end]))
+			i = end - 1
+		case r == '`':
+			inWord = true
+			j := i + 1
+			for j < len(rs) && rs[j] != '`' {
+				j++
+			}
+			end := min(j+1, len(rs))
+			word.WriteString(string(rs[i:end]))
+			i = end - 1
+		case r == '#' && !inWord:
+			// comment until end of line
+			for i+1 < len(rs) && rs[i+1] != '\n' {
+				i++
+			}
+		case r == ' ' || r == '\t':
+			emitWord()
+		case r == '\n' || r == ';' || r == '(' || r == ')':
+			emitWord()
+			tokens = append(tokens, shellToken{kind: tokenSeparator, value: string(r)})
+		case r == '|' || r == '&':
+			// "&>" and "&>>" redirect both streams
+			if r == '&' && i+1 < len(rs) && rs[i+1] == '>' {
+				emitWord()
+				op := "&>"
+				i++
+				if i+1 < len(rs) && rs[i+1] == '>' {
+					op = "&>>"
+					i++
+				}
+				tokens = append(tokens, shellToken{kind: tokenRedirect, value: op})
+				continue
+			}
+			emitWord()
+			op := string(r)
+			if i+1 < len(rs) && (rs[i+1] == r || (r == '|' && rs[i+1] == '&')) {
+				op += string(rs[i+1])
+				i++
+			}
+			tokens = append(tokens, shellToken{kind: tokenSeparator, value: op})
+		case r == '>' || r == '<':
+			// a numeric word right before the operator is its fd: 2>file
+			prefix := ""
+			if inWord && isAllDigits(word.String()) {
+				prefix = word.String()
+				word.Reset()
+				inWord = false
+			}
+			emitWord()
+			op := prefix + string(r)
+			for i+1 < len(rs) && strings.ContainsRune("><&|", rs[i+1]) {
+				i++
+				op += string(rs[i])
+			}
+			// 2>&1 carries its target in the operator
+			if strings.HasSuffix(op, "&") {
+				for i+1 < len(rs) && (rs[i+1] == '-' || (rs[i+1] >= '0' && rs[i+1] <= '9')) {
+					i++
+					op += string(rs[i])
+				}
+			}
+			tokens = append(tokens, shellToken{kind: tokenRedirect, value: op})
+		default:
+			inWord = true
+			word.WriteRune(r)
+		}
+	}
+	emitWord()
+	return tokens
+}
+
+// matchingParen returns the index just past the parenthesis closing the one
+// at open, or len(rs) when it is never closed.
+func matchingParen(rs []rune, open int) int {
+	depth := 0
+	for i := open; i < len(rs); i++ {
+		switch rs[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(rs)
+}
+
+func isAllDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMainCommand(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"ls -la", "ls"},
+		{"git commit -m 'fix: a | b'", "git commit"},
+		{"git -C ~/src/cli status", "git status"},
+		{"sudo rm -rf /tmp/x", "rm"},
+		{"sudo -u root -E apt-get install vim", "apt-get install"},
+		{"env FOO=1 kubectl delete pod x", "kubectl delete"},
+		{"FOO=1 BAR='a b' make test", "make"},
+		{"time make", "make"},
+		{"nohup nice -n 10 ./build.sh &", "build.sh"},
+		{"timeout 5s curl example.com", "curl"},
+		{"/usr/local/bin/docker --context prod ps", "docker ps"},
+		{"kubectl -n kube-system get pods", "kubectl get"},
+		{"(cd web && npm run build)", "cd"},
+		{"echo \"$(git rev-parse HEAD)\" > sha.txt", "echo"},
+		{"time", "time"},
+		{"FOO=1", ""},
+		{"", ""},
+		{"   ", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			assert.Equal(t, tt.expected, MainCommand(tt.command))
+		})
+	}
+}
+
+func TestParseCommandLineStages(t *testing.T) {
+	parsed := ParseCommandLine(`a | b && sudo c --flag "x;y" || (d; e) & f 2>&1 | xargs -I{} -n 1 g {}`)
+
+	mains := make([]string, 0, len(parsed.Stages))
+	for _, stage := range parsed.Stages {
+		mains = append(mains, stage.Main())
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g"}, mains)
+
+	c := parsed.Stages[2]
+	assert.Equal(t, []string{"sudo"}, c.Wrappers)
+	assert.Equal(t, []string{"--flag", "x;y"}, c.Args)
+
+	g := parsed.Stages[6]
+	assert.Equal(t, []string{"xargs"}, g.Wrappers)
+	assert.Equal(t, []string{"{}"}, g.Args)
+}
+
+func TestParseCommandLineRedirections(t *testing.T) {
+	tests := []struct {
+		command  string
+		redirect bool
+		args     []string
+	}{
+		{"echo hi > out.txt", true, []string{"hi"}},
+		{"echo hi >>out.txt", true, []string{"hi"}},
+		{"cmd &> all.log", true, nil},
+		{"cmd 2> err.log", false, nil},
+		{"cmd 2>&1", false, nil},
+		{"cmd >&2 arg", false, []string{"arg"}},
+		{"sort < in.txt", false, nil},
+		{"cat <<EOF", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			stages := ParseCommandLine(tt.command).Stages
+			if assert.Len(t, stages, 1) {
+				assert.Equal(t, tt.redirect, stages[0].RedirectsOutput)
+				assert.Equal(t, tt.args, stages[0].Args)
+			}
+		})
+	}
+}
+
+func TestParseCommandLineQuoting(t *testing.T) {
+	stages := ParseCommandLine(`grep -e 'a b' "c \"d\"" e\ f # trailing comment`).Stages
+	if assert.Len(t, stages, 1) {
+		assert.Equal(t, []string{"-e", "a b", `c "d"`, "e f"}, stages[0].Args)
+	}
+
+	// unterminated quotes don't panic and keep the rest as one word
+	stages = ParseCommandLine(`echo "unterminated | rm`).Stages
+	if assert.Len(t, stages, 1) {
+		assert.Equal(t, []string{"unterminated | rm"}, stages[0].Args)
+	}
+}
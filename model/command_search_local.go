@@ -0,0 +1,200 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// LocalSearchMode selects how SearchLocalCommands matches the search text.
+type LocalSearchMode int
+
+const (
+	// LocalSearchSubstring matches a case-insensitive substring.
+	LocalSearchSubstring LocalSearchMode = iota
+	// LocalSearchRegex matches a Go regular expression.
+	LocalSearchRegex
+	// LocalSearchFuzzy matches the search text as a case-insensitive
+	// subsequence and ranks the results by match quality.
+	LocalSearchFuzzy
+)
+
+// LocalSearchOptions carries the parts of a local search that
+// SearchCommandsFilter encodes for the server only.
+type LocalSearchOptions struct {
+	Mode  LocalSearchMode
+	Since time.Time
+	Until time.Time
+}
+
+// SearchLocalCommands searches commands stored on this machine (buffered and
+// archived) with the same filter the server search takes, so the results
+// render through the same output path. Results are newest first, or best
+// match first in fuzzy mode. Edge IDs are positions in the full result list,
+// so pagination.LastID works like it does against the server.
+func SearchLocalCommands(commands []ListedCommand, filter *SearchCommandsFilter, pagination *SearchCommandsPagination, opts LocalSearchOptions) (*SearchCommandsResult, error) {
+	var re *regexp.Regexp
+	if opts.Mode == LocalSearchRegex {
+		var err error
+		re, err = regexp.Compile(filter.Command)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", filter.Command, err)
+		}
+	}
+	needle := strings.ToLower(filter.Command)
+
+	type match struct {
+		cmd   ListedCommand
+		main  string
+		score int
+	}
+	matches := make([]match, 0)
+	for _, cmd := range commands {
+		if !matchLocalFilter(cmd, filter, opts) {
+			continue
+		}
+		parsed := ParseCommandLine(cmd.Command)
+		if len(filter.MainCommand) > 0 && !matchMainCommand(parsed, filter.MainCommand) {
+			continue
+		}
+
+		score := 0
+		switch opts.Mode {
+		case LocalSearchRegex:
+			if !re.MatchString(cmd.Command) {
+				continue
+			}
+		case LocalSearchFuzzy:
+			var ok bool
+			if score, ok = FuzzyScore(cmd.Command, filter.Command); !ok {
+				continue
+			}
+		default:
+			if !strings.Contains(strings.ToLower(cmd.Command), needle) {
+				continue
+			}
+		}
+		matches = append(matches, match{cmd: cmd, main: parsed.Main(), score: score})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].cmd.EndTime.After(matches[j].cmd.EndTime)
+	})
+
+	result := &SearchCommandsResult{Count: len(matches), Edges: []SearchCommandEdge{}}
+	start, limit := 0, len(matches)
+	if pagination != nil {
+		start = min(max(pagination.LastID, 0), len(matches))
+		if pagination.Limit > 0 {
+			limit = pagination.Limit
+		}
+	}
+	for i := start; i < len(matches) && len(result.Edges) < limit; i++ {
+		m := matches[i]
+		result.Edges = append(result.Edges, SearchCommandEdge{
+			ID:          i + 1,
+			Shell:       m.cmd.Shell,
+			Command:     m.cmd.Command,
+			MainCommand: m.main,
+			Hostname:    m.cmd.Hostname,
+			Username:    m.cmd.Username,
+			Time:        float64(m.cmd.StartTime.UnixMilli()),
+			EndTime:     float64(m.cmd.EndTime.UnixMilli()),
+			Result:      m.cmd.Result,
+		})
+	}
+	return result, nil
+}
+
+func matchLocalFilter(cmd ListedCommand, filter *SearchCommandsFilter, opts LocalSearchOptions) bool {
+	if len(filter.Shell) > 0 && !containsFold(filter.Shell, cmd.Shell) {
+		return false
+	}
+	if len(filter.Hostname) > 0 && !containsFold(filter.Hostname, cmd.Hostname) {
+		return false
+	}
+	if len(filter.Username) > 0 && !containsFold(filter.Username, cmd.Username) {
+		return false
+	}
+	if len(filter.Result) > 0 {
+		found := false
+		for _, r := range filter.Result {
+			found = found || r == cmd.Result
+		}
+		if !found {
+			return false
+		}
+	}
+	if !opts.Since.IsZero() && cmd.StartTime.Before(opts.Since) {
+		return false
+	}
+	if !opts.Until.IsZero() && cmd.StartTime.After(opts.Until) {
+		return false
+	}
+	return true
+}
+
+// matchMainCommand accepts a command when any of its stages runs one of the
+// wanted programs, given either as the program ("git") or with its
+// subcommand ("git commit").
+func matchMainCommand(parsed ParsedCommand, wanted []string) bool {
+	for _, stage := range parsed.Stages {
+		for _, w := range wanted {
+			if strings.EqualFold(w, stage.Program) || strings.EqualFold(w, stage.Main()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// FuzzyScore matches pattern as a case-insensitive subsequence of text. The
+// score rewards consecutive characters and matches at word starts (after
+// whitespace more than after punctuation), and penalizes gaps.
+func FuzzyScore(text, pattern string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	if len(p) == 0 {
+		return 0, true
+	}
+	t := []rune(strings.ToLower(text))
+
+	score, pi, last := 0, 0, -1
+	for ti := 0; ti < len(t) && pi < len(p); ti++ {
+		if t[ti] != p[pi] {
+			continue
+		}
+		score += 1
+		if last >= 0 && last == ti-1 {
+			score += 5
+		} else if last >= 0 {
+			score -= min(ti-last-1, 3)
+		}
+		switch {
+		case ti == 0 || unicode.IsSpace(t[ti-1]):
+			score += 10
+		case !unicode.IsLetter(t[ti-1]) && !unicode.IsDigit(t[ti-1]):
+			score += 6
+		}
+		last = ti
+		pi++
+	}
+	if pi < len(p) {
+		return 0, false
+	}
+	return score, true
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func localSearchFixture() []ListedCommand {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	rows := []struct {
+		command string
+		shell   string
+		result  int
+	}{
+		{"git checkout main", "zsh", 0},
+		{"go vet ./cmd/cli/...", "bash", 1},
+		{"kubectl -n prod get pods", "zsh", 0},
+		{"make && kubectl apply -f deploy.yaml", "fish", 2},
+	}
+	result := make([]ListedCommand, 0, len(rows))
+	for i, r := range rows {
+		t := base.Add(time.Duration(i) * time.Hour)
+		result = append(result, ListedCommand{Command: r.command, Shell: r.shell, Result: r.result, StartTime: t, EndTime: t.Add(time.Second), Hostname: "box", Username: "u"})
+	}
+	return result
+}
+
+func localSearchCommands(t *testing.T, filter *SearchCommandsFilter, pagination *SearchCommandsPagination, opts LocalSearchOptions) []string {
+	t.Helper()
+	res, err := SearchLocalCommands(localSearchFixture(), filter, pagination, opts)
+	require.NoError(t, err)
+	commands := make([]string, 0, len(res.Edges))
+	for _, e := range res.Edges {
+		commands = append(commands, e.Command)
+	}
+	return commands
+}
+
+func TestSearchLocalCommands_Substring(t *testing.T) {
+	got := localSearchCommands(t, &SearchCommandsFilter{Command: "KUBECTL"}, nil, LocalSearchOptions{})
+	assert.Equal(t, []string{"make && kubectl apply -f deploy.yaml", "kubectl -n prod get pods"}, got)
+}
+
+func TestSearchLocalCommands_Filters(t *testing.T) {
+	got := localSearchCommands(t, &SearchCommandsFilter{Shell: []string{"zsh"}, Result: []int{0}}, nil, LocalSearchOptions{})
+	assert.Equal(t, []string{"kubectl -n prod get pods", "git checkout main"}, got)
+
+	got = localSearchCommands(t, &SearchCommandsFilter{MainCommand: []string{"kubectl get"}}, nil, LocalSearchOptions{})
+	assert.Equal(t, []string{"kubectl -n prod get pods"}, got)
+
+	// any stage counts
+	got = localSearchCommands(t, &SearchCommandsFilter{MainCommand: []string{"kubectl"}}, nil, LocalSearchOptions{})
+	assert.Len(t, got, 2)
+
+	since := time.Date(2026, 3, 1, 13, 0, 0, 0, time.UTC)
+	until := time.Date(2026, 3, 1, 14, 30, 0, 0, time.UTC)
+	got = localSearchCommands(t, &SearchCommandsFilter{}, nil, LocalSearchOptions{Since: since, Until: until})
+	assert.Equal(t, []string{"kubectl -n prod get pods", "go vet ./cmd/cli/..."}, got)
+}
+
+func TestSearchLocalCommands_Regex(t *testing.T) {
+	got := localSearchCommands(t, &SearchCommandsFilter{Command: `^g(it|o) `}, nil, LocalSearchOptions{Mode: LocalSearchRegex})
+	assert.Equal(t, []string{"go vet ./cmd/cli/...", "git checkout main"}, got)
+
+	_, err := SearchLocalCommands(localSearchFixture(), &SearchCommandsFilter{Command: "("}, nil, LocalSearchOptions{Mode: LocalSearchRegex})
+	assert.Error(t, err)
+}
+
+func TestSearchLocalCommands_Fuzzy(t *testing.T) {
+	got := localSearchCommands(t, &SearchCommandsFilter{Command: "gcm"}, nil, LocalSearchOptions{Mode: LocalSearchFuzzy})
+	require.NotEmpty(t, got)
+	assert.Equal(t, "git checkout main", got[0])
+
+	assert.Empty(t, localSearchCommands(t, &SearchCommandsFilter{Command: "zzz"}, nil, LocalSearchOptions{Mode: LocalSearchFuzzy}))
+}
+
+func TestSearchLocalCommands_Pagination(t *testing.T) {
+	res, err := SearchLocalCommands(localSearchFixture(), &SearchCommandsFilter{}, &SearchCommandsPagination{Limit: 3}, LocalSearchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, res.Count)
+	require.Len(t, res.Edges, 3)
+	assert.Equal(t, 3, res.Edges[2].ID)
+
+	res, err = SearchLocalCommands(localSearchFixture(), &SearchCommandsFilter{}, &SearchCommandsPagination{LastID: 3, Limit: 3}, LocalSearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, res.Edges, 1)
+	assert.Equal(t, "git checkout main", res.Edges[0].Command)
+	assert.Equal(t, 4, res.Edges[0].ID)
+}
@@ -619,3 +619,33 @@ func TestEnsureStorageFolder(t *testing.T) {
 		t.Fatalf("ensureStorageFolder failed on second call: %v", err)
 	}
 }
+
+func TestCommandID(t *testing.T) {
+	start := time.Unix(1700000000, 123456789)
+	id := CommandID(7, start, "git status")
+	if len(id) != 32 {
+		t.Errorf("expected a 32 character ID, got %q", id)
+	}
+	if id != CommandID(7, start, "git status") {
+		t.Error("CommandID should be deterministic")
+	}
+	for _, other := range []string{
+		CommandID(8, start, "git status"),
+		CommandID(7, start.Add(time.Nanosecond), "git status"),
+		CommandID(7, start, "git diff"),
+	} {
+		if other == id {
+			t.Error("different commands should get different IDs")
+		}
+	}
+
+	// a post command starts Duration before it ended
+	post := Command{SessionID: 7, Command: "git status", Time: start.Add(time.Second), Duration: time.Second, Phase: CommandPhasePost}
+	if post.RecordID() != id {
+		t.Error("post command with a measured duration should get the ID of its start")
+	}
+	post.ID = "stored"
+	if post.RecordID() != "stored" {
+		t.Error("RecordID should return the stored ID")
+	}
+}
@@ -80,6 +80,25 @@ func findConfigFiles(configDir string) configFiles {
 	return result
 }
 
+// ConfigFileStamp identifies the current content of the config files in
+// configDir by their names, sizes and modification times. It changes when one
+// of them is edited, created or removed.
+func ConfigFileStamp(configDir string) string {
+	files := findConfigFiles(configDir)
+	var stamp strings.Builder
+	for _, file := range []string{files.baseFile, files.localFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&stamp, "%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp.String()
+}
+
 var UserShellTimeConfig ShellTimeConfig
 
 type ConfigService interface {
@@ -171,6 +190,12 @@ func mergeConfig(base, local *ShellTimeConfig) {
 	if local.CodeTracking != nil {
 		base.CodeTracking = local.CodeTracking
 	}
+	if local.Storage != nil {
+		base.Storage = local.Storage
+	}
+	if local.MetricsEndpoint != nil {
+		base.MetricsEndpoint = local.MetricsEndpoint
+	}
 	if local.LogCleanup != nil {
 		base.LogCleanup = local.LogCleanup
 	}
@@ -281,6 +306,9 @@ func (cs *configService) ReadConfigFile(ctx context.Context, opts ...ReadConfigO
 	if config.SocketPath == "" {
 		config.SocketPath = DefaultSocketPath
 	}
+	if config.MetricsEndpoint != nil && config.MetricsEndpoint.Listen == "" {
+		config.MetricsEndpoint.Listen = DefaultMetricsListen
+	}
 
 	// Initialize LogCleanup with defaults if not present (enabled by default with 100MB threshold)
 	if config.LogCleanup == nil {
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -89,3 +90,41 @@ func TestReadConfigFile_DeprecatedCCOtelMigratesToAICodeOtel(t *testing.T) {
 	assert.Nil(t, cfg.CCOtel, "deprecated field cleared after migration")
 	assert.Equal(t, 9999, cfg.AICodeOtel.GRPCPort)
 }
+
+func TestConfigFileStamp(t *testing.T) {
+	dir := t.TempDir()
+	assert.Empty(t, ConfigFileStamp(dir), "no config files")
+
+	base := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(base, []byte("token: tok\n"), 0o644))
+	stamp := ConfigFileStamp(dir)
+	assert.NotEmpty(t, stamp)
+	assert.Equal(t, stamp, ConfigFileStamp(dir), "unchanged files keep the stamp")
+
+	require.NoError(t, os.WriteFile(base, []byte("token: other\n"), 0o644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(base, later, later))
+	edited := ConfigFileStamp(dir)
+	assert.NotEqual(t, stamp, edited, "editing the base file changes the stamp")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.local.yaml"), []byte("flushCount: 1\n"), 0o644))
+	assert.NotEqual(t, edited, ConfigFileStamp(dir), "adding a local file changes the stamp")
+}
+
+func TestReadConfigFile_MetricsEndpointDefaultListen(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"),
+		[]byte("token: tok\nmetricsEndpoint:\n  enabled: true\n"), 0o644))
+
+	cs := NewConfigService(dir)
+	cfg, err := cs.ReadConfigFile(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, cfg.MetricsEndpoint)
+	assert.Equal(t, DefaultMetricsListen, cfg.MetricsEndpoint.Listen, "default listen address applied when enabled but unset")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.local.yaml"),
+		[]byte("metricsEndpoint:\n  enabled: true\n  listen: unix:/tmp/metrics.sock\n"), 0o644))
+	cfg, err = cs.ReadConfigFile(context.Background(), WithSkipCache())
+	require.NoError(t, err)
+	assert.Equal(t, "unix:/tmp/metrics.sock", cfg.MetricsEndpoint.Listen, "local config overrides the listen address")
+}
@@ -203,17 +203,33 @@ func TestAESGCMService_Encrypt_LargePlaintext(t *testing.T) {
 	}
 }
 
-func TestAESGCMService_Decrypt_Panics(t *testing.T) {
+func TestAESGCMService_Decrypt(t *testing.T) {
 	service := NewAESGCMService()
+	key, _, err := service.GenerateKeys()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
 
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("Expected Decrypt to panic")
-		}
-	}()
+	ciphertext, nonce, err := service.Encrypt(string(key), []byte("git push --force"))
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	plaintext, err := service.Decrypt(string(key), ciphertext, nonce)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if string(plaintext) != "git push --force" {
+		t.Errorf("Expected the original plaintext, got %q", plaintext)
+	}
 
-	// This should panic as Decrypt is not implemented
-	service.Decrypt("key", []byte("ciphertext"), []byte("nonce"))
+	// a tampered ciphertext or another key fails authentication
+	ciphertext[0] ^= 0xff
+	if _, err := service.Decrypt(string(key), ciphertext, nonce); err == nil {
+		t.Error("Expected tampered ciphertext to fail")
+	}
+	if _, err := service.Decrypt("key", ciphertext, nonce); err == nil {
+		t.Error("Expected invalid key to fail")
+	}
 }
 
 // RSA Service Tests
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -63,6 +64,9 @@ func GetPreCommandsTree(ctx context.Context) (result preCommandTree, err error)
 		cmd := new(Command)
 		_, err := cmd.FromLineBytes(line)
 		if err != nil {
+			if errors.Is(err, ErrStorageKeyUnavailable) {
+				return nil, err
+			}
 			slog.Error("Invalid line parse in pre-command file", slog.String("line", string(line)), slog.Any("err", err))
 			continue
 		}
@@ -106,6 +110,9 @@ func GetPreCommands(ctx context.Context) ([]*Command, error) {
 		cmd := new(Command)
 		_, err := cmd.FromLineBytes(raw)
 		if err != nil {
+			if errors.Is(err, ErrStorageKeyUnavailable) {
+				return nil, err
+			}
 			slog.Error("Invalid line parse in pre-command file", slog.String("line", string(raw)), slog.Any("err", err))
 			continue
 		}
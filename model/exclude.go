@@ -5,12 +5,22 @@ import (
 	"regexp"
 )
 
-// ShouldExcludeCommand checks if a command matches any of the exclude patterns
+// ShouldExcludeCommand checks if a command matches any of the exclude patterns.
+// Besides the raw command line, each parsed stage is matched with its
+// environment assignments and wrappers stripped, so `^kubectl delete` also
+// excludes `sudo env KUBECONFIG=x kubectl delete pod` and `make && kubectl delete`.
 func ShouldExcludeCommand(command string, excludePatterns []string) bool {
 	if len(excludePatterns) == 0 {
 		return false
 	}
 
+	candidates := []string{command}
+	for _, stage := range ParseCommandLine(command).Stages {
+		if line := stage.Line(); line != command {
+			candidates = append(candidates, line)
+		}
+	}
+
 	for _, pattern := range excludePatterns {
 		if pattern == "" {
 			continue
@@ -22,9 +32,11 @@ func ShouldExcludeCommand(command string, excludePatterns []string) bool {
 			continue
 		}
 
-		if re.MatchString(command) {
-			slog.Debug("Command matches exclude pattern", slog.String("command", command), slog.String("pattern", pattern))
-			return true
+		for _, candidate := range candidates {
+			if re.MatchString(candidate) {
+				slog.Debug("Command matches exclude pattern", slog.String("command", command), slog.String("pattern", pattern))
+				return true
+			}
 		}
 	}
 
@@ -2,6 +2,8 @@ package model
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestShouldExcludeCommand(t *testing.T) {
@@ -93,4 +95,13 @@ func TestShouldExcludeCommand(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestShouldExcludeCommandStages(t *testing.T) {
+	patterns := []string{"^kubectl delete"}
+	assert.True(t, ShouldExcludeCommand("kubectl delete pod x", patterns))
+	assert.True(t, ShouldExcludeCommand("env KUBECONFIG=prod sudo kubectl delete pod x", patterns))
+	assert.True(t, ShouldExcludeCommand("make && kubectl delete pod x", patterns))
+	assert.False(t, ShouldExcludeCommand("kubectl get pods", patterns))
+	assert.False(t, ShouldExcludeCommand("echo 'kubectl delete'", patterns))
+}
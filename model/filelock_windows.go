@@ -0,0 +1,26 @@
+//go:build windows
+
+package model
+
+import (
+	"math"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes a lock on f, blocking until it is available. Windows locks
+// are mandatory, so the locked byte is far past any data we read or write.
+func lockFile(f *os.File, exclusive bool) error {
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	ol := &windows.Overlapped{Offset: math.MaxUint32, OffsetHigh: math.MaxUint32}
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol)
+}
+
+func unlockFile(f *os.File) error {
+	ol := &windows.Overlapped{Offset: math.MaxUint32, OffsetHigh: math.MaxUint32}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteHistory writes commands, oldest first, in a shell history format
+// (zsh, bash or fish) or as JSON lines or CSV.
+func WriteHistory(w io.Writer, format string, cmds []ListedCommand) error {
+	switch format {
+	case HistoryFormatJSONL:
+		enc := json.NewEncoder(w)
+		for _, cmd := range cmds {
+			if err := enc.Encode(cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	case HistoryFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"start_time", "end_time", "duration_ms", "result", "shell", "hostname", "username", "cwd", "command"}); err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			if err := cw.Write([]string{
+				strconv.FormatInt(cmd.StartTime.Unix(), 10),
+				strconv.FormatInt(cmd.EndTime.Unix(), 10),
+				strconv.FormatInt(cmd.EndTime.Sub(cmd.StartTime).Milliseconds(), 10),
+				strconv.Itoa(cmd.Result),
+				cmd.Shell,
+				cmd.Hostname,
+				cmd.Username,
+				cmd.Cwd,
+				cmd.Command,
+			}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case HistoryFormatZsh, HistoryFormatBash, HistoryFormatFish:
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	for _, cmd := range cmds {
+		var line string
+		switch format {
+		case HistoryFormatZsh:
+			seconds := int64(cmd.EndTime.Sub(cmd.StartTime).Seconds())
+			if seconds < 0 {
+				seconds = 0
+			}
+			command := metafyZsh(strings.ReplaceAll(cmd.Command, "\n", "\\\n"))
+			line = fmt.Sprintf(": %d:%d;%s\n", cmd.StartTime.Unix(), seconds, command)
+		case HistoryFormatBash:
+			line = fmt.Sprintf("#%d\n%s\n", cmd.StartTime.Unix(), cmd.Command)
+		case HistoryFormatFish:
+			line = fmt.Sprintf("- cmd: %s\n  when: %d\n", escapeFish(cmd.Command), cmd.StartTime.Unix())
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func metafyZsh(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == 0 || (c >= zshMeta && c <= 0xa2) {
+			b.WriteByte(zshMeta)
+			b.WriteByte(c ^ 32)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func escapeFish(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\\", "\\\\"), "\n", "\\n")
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportFixture() []ListedCommand {
+	start := time.Unix(1700000000, 0)
+	return []ListedCommand{
+		{Command: "make test", Shell: "zsh", StartTime: start, EndTime: start.Add(3 * time.Second), Result: 2, Hostname: "h", Username: "u"},
+		{Command: "echo one\necho \\two \x83", Shell: "zsh", StartTime: start.Add(time.Minute), EndTime: start.Add(time.Minute)},
+	}
+}
+
+func TestWriteHistory_RoundTrip(t *testing.T) {
+	parsers := map[string]func(*bytes.Buffer) (HistoryImport, error){
+		HistoryFormatZsh:  func(b *bytes.Buffer) (HistoryImport, error) { return ParseZshHistory(b) },
+		HistoryFormatBash: func(b *bytes.Buffer) (HistoryImport, error) { return ParseBashHistory(b) },
+		HistoryFormatFish: func(b *bytes.Buffer) (HistoryImport, error) { return ParseFishHistory(b) },
+	}
+	for format, parse := range parsers {
+		t.Run(format, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			require.NoError(t, WriteHistory(&buf, format, exportFixture()))
+			result, err := parse(&buf)
+			require.NoError(t, err)
+			require.Len(t, result.Commands, 2)
+			assert.Equal(t, "make test", result.Commands[0].Command)
+			assert.Equal(t, "echo one\necho \\two \x83", result.Commands[1].Command)
+			assert.Equal(t, time.Unix(1700000060, 0), result.Commands[1].Time)
+		})
+	}
+}
+
+func TestWriteHistory_CSVAndJSONL(t *testing.T) {
+	buf := bytes.Buffer{}
+	require.NoError(t, WriteHistory(&buf, HistoryFormatCSV, exportFixture()))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "start_time,end_time,duration_ms,result,shell,hostname,username,cwd,command", lines[0])
+	assert.Equal(t, "1700000000,1700000003,3000,2,zsh,h,u,,make test", lines[1])
+
+	buf.Reset()
+	require.NoError(t, WriteHistory(&buf, HistoryFormatJSONL, exportFixture()))
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `"command":"make test"`)
+
+	assert.Error(t, WriteHistory(&buf, "xml", nil))
+}
@@ -0,0 +1,321 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shell history formats understood by ReadShellHistory and WriteHistory. The
+// atuin database is read by the atuin package.
+const (
+	HistoryFormatZsh   = "zsh"
+	HistoryFormatBash  = "bash"
+	HistoryFormatFish  = "fish"
+	HistoryFormatAtuin = "atuin"
+	HistoryFormatJSONL = "jsonl"
+	HistoryFormatCSV   = "csv"
+)
+
+// zshMeta marks a metafied byte in ~/.zsh_history: zsh writes NUL and the
+// bytes 0x83-0xa2 as zshMeta followed by the byte XOR 32.
+const zshMeta = 0x83
+
+// DefaultHistoryPath returns where a shell keeps its history by default.
+func DefaultHistoryPath(format string) string {
+	home, _ := os.UserHomeDir()
+	switch format {
+	case HistoryFormatZsh:
+		if histFile := os.Getenv("HISTFILE"); histFile != "" && strings.Contains(histFile, "zsh") {
+			return histFile
+		}
+		return filepath.Join(home, ".zsh_history")
+	case HistoryFormatBash:
+		return filepath.Join(home, ".bash_history")
+	case HistoryFormatFish:
+		return filepath.Join(dataHome(home), "fish", "fish_history")
+	case HistoryFormatAtuin:
+		return filepath.Join(dataHome(home), "atuin", "history.db")
+	}
+	return ""
+}
+
+func dataHome(home string) string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir
+	}
+	return filepath.Join(home, ".local", "share")
+}
+
+// HistoryImport is the result of reading a shell history.
+type HistoryImport struct {
+	Commands []Command
+	// Skipped counts the entries without a timestamp, which can't be synced.
+	Skipped int
+}
+
+// ReadShellHistory parses the history at path, written by the shell named by
+// format. The commands are returned as post commands, oldest first, with
+// exact duplicates (same time and command) removed.
+func ReadShellHistory(format, path string) (HistoryImport, error) {
+	var result HistoryImport
+	f, err := os.Open(path)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+	switch format {
+	case HistoryFormatZsh:
+		result, err = ParseZshHistory(f)
+	case HistoryFormatBash:
+		result, err = ParseBashHistory(f)
+	case HistoryFormatFish:
+		result, err = ParseFishHistory(f)
+	default:
+		return result, fmt.Errorf("unsupported history format: %s", format)
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Commands = DedupeHistory(result.Commands)
+	return result, nil
+}
+
+func historyCommand(shell, command string, start time.Time, duration time.Duration, result int) Command {
+	return Command{
+		Shell:    shell,
+		Command:  command,
+		Time:     start.Add(duration),
+		EndTime:  start.Add(duration),
+		Result:   result,
+		Phase:    CommandPhasePost,
+		Duration: duration,
+	}
+}
+
+func unmetafyZsh(data []byte) []byte {
+	if bytes.IndexByte(data, zshMeta) < 0 {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == zshMeta && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^32)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
+
+// ParseZshHistory reads the extended zsh history format,
+// `: <start>:<seconds>;<command>`, where a command continues on the next
+// line while a line ends with a backslash.
+func ParseZshHistory(r io.Reader) (HistoryImport, error) {
+	var result HistoryImport
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return result, err
+	}
+	lines := strings.Split(string(unmetafyZsh(data)), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// a multiline command is written with a backslash before each newline
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, "\\") + "\n" + lines[i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		header, command, ok := strings.Cut(line, ";")
+		if !ok || !strings.HasPrefix(header, ": ") {
+			result.Skipped++
+			continue
+		}
+		startText, secondsText, _ := strings.Cut(strings.TrimPrefix(header, ": "), ":")
+		start, err := strconv.ParseInt(strings.TrimSpace(startText), 10, 64)
+		if err != nil {
+			result.Skipped++
+			continue
+		}
+		seconds, _ := strconv.ParseInt(strings.TrimSpace(secondsText), 10, 64)
+		result.Commands = append(result.Commands, historyCommand(HistoryFormatZsh, command, time.Unix(start, 0), time.Duration(seconds)*time.Second, 0))
+	}
+	return result, nil
+}
+
+// ParseBashHistory reads a bash history written with HISTTIMEFORMAT set: each
+// command follows a `#<start>` line. Commands before the first timestamp are
+// skipped.
+func ParseBashHistory(r io.Reader) (HistoryImport, error) {
+	var result HistoryImport
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, MAX_BUFFER_SIZE), MAX_BUFFER_SIZE)
+
+	var (
+		start   time.Time
+		command []string
+	)
+	flush := func() {
+		if len(command) > 0 && !start.IsZero() {
+			result.Commands = append(result.Commands, historyCommand(HistoryFormatBash, strings.Join(command, "\n"), start, 0, 0))
+		}
+		command = nil
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			if ts, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				flush()
+				start = time.Unix(ts, 0)
+				continue
+			}
+		}
+		if start.IsZero() {
+			if strings.TrimSpace(line) != "" {
+				result.Skipped++
+			}
+			continue
+		}
+		command = append(command, line)
+	}
+	flush()
+	return result, scanner.Err()
+}
+
+// ParseFishHistory reads fish's YAML-like history:
+//
+//   - cmd: git status
+//     when: 1700000000
+func ParseFishHistory(r io.Reader) (HistoryImport, error) {
+	var result HistoryImport
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, MAX_BUFFER_SIZE), MAX_BUFFER_SIZE)
+
+	var (
+		command string
+		when    int64
+		inEntry bool
+	)
+	flush := func() {
+		if !inEntry {
+			return
+		}
+		if when > 0 {
+			result.Commands = append(result.Commands, historyCommand(HistoryFormatFish, command, time.Unix(when, 0), 0, 0))
+		} else {
+			result.Skipped++
+		}
+		command, when, inEntry = "", 0, false
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "- cmd: "):
+			flush()
+			command, inEntry = unescapeFish(strings.TrimPrefix(line, "- cmd: ")), true
+		case strings.HasPrefix(line, "  when: "):
+			when, _ = strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "  when: ")), 10, 64)
+		}
+	}
+	flush()
+	return result, scanner.Err()
+}
+
+func unescapeFish(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// DedupeHistory sorts commands by time and drops repeated entries with the
+// same time and command, as written by shells sharing one history file.
+func DedupeHistory(cmds []Command) []Command {
+	sort.SliceStable(cmds, func(i, j int) bool { return cmds[i].Time.Before(cmds[j].Time) })
+	seen := make(map[string]bool, len(cmds))
+	result := cmds[:0]
+	for _, cmd := range cmds {
+		key := fmt.Sprintf("%d\x00%s", cmd.Time.UnixNano(), cmd.Command)
+		if cmd.Command == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, cmd)
+	}
+	return result
+}
+
+// BuildImportPayloads turns imported commands into sync payloads of at most
+// batchSize commands, one host and user per payload, applying the exclude
+// and data masking settings like a regular sync.
+func BuildImportPayloads(cmds []Command, config ShellTimeConfig, batchSize int) []PostTrackArgs {
+	if batchSize <= 0 {
+		batchSize = len(cmds)
+	}
+	sysInfo, err := GetOSAndVersion()
+	if err != nil {
+		sysInfo = &SysInfo{Os: "unknown", Version: "unknown"}
+	}
+
+	payloads := make([]PostTrackArgs, 0)
+	current := map[string]int{}
+	for _, cmd := range cmds {
+		if ShouldExcludeCommand(cmd.Command, config.Exclude) {
+			continue
+		}
+		td := TrackingData{
+			ID:            CommandID(cmd.SessionID, cmd.Time.Add(-cmd.Duration), cmd.Command),
+			SessionID:     cmd.SessionID,
+			Command:       cmd.Command,
+			StartTime:     cmd.Time.Add(-cmd.Duration).Unix(),
+			StartTimeNano: cmd.Time.Add(-cmd.Duration).UnixNano(),
+			EndTime:       cmd.Time.Unix(),
+			EndTimeNano:   cmd.Time.UnixNano(),
+			Result:        cmd.Result,
+			Cwd:           cmd.Cwd,
+		}
+		if config.DataMasking != nil && *config.DataMasking {
+			td.Command = MaskSensitiveTokens(td.Command)
+		}
+
+		group := cmd.Hostname + "\x00" + cmd.Username + "\x00" + cmd.Shell
+		i, ok := current[group]
+		if !ok || len(payloads[i].Data) >= batchSize {
+			payloads = append(payloads, PostTrackArgs{Meta: TrackingMetaData{
+				Hostname:  cmd.Hostname,
+				Username:  cmd.Username,
+				OS:        sysInfo.Os,
+				OSVersion: sysInfo.Version,
+				Shell:     cmd.Shell,
+			}})
+			i = len(payloads) - 1
+			current[group] = i
+		}
+		payloads[i].Data = append(payloads[i].Data, td)
+		payloads[i].CursorID = cmd.Time.UnixNano()
+	}
+	return payloads
+}
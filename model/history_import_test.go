@@ -0,0 +1,76 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseZshHistory(t *testing.T) {
+	// "é" is 0xc3 0xa9; zsh metafies bytes 0x83-0xa2, so 0xa9 stays as is,
+	// while 0x83 itself would be written as 0x83 0xa3
+	history := ": 1700000000:3;make test\n" +
+		": 1700000010:0;echo one\\\necho two\n" +
+		": 1700000020:0;echo caf\xc3\xa9 \x83\xa3\n" +
+		"plain without time\n" +
+		": 1700000000:3;make test\n"
+
+	result, err := ParseZshHistory(strings.NewReader(history))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Skipped)
+	require.Len(t, result.Commands, 4)
+	assert.Equal(t, "make test", result.Commands[0].Command)
+	assert.Equal(t, 3*time.Second, result.Commands[0].Duration)
+	assert.Equal(t, time.Unix(1700000003, 0), result.Commands[0].Time)
+	assert.Equal(t, "echo one\necho two", result.Commands[1].Command)
+	assert.Equal(t, "echo caf\xc3\xa9 \x83", result.Commands[2].Command)
+
+	assert.Len(t, DedupeHistory(result.Commands), 3)
+}
+
+func TestParseBashHistory(t *testing.T) {
+	history := "ls\n#1700000000\ngit status\n#1700000005\nfor i in 1 2; do\necho $i\ndone\n"
+	result, err := ParseBashHistory(strings.NewReader(history))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Skipped)
+	require.Len(t, result.Commands, 2)
+	assert.Equal(t, "git status", result.Commands[0].Command)
+	assert.Equal(t, HistoryFormatBash, result.Commands[0].Shell)
+	assert.Equal(t, "for i in 1 2; do\necho $i\ndone", result.Commands[1].Command)
+}
+
+func TestParseFishHistory(t *testing.T) {
+	history := "- cmd: echo a\\\\b\\nc\n  when: 1700000000\n  paths:\n    - a\n- cmd: no time\n- cmd: ls\n  when: 1700000001\n"
+	result, err := ParseFishHistory(strings.NewReader(history))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Skipped)
+	require.Len(t, result.Commands, 2)
+	assert.Equal(t, "echo a\\b\nc", result.Commands[0].Command)
+	assert.Equal(t, time.Unix(1700000001, 0), result.Commands[1].Time)
+}
+
+func TestBuildImportPayloads(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	cmds := []Command{
+		historyCommand("zsh", "ls", base, 0, 0),
+		historyCommand("zsh", "export TOKEN=1", base.Add(time.Second), 0, 0),
+		historyCommand("zsh", "pwd", base.Add(2*time.Second), time.Second, 0),
+		historyCommand("zsh", "cd", base.Add(3*time.Second), 0, 0),
+	}
+	for i := range cmds {
+		cmds[i].Hostname, cmds[i].Username = "h", "u"
+	}
+	cmds[3].Hostname = "other"
+
+	payloads := BuildImportPayloads(cmds, ShellTimeConfig{Exclude: []string{"export *"}}, 1)
+	require.Len(t, payloads, 3)
+	assert.Equal(t, "ls", payloads[0].Data[0].Command)
+	assert.Equal(t, "pwd", payloads[1].Data[0].Command)
+	assert.Equal(t, base.Add(2*time.Second).UnixNano(), payloads[1].Data[0].StartTimeNano)
+	assert.Equal(t, base.Add(3*time.Second).UnixNano(), payloads[1].CursorID)
+	assert.Equal(t, "other", payloads[2].Meta.Hostname)
+	assert.Equal(t, "zsh", payloads[2].Meta.Shell)
+}
@@ -22,12 +22,53 @@ else
     shelltime gc
 fi
 
-# Create a timestamp for the session when the shell starts
-SESSION_ID=$(date +%Y%m%d%H%M%S)
+# Get a unique ID for this shell session; a bare timestamp is shared by shells
+# opened in the same second. Keep it when this file is sourced again, so the
+# command doing the sourcing still pairs its pre and post events.
+if [[ "$_SHELLTIME_SESSION_PID" != "$$" ]]; then
+    SESSION_ID=$(shelltime session new --pid=$$ 2> /dev/null)
+    [[ "$SESSION_ID" =~ ^[0-9]+$ ]] || SESSION_ID=$(date +%Y%m%d%H%M%S)
+    _SHELLTIME_SESSION_PID=$$
+    _SHELLTIME_SESSION_NEW=1
+fi
 LAST_COMMAND=""
 
+# Start one `shelltime track --stream` process for this session and write each
+# event to it over a pipe, instead of forking `shelltime track` twice per
+# command. Needs bash 4.4+ for {fd} redirects and $! of a process
+# substitution. Set SHELLTIME_NO_STREAM=1 to go back to one process per event.
+# Background jobs inherit the pipe, so the stream watches --shell-pid to stop
+# with the shell instead of waiting for them.
+if [[ -z "$SHELLTIME_NO_STREAM" && -z "$_SHELLTIME_STREAM_FD" ]] && command -v shelltime &> /dev/null &&
+    (( BASH_VERSINFO[0] > 4 || (BASH_VERSINFO[0] == 4 && BASH_VERSINFO[1] >= 4) )); then
+    exec {_SHELLTIME_STREAM_FD}> >(exec shelltime track --stream -s=bash -id=$SESSION_ID --ppid=$PPID --shell-pid=$$ &> /dev/null)
+    _SHELLTIME_STREAM_PID=$!
+fi
+
+# Escape backslash, tab and newline for the stream format; result in REPLY
+_shelltime_escape() {
+    REPLY=${1//\\/\\\\}
+    REPLY=${REPLY//$'\t'/\\t}
+    REPLY=${REPLY//$'\n'/\\n}
+}
+
+# Write one event line to the stream process. Fails if there is no stream or
+# it has gone away, in which case the caller falls back to a one-shot track.
+_shelltime_stream() {
+    [[ -n "$_SHELLTIME_STREAM_FD" ]] || return 1
+    # writing to a dead reader would kill the shell with SIGPIPE, so check first
+    if ! kill -0 "$_SHELLTIME_STREAM_PID" 2> /dev/null; then
+        exec {_SHELLTIME_STREAM_FD}>&-
+        unset _SHELLTIME_STREAM_FD _SHELLTIME_STREAM_PID
+        return 1
+    fi
+    printf '%s\n' "$1" >&"$_SHELLTIME_STREAM_FD"
+}
+
 # Function to be executed before each command
 preexec_invoke_cmd() {
+    # $EPOCHREALTIME is empty before bash 5, track then falls back to its own clock
+    local START_TIME=$EPOCHREALTIME
     local CMD="$1"
     LAST_COMMAND="$CMD"
     # Check if command starts with exit, logout, or reboot
@@ -40,12 +81,17 @@ preexec_invoke_cmd() {
         return
     fi
 
-    shelltime track -s=bash -id=$SESSION_ID -cmd="$CMD" -p=pre --ppid=$PPID &> /dev/null
+    local REPLY ESC_CMD
+    _shelltime_escape "$CMD"; ESC_CMD=$REPLY
+    _shelltime_escape "$PWD"
+    _shelltime_stream "p=pre"$'\t'"t=$START_TIME"$'\t'"cwd=$REPLY"$'\t'"cmd=$ESC_CMD" && return
+    shelltime track -s=bash -id=$SESSION_ID -cmd="$CMD" -p=pre --ppid=$PPID --cwd="$PWD" --time="$START_TIME" &> /dev/null
 }
 
 # Function to be executed after each command (before prompt)
 precmd_invoke_cmd() {
-    local LAST_RESULT=$?
+    # bash-preexec saves $PIPESTATUS in BP_PIPESTATUS before running precmd hooks
+    local LAST_RESULT=$? LAST_PIPESTATUS="${BP_PIPESTATUS[*]}" END_TIME=$EPOCHREALTIME
     # BASH_COMMAND in precmd is the *previous* command
     local CMD="$LAST_COMMAND"
     # Check if command starts with exit, logout, or reboot
@@ -63,9 +109,46 @@ precmd_invoke_cmd() {
         return
     fi
 
-    shelltime track -s=bash -id=$SESSION_ID -cmd="$CMD" -p=post -r=$LAST_RESULT --ppid=$PPID &> /dev/null
+    local REPLY ESC_CMD
+    _shelltime_escape "$CMD"; ESC_CMD=$REPLY
+    _shelltime_escape "$PWD"
+    _shelltime_stream "p=post"$'\t'"t=$END_TIME"$'\t'"r=$LAST_RESULT"$'\t'"ps=$LAST_PIPESTATUS"$'\t'"cwd=$REPLY"$'\t'"cmd=$ESC_CMD" && return
+    shelltime track -s=bash -id=$SESSION_ID -cmd="$CMD" -p=post -r=$LAST_RESULT --ppid=$PPID --cwd="$PWD" --time="$END_TIME" --pipestatus="$LAST_PIPESTATUS" &> /dev/null
 }
 
 # Set the functions for bash-preexec
 preexec_functions+=(preexec_invoke_cmd)
 precmd_functions+=(precmd_invoke_cmd)
+
+# Record the start and the end of the session, once per shell. The start runs
+# in the background so it never delays the first prompt.
+_shelltime_session_end() {
+    shelltime session end -s=bash -id=$SESSION_ID --ppid=$PPID --cwd="$PWD" --tty="$_SHELLTIME_TTY" --time="$EPOCHREALTIME" &> /dev/null
+}
+if [[ -n "$_SHELLTIME_SESSION_NEW" ]]; then
+    unset _SHELLTIME_SESSION_NEW
+    # bash has no $TTY, and a background job no longer sees the terminal
+    _SHELLTIME_TTY=$(tty 2> /dev/null) || _SHELLTIME_TTY=""
+    (shelltime session start -s=bash -id=$SESSION_ID --ppid=$PPID --cwd="$PWD" --tty="$_SHELLTIME_TTY" --time="$EPOCHREALTIME" &> /dev/null &)
+    # run any EXIT trap set before this hook as well
+    _shelltime_prev_exit_trap=$(trap -p EXIT)
+    _shelltime_prev_exit_trap=${_shelltime_prev_exit_trap#"trap -- '"}
+    _shelltime_prev_exit_trap=${_shelltime_prev_exit_trap%"' EXIT"}
+    _shelltime_prev_exit_trap=${_shelltime_prev_exit_trap//"'\''"/"'"}
+    trap "_shelltime_session_end; ${_shelltime_prev_exit_trap}" EXIT
+    unset _shelltime_prev_exit_trap
+fi
+
+# Ctrl-R opens `shelltime history pick` and puts the chosen command into the
+# line buffer. Set SHELLTIME_NO_HISTORY_WIDGET=1 to keep the shell's own Ctrl-R.
+_shelltime_history_widget() {
+    local selected
+    selected=$(shelltime history pick --query="$READLINE_LINE" < /dev/tty 2> /dev/null)
+    if [[ -n "$selected" ]]; then
+        READLINE_LINE=$selected
+        READLINE_POINT=${#READLINE_LINE}
+    fi
+}
+if [[ -z "$SHELLTIME_NO_HISTORY_WIDGET" && $- == *i* ]]; then
+    bind -x '"\C-r": _shelltime_history_widget'
+fi
@@ -7,8 +7,23 @@ else
     shelltime gc
 end
 
-# Create a timestamp for the session when the shell starts
-set -g SESSION_ID (date +%Y%m%d%H%M%S)
+# Get a unique ID for this shell session; a bare timestamp is shared by shells
+# opened in the same second. Keep it when this file is sourced again, so the
+# command doing the sourcing still pairs its pre and post events.
+if test "$_SHELLTIME_SESSION_PID" != "$fish_pid"
+    set -g SESSION_ID (shelltime session new --pid=$fish_pid 2> /dev/null)
+    string match -qr '^[0-9]+$' -- "$SESSION_ID"; or set -g SESSION_ID (date +%Y%m%d%H%M%S)
+    set -g _SHELLTIME_SESSION_PID $fish_pid
+    set -g _SHELLTIME_SESSION_NEW 1
+end
+
+# Unlike the zsh and bash hooks, fish cannot keep a pipe to a background
+# `shelltime track --stream` open across commands (no exec redirections), so it
+# still runs one `shelltime track` per event. A named fifo doesn't work either:
+# fish can only write to it with a plain redirection, which blocks until a
+# reader opens it, so a stream process that died without cleaning up (kill -9)
+# would hang every prompt. Tailing a regular file instead would delay and skew
+# the timing more than the fork it saves.
 
 # Capture parent process ID at shell startup (fish doesn't have native $PPID)
 set -g FISH_PPID (ps -o ppid= -p %self | string trim)
@@ -19,15 +34,49 @@ function fish_preexec --on-event fish_preexec
         return
     end
 
-    shelltime track -s=fish -id=$SESSION_ID -cmd="$argv" -p=pre --ppid=$FISH_PPID > /dev/null
+    shelltime track -s=fish -id=$SESSION_ID -cmd="$argv" -p=pre --ppid=$FISH_PPID --cwd="$PWD" > /dev/null
 end
 
 # Define the postexec function
 function fish_postexec --on-event fish_postexec
+    # capture these first; $CMD_DURATION is the shell's own measurement in ms
+    set -l last_pipestatus $pipestatus
     set -g LAST_RESULT (echo $status)
     if string match -q 'exit*' -- $argv; or string match -q 'logout*' -- $argv; or string match -q 'reboot*' -- $argv
         return
     end
+    # Fish has no $EPOCHREALTIME, so take the end time here rather than in the
+    # forked `shelltime track`, whose startup would delay it. BSD date has no
+    # %N; track then falls back to its own clock.
+    set -l end_time (date +%s.%N 2> /dev/null)
+    string match -qr '^[0-9]+\.[0-9]+$' -- "$end_time"; or set end_time ""
     # This event is triggered before each prompt, which is after each command
-    shelltime track -s=fish -id=$SESSION_ID -cmd="$argv" -p=post -r=$LAST_RESULT --ppid=$FISH_PPID > /dev/null
+    shelltime track -s=fish -id=$SESSION_ID -cmd="$argv" -p=post -r=$LAST_RESULT --ppid=$FISH_PPID --cwd="$PWD" --time="$end_time" --duration=$CMD_DURATION --pipestatus="$last_pipestatus" > /dev/null
+end
+
+# Record the start and the end of the session, once per shell. The start runs
+# in the background so it never delays the first prompt.
+function _shelltime_session_end --on-event fish_exit
+    shelltime session end -s=fish -id=$SESSION_ID --ppid=$FISH_PPID --cwd="$PWD" --tty="$_SHELLTIME_TTY" > /dev/null 2>&1
+end
+if set -q _SHELLTIME_SESSION_NEW
+    set -e _SHELLTIME_SESSION_NEW
+    set -g _SHELLTIME_TTY (tty 2> /dev/null); or set -g _SHELLTIME_TTY ""
+    shelltime session start -s=fish -id=$SESSION_ID --ppid=$FISH_PPID --cwd="$PWD" --tty="$_SHELLTIME_TTY" > /dev/null 2>&1 &
+    disown
+end
+
+# Ctrl-R opens `shelltime history pick` and puts the chosen command into the
+# line buffer. Set SHELLTIME_NO_HISTORY_WIDGET=1 to keep the shell's own Ctrl-R.
+function _shelltime_history_widget
+    set -l selected (shelltime history pick --query=(commandline | string collect) < /dev/tty 2> /dev/null | string collect)
+    if test -n "$selected"
+        commandline -r -- $selected
+        commandline -f end-of-line
+    end
+    commandline -f repaint
+end
+if not set -q SHELLTIME_NO_HISTORY_WIDGET; and status is-interactive
+    bind \cr _shelltime_history_widget
+    bind -M insert \cr _shelltime_history_widget 2> /dev/null
 end
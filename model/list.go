@@ -2,6 +2,8 @@ package model
 
 import (
 	"context"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -10,11 +12,13 @@ import (
 type ListedCommand struct {
 	Command   string    `json:"command"`
 	Shell     string    `json:"shell"`
+	SessionID int64     `json:"session_id,omitempty"`
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
 	Result    int       `json:"result"`
 	Username  string    `json:"username"`
 	Hostname  string    `json:"hostname"`
+	Cwd       string    `json:"cwd,omitempty"`
 }
 
 // BuildListedCommands pairs each post command with its closest pre command and
@@ -30,32 +34,67 @@ func BuildListedCommands(ctx context.Context, store CommandStore) ([]ListedComma
 		return nil, err
 	}
 
+	pres := newPreIndex(preTree)
 	commands := make([]ListedCommand, 0, len(postCommands))
 	for _, postCommand := range postCommands {
-		if postCommand == nil {
-			continue
-		}
-		key := postCommand.GetUniqueKey()
-		preCommands, ok := preTree[key]
-		if !ok {
-			continue
+		if listed, ok := pairListedCommand(postCommand, pres); ok {
+			commands = append(commands, listed)
 		}
+	}
+	return commands, nil
+}
+
+// pairListedCommand builds the display row of a post command from its closest
+// pre command. ok is false when the post has no pre to pair with.
+func pairListedCommand(postCommand *Command, pres preIndex) (ListedCommand, bool) {
+	if postCommand == nil {
+		return ListedCommand{}, false
+	}
+	preCommands, ok := pres.lookup(postCommand)
+	if !ok {
+		return ListedCommand{}, false
+	}
 
-		closestPreCommand := postCommand.FindClosestCommand(preCommands, false)
-		startTime := postCommand.Time
-		if closestPreCommand != nil {
-			startTime = closestPreCommand.Time
+	closestPreCommand := postCommand.FindClosestCommand(preCommands, false)
+	startTime := postCommand.Time
+	cwd := postCommand.Cwd
+	if closestPreCommand != nil {
+		startTime = closestPreCommand.Time
+		if closestPreCommand.Cwd != "" {
+			cwd = closestPreCommand.Cwd
 		}
+	}
+	if postCommand.Duration > 0 {
+		startTime = postCommand.Time.Add(-postCommand.Duration)
+	}
+
+	return ListedCommand{
+		Command:   postCommand.Command,
+		Shell:     postCommand.Shell,
+		SessionID: postCommand.SessionID,
+		StartTime: startTime,
+		EndTime:   postCommand.Time,
+		Result:    postCommand.Result,
+		Username:  postCommand.Username,
+		Hostname:  postCommand.Hostname,
+		Cwd:       cwd,
+	}, true
+}
 
-		commands = append(commands, ListedCommand{
-			Command:   postCommand.Command,
-			Shell:     postCommand.Shell,
-			StartTime: startTime,
-			EndTime:   postCommand.Time,
-			Result:    postCommand.Result,
-			Username:  postCommand.Username,
-			Hostname:  postCommand.Hostname,
-		})
+// FilterListedCommandsByDir keeps the commands that ran in dir or any of its
+// subdirectories. Commands recorded before cwd tracking existed have no
+// directory and never match.
+func FilterListedCommandsByDir(commands []ListedCommand, dir string) []ListedCommand {
+	dir = filepath.Clean(dir)
+	result := make([]ListedCommand, 0, len(commands))
+	for _, cmd := range commands {
+		if cmd.Cwd == "" {
+			continue
+		}
+		cwd := filepath.Clean(cmd.Cwd)
+		if cwd == dir || strings.HasPrefix(cwd, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+			result = append(result, cmd)
+		}
 	}
-	return commands, nil
+	return result
 }
@@ -36,3 +36,71 @@ func TestBuildListedCommands(t *testing.T) {
 	require.Equal(t, start.Unix(), listed[0].StartTime.Unix())
 	require.Equal(t, post.Time.Unix(), listed[0].EndTime.Unix())
 }
+
+func TestBuildListedCommandsMixedSessionIDs(t *testing.T) {
+	store, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	// the shell re-sourced the upgraded hook while `make` ran: the pre carries
+	// the old date-shaped ID, the post a minted one
+	pre := Command{Shell: "zsh", SessionID: 20240405123456, Command: "make", Username: "u", Time: start}
+	require.NoError(t, store.SavePre(ctx, pre, start))
+	post := pre
+	post.SessionID = NewSessionID(start, 4242)
+	post.Time = start.Add(5 * time.Second)
+	require.NoError(t, store.SavePost(ctx, post, 0, post.Time))
+
+	// a minted session never pairs with another minted session's pre
+	other := Command{Shell: "zsh", SessionID: NewSessionID(start, 1), Command: "ls", Username: "u", Time: start}
+	require.NoError(t, store.SavePre(ctx, other, start))
+	otherPost := other
+	otherPost.SessionID = NewSessionID(start, 2)
+	require.NoError(t, store.SavePost(ctx, otherPost, 0, start.Add(time.Second)))
+
+	listed, err := BuildListedCommands(ctx, store)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, "make", listed[0].Command)
+	require.Equal(t, post.SessionID, listed[0].SessionID)
+	require.Equal(t, start.Unix(), listed[0].StartTime.Unix())
+}
+
+func TestBuildListedCommandsCwd(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+
+	store := NewFileStore()
+	ctx := context.Background()
+	start := time.Now()
+	cmd := Command{Shell: "bash", SessionID: 1, Command: "go test ./...", Username: "u", Time: start, Cwd: "/src/cli"}
+	require.NoError(t, store.SavePre(ctx, cmd, start))
+	post := cmd
+	post.Time = start.Add(time.Second)
+	require.NoError(t, store.SavePost(ctx, post, 0, post.Time))
+
+	listed, err := BuildListedCommands(ctx, store)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, "/src/cli", listed[0].Cwd)
+}
+
+func TestFilterListedCommandsByDir(t *testing.T) {
+	commands := []ListedCommand{
+		{Command: "a", Cwd: "/src/cli"},
+		{Command: "b", Cwd: "/src/cli/model"},
+		{Command: "c", Cwd: "/src/cli-old"},
+		{Command: "d", Cwd: "/src"},
+		{Command: "e"},
+	}
+
+	got := FilterListedCommandsByDir(commands, "/src/cli/")
+	require.Len(t, got, 2)
+	require.Equal(t, "a", got[0].Command)
+	require.Equal(t, "b", got[1].Command)
+
+	require.Len(t, FilterListedCommandsByDir(commands, "/"), 4)
+	require.Empty(t, FilterListedCommandsByDir(commands, "/nowhere"))
+}
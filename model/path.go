@@ -74,11 +74,43 @@ func GetPostCommandFilePath() string {
 	return GetStoragePath("commands", "post.txt")
 }
 
+// GetSessionEventFilePath returns the path to the shell session events file
+func GetSessionEventFilePath() string {
+	return GetStoragePath("commands", "sessions.txt")
+}
+
+// GetArchiveFilePath returns the path to the local history archive
+func GetArchiveFilePath() string {
+	return GetStoragePath("commands", "archive.txt")
+}
+
+// GetArchiveIndexFilePath returns the path to the local history archive index
+func GetArchiveIndexFilePath() string {
+	return GetStoragePath("commands", "archive.idx")
+}
+
 // GetCursorFilePath returns the path to the cursor storage file
 func GetCursorFilePath() string {
 	return GetStoragePath("commands", "cursor.txt")
 }
 
+// GetEndpointCursorFilePath returns the path to the file store's per-endpoint
+// sync cursors
+func GetEndpointCursorFilePath() string {
+	return GetStoragePath("commands", "endpoint-cursors.json")
+}
+
+// GetSegmentStoragePath returns the folder of the segment engine's log files
+func GetSegmentStoragePath() string {
+	return GetStoragePath("commands", "segments")
+}
+
+// GetStorageKeyFilePath returns the default path of the key encrypting the
+// command storage
+func GetStorageKeyFilePath() string {
+	return GetStoragePath("storage.key")
+}
+
 // GetBoltDBPath returns the path to the bbolt command database (daemon-owned).
 func GetBoltDBPath() string {
 	return GetStoragePath("commands", "commands.db")
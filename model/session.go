@@ -0,0 +1,160 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+// NewSessionID mints the ID a shell hook tags its commands with. The hooks
+// used to take `date +%Y%m%d%H%M%S`, so two shells started in the same second
+// (tmux restoring a layout) shared a session and GetUniqueKey paired one
+// shell's pre record with the other's post. The ID packs the start time, the
+// shell's pid and random bits into 53 bits, so it survives a round trip
+// through a float64 (JSON decoders in the web app and jq):
+//
+//	| 32 bits unix seconds | 10 bits pid | 11 bits random |
+//
+// Minted IDs are far above the old date-shaped ones (~2e13), so both kinds can
+// sit in the store side by side without colliding.
+func NewSessionID(now time.Time, pid int) int64 {
+	sec := uint64(now.Unix()) & 0xFFFFFFFF
+	p := uint64(pid) & 0x3FF
+	r := rand.Uint64() & 0x7FF
+	return int64(sec<<21 | p<<11 | r)
+}
+
+// maxLegacySessionID is the largest date-shaped session ID the old hooks
+// wrote (`date +%Y%m%d%H%M%S`).
+const maxLegacySessionID = 99991231235959
+
+// IsLegacySessionID reports whether id was written by a hook from before
+// NewSessionID.
+func IsLegacySessionID(id int64) bool {
+	return id > 0 && id <= maxLegacySessionID
+}
+
+// crossesSessionIDChange reports whether post may complete pre although their
+// session IDs differ: a shell that re-sources the upgraded hook while a
+// command runs (`exec zsh` in a tmux pane, `source ~/.bashrc` from a script)
+// wrote the pre with its old date-shaped ID and writes the post with a minted
+// one.
+func crossesSessionIDChange(pre, post *Command) bool {
+	return IsLegacySessionID(pre.SessionID) && !IsLegacySessionID(post.SessionID) &&
+		pre.Shell == post.Shell && pre.Command == post.Command && pre.Username == post.Username
+}
+
+// preIndex finds the pre commands a post command pairs with. Posts match on
+// GetUniqueKey; a post without a match falls back to the pre commands of
+// date-shaped sessions, see crossesSessionIDChange.
+type preIndex struct {
+	tree   map[string][]*Command
+	legacy map[string][]*Command
+}
+
+func newPreIndex(preTree map[string][]*Command) preIndex {
+	idx := preIndex{tree: preTree}
+	for _, pres := range preTree {
+		for _, pre := range pres {
+			if pre == nil || !IsLegacySessionID(pre.SessionID) {
+				continue
+			}
+			if idx.legacy == nil {
+				idx.legacy = make(map[string][]*Command)
+			}
+			key := legacyPairKey(pre)
+			idx.legacy[key] = append(idx.legacy[key], pre)
+		}
+	}
+	return idx
+}
+
+func legacyPairKey(cmd *Command) string {
+	return cmd.Shell + "|" + cmd.Command + "|" + cmd.Username
+}
+
+// lookup returns the pre commands post may pair with. ok is false when there
+// are none.
+func (idx preIndex) lookup(post *Command) ([]*Command, bool) {
+	if pres, ok := idx.tree[post.GetUniqueKey()]; ok {
+		return pres, true
+	}
+	if idx.legacy == nil || IsLegacySessionID(post.SessionID) {
+		return nil, false
+	}
+	pres, ok := idx.legacy[legacyPairKey(post)]
+	return pres, ok
+}
+
+// SessionEventType marks the start or the end of a shell session.
+type SessionEventType string
+
+const (
+	SessionEventStart SessionEventType = "start"
+	SessionEventEnd   SessionEventType = "end"
+)
+
+// SessionEvent is a shell session lifecycle event, sent by the hooks when a
+// shell starts and when it exits. It gives a session a real start, end and
+// the environment it ran in, instead of inferring them from its commands.
+type SessionEvent struct {
+	Type      SessionEventType `json:"type"`
+	Shell     string           `json:"shell"`
+	SessionID int64            `json:"sid"`
+	Hostname  string           `json:"hn"`
+	Username  string           `json:"un"`
+	Time      time.Time        `json:"t"`
+	PPID      int              `json:"ppid,omitempty"`
+
+	TTY           string `json:"tty,omitempty"`
+	SSHConnection string `json:"ssh,omitempty"`
+	TermProgram   string `json:"termProgram,omitempty"`
+	// Cwd is the directory the shell started in (or exited from)
+	Cwd string `json:"cwd,omitempty"`
+	// Terminal and Multiplexer are resolved from PPID when a start event is
+	// persisted, while the process tree is still alive.
+	Terminal    string `json:"terminal,omitempty"`
+	Multiplexer string `json:"multiplexer,omitempty"`
+
+	RecordingTime time.Time `json:"-"`
+}
+
+// ToLine encodes the event in the same `json<TAB>recording nanos` line format
+// as Command.ToLine.
+func (ev SessionEvent) ToLine(recordingTime time.Time) ([]byte, error) {
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, SEPARATOR)
+	buf = strconv.AppendInt(buf, recordingTime.UnixNano(), 10)
+	buf, err = sealStorageRecord(buf)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, '\n')
+	return buf, nil
+}
+
+func (ev *SessionEvent) FromLineBytes(line []byte) error {
+	line, err := openStorageRecord(line)
+	if err != nil {
+		return err
+	}
+	data, nanos, ok := bytes.Cut(line, []byte{SEPARATOR})
+	if !ok {
+		return fmt.Errorf("invalid line format in session events file: %s", string(line))
+	}
+	if err := json.Unmarshal(data, ev); err != nil {
+		return fmt.Errorf("failed to unmarshal session event: %w", err)
+	}
+	unixNano, err := strconv.ParseInt(string(bytes.TrimSpace(nanos)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to parse session event timestamp: %w", err)
+	}
+	ev.RecordingTime = time.Unix(0, unixNano)
+	return nil
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSessionID(t *testing.T) {
+	now := time.Unix(1712345678, 0)
+
+	id := NewSessionID(now, 4242)
+	require.Positive(t, id)
+	require.Equal(t, now.Unix(), id>>21, "the start time is recoverable from the high bits")
+	require.Equal(t, int64(4242&0x3FF), id>>11&0x3FF)
+	require.Greater(t, id, int64(99991231235959), "never overlaps the old date-shaped IDs")
+	require.False(t, IsLegacySessionID(id))
+	require.True(t, IsLegacySessionID(20240405123456))
+
+	// the largest ID still decodes exactly through a float64
+	maxID := NewSessionID(time.Unix(0xFFFFFFFF, 0), 0x3FF)
+	require.LessOrEqual(t, maxID, int64(1)<<53)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"sid":`+strconv.FormatInt(id, 10)+`}`), &decoded))
+	require.Equal(t, id, int64(decoded["sid"].(float64)))
+
+	// two shells opened in the same second get different sessions
+	require.NotEqual(t, NewSessionID(now, 100), NewSessionID(now, 101))
+
+	// the same pid in the same second still differs by the random bits
+	seen := map[int64]bool{}
+	for i := 0; i < 100; i++ {
+		seen[NewSessionID(now, 100)] = true
+	}
+	require.Greater(t, len(seen), 90)
+}
+
+func TestPreHasSyncedPostAcrossSessionIDChange(t *testing.T) {
+	start := time.Now()
+	pre := &Command{Shell: "zsh", SessionID: 20240405123456, Command: "make", Username: "u", Time: start}
+	post := &Command{Shell: "zsh", SessionID: NewSessionID(start, 7), Command: "make", Username: "u", Time: start.Add(time.Second), RecordingTime: start.Add(time.Second)}
+	require.True(t, preHasSyncedPost(pre, []*Command{post}, start.Add(time.Minute)))
+
+	// a minted session never falls back to another session's pre
+	pre.SessionID = NewSessionID(start, 8)
+	require.False(t, preHasSyncedPost(pre, []*Command{post}, start.Add(time.Minute)))
+}
+
+func TestSessionEventStores(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+
+	bolt, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer bolt.Close()
+
+	for _, store := range []CommandStore{NewFileStore(), bolt} {
+		t.Run(store.Engine(), func(t *testing.T) {
+			ctx := context.Background()
+			base := time.Now()
+			start := SessionEvent{Type: SessionEventStart, Shell: "zsh", SessionID: 9, Time: base, TTY: "/dev/pts/1", SSHConnection: "10.0.0.1 5000 10.0.0.2 22", Cwd: "/src"}
+			end := SessionEvent{Type: SessionEventEnd, Shell: "zsh", SessionID: 9, Time: base.Add(time.Hour)}
+			require.NoError(t, store.SaveSessionEvent(ctx, start, base))
+			require.NoError(t, store.SaveSessionEvent(ctx, end, base.Add(time.Hour)))
+
+			events, err := store.GetSessionEvents(ctx)
+			require.NoError(t, err)
+			require.Len(t, events, 2)
+			require.Equal(t, SessionEventStart, events[0].Type)
+			require.Equal(t, "/dev/pts/1", events[0].TTY)
+			require.Equal(t, "10.0.0.1 5000 10.0.0.2 22", events[0].SSHConnection)
+			require.Equal(t, base.UnixNano(), events[0].RecordingTime.UnixNano())
+
+			cmd := Command{Shell: "zsh", SessionID: 9, Command: "ls", Username: "u", Time: base}
+			require.NoError(t, store.SavePre(ctx, cmd, base))
+			require.NoError(t, store.SavePost(ctx, cmd, 0, base))
+
+			// only the start has been synced
+			require.NoError(t, store.Prune(ctx, base))
+			events, err = store.GetSessionEvents(ctx)
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			require.Equal(t, SessionEventEnd, events[0].Type)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// service/elvish_hook_service.go
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gookit/color"
+)
+
+type ElvishHookService struct {
+	BaseHookService
+
+	shellName  string
+	configPath string
+	hookLines  []string
+}
+
+// elvishConfigPath returns the rc.elv elvish reads, preferring the legacy
+// ~/.elvish/rc.elv only when it is already in use.
+func elvishConfigPath() string {
+	legacy := os.ExpandEnv("$HOME/.elvish/rc.elv")
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "elvish", "rc.elv")
+	}
+	return os.ExpandEnv("$HOME/.config/elvish/rc.elv")
+}
+
+func NewElvishHookService() ShellHookService {
+	sourceContent := os.ExpandEnv(fmt.Sprintf("$HOME/%s/hooks/elvish.elv", COMMAND_BASE_STORAGE_FOLDER))
+	hookLines := []string{
+		"# Added by shelltime CLI",
+		fmt.Sprintf("set paths = [$E:HOME/%s/bin $@paths]", COMMAND_BASE_STORAGE_FOLDER),
+		fmt.Sprintf("eval (slurp < '%s')", sourceContent),
+	}
+
+	return &ElvishHookService{
+		shellName:  "elvish",
+		configPath: elvishConfigPath(),
+		hookLines:  hookLines,
+	}
+}
+
+func (s *ElvishHookService) Match(shellName string) bool {
+	return strings.Contains(strings.ToLower(shellName), strings.ToLower(s.shellName))
+}
+
+func (s *ElvishHookService) ShellName() string {
+	return s.shellName
+}
+
+func (s *ElvishHookService) Install() error {
+	hookFilePath := os.ExpandEnv(fmt.Sprintf("$HOME/%s/hooks/elvish.elv", COMMAND_BASE_STORAGE_FOLDER))
+	if err := ensureHookFile(hookFilePath, EmbeddedElvishHook); err != nil {
+		return fmt.Errorf("failed to ensure elvish hook file: %w", err)
+	}
+
+	if err := ensureShellConfig(s.configPath, "elvish"); err != nil {
+		return err
+	}
+
+	if err := s.Check(); err == nil {
+		color.Green.Println("Elvish hook is already installed.")
+		return nil
+	}
+
+	// Backup the file
+	if err := s.backupFile(s.configPath); err != nil {
+		return err
+	}
+
+	// Add hook lines
+	return s.addHookLines(s.configPath, s.hookLines)
+}
+
+func (s *ElvishHookService) Uninstall() error {
+	if _, err := os.Stat(s.configPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Backup the file
+	if err := s.backupFile(s.configPath); err != nil {
+		return err
+	}
+	return s.removeHookLines(s.configPath, s.hookLines)
+}
+
+func (s *ElvishHookService) Check() error {
+	content, err := os.ReadFile(s.configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("elvish config file not found at %s. Please run 'shelltime hooks install'", s.configPath)
+		}
+		return fmt.Errorf("failed to read elvish config file %s: %w", s.configPath, err)
+	}
+
+	fileContent := string(content)
+	for _, hookLine := range s.hookLines {
+		if !strings.Contains(fileContent, hookLine) {
+			return fmt.Errorf("hook line missing in %s: '%s'. Please run 'shelltime hooks install' or manually add it", s.configPath, hookLine)
+		}
+	}
+
+	return nil
+}
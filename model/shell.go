@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/gookit/color"
 )
 
 type ShellHookService interface {
@@ -35,6 +38,28 @@ func ensureHookFile(hookFilePath string, embeddedContent []byte) error {
 	return nil
 }
 
+// ensureShellConfig makes sure the rc file of a shell exists. Shells like
+// nushell, xonsh and elvish run fine without one, so it is created when the
+// shell is installed; otherwise the shell is most likely not used at all.
+func ensureShellConfig(configPath, binary string) error {
+	if _, err := os.Stat(configPath); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error checking %s config file %s: %w", binary, configPath, err)
+	}
+	if _, err := exec.LookPath(binary); err != nil {
+		return fmt.Errorf("%s config file not found at %s and %s is not installed", binary, configPath, binary)
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s config directory: %w", binary, err)
+	}
+	if err := os.WriteFile(configPath, nil, 0644); err != nil {
+		return fmt.Errorf("failed to create %s config file: %w", binary, err)
+	}
+	color.Yellow.Printf("%s config file not found at %s, created an empty one.\n", binary, configPath)
+	return nil
+}
+
 // Common utilities for hook services
 type BaseHookService struct{}
 
@@ -0,0 +1,116 @@
+// service/nushell_hook_service.go
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/gookit/color"
+)
+
+type NushellHookService struct {
+	BaseHookService
+
+	shellName  string
+	configPath string
+	hookLines  []string
+}
+
+// nushellConfigDir mirrors nushell's $nu.default-config-dir.
+func nushellConfigDir() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "nushell")
+	}
+	if runtime.GOOS == "darwin" {
+		return os.ExpandEnv("$HOME/Library/Application Support/nushell")
+	}
+	return os.ExpandEnv("$HOME/.config/nushell")
+}
+
+func NewNushellHookService() ShellHookService {
+	sourceContent := os.ExpandEnv(fmt.Sprintf("$HOME/%s/hooks/nushell.nu", COMMAND_BASE_STORAGE_FOLDER))
+	hookLines := []string{
+		"# Added by shelltime CLI",
+		// PATH may still be a string when config.nu runs on older nushell
+		fmt.Sprintf("$env.PATH = ($env.PATH | split row (char esep) | prepend ($env.HOME | path join '%s' 'bin'))", COMMAND_BASE_STORAGE_FOLDER),
+		fmt.Sprintf("source '%s'", sourceContent),
+	}
+
+	return &NushellHookService{
+		shellName:  "nushell",
+		configPath: filepath.Join(nushellConfigDir(), "config.nu"),
+		hookLines:  hookLines,
+	}
+}
+
+// Match accepts the binary name too, which is `nu` rather than `nushell`.
+func (s *NushellHookService) Match(shellName string) bool {
+	shellName = strings.ToLower(shellName)
+	if strings.Contains(shellName, s.shellName) {
+		return true
+	}
+	base := filepath.Base(shellName)
+	return base == "nu" || base == "nu.exe"
+}
+
+func (s *NushellHookService) ShellName() string {
+	return s.shellName
+}
+
+func (s *NushellHookService) Install() error {
+	hookFilePath := os.ExpandEnv(fmt.Sprintf("$HOME/%s/hooks/nushell.nu", COMMAND_BASE_STORAGE_FOLDER))
+	if err := ensureHookFile(hookFilePath, EmbeddedNushellHook); err != nil {
+		return fmt.Errorf("failed to ensure nushell hook file: %w", err)
+	}
+
+	if err := ensureShellConfig(s.configPath, "nu"); err != nil {
+		return err
+	}
+
+	if err := s.Check(); err == nil {
+		color.Green.Println("Nushell hook is already installed.")
+		return nil
+	}
+
+	// Backup the file
+	if err := s.backupFile(s.configPath); err != nil {
+		return err
+	}
+
+	// Add hook lines
+	return s.addHookLines(s.configPath, s.hookLines)
+}
+
+func (s *NushellHookService) Uninstall() error {
+	if _, err := os.Stat(s.configPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Backup the file
+	if err := s.backupFile(s.configPath); err != nil {
+		return err
+	}
+	return s.removeHookLines(s.configPath, s.hookLines)
+}
+
+func (s *NushellHookService) Check() error {
+	content, err := os.ReadFile(s.configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("nushell config file not found at %s. Please run 'shelltime hooks install'", s.configPath)
+		}
+		return fmt.Errorf("failed to read nushell config file %s: %w", s.configPath, err)
+	}
+
+	fileContent := string(content)
+	for _, hookLine := range s.hookLines {
+		if !strings.Contains(fileContent, hookLine) {
+			return fmt.Errorf("hook line missing in %s: '%s'. Please run 'shelltime hooks install' or manually add it", s.configPath, hookLine)
+		}
+	}
+
+	return nil
+}
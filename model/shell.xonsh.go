@@ -0,0 +1,96 @@
+// service/xonsh_hook_service.go
+package model
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gookit/color"
+)
+
+type XonshHookService struct {
+	BaseHookService
+
+	shellName  string
+	configPath string
+	hookLines  []string
+}
+
+func NewXonshHookService() ShellHookService {
+	sourceContent := os.ExpandEnv(fmt.Sprintf("$HOME/%s/hooks/xonsh.xsh", COMMAND_BASE_STORAGE_FOLDER))
+	hookLines := []string{
+		"# Added by shelltime CLI",
+		fmt.Sprintf("$PATH.insert(0, $HOME + '/%s/bin')", COMMAND_BASE_STORAGE_FOLDER),
+		fmt.Sprintf("source '%s'", sourceContent),
+	}
+
+	return &XonshHookService{
+		shellName:  "xonsh",
+		configPath: os.ExpandEnv("$HOME/.xonshrc"),
+		hookLines:  hookLines,
+	}
+}
+
+func (s *XonshHookService) Match(shellName string) bool {
+	return strings.Contains(strings.ToLower(shellName), strings.ToLower(s.shellName))
+}
+
+func (s *XonshHookService) ShellName() string {
+	return s.shellName
+}
+
+func (s *XonshHookService) Install() error {
+	hookFilePath := os.ExpandEnv(fmt.Sprintf("$HOME/%s/hooks/xonsh.xsh", COMMAND_BASE_STORAGE_FOLDER))
+	if err := ensureHookFile(hookFilePath, EmbeddedXonshHook); err != nil {
+		return fmt.Errorf("failed to ensure xonsh hook file: %w", err)
+	}
+
+	if err := ensureShellConfig(s.configPath, "xonsh"); err != nil {
+		return err
+	}
+
+	if err := s.Check(); err == nil {
+		color.Green.Println("Xonsh hook is already installed.")
+		return nil
+	}
+
+	// Backup the file
+	if err := s.backupFile(s.configPath); err != nil {
+		return err
+	}
+
+	// Add hook lines
+	return s.addHookLines(s.configPath, s.hookLines)
+}
+
+func (s *XonshHookService) Uninstall() error {
+	if _, err := os.Stat(s.configPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Backup the file
+	if err := s.backupFile(s.configPath); err != nil {
+		return err
+	}
+	return s.removeHookLines(s.configPath, s.hookLines)
+}
+
+func (s *XonshHookService) Check() error {
+	content, err := os.ReadFile(s.configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("xonsh config file not found at %s. Please run 'shelltime hooks install'", s.configPath)
+		}
+		return fmt.Errorf("failed to read xonsh config file %s: %w", s.configPath, err)
+	}
+
+	fileContent := string(content)
+	for _, hookLine := range s.hookLines {
+		if !strings.Contains(fileContent, hookLine) {
+			return fmt.Errorf("hook line missing in %s: '%s'. Please run 'shelltime hooks install' or manually add it", s.configPath, hookLine)
+		}
+	}
+
+	return nil
+}
@@ -10,3 +10,12 @@ var EmbeddedZshHook []byte
 
 //go:embed hooks/fish.fish
 var EmbeddedFishHook []byte
+
+//go:embed hooks/nushell.nu
+var EmbeddedNushellHook []byte
+
+//go:embed hooks/xonsh.xsh
+var EmbeddedXonshHook []byte
+
+//go:embed hooks/elvish.elv
+var EmbeddedElvishHook []byte
@@ -163,3 +163,99 @@ func TestEnsureBashPreexec_AlreadyPresent(t *testing.T) {
 	require.NoError(t, os.WriteFile(filepath.Join(dir, "bash-preexec.sh"), []byte("x"), 0644))
 	assert.NoError(t, ensureBashPreexec(dir))
 }
+
+// shFakeBinary puts an executable named name on an otherwise empty PATH.
+func shFakeBinary(t *testing.T, name string) {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755))
+	t.Setenv("PATH", dir)
+}
+
+func TestNushellHookService_Install_Lifecycle(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("PATH", t.TempDir())
+
+	svc := NewNushellHookService()
+
+	// nu is not installed and there is no config.nu -> nothing to hook into.
+	err := svc.Install()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+
+	// With nu on PATH the missing config.nu is created.
+	shFakeBinary(t, "nu")
+	require.NoError(t, svc.Install())
+	rc := filepath.Join(home, ".config", "nushell", "config.nu")
+	assert.NoError(t, svc.Check())
+	assert.Equal(t, 1, shCount(t, rc, "# Added by shelltime CLI"))
+	assert.FileExists(t, filepath.Join(shHooksDir(t), "nushell.nu"))
+
+	// Idempotent.
+	require.NoError(t, svc.Install())
+	assert.Equal(t, 1, shCount(t, rc, "# Added by shelltime CLI"))
+
+	require.NoError(t, svc.Uninstall())
+	assert.Error(t, svc.Check())
+	matches, _ := filepath.Glob(rc + ".bak.*")
+	assert.NotEmpty(t, matches, "uninstall should back up config.nu")
+}
+
+func TestXonshHookService_Install_Lifecycle(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PATH", t.TempDir())
+
+	svc := NewXonshHookService()
+	rc := filepath.Join(home, ".xonshrc")
+
+	// An existing rc file is used even when xonsh is not on PATH.
+	require.NoError(t, os.WriteFile(rc, []byte("$XONSH_SHOW_TRACEBACK = True\n"), 0644))
+	require.NoError(t, svc.Install())
+	assert.NoError(t, svc.Check())
+	assert.Equal(t, 1, shCount(t, rc, "$XONSH_SHOW_TRACEBACK = True"))
+	assert.Equal(t, 1, shCount(t, rc, "# Added by shelltime CLI"))
+	assert.FileExists(t, filepath.Join(shHooksDir(t), "xonsh.xsh"))
+	matches, _ := filepath.Glob(rc + ".bak.*")
+	assert.NotEmpty(t, matches, "backup file should be created")
+
+	require.NoError(t, svc.Install())
+	assert.Equal(t, 1, shCount(t, rc, "# Added by shelltime CLI"))
+
+	require.NoError(t, svc.Uninstall())
+	assert.Error(t, svc.Check())
+	assert.Equal(t, 1, shCount(t, rc, "$XONSH_SHOW_TRACEBACK = True"))
+}
+
+func TestElvishHookService_Install_Lifecycle(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	shFakeBinary(t, "elvish")
+
+	svc := NewElvishHookService()
+	require.NoError(t, svc.Install())
+	rc := filepath.Join(home, ".config", "elvish", "rc.elv")
+	assert.NoError(t, svc.Check())
+	assert.Equal(t, 1, shCount(t, rc, "# Added by shelltime CLI"))
+	assert.FileExists(t, filepath.Join(shHooksDir(t), "elvish.elv"))
+
+	require.NoError(t, svc.Install())
+	assert.Equal(t, 1, shCount(t, rc, "# Added by shelltime CLI"))
+
+	require.NoError(t, svc.Uninstall())
+	assert.Error(t, svc.Check())
+}
+
+func TestElvishHookService_LegacyConfigPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	legacy := filepath.Join(home, ".elvish", "rc.elv")
+	require.NoError(t, os.MkdirAll(filepath.Dir(legacy), 0755))
+	require.NoError(t, os.WriteFile(legacy, nil, 0644))
+
+	require.NoError(t, NewElvishHookService().Install())
+	assert.Equal(t, 1, shCount(t, legacy, "# Added by shelltime CLI"))
+}
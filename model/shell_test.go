@@ -543,3 +543,39 @@ func TestFishHookService_HookLines(t *testing.T) {
 		t.Error("Fish hook lines should include fish_add_path")
 	}
 }
+
+func TestNushellHookService_Match(t *testing.T) {
+	service := NewNushellHookService()
+
+	testCases := []struct {
+		input    string
+		expected bool
+	}{
+		{"nu", true},
+		{"/usr/bin/nu", true},
+		{"/opt/homebrew/bin/nushell", true},
+		{"/home/nuno/bin/zsh", false},
+		{"bash", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			result := service.Match(tc.input)
+			if result != tc.expected {
+				t.Errorf("Match(%q) = %v, expected %v", tc.input, result, tc.expected)
+			}
+		})
+	}
+}
+
+func TestXonshAndElvishHookService_Match(t *testing.T) {
+	if !NewXonshHookService().Match("/usr/local/bin/xonsh") {
+		t.Error("xonsh service should match a xonsh path")
+	}
+	if !NewElvishHookService().Match("/usr/bin/elvish") {
+		t.Error("elvish service should match an elvish path")
+	}
+	if NewElvishHookService().Match("/bin/bash") {
+		t.Error("elvish service should not match bash")
+	}
+}
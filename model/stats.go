@@ -0,0 +1,259 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+// StatsOptions tunes ComputeStats.
+type StatsOptions struct {
+	// Top caps the ranked lists. Zero means 10.
+	Top int
+	// Now is the reference time for the current streak. Zero means time.Now().
+	Now time.Time
+	// Location is the time zone of the heatmap and the streak days. Nil means
+	// time.Local.
+	Location *time.Location
+}
+
+// MainCommandStats aggregates the runs of one main command ("git commit",
+// "ls").
+type MainCommandStats struct {
+	Main            string  `json:"main"`
+	Count           int     `json:"count"`
+	Failed          int     `json:"failed"`
+	FailureRate     float64 `json:"failureRate"`
+	TotalDurationMs int64   `json:"totalDurationMs"`
+}
+
+// BreakdownStats aggregates the commands of one shell or host.
+type BreakdownStats struct {
+	Name            string `json:"name"`
+	Count           int    `json:"count"`
+	TotalDurationMs int64  `json:"totalDurationMs"`
+}
+
+// LongestCommand is one run in the longest-running list.
+type LongestCommand struct {
+	Command    string    `json:"command"`
+	DurationMs int64     `json:"durationMs"`
+	Result     int       `json:"result"`
+	Hostname   string    `json:"hostname"`
+	EndTime    time.Time `json:"endTime"`
+}
+
+// StreakStats counts consecutive days with at least one command.
+type StreakStats struct {
+	// Current is the streak ending today, or yesterday when nothing ran yet
+	// today.
+	Current      int       `json:"current"`
+	Longest      int       `json:"longest"`
+	LongestStart time.Time `json:"longestStart,omitempty"`
+	LongestEnd   time.Time `json:"longestEnd,omitempty"`
+	ActiveDays   int       `json:"activeDays"`
+}
+
+// CommandStats is the local analytics report behind `shelltime stats`.
+type CommandStats struct {
+	Total           int       `json:"total"`
+	Failed          int       `json:"failed"`
+	TotalDurationMs int64     `json:"totalDurationMs"`
+	From            time.Time `json:"from,omitempty"`
+	To              time.Time `json:"to,omitempty"`
+
+	TopByCount    []MainCommandStats `json:"topByCount"`
+	TopByDuration []MainCommandStats `json:"topByDuration"`
+	// TopFailures ranks the main commands that failed at least once by
+	// failure rate.
+	TopFailures []MainCommandStats `json:"topFailures"`
+	Longest     []LongestCommand   `json:"longest"`
+	Shells      []BreakdownStats   `json:"shells"`
+	Hosts       []BreakdownStats   `json:"hosts"`
+	// Heatmap counts commands by weekday (Sunday first) and hour of day.
+	Heatmap [7][24]int  `json:"heatmap"`
+	Streak  StreakStats `json:"streak"`
+}
+
+// ComputeStats builds the analytics report from paired commands, e.g. the
+// archived and buffered ones on this machine.
+func ComputeStats(commands []ListedCommand, opts StatsOptions) CommandStats {
+	top := opts.Top
+	if top <= 0 {
+		top = 10
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	stats := CommandStats{}
+	mains := map[string]*MainCommandStats{}
+	shells := map[string]*BreakdownStats{}
+	hosts := map[string]*BreakdownStats{}
+	days := map[time.Time]bool{}
+	longest := make([]LongestCommand, 0, len(commands))
+
+	for _, cmd := range commands {
+		duration := max(cmd.EndTime.Sub(cmd.StartTime).Milliseconds(), 0)
+		failed := cmd.Result != 0
+
+		stats.Total++
+		stats.TotalDurationMs += duration
+		if failed {
+			stats.Failed++
+		}
+		if stats.From.IsZero() || cmd.StartTime.Before(stats.From) {
+			stats.From = cmd.StartTime
+		}
+		if cmd.EndTime.After(stats.To) {
+			stats.To = cmd.EndTime
+		}
+
+		main := MainCommand(cmd.Command)
+		if main == "" {
+			main = cmd.Command
+		}
+		m, ok := mains[main]
+		if !ok {
+			m = &MainCommandStats{Main: main}
+			mains[main] = m
+		}
+		m.Count++
+		m.TotalDurationMs += duration
+		if failed {
+			m.Failed++
+		}
+
+		addBreakdown(shells, cmd.Shell, duration)
+		addBreakdown(hosts, cmd.Hostname, duration)
+
+		local := cmd.StartTime.In(loc)
+		stats.Heatmap[local.Weekday()][local.Hour()]++
+		days[time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)] = true
+
+		longest = append(longest, LongestCommand{
+			Command:    cmd.Command,
+			DurationMs: duration,
+			Result:     cmd.Result,
+			Hostname:   cmd.Hostname,
+			EndTime:    cmd.EndTime,
+		})
+	}
+
+	all := make([]MainCommandStats, 0, len(mains))
+	for _, m := range mains {
+		m.FailureRate = float64(m.Failed) / float64(m.Count)
+		all = append(all, *m)
+	}
+
+	stats.TopByCount = rankMainCommands(all, top, func(a, b MainCommandStats) bool {
+		return a.Count > b.Count
+	})
+	stats.TopByDuration = rankMainCommands(all, top, func(a, b MainCommandStats) bool {
+		return a.TotalDurationMs > b.TotalDurationMs
+	})
+	failing := make([]MainCommandStats, 0)
+	for _, m := range all {
+		if m.Failed > 0 {
+			failing = append(failing, m)
+		}
+	}
+	stats.TopFailures = rankMainCommands(failing, top, func(a, b MainCommandStats) bool {
+		if a.FailureRate != b.FailureRate {
+			return a.FailureRate > b.FailureRate
+		}
+		return a.Failed > b.Failed
+	})
+
+	sort.SliceStable(longest, func(i, j int) bool { return longest[i].DurationMs > longest[j].DurationMs })
+	stats.Longest = longest[:min(top, len(longest))]
+
+	stats.Shells = rankBreakdown(shells)
+	stats.Hosts = rankBreakdown(hosts)
+	stats.Streak = computeStreak(days, now.In(loc))
+	return stats
+}
+
+func addBreakdown(m map[string]*BreakdownStats, name string, duration int64) {
+	if name == "" {
+		name = "unknown"
+	}
+	b, ok := m[name]
+	if !ok {
+		b = &BreakdownStats{Name: name}
+		m[name] = b
+	}
+	b.Count++
+	b.TotalDurationMs += duration
+}
+
+// rankMainCommands sorts a copy of all by less, ties by name so the report is
+// stable, and keeps the first top entries.
+func rankMainCommands(all []MainCommandStats, top int, less func(a, b MainCommandStats) bool) []MainCommandStats {
+	ranked := append([]MainCommandStats{}, all...)
+	sort.Slice(ranked, func(i, j int) bool {
+		if less(ranked[i], ranked[j]) {
+			return true
+		}
+		if less(ranked[j], ranked[i]) {
+			return false
+		}
+		return ranked[i].Main < ranked[j].Main
+	})
+	return ranked[:min(top, len(ranked))]
+}
+
+func rankBreakdown(m map[string]*BreakdownStats) []BreakdownStats {
+	result := make([]BreakdownStats, 0, len(m))
+	for _, b := range m {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func computeStreak(days map[time.Time]bool, now time.Time) StreakStats {
+	streak := StreakStats{ActiveDays: len(days)}
+	if len(days) == 0 {
+		return streak
+	}
+
+	sorted := make([]time.Time, 0, len(days))
+	for d := range days {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	run := 0
+	var runStart time.Time
+	for i, d := range sorted {
+		// AddDate rather than 24h keeps DST days consecutive
+		if i > 0 && sorted[i-1].AddDate(0, 0, 1).Equal(d) {
+			run++
+		} else {
+			run, runStart = 1, d
+		}
+		if run > streak.Longest {
+			streak.Longest, streak.LongestStart, streak.LongestEnd = run, runStart, d
+		}
+	}
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !days[day] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for days[day] {
+		streak.Current++
+		day = day.AddDate(0, 0, -1)
+	}
+	return streak
+}
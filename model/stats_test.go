@@ -0,0 +1,74 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statsCommand(command, shell, host string, result int, start time.Time, d time.Duration) ListedCommand {
+	return ListedCommand{Command: command, Shell: shell, Hostname: host, Result: result, StartTime: start, EndTime: start.Add(d)}
+}
+
+func TestComputeStats(t *testing.T) {
+	loc := time.UTC
+	// Monday 2024-01-01
+	day := time.Date(2024, 1, 1, 9, 0, 0, 0, loc)
+	commands := []ListedCommand{
+		statsCommand("git status", "zsh", "laptop", 0, day, time.Second),
+		statsCommand("git status", "zsh", "laptop", 0, day.Add(time.Minute), time.Second),
+		statsCommand("git commit -m x", "zsh", "laptop", 1, day.Add(2*time.Minute), 2*time.Second),
+		statsCommand("make test", "bash", "server", 2, day.AddDate(0, 0, 1), time.Minute),
+		statsCommand("make test", "bash", "server", 0, day.AddDate(0, 0, 2), 3*time.Minute),
+		statsCommand("ls", "zsh", "laptop", 0, day.AddDate(0, 0, 5).Add(5*time.Hour), 10*time.Millisecond),
+	}
+
+	stats := ComputeStats(commands, StatsOptions{Top: 2, Now: day.AddDate(0, 0, 6), Location: loc})
+
+	assert.Equal(t, 6, stats.Total)
+	assert.Equal(t, 2, stats.Failed)
+	assert.Equal(t, day, stats.From)
+
+	require.Len(t, stats.TopByCount, 2)
+	assert.Equal(t, "git status", stats.TopByCount[0].Main)
+	assert.Equal(t, 2, stats.TopByCount[0].Count)
+	assert.Equal(t, "make", stats.TopByCount[1].Main)
+
+	require.Len(t, stats.TopByDuration, 2)
+	assert.Equal(t, "make", stats.TopByDuration[0].Main)
+	assert.Equal(t, int64(4*60*1000), stats.TopByDuration[0].TotalDurationMs)
+
+	require.Len(t, stats.TopFailures, 2)
+	assert.Equal(t, "git commit", stats.TopFailures[0].Main)
+	assert.Equal(t, 1.0, stats.TopFailures[0].FailureRate)
+	assert.Equal(t, 0.5, stats.TopFailures[1].FailureRate)
+
+	require.Len(t, stats.Longest, 2)
+	assert.Equal(t, int64(3*60*1000), stats.Longest[0].DurationMs)
+
+	require.Len(t, stats.Shells, 2)
+	assert.Equal(t, BreakdownStats{Name: "zsh", Count: 4, TotalDurationMs: 4010}, stats.Shells[0])
+	assert.Equal(t, "server", stats.Hosts[1].Name)
+
+	assert.Equal(t, 3, stats.Heatmap[time.Monday][9])
+	assert.Equal(t, 1, stats.Heatmap[time.Saturday][14])
+
+	// Jan 1-3 in a row, Jan 6 alone; "now" is Jan 7 with nothing run yet
+	assert.Equal(t, StreakStats{
+		Current:      1,
+		Longest:      3,
+		LongestStart: time.Date(2024, 1, 1, 0, 0, 0, 0, loc),
+		LongestEnd:   time.Date(2024, 1, 3, 0, 0, 0, 0, loc),
+		ActiveDays:   4,
+	}, stats.Streak)
+}
+
+func TestComputeStats_Empty(t *testing.T) {
+	stats := ComputeStats(nil, StatsOptions{})
+	assert.Zero(t, stats.Total)
+	assert.Empty(t, stats.TopByCount)
+	assert.NotNil(t, stats.Longest)
+	assert.Equal(t, StreakStats{}, stats.Streak)
+}
@@ -0,0 +1,647 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// storageRecordPrefix marks an encrypted record: the prefix, the ID of its
+// key, a colon, and the base64 nonce and ciphertext. Records without it are
+// plaintext, e.g. written before encryption was turned on, and stay readable.
+const storageRecordPrefix = "enc:"
+
+// storageKeyCommandTimeout bounds the key command, so a password manager
+// waiting for input can't hang the shell hooks.
+const storageKeyCommandTimeout = 30 * time.Second
+
+// ErrStorageKeyUnavailable is returned for an encrypted record whose key
+// can't be loaded. Readers fail instead of skipping such records, so a
+// rewrite never drops them.
+var ErrStorageKeyUnavailable = errors.New("storage encryption key unavailable")
+
+// storageKeyring holds the keys of the command records. The keys are loaded
+// on first use, once per process.
+type storageKeyring struct {
+	mu      sync.Mutex
+	config  StorageEncryptionConfig
+	enabled bool
+	loaded  bool
+	loadErr error
+	// keys holds the base64 keys by key ID; current seals new records.
+	keys    map[string]string
+	current string
+}
+
+var storageKeys = &storageKeyring{}
+
+// ConfigureStorageEncryption applies the storage.encryption settings of cfg
+// to the records this process reads and writes. Call it before opening a
+// store or reading the pending files. The key itself is loaded on the first
+// record sealed or opened, so a keyCommand only runs in the processes that
+// touch records: once per daemon, but once per hook when no daemon takes the
+// events.
+func ConfigureStorageEncryption(cfg ShellTimeConfig) {
+	var config StorageEncryptionConfig
+	if cfg.Storage != nil && cfg.Storage.Encryption != nil {
+		config = *cfg.Storage.Encryption
+	}
+	enabled := config.Enabled != nil && *config.Enabled
+
+	storageKeys.mu.Lock()
+	defer storageKeys.mu.Unlock()
+	if storageKeys.enabled == enabled && storageKeys.config.KeyFile == config.KeyFile && storageKeys.config.KeyCommand == config.KeyCommand {
+		return
+	}
+	storageKeys.resetLocked(config, enabled)
+}
+
+// resetLocked applies new settings and forgets the loaded keys.
+func (k *storageKeyring) resetLocked(config StorageEncryptionConfig, enabled bool) {
+	k.config, k.enabled = config, enabled
+	k.loaded, k.loadErr = false, nil
+	k.keys, k.current = nil, ""
+}
+
+// StorageEncryptionEnabled reports whether new records are encrypted.
+func StorageEncryptionEnabled() bool {
+	storageKeys.mu.Lock()
+	defer storageKeys.mu.Unlock()
+	return storageKeys.enabled
+}
+
+// keyFilePath returns the key file, expanding a leading ~.
+func (k *storageKeyring) keyFilePath() string {
+	path := k.config.KeyFile
+	if path == "" {
+		return GetStorageKeyFilePath()
+	}
+	if strings.HasPrefix(path, "~") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+func storageKeyID(key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return "", fmt.Errorf("invalid storage key: %w", err)
+	}
+	if len(raw) != 32 {
+		return "", fmt.Errorf("invalid storage key: want 32 bytes, got %d", len(raw))
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:4]), nil
+}
+
+// runStorageKeyCommand returns the key a key command prints.
+func runStorageKeyCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageKeyCommandTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/c", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("storage key command failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// readStorageKeyFile reads a key file, refusing one others can read.
+func readStorageKeyFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("storage key file %s is accessible by others, run chmod 600 on it", path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// writeStorageKeyFile creates a key file readable by its owner only.
+func writeStorageKeyFile(path, key string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func (k *storageKeyring) addKey(key string, current bool) error {
+	id, err := storageKeyID(key)
+	if err != nil {
+		return err
+	}
+	if k.keys == nil {
+		k.keys = make(map[string]string)
+	}
+	k.keys[id] = strings.TrimSpace(key)
+	if current {
+		k.current = id
+	}
+	return nil
+}
+
+// loadLocked loads the keys. Besides the key file, it reads the new key of
+// an unfinished rotation and the key retired by the last one, so records
+// written with either stay readable. A missing key file is created, unless
+// encryption is off and it is only needed to read old records.
+func (k *storageKeyring) loadLocked() error {
+	if k.loaded {
+		return k.loadErr
+	}
+	k.loaded = true
+	k.loadErr = func() error {
+		if k.config.KeyCommand != "" {
+			key, err := runStorageKeyCommand(k.config.KeyCommand)
+			if err != nil {
+				return err
+			}
+			return k.addKey(key, true)
+		}
+
+		path := k.keyFilePath()
+		key, err := readStorageKeyFile(path)
+		if os.IsNotExist(err) && k.enabled {
+			generated, _, genErr := NewAESGCMService().GenerateKeys()
+			if genErr != nil {
+				return genErr
+			}
+			if err = writeStorageKeyFile(path, string(generated)); err == nil {
+				slog.Info("Created storage encryption key", slog.String("path", path))
+				key = string(generated)
+			} else if os.IsExist(err) {
+				// another process created it first
+				key, err = readStorageKeyFile(path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := k.addKey(key, true); err != nil {
+			return err
+		}
+		for _, extra := range []string{path + ".new", path + ".old"} {
+			if key, err := readStorageKeyFile(extra); err == nil {
+				if err := k.addKey(key, false); err != nil {
+					slog.Warn("Ignoring invalid storage key", slog.String("path", extra), slog.Any("err", err))
+				}
+			}
+		}
+		return nil
+	}()
+	if k.loadErr == nil && k.enabled {
+		restrictStorageFiles()
+	}
+	return k.loadErr
+}
+
+// restrictStorageFiles makes the storage files and the pending files that
+// others can read readable by their owner only. The stores create their files
+// with mode 0600, but the files of older versions were created 0644 and keep
+// their mode when opened for appending.
+func restrictStorageFiles() {
+	restrict := func(path string) {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0077 != 0 {
+			if err := os.Chmod(path, 0600); err != nil {
+				slog.Warn("Failed to restrict a storage file to its owner", slog.String("path", path), slog.Any("err", err))
+			}
+		}
+	}
+	filepath.WalkDir(GetCommandsStoragePath(), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			restrict(path)
+		}
+		return nil
+	})
+	for _, file := range PendingFiles() {
+		restrict(os.ExpandEnv("$HOME/" + file))
+	}
+}
+
+// sealStorageRecord encrypts a record when encryption is on.
+func sealStorageRecord(plain []byte) ([]byte, error) {
+	storageKeys.mu.Lock()
+	if !storageKeys.enabled {
+		storageKeys.mu.Unlock()
+		return plain, nil
+	}
+	err := storageKeys.loadLocked()
+	id, key := storageKeys.current, storageKeys.keys[storageKeys.current]
+	storageKeys.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStorageKeyUnavailable, err)
+	}
+
+	ciphertext, nonce, err := NewAESGCMService().Encrypt(key, plain)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, 0, len(storageRecordPrefix)+len(id)+1+base64.StdEncoding.EncodedLen(len(nonce)+len(ciphertext)))
+	sealed = append(sealed, storageRecordPrefix...)
+	sealed = append(sealed, id...)
+	sealed = append(sealed, ':')
+	return base64.StdEncoding.AppendEncode(sealed, append(nonce, ciphertext...)), nil
+}
+
+// openStorageRecord decrypts an encrypted record and returns a plaintext one
+// as it is.
+func openStorageRecord(record []byte) ([]byte, error) {
+	if !bytes.HasPrefix(record, []byte(storageRecordPrefix)) {
+		return record, nil
+	}
+	id, encoded, ok := bytes.Cut(record[len(storageRecordPrefix):], []byte{':'})
+	if !ok {
+		return nil, errors.New("invalid encrypted record")
+	}
+	sealed, err := base64.StdEncoding.AppendDecode(nil, encoded)
+	if err != nil || len(sealed) < 12 {
+		return nil, errors.New("invalid encrypted record")
+	}
+
+	storageKeys.mu.Lock()
+	err = storageKeys.loadLocked()
+	key, found := storageKeys.keys[string(id)]
+	storageKeys.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStorageKeyUnavailable, err)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: record encrypted with unknown key %s", ErrStorageKeyUnavailable, id)
+	}
+	return NewAESGCMService().Decrypt(key, sealed[12:], sealed[:12])
+}
+
+// storageResealer is implemented by the stores that encrypt their records.
+type storageResealer interface {
+	// reseal rewrites every record with the current key and returns how
+	// many were rewritten.
+	reseal(ctx context.Context) (int, error)
+}
+
+// StorageKeyRotation describes a key rotation.
+type StorageKeyRotation struct {
+	OldKeyID string `json:"oldKeyId"`
+	NewKeyID string `json:"newKeyId"`
+	// Resealed counts the records encrypted with the new key.
+	Resealed int `json:"resealed"`
+	// KeyFile is where the new key was written. It is empty with a key
+	// command, which must print the new key from now on.
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// RotateStorageKey encrypts every record of stores with a new key. Pass the
+// file store along with the configured engine's: it holds the txt files the
+// hooks write while the daemon is down, and the shared archive.
+//
+// With a key file, the new key is generated and replaces it; the old one is
+// kept as <keyFile>.old for the records other processes still write with it.
+// With a key command, newKeyCommand prints the new key and keyCommand must be
+// switched to it afterwards. An interrupted rotation resumes when run again.
+func RotateStorageKey(ctx context.Context, stores []CommandStore, newKeyCommand string) (StorageKeyRotation, error) {
+	storageKeys.mu.Lock()
+	defer storageKeys.mu.Unlock()
+
+	var rotation StorageKeyRotation
+	if !storageKeys.enabled {
+		return rotation, errors.New("storage encryption is not enabled")
+	}
+	if err := storageKeys.loadLocked(); err != nil {
+		return rotation, fmt.Errorf("%w: %v", ErrStorageKeyUnavailable, err)
+	}
+	rotation.OldKeyID = storageKeys.current
+
+	var newKey, keyFile string
+	if storageKeys.config.KeyCommand != "" {
+		if newKeyCommand == "" {
+			return rotation, errors.New("storage.encryption.keyCommand is set: pass the command printing the new key")
+		}
+		key, err := runStorageKeyCommand(newKeyCommand)
+		if err != nil {
+			return rotation, err
+		}
+		newKey = key
+	} else {
+		keyFile = storageKeys.keyFilePath()
+		// resume an interrupted rotation with the key it started
+		key, err := readStorageKeyFile(keyFile + ".new")
+		if os.IsNotExist(err) {
+			generated, _, genErr := NewAESGCMService().GenerateKeys()
+			if genErr != nil {
+				return rotation, genErr
+			}
+			key = string(generated)
+			err = writeStorageKeyFile(keyFile+".new", key)
+		}
+		if err != nil {
+			return rotation, err
+		}
+		newKey = key
+	}
+
+	if err := storageKeys.addKey(newKey, true); err != nil {
+		return rotation, err
+	}
+	rotation.NewKeyID = storageKeys.current
+	if rotation.NewKeyID == rotation.OldKeyID {
+		return rotation, errors.New("the new storage key is the current one")
+	}
+
+	// the stores seal with the new key while resealing
+	storageKeys.mu.Unlock()
+	var err error
+	for _, store := range stores {
+		resealer, ok := store.(storageResealer)
+		if !ok {
+			continue
+		}
+		n, resealErr := resealer.reseal(ctx)
+		rotation.Resealed += n
+		if resealErr != nil {
+			err = fmt.Errorf("failed to re-encrypt the %s store: %w", store.Engine(), resealErr)
+			break
+		}
+	}
+	if err == nil {
+		// the retry queues are encrypted too, and the old key is deleted on
+		// the next rotation
+		n, resealErr := resealPendingFiles()
+		rotation.Resealed += n
+		if resealErr != nil {
+			err = fmt.Errorf("failed to re-encrypt the pending files: %w", resealErr)
+		}
+	}
+	storageKeys.mu.Lock()
+	if err != nil {
+		return rotation, err
+	}
+
+	if keyFile != "" {
+		os.Remove(keyFile + ".old")
+		if err := os.Rename(keyFile, keyFile+".old"); err != nil {
+			return rotation, err
+		}
+		if err := os.Rename(keyFile+".new", keyFile); err != nil {
+			return rotation, err
+		}
+		rotation.KeyFile = keyFile
+	}
+	slog.Info("Rotated storage encryption key", slog.String("from", rotation.OldKeyID), slog.String("to", rotation.NewKeyID), slog.Int("resealed", rotation.Resealed))
+	return rotation, nil
+}
+
+// marshalStorageRecord encodes v as JSON, encrypted when encryption is on.
+func marshalStorageRecord(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return sealStorageRecord(data)
+}
+
+// unmarshalStorageRecord decodes a record written by marshalStorageRecord.
+func unmarshalStorageRecord(data []byte, v interface{}) error {
+	data, err := openStorageRecord(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// resealStorageFile encrypts every record of a txt store file with the
+// current key and returns the offsets of the rewritten lines. A malformed
+// line is kept as it is for fsck. The file is left readable by its owner
+// only, like the ones created since encryption was added.
+func resealStorageFile(path string) ([]int64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	offsets := make([]int64, 0)
+	for _, line := range bytes.Split(content, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		plain, err := openStorageRecord(line)
+		if errors.Is(err, ErrStorageKeyUnavailable) {
+			return nil, err
+		}
+		if err == nil {
+			if line, err = sealStorageRecord(plain); err != nil {
+				return nil, err
+			}
+		}
+		offsets = append(offsets, int64(buf.Len()))
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return offsets, ReplaceFile(path, buf.Bytes())
+}
+
+func (s *fileStore) reseal(ctx context.Context) (int, error) {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	resealed := 0
+	for _, path := range []string{GetPreCommandFilePath(), GetPostCommandFilePath(), GetSessionEventFilePath()} {
+		offsets, err := resealStorageFile(path)
+		resealed += len(offsets)
+		if err != nil {
+			return resealed, err
+		}
+	}
+	n, err := resealArchiveFile()
+	return resealed + n, err
+}
+
+// resealArchiveFile encrypts the file archive with the current key. Its
+// lines change size, so the index is rewritten too.
+func resealArchiveFile() (int, error) {
+	index, err := readArchiveIndex()
+	if err != nil {
+		return 0, err
+	}
+	offsets, err := resealStorageFile(GetArchiveFilePath())
+	if err != nil {
+		return len(offsets), err
+	}
+	if len(offsets) != len(index) {
+		return len(offsets), fmt.Errorf("the archive index doesn't match %s", GetArchiveFilePath())
+	}
+	if len(index) == 0 {
+		return 0, nil
+	}
+	for i := range index {
+		index[i].offset = offsets[i]
+	}
+	return len(offsets), ReplaceFile(GetArchiveIndexFilePath(), encodeArchiveIndex(index))
+}
+
+// resealPendingFiles encrypts the payloads of the sync and heartbeat retry
+// queues with the current key, holding pendingFilesMu so no payload is
+// appended meanwhile.
+func resealPendingFiles() (int, error) {
+	pendingFilesMu.Lock()
+	defer pendingFilesMu.Unlock()
+
+	resealed := 0
+	for _, file := range PendingFiles() {
+		offsets, err := resealStorageFile(os.ExpandEnv("$HOME/" + file))
+		resealed += len(offsets)
+		if err != nil {
+			return resealed, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return resealed, nil
+}
+
+// reseal encrypts every segment with the current key, one locked segment at
+// a time. The archive the engine shares with the file store is left to the
+// file store's reseal.
+func (s *segmentStore) reseal(ctx context.Context) (int, error) {
+	resealed := 0
+	for _, kind := range fsckKinds {
+		paths, err := s.segmentPaths(kind)
+		if err != nil {
+			return resealed, err
+		}
+		for _, path := range paths {
+			n, err := resealSegment(path)
+			resealed += n
+			if err != nil {
+				return resealed, fmt.Errorf("failed to re-encrypt segment %s: %w", path, err)
+			}
+		}
+	}
+	return resealed, nil
+}
+
+func resealSegment(path string) (int, error) {
+	f, err := openSegment(path, true, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer closeSegment(f)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	records, end, _ := decodeSegmentRecords(data, 0)
+	buf := bytes.Buffer{}
+	kept := make([]segmentRecord, 0, len(records))
+	for _, r := range records {
+		payload := r.payload
+		plain, err := openStorageRecord(payload)
+		if errors.Is(err, ErrStorageKeyUnavailable) {
+			return 0, err
+		}
+		if err == nil {
+			if payload, err = sealStorageRecord(plain); err != nil {
+				return 0, err
+			}
+		}
+		kept = append(kept, segmentRecord{nano: r.nano, offset: int64(buf.Len())})
+		buf.Write(encodeSegmentRecord(r.nano, payload))
+	}
+	// a damaged tail is left for fsck
+	buf.Write(data[end:])
+
+	if err := ReplaceFile(path, buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(kept), ReplaceFile(segmentIndexPath(path), encodeSegmentIndex(kept))
+}
+
+// reseal encrypts the records of every bucket with the current key in a
+// single transaction.
+func (s *boltStore) reseal(ctx context.Context) (int, error) {
+	resealed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{activeBucket, archivedBucket, sessionsBucket, archiveBucket} {
+			b := tx.Bucket([]byte(name))
+			if b == nil {
+				return fmt.Errorf("bucket %s not found", name)
+			}
+			var keys, values [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				plain, err := openStorageRecord(v)
+				if errors.Is(err, ErrStorageKeyUnavailable) {
+					return err
+				}
+				if err != nil {
+					return nil
+				}
+				sealed, err := sealStorageRecord(plain)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, sealed)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for i, k := range keys {
+				if err := b.Put(k, values[i]); err != nil {
+					return err
+				}
+			}
+			resealed += len(keys)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return resealed, nil
+}
@@ -0,0 +1,349 @@
+package model
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+// setupEncryptionTest starts every test with a fresh HOME and keyring, and
+// turns encryption on with the given settings.
+func setupEncryptionTest(t *testing.T, config StorageEncryptionConfig) {
+	t.Helper()
+	setupMigrateTest(t)
+	clearStorageKeys(t)
+	enabled := true
+	config.Enabled = &enabled
+	ConfigureStorageEncryption(ShellTimeConfig{Storage: &StorageConfig{Encryption: &config}})
+}
+
+// clearStorageKeys turns encryption off and forgets the keys, now and after
+// the test.
+func clearStorageKeys(t *testing.T) {
+	t.Helper()
+	forget := func() {
+		storageKeys.mu.Lock()
+		defer storageKeys.mu.Unlock()
+		storageKeys.resetLocked(StorageEncryptionConfig{}, false)
+	}
+	forget()
+	t.Cleanup(forget)
+}
+
+// resetStorageKeys forgets the loaded keys, as a new process would.
+func resetStorageKeys() {
+	storageKeys.mu.Lock()
+	defer storageKeys.mu.Unlock()
+	storageKeys.resetLocked(storageKeys.config, storageKeys.enabled)
+}
+
+func writeTestKeyFile(t *testing.T, path string) {
+	t.Helper()
+	key, _, err := NewAESGCMService().GenerateKeys()
+	require.NoError(t, err)
+	require.NoError(t, writeStorageKeyFile(path, string(key)))
+}
+
+func TestStorageEncryption_FileStore(t *testing.T) {
+	setupEncryptionTest(t, StorageEncryptionConfig{})
+	ctx := context.Background()
+	s := newFileStore()
+	now := time.Now()
+	cmd := segmentCommand("export TOKEN=secret", now)
+	require.NoError(t, s.SavePre(ctx, cmd, now))
+	require.NoError(t, s.SavePost(ctx, cmd, 0, now.Add(time.Second)))
+	require.NoError(t, s.SaveSessionEvent(ctx, SessionEvent{SessionID: 7, Type: SessionEventStart}, now))
+
+	for _, path := range []string{GetPreCommandFilePath(), GetPostCommandFilePath(), GetSessionEventFilePath()} {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(content), storageRecordPrefix), path)
+		assert.NotContains(t, string(content), "secret")
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), path)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(GetStorageKeyFilePath())
+		require.NoError(t, err, "the key file is created on first use")
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	resetStorageKeys()
+	pre, err := s.GetPreCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, pre, 1)
+	assert.Equal(t, "export TOKEN=secret", pre[0].Command)
+	assert.Equal(t, now.UnixNano(), pre[0].RecordingTime.UnixNano())
+	post, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, post, 1)
+	events, err := s.GetSessionEvents(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, int64(7), events[0].SessionID)
+}
+
+func TestStorageEncryption_PlaintextStaysReadable(t *testing.T) {
+	setupMigrateTest(t)
+	clearStorageKeys(t)
+	ConfigureStorageEncryption(ShellTimeConfig{})
+	ctx := context.Background()
+	s := newFileStore()
+	now := time.Now()
+	require.NoError(t, s.SavePre(ctx, segmentCommand("before", now), now))
+
+	enabled := true
+	ConfigureStorageEncryption(ShellTimeConfig{Storage: &StorageConfig{Encryption: &StorageEncryptionConfig{Enabled: &enabled}}})
+	require.NoError(t, s.SavePre(ctx, segmentCommand("after", now.Add(time.Second)), now.Add(time.Second)))
+
+	pre, err := s.GetPreCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, pre, 2)
+	assert.Equal(t, "before", pre[0].Command)
+	assert.Equal(t, "after", pre[1].Command)
+
+	// turning encryption off again keeps the encrypted records readable
+	ConfigureStorageEncryption(ShellTimeConfig{})
+	pre, err = s.GetPreCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, pre, 2)
+	assert.Equal(t, "after", pre[1].Command)
+}
+
+func TestStorageEncryption_ConfiguredWhereStoresOpen(t *testing.T) {
+	setupMigrateTest(t)
+	clearStorageKeys(t)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("storage:\n  encryption:\n    enabled: true\n"), 0600))
+
+	// reading the config alone doesn't touch the keyring
+	cfg, err := NewConfigService(dir).ReadConfigFile(context.Background())
+	require.NoError(t, err)
+	assert.False(t, StorageEncryptionEnabled())
+
+	store := NewLocalStore(cfg)
+	defer store.Close()
+	assert.True(t, StorageEncryptionEnabled())
+}
+
+func TestStorageEncryption_BoltStore(t *testing.T) {
+	setupEncryptionTest(t, StorageEncryptionConfig{})
+	ctx := context.Background()
+	s, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	require.NoError(t, s.SavePost(ctx, segmentCommand("export TOKEN=secret", now), 0, now))
+	require.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket([]byte(archivedBucket)).Cursor().First()
+		assert.True(t, strings.HasPrefix(string(v), storageRecordPrefix))
+		assert.NotContains(t, string(v), "secret")
+		return nil
+	}))
+
+	post, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, post, 1)
+	assert.Equal(t, "export TOKEN=secret", post[0].Command)
+}
+
+func TestStorageEncryption_KeyUnavailable(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "storage.key")
+	setupEncryptionTest(t, StorageEncryptionConfig{KeyFile: keyFile})
+	ctx := context.Background()
+	s := newFileStore()
+	now := time.Now()
+	require.NoError(t, s.SavePost(ctx, segmentCommand("ls", now), 0, now))
+
+	require.NoError(t, os.Remove(keyFile))
+	ConfigureStorageEncryption(ShellTimeConfig{})
+	resetStorageKeys()
+	_, err := s.GetPostCommands(ctx)
+	assert.ErrorIs(t, err, ErrStorageKeyUnavailable, "the record isn't skipped")
+	assert.ErrorIs(t, s.Prune(ctx, now), ErrStorageKeyUnavailable)
+
+	_, err = FsckStore(ctx, s, FsckOptions{Repair: true})
+	assert.ErrorIs(t, err, ErrStorageKeyUnavailable, "fsck doesn't quarantine it")
+	content, err := os.ReadFile(GetPostCommandFilePath())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), storageRecordPrefix))
+}
+
+func TestStorageEncryption_KeyFileOpenToOthers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't enforced on windows")
+	}
+	keyFile := filepath.Join(t.TempDir(), "storage.key")
+	setupEncryptionTest(t, StorageEncryptionConfig{KeyFile: keyFile})
+	writeTestKeyFile(t, keyFile)
+	require.NoError(t, os.Chmod(keyFile, 0644))
+
+	err := newFileStore().SavePre(context.Background(), segmentCommand("ls", time.Now()), time.Now())
+	assert.ErrorIs(t, err, ErrStorageKeyUnavailable)
+	assert.ErrorContains(t, err, "chmod 600")
+}
+
+func TestRotateStorageKey(t *testing.T) {
+	setupEncryptionTest(t, StorageEncryptionConfig{})
+	ctx := context.Background()
+	file := newFileStore()
+	bolt, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer bolt.Close()
+
+	now := time.Now()
+	require.NoError(t, file.SavePost(ctx, segmentCommand("file", now), 0, now))
+	require.NoError(t, file.AppendArchive(ctx, []ArchivedCommand{archiveRecord("archived", 1, now)}))
+	require.NoError(t, bolt.SavePost(ctx, segmentCommand("bolt", now), 0, now))
+	oldKey, err := os.ReadFile(GetStorageKeyFilePath())
+	require.NoError(t, err)
+
+	rotation, err := RotateStorageKey(ctx, []CommandStore{file, bolt}, "")
+	require.NoError(t, err)
+	assert.Equal(t, 3, rotation.Resealed)
+	assert.NotEqual(t, rotation.OldKeyID, rotation.NewKeyID)
+	assert.Equal(t, GetStorageKeyFilePath(), rotation.KeyFile)
+	retired, err := os.ReadFile(GetStorageKeyFilePath() + ".old")
+	require.NoError(t, err)
+	assert.Equal(t, oldKey, retired)
+	assert.NoFileExists(t, GetStorageKeyFilePath()+".new")
+
+	content, err := os.ReadFile(GetPostCommandFilePath())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), storageRecordPrefix+rotation.NewKeyID+":"))
+
+	// a new process reads everything with the new key
+	resetStorageKeys()
+	require.NoError(t, os.Remove(GetStorageKeyFilePath()+".old"))
+	post, err := file.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, post, 1)
+	archived, err := file.QueryArchive(ctx, ArchiveQuery{})
+	require.NoError(t, err)
+	require.Len(t, archived, 1)
+	assert.Equal(t, "archived", archived[0].Command)
+	post, err = bolt.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, post, 1)
+	assert.Equal(t, "bolt", post[0].Command)
+}
+
+func TestRotateStorageKey_KeyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the key commands use cat")
+	}
+	dir := t.TempDir()
+	oldKey, newKey := filepath.Join(dir, "old.key"), filepath.Join(dir, "new.key")
+	writeTestKeyFile(t, oldKey)
+	writeTestKeyFile(t, newKey)
+	setupEncryptionTest(t, StorageEncryptionConfig{KeyCommand: "cat " + oldKey})
+	ctx := context.Background()
+	s := newFileStore()
+	now := time.Now()
+	require.NoError(t, s.SavePre(ctx, segmentCommand("ls", now), now))
+
+	_, err := RotateStorageKey(ctx, []CommandStore{s}, "")
+	assert.ErrorContains(t, err, "keyCommand is set")
+
+	rotation, err := RotateStorageKey(ctx, []CommandStore{s}, "cat "+newKey)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rotation.Resealed)
+	assert.Empty(t, rotation.KeyFile)
+
+	enabled := true
+	ConfigureStorageEncryption(ShellTimeConfig{Storage: &StorageConfig{Encryption: &StorageEncryptionConfig{Enabled: &enabled, KeyCommand: "cat " + newKey}}})
+	pre, err := s.GetPreCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, pre, 1)
+	assert.Equal(t, "ls", pre[0].Command)
+}
+
+func TestRotateStorageKey_Disabled(t *testing.T) {
+	setupMigrateTest(t)
+	clearStorageKeys(t)
+	ConfigureStorageEncryption(ShellTimeConfig{})
+
+	_, err := RotateStorageKey(context.Background(), []CommandStore{newFileStore()}, "")
+	assert.ErrorContains(t, err, "not enabled")
+}
+
+func TestStorageEncryption_SegmentStore(t *testing.T) {
+	setupEncryptionTest(t, StorageEncryptionConfig{})
+	ctx := context.Background()
+	s := newSegmentStore(GetSegmentStoragePath())
+	now := time.Now()
+	require.NoError(t, s.SavePre(ctx, segmentCommand("export TOKEN=secret", now), now))
+
+	rotation, err := RotateStorageKey(ctx, []CommandStore{newFileStore(), s}, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, rotation.Resealed)
+
+	resetStorageKeys()
+	pre, err := s.GetPreCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, pre, 1)
+	assert.Equal(t, "export TOKEN=secret", pre[0].Command)
+	records, err := s.records(segmentKindPre)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.True(t, strings.HasPrefix(string(records[0].payload), storageRecordPrefix+rotation.NewKeyID+":"))
+}
+
+func TestRotateStorageKey_PendingFiles(t *testing.T) {
+	setupEncryptionTest(t, StorageEncryptionConfig{})
+	ctx := context.Background()
+	path := filepath.Join(os.Getenv("HOME"), SyncPendingFileFor("abc"))
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, AppendPendingLine(path, []byte(`{"type":"sync","payload":{"data":[]}}`)))
+
+	rotation, err := RotateStorageKey(ctx, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, rotation.Resealed)
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), storageRecordPrefix+rotation.NewKeyID+":"))
+
+	// after a second rotation deletes the first key, the payload still opens
+	_, err = RotateStorageKey(ctx, nil, "")
+	require.NoError(t, err)
+	resetStorageKeys()
+	raw, err = os.ReadFile(path)
+	require.NoError(t, err)
+	line, err := OpenPendingLine([]byte(strings.TrimSpace(string(raw))))
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"sync","payload":{"data":[]}}`, string(line))
+}
+
+func TestStorageEncryption_RestrictsOlderFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on windows")
+	}
+	setupEncryptionTest(t, StorageEncryptionConfig{})
+	require.NoError(t, ensureStorageFolder())
+	require.NoError(t, os.WriteFile(GetPostCommandFilePath(), nil, 0o644))
+	require.NoError(t, os.Chmod(GetPostCommandFilePath(), 0o644))
+	pending := filepath.Join(os.Getenv("HOME"), HEARTBEAT_LOG_FILE)
+	require.NoError(t, os.WriteFile(pending, nil, 0o644))
+	require.NoError(t, os.Chmod(pending, 0o644))
+
+	now := time.Now()
+	require.NoError(t, newFileStore().SavePost(context.Background(), segmentCommand("a", now), 0, now))
+
+	for _, path := range []string{GetPostCommandFilePath(), pending} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), path)
+	}
+}
@@ -8,16 +8,22 @@ import (
 // CommandStore abstracts persistence of tracked commands so the buffering
 // backend can be swapped without touching call sites.
 //
-// Two implementations exist:
+// Three implementations exist:
 //   - fileStore: the historical append-only txt files (pre.txt/post.txt/cursor.txt).
 //     Always available; used as the fallback whenever bolt is disabled or no daemon
 //     is running.
 //   - boltStore: a bbolt embedded KV store. bbolt holds an exclusive OS file lock,
 //     so it is meant to be owned by the single long-lived daemon process.
+//   - segmentStore: per-day append-only segment files with CRC-checked records.
+//     It only takes short per-segment locks, so the CLI and the daemon can both
+//     open it.
 //
-// Pre commands live in the "active" bucket, post commands in "archived"; the
-// sync cursor lives in a "meta" bucket. These names mirror the activeBucket /
-// archivedBucket constants in command.go.
+// Pre commands live in the "active" bucket, post commands in "archived" and
+// session events in "sessions"; the sync cursor lives in a "meta" bucket. These
+// names mirror the activeBucket / archivedBucket constants in command.go.
+//
+// All stores also implement ArchiveStore, the optional local history archive
+// that outlives Prune.
 type CommandStore interface {
 	// SavePre persists a pre-execution command record.
 	SavePre(ctx context.Context, cmd Command, recordingTime time.Time) error
@@ -32,22 +38,35 @@ type CommandStore interface {
 	// GetPostCommands returns all post commands with RecordingTime populated.
 	GetPostCommands(ctx context.Context) ([]*Command, error)
 
+	// SaveSessionEvent persists a shell session start/end event.
+	SaveSessionEvent(ctx context.Context, ev SessionEvent, recordingTime time.Time) error
+	// GetSessionEvents returns all session events with RecordingTime populated.
+	GetSessionEvents(ctx context.Context) ([]*SessionEvent, error)
+
 	// GetLastCursor returns the last synced recording time. noCursorExist is true
 	// when no cursor has ever been written (first sync).
 	GetLastCursor(ctx context.Context) (cursorTime time.Time, noCursorExist bool, err error)
 	// SetCursor advances the sync cursor.
 	SetCursor(ctx context.Context, cursor time.Time) error
 
+	// GetEndpointCursors returns the sync cursor of every endpoint that has
+	// one, by EndpointKey. The store's own cursor trails the slowest of them.
+	GetEndpointCursors(ctx context.Context) (map[string]time.Time, error)
+	// SetEndpointCursor advances the sync cursor of one endpoint.
+	SetEndpointCursor(ctx context.Context, key string, cursor time.Time) error
+
 	// Prune removes records that have already been synced (recording time at or
-	// before the cursor), keeping unfinished pre commands.
+	// before the cursor), keeping unfinished pre commands. Session events are
+	// pruned by the same rule.
 	Prune(ctx context.Context, cursor time.Time) error
 
-	// Engine reports which storage engine backs this store (StorageEngineFile
-	// or StorageEngineBolt). It is attached to sync metadata so the server
-	// knows how the commands were buffered.
+	// Engine reports which storage engine backs this store (StorageEngineFile,
+	// StorageEngineBolt or StorageEngineSegment). It is attached to sync
+	// metadata so the server knows how the commands were buffered.
 	Engine() string
 
-	// Close releases any resources held by the store (no-op for fileStore).
+	// Close releases any resources held by the store (no-op for fileStore and
+	// segmentStore).
 	Close() error
 }
 
@@ -57,6 +76,9 @@ const StorageEngineFile = "file"
 // StorageEngineBolt selects the bbolt backend (daemon-owned).
 const StorageEngineBolt = "bolt"
 
+// StorageEngineSegment selects the segmented append-only log backend.
+const StorageEngineSegment = "segment"
+
 // NewFileStore returns the txt-file backed store. The CLI uses this for the
 // fallback path (bolt disabled or no daemon) and must never open the bolt DB
 // directly, since the daemon holds its exclusive lock.
@@ -79,15 +101,30 @@ func NewCommandStore(cfg ShellTimeConfig) (CommandStore, error) {
 	switch engine {
 	case StorageEngineBolt:
 		return newBoltStore(GetBoltDBPath())
+	case StorageEngineSegment:
+		return newSegmentStore(GetSegmentStoragePath()), nil
 	default:
 		return newFileStore(), nil
 	}
 }
 
+// NewLocalStore returns the store the CLI may open itself when no daemon
+// takes the event: the segment store when it is selected, otherwise the txt
+// file store (the bolt DB is locked by the daemon). It applies the
+// storage.encryption settings of cfg, see ConfigureStorageEncryption.
+func NewLocalStore(cfg ShellTimeConfig) CommandStore {
+	ConfigureStorageEncryption(cfg)
+	if cfg.Storage != nil && cfg.Storage.Engine == StorageEngineSegment {
+		return newSegmentStore(GetSegmentStoragePath())
+	}
+	return newFileStore()
+}
+
 // preHasSyncedPost reports whether posts contains a synced post command (recording
 // time at or before cursor) that completes the given pre command: same unique key
-// and running at or after the pre started. Prune uses it to drop finished, synced
-// pre rows while keeping unfinished ones.
+// (or across a session ID change, see crossesSessionIDChange) and running at or
+// after the pre started. Prune uses it to drop finished, synced pre rows while
+// keeping unfinished ones.
 //
 // Note the direction: a post always runs after its pre, so matching must be
 // post.Time >= pre.Time. (Command.FindClosestCommand only accepts candidates at or
@@ -104,7 +141,7 @@ func preHasSyncedPost(pre *Command, posts []*Command, cursor time.Time) bool {
 		if p.RecordingTime.After(cursor) {
 			continue // post not yet synced; keep the pre for the next sync
 		}
-		if p.GetUniqueKey() != key {
+		if p.GetUniqueKey() != key && !crossesSessionIDChange(pre, p) {
 			continue
 		}
 		if !p.Time.Before(pre.Time) {
@@ -3,7 +3,7 @@ package model
 import (
 	"context"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -16,17 +16,30 @@ import (
 const (
 	// metaBucket holds singleton values such as the sync cursor.
 	metaBucket = "meta"
+	// sessionsBucket holds shell session start/end events.
+	sessionsBucket = "sessions"
 	// cursorKey is the key under metaBucket storing the last synced recording time.
 	cursorKey = "cursor"
+	// endpointCursorsBucket holds the last synced recording time of every
+	// sync endpoint, by EndpointKey.
+	endpointCursorsBucket = "endpoint_cursors"
 	// boltOpenTimeout bounds how long we wait for the exclusive file lock before
 	// giving up, so a stale lock can't hang the daemon forever.
 	boltOpenTimeout = 5 * time.Second
+	// boltReadOnlyTimeout bounds how long the CLI waits for the shared lock
+	// when it reads the DB without the daemon.
+	boltReadOnlyTimeout = time.Second
 )
 
+// ErrBoltStoreLocked is returned by OpenBoltStoreReadOnly when another
+// process, normally the daemon, holds the bolt DB.
+var ErrBoltStoreLocked = errors.New("the bolt store is locked by another process")
+
 // boltStore persists commands in a bbolt database. It holds an exclusive OS
 // file lock for its lifetime, so only the daemon should own one.
 type boltStore struct {
-	db *bolt.DB
+	db   *bolt.DB
+	path string
 }
 
 func newBoltStore(path string) (*boltStore, error) {
@@ -40,7 +53,7 @@ func newBoltStore(path string) (*boltStore, error) {
 	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
-		for _, name := range []string{activeBucket, archivedBucket, metaBucket} {
+		for _, name := range []string{activeBucket, archivedBucket, sessionsBucket, metaBucket, endpointCursorsBucket, archiveBucket, archiveSessionBucket} {
 			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
 				return err
 			}
@@ -52,7 +65,27 @@ func newBoltStore(path string) (*boltStore, error) {
 		return nil, fmt.Errorf("failed to init bolt buckets: %w", err)
 	}
 
-	return &boltStore{db: db}, nil
+	return &boltStore{db: db, path: path}, nil
+}
+
+// OpenBoltStoreReadOnly opens the bolt DB for reading while no daemon holds
+// it, so the CLI can list the commands in it. It returns an error wrapping
+// os.ErrNotExist when there is no DB yet, and ErrBoltStoreLocked when another
+// process holds it. Callers must Close the store; its write methods fail.
+func OpenBoltStoreReadOnly() (CommandStore, error) {
+	path := GetBoltDBPath()
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: boltReadOnlyTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%w: %s", ErrBoltStoreLocked, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt db %s: %w", path, err)
+	}
+	return &boltStore{db: db, path: path}, nil
 }
 
 // encodeKey produces a time-ordered, collision-free key:
@@ -72,10 +105,14 @@ func decodeKeyNano(key []byte) int64 {
 }
 
 func (s *boltStore) put(bucket string, cmd Command, recordingTime time.Time) error {
-	val, err := json.Marshal(cmd)
+	val, err := marshalStorageRecord(cmd)
 	if err != nil {
 		return err
 	}
+	return s.putRaw(bucket, val, recordingTime)
+}
+
+func (s *boltStore) putRaw(bucket string, val []byte, recordingTime time.Time) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		if b == nil {
@@ -98,7 +135,10 @@ func (s *boltStore) all(bucket string) ([]*Command, error) {
 		}
 		return b.ForEach(func(k, v []byte) error {
 			cmd := new(Command)
-			if err := json.Unmarshal(v, cmd); err != nil {
+			if err := unmarshalStorageRecord(v, cmd); err != nil {
+				if errors.Is(err, ErrStorageKeyUnavailable) {
+					return err
+				}
 				slog.Warn("failed to unmarshal command from bolt", slog.Any("err", err))
 				return nil
 			}
@@ -112,6 +152,7 @@ func (s *boltStore) all(bucket string) ([]*Command, error) {
 
 func (s *boltStore) SavePre(ctx context.Context, cmd Command, recordingTime time.Time) error {
 	cmd.Phase = CommandPhasePre
+	cmd.ID = cmd.RecordID()
 	return s.put(activeBucket, cmd, recordingTime)
 }
 
@@ -119,6 +160,7 @@ func (s *boltStore) SavePost(ctx context.Context, cmd Command, result int, recor
 	cmd.Phase = CommandPhasePost
 	cmd.Result = result
 	cmd.EndTime = time.Now()
+	cmd.ID = cmd.RecordID()
 	return s.put(archivedBucket, cmd, recordingTime)
 }
 
@@ -143,6 +185,38 @@ func (s *boltStore) GetPostCommands(ctx context.Context) ([]*Command, error) {
 	return s.all(archivedBucket)
 }
 
+func (s *boltStore) SaveSessionEvent(ctx context.Context, ev SessionEvent, recordingTime time.Time) error {
+	val, err := marshalStorageRecord(ev)
+	if err != nil {
+		return err
+	}
+	return s.putRaw(sessionsBucket, val, recordingTime)
+}
+
+func (s *boltStore) GetSessionEvents(ctx context.Context) ([]*SessionEvent, error) {
+	result := make([]*SessionEvent, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sessionsBucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", sessionsBucket)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			ev := new(SessionEvent)
+			if err := unmarshalStorageRecord(v, ev); err != nil {
+				if errors.Is(err, ErrStorageKeyUnavailable) {
+					return err
+				}
+				slog.Warn("failed to unmarshal session event from bolt", slog.Any("err", err))
+				return nil
+			}
+			ev.RecordingTime = time.Unix(0, decodeKeyNano(k))
+			result = append(result, ev)
+			return nil
+		})
+	})
+	return result, err
+}
+
 func (s *boltStore) GetLastCursor(ctx context.Context) (cursorTime time.Time, noCursorExist bool, err error) {
 	err = s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(metaBucket))
@@ -173,8 +247,35 @@ func (s *boltStore) SetCursor(ctx context.Context, cursor time.Time) error {
 	})
 }
 
-// Prune deletes synced post commands (recording time <= cursor) and the pre
-// commands they complete, keeping unfinished pre commands. It runs in a single
+func (s *boltStore) GetEndpointCursors(ctx context.Context) (map[string]time.Time, error) {
+	result := make(map[string]time.Time)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(endpointCursorsBucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", endpointCursorsBucket)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			result[string(k)] = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *boltStore) SetEndpointCursor(ctx context.Context, key string, cursor time.Time) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(cursor.UnixNano()))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(endpointCursorsBucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", endpointCursorsBucket)
+		}
+		return b.Put([]byte(key), buf)
+	})
+}
+
+// Prune deletes synced post commands and session events (recording time <=
+// cursor) and the pre commands they complete, keeping unfinished pre commands. It runs in a single
 // write transaction so the post set is consistent with the deletions.
 func (s *boltStore) Prune(ctx context.Context, cursor time.Time) error {
 	cursorNano := cursor.UnixNano()
@@ -195,7 +296,11 @@ func (s *boltStore) Prune(ctx context.Context, cursor time.Time) error {
 		var delArchived [][]byte
 		if err := archived.ForEach(func(k, v []byte) error {
 			cmd := new(Command)
-			if err := json.Unmarshal(v, cmd); err == nil {
+			err := unmarshalStorageRecord(v, cmd)
+			if errors.Is(err, ErrStorageKeyUnavailable) {
+				return err
+			}
+			if err == nil {
 				cmd.RecordingTime = time.Unix(0, decodeKeyNano(k))
 				postCommands = append(postCommands, cmd)
 			}
@@ -215,7 +320,10 @@ func (s *boltStore) Prune(ctx context.Context, cursor time.Time) error {
 				return nil // keep anything newer than the cursor
 			}
 			pre := new(Command)
-			if err := json.Unmarshal(v, pre); err != nil {
+			if err := unmarshalStorageRecord(v, pre); err != nil {
+				if errors.Is(err, ErrStorageKeyUnavailable) {
+					return err
+				}
 				return nil
 			}
 			pre.RecordingTime = time.Unix(0, nano)
@@ -227,6 +335,26 @@ func (s *boltStore) Prune(ctx context.Context, cursor time.Time) error {
 			return err
 		}
 
+		// Synced session events go with the commands they were sent alongside.
+		sessions := tx.Bucket([]byte(sessionsBucket))
+		if sessions == nil {
+			return fmt.Errorf("bucket %s not found", sessionsBucket)
+		}
+		var delSessions [][]byte
+		if err := sessions.ForEach(func(k, v []byte) error {
+			if decodeKeyNano(k) <= cursorNano {
+				delSessions = append(delSessions, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range delSessions {
+			if err := sessions.Delete(k); err != nil {
+				return err
+			}
+		}
+
 		for _, k := range delArchived {
 			if err := archived.Delete(k); err != nil {
 				return err
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"context"
+	"os"
 	"testing"
 	"time"
 
@@ -83,3 +85,32 @@ func TestPreHasSyncedPost(t *testing.T) {
 		assert.False(t, preHasSyncedPost(pre, []*Command{nil}, base.Add(2*time.Second)))
 	})
 }
+
+func TestOpenBoltStoreReadOnly(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+	require.NoError(t, ensureStorageFolder())
+
+	_, err := OpenBoltStoreReadOnly()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	ctx := context.Background()
+	s, err := NewCommandStore(ShellTimeConfig{Storage: &StorageConfig{Engine: StorageEngineBolt}})
+	require.NoError(t, err)
+	cmd := Command{Shell: "zsh", SessionID: 1, Command: "make", Username: "u", Time: time.Now()}
+	require.NoError(t, s.SavePre(ctx, cmd, cmd.Time))
+
+	// the daemon holds the DB
+	_, err = OpenBoltStoreReadOnly()
+	assert.ErrorIs(t, err, ErrBoltStoreLocked)
+	require.NoError(t, s.Close())
+
+	ro, err := OpenBoltStoreReadOnly()
+	require.NoError(t, err)
+	defer ro.Close()
+	pres, err := ro.GetPreCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, pres, 1)
+	assert.Equal(t, "make", pres[0].Command)
+	assert.Error(t, ro.SavePre(ctx, cmd, cmd.Time))
+}
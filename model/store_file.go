@@ -3,9 +3,11 @@ package model
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"sort"
 	"time"
 )
@@ -16,15 +18,45 @@ type fileStore struct{}
 
 func newFileStore() *fileStore { return &fileStore{} }
 
-func (s *fileStore) appendLine(path string, cmd Command, recordingTime time.Time) error {
+// lock takes the lock of the txt files across processes: shared for the
+// appends, which O_APPEND keeps apart, and exclusive for the rewrites that
+// replace a file, so a rewrite never drops a line appended meanwhile.
+func (s *fileStore) lock(exclusive bool) (unlock func(), err error) {
 	if err := ensureStorageFolder(); err != nil {
-		return err
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(GetCommandsStoragePath(), "LOCK"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
 	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock the command storage: %w", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// LockCommandStorage takes the exclusive lock of the txt files, for the
+// callers outside the store that rewrite them (shelltime gc). Appends wait
+// until unlock is called.
+func LockCommandStorage() (unlock func(), err error) {
+	return newFileStore().lock(true)
+}
+
+func (s *fileStore) appendLine(path string, cmd Command, recordingTime time.Time) error {
 	buf, err := cmd.ToLine(recordingTime)
 	if err != nil {
 		return err
 	}
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	unlock, err := s.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to open command storage file %s: %w", path, err)
 	}
@@ -37,6 +69,7 @@ func (s *fileStore) appendLine(path string, cmd Command, recordingTime time.Time
 
 func (s *fileStore) SavePre(ctx context.Context, cmd Command, recordingTime time.Time) error {
 	cmd.Phase = CommandPhasePre
+	cmd.ID = cmd.RecordID()
 	return s.appendLine(GetPreCommandFilePath(), cmd, recordingTime)
 }
 
@@ -44,6 +77,7 @@ func (s *fileStore) SavePost(ctx context.Context, cmd Command, result int, recor
 	cmd.Phase = CommandPhasePost
 	cmd.Result = result
 	cmd.EndTime = time.Now()
+	cmd.ID = cmd.RecordID()
 	return s.appendLine(GetPostCommandFilePath(), cmd, recordingTime)
 }
 
@@ -64,6 +98,9 @@ func (s *fileStore) GetPostCommands(ctx context.Context) ([]*Command, error) {
 	for _, line := range raw {
 		cmd := new(Command)
 		if _, err := cmd.FromLineBytes(line); err != nil {
+			if errors.Is(err, ErrStorageKeyUnavailable) {
+				return nil, err
+			}
 			slog.Warn("failed to parse post command line", slog.Any("err", err))
 			continue
 		}
@@ -72,14 +109,85 @@ func (s *fileStore) GetPostCommands(ctx context.Context) ([]*Command, error) {
 	return result, nil
 }
 
+func (s *fileStore) SaveSessionEvent(ctx context.Context, ev SessionEvent, recordingTime time.Time) error {
+	buf, err := ev.ToLine(recordingTime)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	path := GetSessionEventFilePath()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open session storage file %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf); err != nil {
+		return fmt.Errorf("failed to write session storage file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *fileStore) GetSessionEvents(ctx context.Context) ([]*SessionEvent, error) {
+	content, err := os.ReadFile(GetSessionEventFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result := make([]*SessionEvent, 0)
+	for _, line := range bytes.Split(content, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		ev := new(SessionEvent)
+		if err := ev.FromLineBytes(line); err != nil {
+			if errors.Is(err, ErrStorageKeyUnavailable) {
+				return nil, err
+			}
+			slog.Warn("failed to parse session event line", slog.Any("err", err))
+			continue
+		}
+		result = append(result, ev)
+	}
+	return result, nil
+}
+
+// pruneSessionEvents drops synced session events from the sessions file. The
+// caller holds the exclusive lock.
+func (s *fileStore) pruneSessionEvents(ctx context.Context, cursor time.Time) error {
+	events, err := s.GetSessionEvents(ctx)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	buf := bytes.Buffer{}
+	for _, ev := range events {
+		if !ev.RecordingTime.After(cursor) {
+			continue
+		}
+		line, err := ev.ToLine(ev.RecordingTime)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+	}
+	return ReplaceFile(GetSessionEventFilePath(), buf.Bytes())
+}
+
 func (s *fileStore) GetLastCursor(ctx context.Context) (time.Time, bool, error) {
 	return GetLastCursor(ctx)
 }
 
 func (s *fileStore) SetCursor(ctx context.Context, cursor time.Time) error {
-	if err := ensureStorageFolder(); err != nil {
+	unlock, err := s.lock(false)
+	if err != nil {
 		return err
 	}
+	defer unlock()
 	cursorFile, err := os.OpenFile(GetCursorFilePath(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return err
@@ -89,9 +197,28 @@ func (s *fileStore) SetCursor(ctx context.Context, cursor time.Time) error {
 	return err
 }
 
+func (s *fileStore) GetEndpointCursors(ctx context.Context) (map[string]time.Time, error) {
+	return readEndpointCursorFile(GetEndpointCursorFilePath())
+}
+
+func (s *fileStore) SetEndpointCursor(ctx context.Context, key string, cursor time.Time) error {
+	return writeEndpointCursorFile(GetEndpointCursorFilePath(), key, cursor)
+}
+
 // Prune compacts the txt files, dropping synced records and keeping unfinished
-// pre commands. Mirrors the historical gc cleanCommandFiles behavior.
+// pre commands. Mirrors the historical gc cleanCommandFiles behavior. It holds
+// the exclusive lock, so the lines appended meanwhile wait and are kept.
 func (s *fileStore) Prune(ctx context.Context, cursor time.Time) error {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.pruneSessionEvents(ctx, cursor); err != nil {
+		return err
+	}
+
 	postCommands, err := s.GetPostCommands(ctx)
 	if err != nil {
 		return err
@@ -146,13 +273,13 @@ func (s *fileStore) Prune(ctx context.Context, cursor time.Time) error {
 		postBuf.Write(line)
 	}
 
-	if err := os.WriteFile(GetPreCommandFilePath(), preBuf.Bytes(), 0644); err != nil {
+	if err := ReplaceFile(GetPreCommandFilePath(), preBuf.Bytes()); err != nil {
 		return err
 	}
-	if err := os.WriteFile(GetPostCommandFilePath(), postBuf.Bytes(), 0644); err != nil {
+	if err := ReplaceFile(GetPostCommandFilePath(), postBuf.Bytes()); err != nil {
 		return err
 	}
-	return os.WriteFile(GetCursorFilePath(), []byte(fmt.Sprintf("%d", cursor.UnixNano())), 0644)
+	return ReplaceFile(GetCursorFilePath(), []byte(fmt.Sprintf("%d", cursor.UnixNano())))
 }
 
 func (s *fileStore) Engine() string { return StorageEngineFile }
@@ -0,0 +1,547 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Problems reported by FsckStore.
+const (
+	// FsckMalformed is a record that can't be parsed. The readers skip it.
+	FsckMalformed = "malformed"
+	// FsckDuplicate is a record stored more than once, e.g. after an
+	// interrupted gc.
+	FsckDuplicate = "duplicate"
+	// FsckOrphanedPre is a pre command older than the pairing window without
+	// a post command. It can never be paired and gc keeps it forever.
+	FsckOrphanedPre = "orphaned_pre"
+	// FsckCursorAhead is a sync cursor later than every record and the clock;
+	// new commands would be taken for synced and never sent.
+	FsckCursorAhead = "cursor_ahead"
+	// FsckCursorInvalid is a cursor that can't be read.
+	FsckCursorInvalid = "cursor_invalid"
+)
+
+// FsckOptions tunes FsckStore.
+type FsckOptions struct {
+	// Repair quarantines malformed records and orphaned pre commands, drops
+	// duplicates and rebuilds a bad cursor.
+	Repair bool
+	// Now defaults to time.Now().
+	Now time.Time
+}
+
+// FsckIssue is one problem found in a store.
+type FsckIssue struct {
+	Problem string `json:"problem"`
+	// Kind is pre, post, sessions or cursor.
+	Kind     string `json:"kind"`
+	Location string `json:"location"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// FsckReport describes a store check.
+type FsckReport struct {
+	Engine  string      `json:"engine"`
+	Records int         `json:"records"`
+	Issues  []FsckIssue `json:"issues"`
+	// Repaired is set once the issues were fixed.
+	Repaired bool `json:"repaired,omitempty"`
+	// QuarantinedTo is the side file holding the removed records.
+	QuarantinedTo string `json:"quarantinedTo,omitempty"`
+	// Cursor is the rebuilt cursor, zero when it was left alone.
+	Cursor time.Time `json:"cursor,omitempty"`
+}
+
+// Count returns the number of issues of a problem class.
+func (r FsckReport) Count(problem string) int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Problem == problem {
+			n++
+		}
+	}
+	return n
+}
+
+// fsckRecord is one stored record, parsed when possible. The location
+// fields let the engine find it again for a repair.
+type fsckRecord struct {
+	kind     string
+	location string
+	nano     int64
+	raw      []byte
+	cmd      *Command
+	event    *SessionEvent
+	err      error
+
+	path   string // file and segment engines
+	line   int    // file engine, 1-based
+	offset int64  // segment engine
+	tail   bool   // segment engine: a damaged tail, not a record
+	key    []byte // bolt engine
+}
+
+// fsckBackend is implemented by the stores that can be checked.
+type fsckBackend interface {
+	fsckScan(kind string) ([]*fsckRecord, error)
+	fsckDrop(kind string, drop []*fsckRecord) error
+}
+
+var fsckKinds = []string{segmentKindPre, segmentKindPost, segmentKindSessions}
+
+func parseFsckRecord(rec *fsckRecord, payload []byte) {
+	if rec.kind == segmentKindSessions {
+		ev := new(SessionEvent)
+		if rec.err = unmarshalStorageRecord(payload, ev); rec.err == nil {
+			ev.RecordingTime = time.Unix(0, rec.nano)
+			rec.event = ev
+		}
+		return
+	}
+	cmd := new(Command)
+	if rec.err = unmarshalStorageRecord(payload, cmd); rec.err == nil {
+		cmd.RecordingTime = time.Unix(0, rec.nano)
+		rec.cmd = cmd
+	}
+}
+
+func (s *fileStore) kindPath(kind string) string {
+	switch kind {
+	case segmentKindPre:
+		return GetPreCommandFilePath()
+	case segmentKindPost:
+		return GetPostCommandFilePath()
+	}
+	return GetSessionEventFilePath()
+}
+
+func (s *fileStore) fsckScan(kind string) ([]*fsckRecord, error) {
+	path := s.kindPath(kind)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result := make([]*fsckRecord, 0)
+	for i, line := range bytes.Split(content, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		rec := &fsckRecord{kind: kind, path: path, line: i + 1, raw: line}
+		rec.location = fmt.Sprintf("%s:%d", filepath.Base(path), rec.line)
+		if kind == segmentKindSessions {
+			ev := new(SessionEvent)
+			if rec.err = ev.FromLineBytes(line); rec.err == nil {
+				rec.event, rec.nano = ev, ev.RecordingTime.UnixNano()
+			}
+		} else {
+			cmd := new(Command)
+			if _, rec.err = cmd.FromLineBytes(line); rec.err == nil {
+				rec.cmd, rec.nano = cmd, cmd.RecordingTime.UnixNano()
+			}
+		}
+		result = append(result, rec)
+	}
+	return result, nil
+}
+
+// fsckDrop rewrites the file without the dropped lines. The appends wait for
+// the rewrite, and the lines appended since the scan come after the scanned
+// ones, so they are kept.
+func (s *fileStore) fsckDrop(kind string, drop []*fsckRecord) error {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := s.kindPath(kind)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dropped := make(map[int][]byte, len(drop))
+	for _, rec := range drop {
+		dropped[rec.line] = rec.raw
+	}
+	buf := bytes.Buffer{}
+	for i, line := range bytes.Split(content, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if raw, ok := dropped[i+1]; ok && bytes.Equal(raw, line) {
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return ReplaceFile(path, buf.Bytes())
+}
+
+func boltKindBucket(kind string) string {
+	switch kind {
+	case segmentKindPre:
+		return activeBucket
+	case segmentKindPost:
+		return archivedBucket
+	}
+	return sessionsBucket
+}
+
+func (s *boltStore) fsckScan(kind string) ([]*fsckRecord, error) {
+	bucket := boltKindBucket(kind)
+	result := make([]*fsckRecord, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucket)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			rec := &fsckRecord{
+				kind: kind,
+				nano: decodeKeyNano(k),
+				raw:  append([]byte(nil), v...),
+				key:  append([]byte(nil), k...),
+			}
+			rec.location = fmt.Sprintf("%s/%x", bucket, k)
+			parseFsckRecord(rec, rec.raw)
+			result = append(result, rec)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *boltStore) fsckDrop(kind string, drop []*fsckRecord) error {
+	bucket := boltKindBucket(kind)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucket)
+		}
+		for _, rec := range drop {
+			if !bytes.Equal(b.Get(rec.key), rec.raw) {
+				continue
+			}
+			if err := b.Delete(rec.key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *segmentStore) fsckScan(kind string) ([]*fsckRecord, error) {
+	paths, err := s.segmentPaths(kind)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*fsckRecord, 0)
+	for _, path := range paths {
+		data, err := readSegmentData(path)
+		if err != nil {
+			return nil, err
+		}
+		records, end, tailErr := decodeSegmentRecords(data, 0)
+		name := filepath.Join(kind, filepath.Base(path))
+		for _, r := range records {
+			rec := &fsckRecord{kind: kind, path: path, offset: r.offset, nano: r.nano, raw: r.payload}
+			rec.location = fmt.Sprintf("%s@%d", name, r.offset)
+			parseFsckRecord(rec, r.payload)
+			result = append(result, rec)
+		}
+		if tailErr != nil {
+			result = append(result, &fsckRecord{
+				kind:     kind,
+				path:     path,
+				offset:   end,
+				tail:     true,
+				raw:      data[end:],
+				err:      fmt.Errorf("%w, %d bytes", tailErr, int64(len(data))-end),
+				location: fmt.Sprintf("%s@%d", name, end),
+			})
+		}
+	}
+	return result, nil
+}
+
+func readSegmentData(path string) ([]byte, error) {
+	f, err := openSegment(path, false, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer closeSegment(f)
+	return io.ReadAll(f)
+}
+
+// fsckDrop rewrites each affected segment without the dropped records and a
+// damaged tail, and writes a fresh index. The segment stays locked from the
+// read to the rewrite, so records appended since the scan are kept.
+func (s *segmentStore) fsckDrop(kind string, drop []*fsckRecord) error {
+	byPath := make(map[string][]*fsckRecord)
+	for _, rec := range drop {
+		byPath[rec.path] = append(byPath[rec.path], rec)
+	}
+	for path, recs := range byPath {
+		if err := rewriteSegment(path, recs); err != nil {
+			return fmt.Errorf("failed to repair segment %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func rewriteSegment(path string, drop []*fsckRecord) error {
+	f, err := openSegment(path, true, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer closeSegment(f)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	records, _, _ := decodeSegmentRecords(data, 0)
+	dropped := make(map[int64][]byte, len(drop))
+	for _, rec := range drop {
+		if !rec.tail {
+			dropped[rec.offset] = rec.raw
+		}
+	}
+
+	buf := bytes.Buffer{}
+	kept := make([]segmentRecord, 0, len(records))
+	for _, r := range records {
+		if raw, ok := dropped[r.offset]; ok && bytes.Equal(raw, r.payload) {
+			continue
+		}
+		kept = append(kept, segmentRecord{nano: r.nano, offset: int64(buf.Len())})
+		buf.Write(encodeSegmentRecord(r.nano, r.payload))
+	}
+
+	if err := ReplaceFile(path, buf.Bytes()); err != nil {
+		return err
+	}
+	// a crash before the index is replaced leaves a stale one, which the
+	// next append rebuilds
+	return ReplaceFile(segmentIndexPath(path), encodeSegmentIndex(kept))
+}
+
+// FsckStore checks a store for malformed records, duplicates, orphaned pre
+// commands and a bad sync cursor. With opts.Repair the problems are fixed:
+// malformed records and orphaned pre commands are moved to a quarantine file
+// under commands/quarantine/, duplicates are dropped and the cursor is
+// rebuilt from the newest post command.
+//
+// Like gc, a repair must not race with another process rewriting the store;
+// the daemon runs it while its track handlers are paused.
+func FsckStore(ctx context.Context, store CommandStore, opts FsckOptions) (FsckReport, error) {
+	report := FsckReport{Engine: store.Engine(), Issues: []FsckIssue{}}
+	backend, ok := store.(fsckBackend)
+	if !ok {
+		return report, fmt.Errorf("storage engine %s can't be checked", report.Engine)
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	records := make(map[string][]*fsckRecord, len(fsckKinds))
+	for _, kind := range fsckKinds {
+		recs, err := backend.fsckScan(kind)
+		if err != nil {
+			return report, fmt.Errorf("failed to read %s records: %w", kind, err)
+		}
+		records[kind] = recs
+		report.Records += len(recs)
+	}
+
+	var quarantine []*fsckRecord
+	drops := make(map[string][]*fsckRecord)
+	flag := func(rec *fsckRecord, problem, detail string, keep bool) {
+		report.Issues = append(report.Issues, FsckIssue{Problem: problem, Kind: rec.kind, Location: rec.location, Detail: detail})
+		drops[rec.kind] = append(drops[rec.kind], rec)
+		if keep {
+			quarantine = append(quarantine, rec)
+		}
+	}
+
+	var newestPost int64
+	for _, kind := range fsckKinds {
+		seen := make(map[string]string)
+		for _, rec := range records[kind] {
+			if errors.Is(rec.err, ErrStorageKeyUnavailable) {
+				// not damaged, only unreadable without its key
+				return report, fmt.Errorf("failed to check %s: %w", rec.location, rec.err)
+			}
+			if rec.err != nil {
+				flag(rec, FsckMalformed, rec.err.Error(), true)
+				continue
+			}
+			// the same record encrypted twice differs by its nonce
+			plain, _ := openStorageRecord(rec.raw)
+			id := fmt.Sprintf("%d\x00%s", rec.nano, plain)
+			if first, ok := seen[id]; ok {
+				flag(rec, FsckDuplicate, "same as "+first, false)
+				continue
+			}
+			seen[id] = rec.location
+			if kind == segmentKindPost && rec.nano > newestPost && rec.nano <= now.UnixNano() {
+				newestPost = rec.nano
+			}
+		}
+	}
+
+	cutoff := now.Add(-preCommandPairWindow)
+	for _, rec := range records[segmentKindPre] {
+		if rec.cmd == nil || !rec.cmd.Time.Before(cutoff) {
+			continue
+		}
+		if !fsckHasPost(rec.cmd, records[segmentKindPost]) {
+			flag(rec, FsckOrphanedPre, fmt.Sprintf("started %s, no post command", rec.cmd.Time.Format(time.RFC3339)), true)
+		}
+	}
+
+	rebuildCursor := false
+	cursor, noCursor, err := store.GetLastCursor(ctx)
+	switch {
+	case err != nil:
+		report.Issues = append(report.Issues, FsckIssue{Problem: FsckCursorInvalid, Kind: "cursor", Location: report.Engine, Detail: err.Error()})
+		rebuildCursor = true
+	case !noCursor && cursor.After(now) && cursor.UnixNano() > fsckNewest(records):
+		report.Issues = append(report.Issues, FsckIssue{Problem: FsckCursorAhead, Kind: "cursor", Location: report.Engine, Detail: cursor.Format(time.RFC3339Nano)})
+		rebuildCursor = true
+	}
+
+	if !opts.Repair || len(report.Issues) == 0 {
+		return report, nil
+	}
+
+	if len(quarantine) > 0 {
+		report.QuarantinedTo, err = writeQuarantine(report.Engine, quarantine, report.Issues)
+		if err != nil {
+			return report, fmt.Errorf("failed to write the quarantine file: %w", err)
+		}
+	}
+	for _, kind := range fsckKinds {
+		if len(drops[kind]) == 0 {
+			continue
+		}
+		if err := backend.fsckDrop(kind, drops[kind]); err != nil {
+			return report, fmt.Errorf("failed to repair %s records: %w", kind, err)
+		}
+	}
+	if rebuildCursor {
+		// the newest post command was sent before the cursor could pass it;
+		// without one, start from now rather than resending everything
+		report.Cursor = now
+		if newestPost > 0 {
+			report.Cursor = time.Unix(0, newestPost)
+		}
+		if err := store.SetCursor(ctx, report.Cursor); err != nil {
+			return report, fmt.Errorf("failed to rebuild the cursor: %w", err)
+		}
+		// an endpoint cursor past the rebuilt one would skip its records
+		endpointCursors, err := store.GetEndpointCursors(ctx)
+		if err != nil {
+			return report, fmt.Errorf("failed to read the endpoint cursors: %w", err)
+		}
+		for key, cursor := range endpointCursors {
+			if !cursor.After(report.Cursor) {
+				continue
+			}
+			if err := store.SetEndpointCursor(ctx, key, report.Cursor); err != nil {
+				return report, fmt.Errorf("failed to rebuild the endpoint cursors: %w", err)
+			}
+		}
+	}
+	report.Repaired = true
+	return report, nil
+}
+
+// fsckHasPost reports whether any post command completes pre, ignoring the
+// pairing window.
+func fsckHasPost(pre *Command, posts []*fsckRecord) bool {
+	key := pre.GetUniqueKey()
+	for _, rec := range posts {
+		if rec.cmd == nil || rec.cmd.Time.Before(pre.Time) {
+			continue
+		}
+		if rec.cmd.GetUniqueKey() == key || crossesSessionIDChange(pre, rec.cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+func fsckNewest(records map[string][]*fsckRecord) int64 {
+	var newest int64
+	for _, recs := range records {
+		for _, rec := range recs {
+			if rec.nano > newest {
+				newest = rec.nano
+			}
+		}
+	}
+	return newest
+}
+
+type quarantineEntry struct {
+	Problem  string `json:"problem"`
+	Kind     string `json:"kind"`
+	Location string `json:"location"`
+	Raw      string `json:"raw"`
+}
+
+// writeQuarantine saves the removed records, one JSON line each, so nothing
+// a repair drops is lost for good.
+func writeQuarantine(engine string, recs []*fsckRecord, issues []FsckIssue) (string, error) {
+	problems := make(map[string]string, len(issues))
+	for _, issue := range issues {
+		problems[issue.Location] = issue.Problem
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].location < recs[j].location })
+
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		entry := quarantineEntry{Problem: problems[rec.location], Kind: rec.kind, Location: rec.location, Raw: string(rec.raw)}
+		if err := enc.Encode(entry); err != nil {
+			return "", err
+		}
+	}
+
+	dir := GetStoragePath("commands", "quarantine")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", engine, time.Now().Format("20060102T150405")))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return "", err
+	}
+	return path, nil
+}
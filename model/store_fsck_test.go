@@ -0,0 +1,204 @@
+package model
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsckStore_File(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	s := newFileStore()
+	now := time.Now()
+
+	cmd := segmentCommand("make", now.Add(-time.Hour))
+	require.NoError(t, s.SavePre(ctx, cmd, cmd.Time))
+	require.NoError(t, s.SavePost(ctx, cmd, 0, now.Add(-time.Minute)))
+	// an interrupted gc left the post twice
+	posts, err := os.ReadFile(GetPostCommandFilePath())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(GetPostCommandFilePath(), append(posts, posts...), 0644))
+
+	old := segmentCommand("vim", now.AddDate(0, 0, -20))
+	require.NoError(t, s.SavePre(ctx, old, old.Time))
+	f, err := os.OpenFile(GetPreCommandFilePath(), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("{\"shell\":\"zsh\"\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, s.SetCursor(ctx, now.Add(24*time.Hour)))
+
+	report, err := FsckStore(ctx, s, FsckOptions{Now: now})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Count(FsckMalformed))
+	assert.Equal(t, 1, report.Count(FsckDuplicate))
+	assert.Equal(t, 1, report.Count(FsckOrphanedPre))
+	assert.Equal(t, 1, report.Count(FsckCursorAhead))
+	assert.False(t, report.Repaired)
+
+	report, err = FsckStore(ctx, s, FsckOptions{Now: now, Repair: true})
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+	assert.Equal(t, now.Add(-time.Minute).UnixNano(), report.Cursor.UnixNano())
+
+	quarantined, err := os.ReadFile(report.QuarantinedTo)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(quarantined)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, string(quarantined), `"problem":"orphaned_pre"`)
+
+	pres, err := s.GetPreCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, pres, 1)
+	assert.Equal(t, "make", pres[0].Command)
+	postCommands, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Len(t, postCommands, 1)
+
+	report, err = FsckStore(ctx, s, FsckOptions{Now: now})
+	require.NoError(t, err)
+	assert.Empty(t, report.Issues)
+}
+
+func TestFsckStore_Bolt(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	s, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	cmd := segmentCommand("ls", now)
+	require.NoError(t, s.SavePost(ctx, cmd, 0, now))
+	posts, err := s.fsckScan(segmentKindPost)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	require.NoError(t, s.putRaw(archivedBucket, posts[0].raw, now))
+	require.NoError(t, s.putRaw(activeBucket, []byte("not json"), now))
+
+	report, err := FsckStore(ctx, s, FsckOptions{Now: now, Repair: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Count(FsckMalformed))
+	assert.Equal(t, 1, report.Count(FsckDuplicate))
+
+	postCommands, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Len(t, postCommands, 1)
+	pres, err := s.fsckScan(segmentKindPre)
+	require.NoError(t, err)
+	assert.Empty(t, pres)
+}
+
+func TestFsckStore_Segment(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	s := newSegmentStore(GetSegmentStoragePath())
+	now := time.Now()
+
+	require.NoError(t, s.SavePost(ctx, segmentCommand("a", now), 0, now))
+	require.NoError(t, s.appendRecord(segmentKindPost, segmentName(now), now.UnixNano()+1, []byte("{broken")))
+	require.NoError(t, s.SavePost(ctx, segmentCommand("b", now), 0, now.Add(time.Millisecond)))
+	path := filepath.Join(s.dir, segmentKindPost, segmentName(now)+segmentExt)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 99, 1})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	report, err := FsckStore(ctx, s, FsckOptions{Now: now})
+	require.NoError(t, err)
+	// the broken JSON and the torn tail
+	assert.Equal(t, 2, report.Count(FsckMalformed))
+
+	report, err = FsckStore(ctx, s, FsckOptions{Now: now, Repair: true})
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+
+	posts, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	assert.Equal(t, "b", posts[1].Command)
+	index, err := readSegmentIndex(segmentIndexPath(path))
+	require.NoError(t, err)
+	assert.Len(t, index, 2)
+
+	// appends continue after the rewritten records
+	require.NoError(t, s.SavePost(ctx, segmentCommand("c", now), 0, now.Add(2*time.Millisecond)))
+	posts, err = s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Len(t, posts, 3)
+}
+
+func TestFsckDrop_FileKeepsConcurrentAppends(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	s := newFileStore()
+	now := time.Now()
+
+	require.NoError(t, s.SavePost(ctx, segmentCommand("a", now), 0, now))
+	f, err := os.OpenFile(GetPostCommandFilePath(), os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString("{broken\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	scanned, err := s.fsckScan(segmentKindPost)
+	require.NoError(t, err)
+	require.Len(t, scanned, 2)
+
+	// an append waits while the rewrite holds the lock
+	unlock, err := s.lock(true)
+	require.NoError(t, err)
+	appended := make(chan error, 1)
+	go func() { appended <- s.SavePost(ctx, segmentCommand("b", now), 0, now.Add(time.Second)) }()
+	select {
+	case <-appended:
+		t.Fatal("the append didn't wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	require.NoError(t, <-appended)
+
+	// the line appended since the scan survives the drop
+	require.NoError(t, s.fsckDrop(segmentKindPost, scanned[1:]))
+	posts, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	assert.Equal(t, "b", posts[1].Command)
+	_, err = os.Stat(GetPostCommandFilePath() + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRewriteSegment_ReplacesFile(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	s := newSegmentStore(GetSegmentStoragePath())
+	now := time.Now()
+
+	require.NoError(t, s.SavePost(ctx, segmentCommand("a", now), 0, now))
+	require.NoError(t, s.appendRecord(segmentKindPost, segmentName(now), now.UnixNano()+1, []byte("{broken")))
+	path := filepath.Join(s.dir, segmentKindPost, segmentName(now)+segmentExt)
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	records, err := s.fsckScan(segmentKindPost)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.NoError(t, rewriteSegment(path, records[1:]))
+
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(before, after), "the segment is replaced, not truncated in place")
+	require.NoError(t, s.SavePost(ctx, segmentCommand("b", now), 0, now.Add(time.Second)))
+	posts, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	assert.Equal(t, "b", posts[1].Command)
+}
@@ -0,0 +1,382 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MigrateOptions tunes MigrateStore.
+type MigrateOptions struct {
+	// DryRun only counts what would be copied.
+	DryRun bool
+	// KeepSource leaves the source data in place after a verified copy.
+	KeepSource bool
+}
+
+// MigrationReport describes a storage migration.
+type MigrationReport struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Pre, Post and Sessions count the unsynced records of the source.
+	Pre      int `json:"pre"`
+	Post     int `json:"post"`
+	Sessions int `json:"sessions"`
+	// Copied counts the records written to the destination; records it
+	// already held (e.g. from an interrupted run) are Skipped.
+	Copied   int `json:"copied"`
+	Skipped  int `json:"skipped"`
+	Archived int `json:"archived"`
+	// Cursor is the sync cursor carried over, zero before the first sync.
+	Cursor time.Time `json:"cursor,omitempty"`
+	// Digest is a SHA-256 over the migrated records, identical for the source
+	// and the verified destination.
+	Digest string `json:"digest"`
+	DryRun bool   `json:"dryRun,omitempty"`
+	// RetiredTo is where the source data was moved, empty when it was kept.
+	RetiredTo string `json:"retiredTo,omitempty"`
+}
+
+// rawRecordWriter is implemented by the stores so a migration can copy
+// commands as they are; SavePre / SavePost would stamp a new end time.
+type rawRecordWriter interface {
+	saveRawCommand(ctx context.Context, cmd Command, recordingTime time.Time) error
+}
+
+func (s *fileStore) saveRawCommand(ctx context.Context, cmd Command, recordingTime time.Time) error {
+	path := GetPreCommandFilePath()
+	if cmd.Phase == CommandPhasePost {
+		path = GetPostCommandFilePath()
+	}
+	return s.appendLine(path, cmd, recordingTime)
+}
+
+func (s *boltStore) saveRawCommand(ctx context.Context, cmd Command, recordingTime time.Time) error {
+	bucket := activeBucket
+	if cmd.Phase == CommandPhasePost {
+		bucket = archivedBucket
+	}
+	return s.put(bucket, cmd, recordingTime)
+}
+
+func (s *segmentStore) saveRawCommand(ctx context.Context, cmd Command, recordingTime time.Time) error {
+	kind := segmentKindPre
+	if cmd.Phase == CommandPhasePost {
+		kind = segmentKindPost
+	}
+	return s.put(kind, cmd, recordingTime)
+}
+
+// migrationRecord is one buffered record with a fingerprint that is equal
+// across engines.
+type migrationRecord struct {
+	fingerprint string
+	cmd         *Command
+	event       *SessionEvent
+	rt          time.Time
+}
+
+func fingerprintRecord(kind string, v interface{}, rt time.Time) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(kind))
+	var nano [8]byte
+	binary.BigEndian.PutUint64(nano[:], uint64(rt.UnixNano()))
+	h.Write(nano[:])
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeRecords reads every buffered record of a store. With unsyncedOnly,
+// records a Prune at the store's cursor would drop are left out. Missing
+// buffer files (a fresh or retired file store) count as empty.
+func storeRecords(ctx context.Context, store CommandStore, cursor time.Time, unsyncedOnly bool) (pre, post, sessions []migrationRecord, err error) {
+	synced := func(rt time.Time) bool { return unsyncedOnly && !rt.After(cursor) }
+
+	posts, err := store.GetPostCommands(ctx)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil, err
+	}
+	for _, cmd := range posts {
+		if cmd == nil || synced(cmd.RecordingTime) {
+			continue
+		}
+		fp, err := fingerprintRecord("post", cmd, cmd.RecordingTime)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		post = append(post, migrationRecord{fingerprint: fp, cmd: cmd, rt: cmd.RecordingTime})
+	}
+
+	pres, err := store.GetPreCommands(ctx)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil, err
+	}
+	for _, cmd := range pres {
+		if cmd == nil || (synced(cmd.RecordingTime) && preHasSyncedPost(cmd, posts, cursor)) {
+			continue
+		}
+		fp, err := fingerprintRecord("pre", cmd, cmd.RecordingTime)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		pre = append(pre, migrationRecord{fingerprint: fp, cmd: cmd, rt: cmd.RecordingTime})
+	}
+
+	events, err := store.GetSessionEvents(ctx)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil, err
+	}
+	for _, ev := range events {
+		if ev == nil || synced(ev.RecordingTime) {
+			continue
+		}
+		fp, err := fingerprintRecord("session", ev, ev.RecordingTime)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sessions = append(sessions, migrationRecord{fingerprint: fp, event: ev, rt: ev.RecordingTime})
+	}
+	return pre, post, sessions, nil
+}
+
+// ErrRetireSource is returned by MigrateStore when the copy succeeded but
+// the source could not be retired. The source store is closed by then.
+var ErrRetireSource = errors.New("failed to retire the source store")
+
+// MigrateStore copies the unsynced pre, post and session records, the sync
+// cursor and the local archive from one store to another, verifies the copy
+// by fingerprint and retires the source data unless opts.KeepSource is set.
+// Records the destination already holds are not copied twice, so an
+// interrupted migration can simply be run again.
+//
+// from is closed before it is retired, since the bolt DB can't be moved while
+// it is open. Both stores must be exclusively held by the caller: the daemon
+// pauses its track handlers, the CLI only migrates when no daemon runs.
+func MigrateStore(ctx context.Context, from, to CommandStore, opts MigrateOptions) (MigrationReport, error) {
+	report := MigrationReport{From: from.Engine(), To: to.Engine(), DryRun: opts.DryRun}
+	if report.From == report.To {
+		return report, fmt.Errorf("source and destination are both %s", report.From)
+	}
+	writer, ok := to.(rawRecordWriter)
+	if !ok {
+		return report, fmt.Errorf("storage engine %s can't be migrated to", report.To)
+	}
+
+	cursor, noCursor, err := from.GetLastCursor(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to read %s cursor: %w", report.From, err)
+	}
+	pre, post, sessions, err := storeRecords(ctx, from, cursor, !noCursor)
+	if err != nil {
+		return report, fmt.Errorf("failed to read %s records: %w", report.From, err)
+	}
+	report.Pre, report.Post, report.Sessions = len(pre), len(post), len(sessions)
+	if !noCursor {
+		report.Cursor = cursor
+	}
+
+	all := make([]migrationRecord, 0, len(pre)+len(post)+len(sessions))
+	all = append(append(append(all, pre...), post...), sessions...)
+	fingerprints := make([]string, 0, len(all))
+	for _, r := range all {
+		fingerprints = append(fingerprints, r.fingerprint)
+	}
+	sort.Strings(fingerprints)
+	digest := sha256.New()
+	for _, fp := range fingerprints {
+		digest.Write([]byte(fp))
+	}
+	report.Digest = hex.EncodeToString(digest.Sum(nil))
+
+	if opts.DryRun {
+		return report, nil
+	}
+
+	existing, err := destinationFingerprints(ctx, to)
+	if err != nil {
+		return report, fmt.Errorf("failed to read %s records: %w", report.To, err)
+	}
+	for _, r := range all {
+		if existing[r.fingerprint] > 0 {
+			existing[r.fingerprint]--
+			report.Skipped++
+			continue
+		}
+		if r.event != nil {
+			err = to.SaveSessionEvent(ctx, *r.event, r.rt)
+		} else {
+			err = writer.saveRawCommand(ctx, *r.cmd, r.rt)
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to write %s record: %w", report.To, err)
+		}
+		report.Copied++
+	}
+
+	if !noCursor {
+		// with cursors on both sides keep the older one, so no unsynced record
+		// is taken for synced
+		toCursor, toNoCursor, err := to.GetLastCursor(ctx)
+		if err != nil {
+			return report, err
+		}
+		if toNoCursor || cursor.Before(toCursor) {
+			if err := to.SetCursor(ctx, cursor); err != nil {
+				return report, fmt.Errorf("failed to set %s cursor: %w", report.To, err)
+			}
+		}
+	}
+	if err := migrateEndpointCursors(ctx, from, to); err != nil {
+		return report, fmt.Errorf("failed to copy %s endpoint cursors: %w", report.From, err)
+	}
+
+	fromArchive, ok1 := from.(ArchiveStore)
+	toArchive, ok2 := to.(ArchiveStore)
+	if ok1 && ok2 && !sharesArchive(report.From, report.To) {
+		records, err := fromArchive.QueryArchive(ctx, ArchiveQuery{})
+		if err != nil {
+			return report, err
+		}
+		if err := toArchive.AppendArchive(ctx, records); err != nil {
+			return report, fmt.Errorf("failed to copy the archive: %w", err)
+		}
+		report.Archived = len(records)
+	}
+
+	if err := verifyMigration(ctx, to, fingerprints, report.Archived); err != nil {
+		return report, err
+	}
+
+	if opts.KeepSource {
+		return report, nil
+	}
+	if err := from.Close(); err != nil {
+		return report, fmt.Errorf("%w: %w", ErrRetireSource, err)
+	}
+	report.RetiredTo, err = RetireStore(from)
+	if err != nil {
+		return report, fmt.Errorf("%w: %w", ErrRetireSource, err)
+	}
+	return report, nil
+}
+
+// sharesArchive reports whether both engines use archive.txt, in which case
+// there is nothing to copy.
+func sharesArchive(from, to string) bool {
+	local := func(engine string) bool {
+		return engine == StorageEngineFile || engine == StorageEngineSegment
+	}
+	return local(from) && local(to)
+}
+
+func destinationFingerprints(ctx context.Context, store CommandStore) (map[string]int, error) {
+	pre, post, sessions, err := storeRecords(ctx, store, time.Time{}, false)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(pre)+len(post)+len(sessions))
+	for _, records := range [][]migrationRecord{pre, post, sessions} {
+		for _, r := range records {
+			result[r.fingerprint]++
+		}
+	}
+	return result, nil
+}
+
+// verifyMigration re-reads the destination and checks that it holds every
+// migrated record and at least the archived count.
+func verifyMigration(ctx context.Context, to CommandStore, fingerprints []string, archived int) error {
+	existing, err := destinationFingerprints(ctx, to)
+	if err != nil {
+		return err
+	}
+	missing := 0
+	for _, fp := range fingerprints {
+		if existing[fp] > 0 {
+			existing[fp]--
+			continue
+		}
+		missing++
+	}
+	if missing > 0 {
+		return fmt.Errorf("verification failed: %d records missing in %s, source kept", missing, to.Engine())
+	}
+
+	if archive, ok := to.(ArchiveStore); ok && archived > 0 {
+		records, err := archive.QueryArchive(ctx, ArchiveQuery{})
+		if err != nil {
+			return err
+		}
+		if len(records) < archived {
+			return fmt.Errorf("verification failed: %s archive has %d of %d records, source kept", to.Engine(), len(records), archived)
+		}
+	}
+	return nil
+}
+
+// migrateEndpointCursors copies the per-endpoint sync cursors, keeping the
+// older one where both stores have a cursor, like the store cursor.
+func migrateEndpointCursors(ctx context.Context, from, to CommandStore) error {
+	fromCursors, err := from.GetEndpointCursors(ctx)
+	if err != nil {
+		return err
+	}
+	toCursors, err := to.GetEndpointCursors(ctx)
+	if err != nil {
+		return err
+	}
+	for key, cursor := range fromCursors {
+		if toCursor, ok := toCursors[key]; ok && !cursor.Before(toCursor) {
+			continue
+		}
+		if err := to.SetEndpointCursor(ctx, key, cursor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetireStore moves the buffer data of a closed store to
+// commands/retired/<engine>-<time>/ and returns that folder. Nothing is
+// deleted, so a migration can be undone by moving the files back.
+func RetireStore(store CommandStore) (string, error) {
+	var paths []string
+	switch s := store.(type) {
+	case *fileStore:
+		// the archive stays: the segment engine shares it
+		paths = []string{GetPreCommandFilePath(), GetPostCommandFilePath(), GetCursorFilePath(), GetEndpointCursorFilePath(), GetSessionEventFilePath()}
+	case *boltStore:
+		paths = []string{s.path}
+	case *segmentStore:
+		paths = []string{s.dir}
+	default:
+		return "", fmt.Errorf("storage engine %s can't be retired", store.Engine())
+	}
+
+	dir := GetStoragePath("commands", "retired", fmt.Sprintf("%s-%s", store.Engine(), time.Now().Format("20060102T150405")))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			return "", fmt.Errorf("failed to retire %s: %w", path, err)
+		}
+	}
+	return dir, nil
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupMigrateTest(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+	require.NoError(t, os.MkdirAll(GetStoragePath("commands"), 0755))
+}
+
+// fillMigrateSource writes one synced and one unsynced command plus an
+// unsynced session event, and sets the cursor between them.
+func fillMigrateSource(t *testing.T, s CommandStore) time.Time {
+	t.Helper()
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+	synced := segmentCommand("synced", base)
+	require.NoError(t, s.SavePre(ctx, synced, base))
+	require.NoError(t, s.SavePost(ctx, synced, 0, base.Add(time.Second)))
+
+	cursor := base.Add(2 * time.Second)
+	require.NoError(t, s.SetCursor(ctx, cursor))
+
+	pending := segmentCommand("pending", base.Add(time.Minute))
+	require.NoError(t, s.SavePre(ctx, pending, base.Add(time.Minute)))
+	require.NoError(t, s.SavePost(ctx, pending, 1, base.Add(2*time.Minute)))
+	require.NoError(t, s.SavePre(ctx, segmentCommand("running", base.Add(3*time.Minute)), base.Add(3*time.Minute)))
+	require.NoError(t, s.SaveSessionEvent(ctx, SessionEvent{Type: SessionEventStart, SessionID: 7}, base.Add(time.Minute)))
+	return cursor
+}
+
+func TestMigrateStore_FileToSegment(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	from := newFileStore()
+	cursor := fillMigrateSource(t, from)
+	to := newSegmentStore(GetSegmentStoragePath())
+
+	report, err := MigrateStore(ctx, from, to, MigrateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Pre)
+	assert.Equal(t, 1, report.Post)
+	assert.Equal(t, 1, report.Sessions)
+	assert.Equal(t, 4, report.Copied)
+	assert.Equal(t, cursor.UnixNano(), report.Cursor.UnixNano())
+	assert.NotEmpty(t, report.Digest)
+
+	posts, err := to.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "pending", posts[0].Command)
+	assert.Equal(t, 1, posts[0].Result)
+
+	toCursor, noCursor, err := to.GetLastCursor(ctx)
+	require.NoError(t, err)
+	assert.False(t, noCursor)
+	assert.Equal(t, cursor.UnixNano(), toCursor.UnixNano())
+
+	// the source files were moved aside, not deleted
+	require.NotEmpty(t, report.RetiredTo)
+	_, err = os.Stat(GetPostCommandFilePath())
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(report.RetiredTo, filepath.Base(GetPostCommandFilePath())))
+	assert.NoError(t, err)
+}
+
+func TestMigrateStore_RerunAndReverse(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	from := newSegmentStore(GetSegmentStoragePath())
+	fillMigrateSource(t, from)
+	to := newFileStore()
+
+	first, err := MigrateStore(ctx, from, to, MigrateOptions{KeepSource: true})
+	require.NoError(t, err)
+	assert.Empty(t, first.RetiredTo)
+
+	// an interrupted run can be repeated without duplicates
+	second, err := MigrateStore(ctx, from, to, MigrateOptions{KeepSource: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, second.Copied)
+	assert.Equal(t, 4, second.Skipped)
+	assert.Equal(t, first.Digest, second.Digest)
+
+	pres, err := to.GetPreCommands(ctx)
+	require.NoError(t, err)
+	assert.Len(t, pres, 2)
+
+	// and back again produces the same digest
+	back := newSegmentStore(filepath.Join(t.TempDir(), "segments"))
+	reverse, err := MigrateStore(ctx, to, back, MigrateOptions{KeepSource: true})
+	require.NoError(t, err)
+	assert.Equal(t, first.Digest, reverse.Digest)
+}
+
+func TestMigrateStore_DryRun(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	from := newFileStore()
+	fillMigrateSource(t, from)
+	to := newSegmentStore(GetSegmentStoragePath())
+
+	report, err := MigrateStore(ctx, from, to, MigrateOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.Post)
+	assert.Equal(t, 0, report.Copied)
+
+	posts, err := to.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+	_, err = os.Stat(GetPostCommandFilePath())
+	assert.NoError(t, err)
+}
+
+func TestMigrateStore_Bolt(t *testing.T) {
+	setupMigrateTest(t)
+	ctx := context.Background()
+	from, err := newBoltStore(GetBoltDBPath())
+	require.NoError(t, err)
+	cursor := fillMigrateSource(t, from)
+	to := newFileStore()
+
+	report, err := MigrateStore(ctx, from, to, MigrateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Copied)
+	_, err = os.Stat(GetBoltDBPath())
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(report.RetiredTo, filepath.Base(GetBoltDBPath())))
+	assert.NoError(t, err)
+
+	toCursor, _, err := to.GetLastCursor(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, cursor.UnixNano(), toCursor.UnixNano())
+}
+
+func TestMigrateStore_SameEngine(t *testing.T) {
+	_, err := MigrateStore(context.Background(), newFileStore(), newFileStore(), MigrateOptions{})
+	assert.Error(t, err)
+}
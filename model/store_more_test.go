@@ -235,3 +235,52 @@ func TestDB_GetPreCommandsTree_SkipsInvalidLines(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, tree[valid.GetUniqueKey()], 1)
 }
+
+func TestFileStore_Prune_WaitsForLock(t *testing.T) {
+	m2setupHome(t)
+	require.NoError(t, ensureStorageFolder())
+
+	store := newFileStore()
+	ctx := context.Background()
+	base := time.Now()
+	require.NoError(t, store.SavePre(ctx, m2cmd("make", base), base))
+	require.NoError(t, store.SavePost(ctx, m2cmd("make", base), 0, base))
+
+	// a rewrite waits while another process holds the lock
+	unlock, err := LockCommandStorage()
+	require.NoError(t, err)
+	pruned := make(chan error, 1)
+	go func() { pruned <- store.Prune(ctx, base) }()
+	select {
+	case <-pruned:
+		t.Fatal("the prune didn't wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	later := base.Add(time.Second)
+	require.NoError(t, appendUnlocked(GetPostCommandFilePath(), m2cmd("echo later", later), later))
+	unlock()
+	require.NoError(t, <-pruned)
+
+	posts, err := store.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "echo later", posts[0].Command)
+	_, err = os.Stat(GetPostCommandFilePath() + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+// appendUnlocked appends like a hook that already holds the shared lock.
+func appendUnlocked(path string, cmd Command, recordingTime time.Time) error {
+	cmd.Phase = CommandPhasePost
+	buf, err := cmd.ToLine(recordingTime)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(buf)
+	return err
+}
@@ -0,0 +1,665 @@
+package model
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segmentStore is an append-only log backend. Each record kind (pre, post,
+// sessions) has a folder of per-day segment files named after the UTC day of
+// the recording time, e.g. commands/segments/post/20240101.seg. A record is
+//
+//	[4-byte length][4-byte CRC-32C][8-byte recording time][JSON payload]
+//
+// where length and CRC cover everything after the header. Next to each
+// segment, a .idx file holds one entry (recording time, offset) per record.
+//
+// Appends take an exclusive flock on the segment, so concurrent CLI processes
+// can write without a daemon. Prune deletes whole segments once their day is
+// over and every record in them is synced, instead of rewriting files.
+type segmentStore struct {
+	dir string
+}
+
+const (
+	segmentKindPre      = "pre"
+	segmentKindPost     = "post"
+	segmentKindSessions = "sessions"
+
+	segmentExt      = ".seg"
+	segmentIndexExt = ".idx"
+
+	segmentHeaderSize     = 8
+	segmentIndexEntrySize = 16
+	segmentDayLayout      = "20060102"
+)
+
+var segmentCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	errSegmentTorn    = errors.New("torn segment record")
+	errSegmentCorrupt = errors.New("segment record checksum mismatch")
+)
+
+type segmentRecord struct {
+	nano    int64
+	offset  int64
+	payload []byte
+}
+
+type segmentIndexEntry struct {
+	nano   int64
+	offset int64
+}
+
+func newSegmentStore(dir string) *segmentStore {
+	return &segmentStore{dir: dir}
+}
+
+func segmentName(t time.Time) string {
+	return t.UTC().Format(segmentDayLayout)
+}
+
+func segmentIndexPath(path string) string {
+	return strings.TrimSuffix(path, segmentExt) + segmentIndexExt
+}
+
+func encodeSegmentRecord(nano int64, payload []byte) []byte {
+	buf := make([]byte, segmentHeaderSize+8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(nano))
+	copy(buf[16:], payload)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(buf[8:], segmentCRCTable))
+	return buf
+}
+
+// decodeSegmentRecords decodes the records in data, with offsets relative to
+// base. It stops at the first torn or corrupt record and returns the offset
+// where the valid records end along with the reason.
+func decodeSegmentRecords(data []byte, base int64) ([]segmentRecord, int64, error) {
+	records := make([]segmentRecord, 0)
+	off := 0
+	for off < len(data) {
+		rest := data[off:]
+		if len(rest) < segmentHeaderSize {
+			return records, base + int64(off), errSegmentTorn
+		}
+		n := int(binary.BigEndian.Uint32(rest[0:4]))
+		if n < 8 || len(rest)-segmentHeaderSize < n {
+			return records, base + int64(off), errSegmentTorn
+		}
+		body := rest[segmentHeaderSize : segmentHeaderSize+n]
+		if crc32.Checksum(body, segmentCRCTable) != binary.BigEndian.Uint32(rest[4:8]) {
+			return records, base + int64(off), errSegmentCorrupt
+		}
+		records = append(records, segmentRecord{
+			nano:    int64(binary.BigEndian.Uint64(body[0:8])),
+			offset:  base + int64(off),
+			payload: body[8:],
+		})
+		off += segmentHeaderSize + n
+	}
+	return records, base + int64(off), nil
+}
+
+func readSegmentIndex(path string) ([]segmentIndexEntry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := make([]segmentIndexEntry, 0, len(content)/segmentIndexEntrySize)
+	for i := 0; i+segmentIndexEntrySize <= len(content); i += segmentIndexEntrySize {
+		entries = append(entries, segmentIndexEntry{
+			nano:   int64(binary.BigEndian.Uint64(content[i : i+8])),
+			offset: int64(binary.BigEndian.Uint64(content[i+8 : i+16])),
+		})
+	}
+	return entries, nil
+}
+
+func encodeSegmentIndex(records []segmentRecord) []byte {
+	buf := make([]byte, len(records)*segmentIndexEntrySize)
+	for i, r := range records {
+		b := buf[i*segmentIndexEntrySize:]
+		binary.BigEndian.PutUint64(b[0:8], uint64(r.nano))
+		binary.BigEndian.PutUint64(b[8:16], uint64(r.offset))
+	}
+	return buf
+}
+
+func appendSegmentIndex(path string, records []segmentRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open segment index %s: %w", path, err)
+	}
+	defer f.Close()
+	_, err = f.Write(encodeSegmentIndex(records))
+	return err
+}
+
+// openSegment opens and locks a segment. Prune may delete the segment while
+// we wait for the lock; the file is then reopened (writers) or reported as
+// missing (readers), so nothing is written to an unlinked file.
+func openSegment(path string, exclusive, create bool) (*os.File, error) {
+	flag := os.O_RDONLY
+	if exclusive {
+		flag = os.O_RDWR
+	}
+	if create {
+		flag |= os.O_CREATE
+	}
+	for {
+		f, err := os.OpenFile(path, flag, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f, exclusive); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock segment %s: %w", path, err)
+		}
+		opened, err := f.Stat()
+		if err != nil {
+			closeSegment(f)
+			return nil, err
+		}
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(opened, current) {
+			return f, nil
+		}
+		closeSegment(f)
+		if err != nil && (!os.IsNotExist(err) || !create) {
+			return nil, err
+		}
+	}
+}
+
+func closeSegment(f *os.File) {
+	unlockFile(f)
+	f.Close()
+}
+
+// ReplaceFile replaces the file at path with data through a temp file and a
+// rename, so a crash leaves either the old or the new content. Rewrites of a
+// segment hold its lock meanwhile; the writers waiting for it notice the
+// rename in openSegment and append to the new file. Rewrites of the txt files
+// hold LockCommandStorage.
+func ReplaceFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// recoverSegmentTail returns the offset past the last valid record of a
+// locked segment. Records a crash left out of the index are indexed, and a
+// torn record at the end is cut off so the next append starts clean.
+func recoverSegmentTail(f *os.File, indexPath string) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	// a torn index entry would shift every later one
+	if info, err := os.Stat(indexPath); err == nil && info.Size()%segmentIndexEntrySize != 0 {
+		if err := os.Truncate(indexPath, info.Size()-info.Size()%segmentIndexEntrySize); err != nil {
+			return 0, err
+		}
+	}
+	index, err := readSegmentIndex(indexPath)
+	if err != nil {
+		return 0, err
+	}
+
+	start := int64(0)
+	if len(index) > 0 {
+		start = index[len(index)-1].offset
+	}
+	records, end, err := readSegmentFrom(f, start, size)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case len(index) > 0 && (len(records) == 0 || records[0].nano != index[len(index)-1].nano):
+		// the index doesn't match the data; rebuild it from scratch
+		slog.Warn("rebuilding segment index", slog.String("index", indexPath))
+		if records, end, err = readSegmentFrom(f, 0, size); err != nil {
+			return 0, err
+		}
+		if err := os.WriteFile(indexPath, encodeSegmentIndex(records), 0644); err != nil {
+			return 0, err
+		}
+	case len(index) > 0:
+		err = appendSegmentIndex(indexPath, records[1:])
+	default:
+		err = appendSegmentIndex(indexPath, records)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if end < size {
+		slog.Warn("truncating torn segment tail", slog.String("segment", f.Name()), slog.Int64("bytes", size-end))
+		if err := f.Truncate(end); err != nil {
+			return 0, err
+		}
+	}
+	return end, nil
+}
+
+func readSegmentFrom(f *os.File, start, size int64) ([]segmentRecord, int64, error) {
+	if start > size {
+		start = size
+	}
+	data := make([]byte, size-start)
+	if _, err := f.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	records, end, _ := decodeSegmentRecords(data, start)
+	return records, end, nil
+}
+
+// readSegment returns the valid records of a segment. A damaged tail is
+// logged and skipped; the next append to the segment cuts it off.
+func readSegment(path string) ([]segmentRecord, error) {
+	f, err := openSegment(path, false, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer closeSegment(f)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	records, end, err := decodeSegmentRecords(data, 0)
+	if err != nil {
+		slog.Warn("skipping damaged segment tail", slog.String("segment", path), slog.Int64("offset", end), slog.Any("err", err))
+	}
+	return records, nil
+}
+
+// segmentPaths lists the segments of a kind, oldest day first.
+func (s *segmentStore) segmentPaths(kind string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, kind))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	paths := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), segmentExt) {
+			paths = append(paths, filepath.Join(s.dir, kind, e.Name()))
+		}
+	}
+	return paths, nil
+}
+
+func (s *segmentStore) appendRecord(kind, day string, nano int64, payload []byte) error {
+	dir := filepath.Join(s.dir, kind)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create segment folder: %w", err)
+	}
+	path := filepath.Join(dir, day+segmentExt)
+	f, err := openSegment(path, true, true)
+	if err != nil {
+		return fmt.Errorf("failed to open segment %s: %w", path, err)
+	}
+	defer closeSegment(f)
+
+	end, err := recoverSegmentTail(f, segmentIndexPath(path))
+	if err != nil {
+		return fmt.Errorf("failed to recover segment %s: %w", path, err)
+	}
+	if _, err := f.WriteAt(encodeSegmentRecord(nano, payload), end); err != nil {
+		return fmt.Errorf("failed to write segment %s: %w", path, err)
+	}
+	return appendSegmentIndex(segmentIndexPath(path), []segmentRecord{{nano: nano, offset: end}})
+}
+
+func (s *segmentStore) put(kind string, v interface{}, recordingTime time.Time) error {
+	payload, err := marshalStorageRecord(v)
+	if err != nil {
+		return err
+	}
+	return s.appendRecord(kind, segmentName(recordingTime), recordingTime.UnixNano(), payload)
+}
+
+func (s *segmentStore) records(kind string) ([]segmentRecord, error) {
+	paths, err := s.segmentPaths(kind)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]segmentRecord, 0)
+	for _, path := range paths {
+		records, err := readSegment(path)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, records...)
+	}
+	return result, nil
+}
+
+func (s *segmentStore) commands(kind string) ([]*Command, error) {
+	records, err := s.records(kind)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Command, 0, len(records))
+	for _, rec := range records {
+		cmd := new(Command)
+		if err := unmarshalStorageRecord(rec.payload, cmd); err != nil {
+			if errors.Is(err, ErrStorageKeyUnavailable) {
+				return nil, err
+			}
+			slog.Warn("failed to unmarshal command from segment", slog.Any("err", err))
+			continue
+		}
+		cmd.RecordingTime = time.Unix(0, rec.nano)
+		result = append(result, cmd)
+	}
+	return result, nil
+}
+
+func (s *segmentStore) SavePre(ctx context.Context, cmd Command, recordingTime time.Time) error {
+	cmd.Phase = CommandPhasePre
+	cmd.ID = cmd.RecordID()
+	return s.put(segmentKindPre, cmd, recordingTime)
+}
+
+func (s *segmentStore) SavePost(ctx context.Context, cmd Command, result int, recordingTime time.Time) error {
+	cmd.Phase = CommandPhasePost
+	cmd.Result = result
+	cmd.EndTime = time.Now()
+	cmd.ID = cmd.RecordID()
+	return s.put(segmentKindPost, cmd, recordingTime)
+}
+
+func (s *segmentStore) GetPreTree(ctx context.Context) (map[string][]*Command, error) {
+	cmds, err := s.commands(segmentKindPre)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(map[string][]*Command)
+	for _, cmd := range cmds {
+		key := cmd.GetUniqueKey()
+		tree[key] = append(tree[key], cmd)
+	}
+	return tree, nil
+}
+
+func (s *segmentStore) GetPreCommands(ctx context.Context) ([]*Command, error) {
+	return s.commands(segmentKindPre)
+}
+
+func (s *segmentStore) GetPostCommands(ctx context.Context) ([]*Command, error) {
+	return s.commands(segmentKindPost)
+}
+
+func (s *segmentStore) SaveSessionEvent(ctx context.Context, ev SessionEvent, recordingTime time.Time) error {
+	return s.put(segmentKindSessions, ev, recordingTime)
+}
+
+func (s *segmentStore) GetSessionEvents(ctx context.Context) ([]*SessionEvent, error) {
+	records, err := s.records(segmentKindSessions)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*SessionEvent, 0, len(records))
+	for _, rec := range records {
+		ev := new(SessionEvent)
+		if err := unmarshalStorageRecord(rec.payload, ev); err != nil {
+			if errors.Is(err, ErrStorageKeyUnavailable) {
+				return nil, err
+			}
+			slog.Warn("failed to unmarshal session event from segment", slog.Any("err", err))
+			continue
+		}
+		ev.RecordingTime = time.Unix(0, rec.nano)
+		result = append(result, ev)
+	}
+	return result, nil
+}
+
+func (s *segmentStore) cursorPath() string {
+	return filepath.Join(s.dir, "cursor")
+}
+
+func (s *segmentStore) GetLastCursor(ctx context.Context) (time.Time, bool, error) {
+	content, err := os.ReadFile(s.cursorPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, true, nil
+		}
+		return time.Time{}, false, err
+	}
+	text := strings.TrimSpace(string(content))
+	if text == "" {
+		return time.Time{}, true, nil
+	}
+	nano, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid segment cursor %q: %w", text, err)
+	}
+	return time.Unix(0, nano), false, nil
+}
+
+// SetCursor replaces the cursor file through a rename, so a reader never sees
+// it half written.
+func (s *segmentStore) SetCursor(ctx context.Context, cursor time.Time) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "cursor-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strconv.FormatInt(cursor.UnixNano(), 10)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.cursorPath())
+}
+
+func (s *segmentStore) GetEndpointCursors(ctx context.Context) (map[string]time.Time, error) {
+	return readEndpointCursorFile(filepath.Join(s.dir, "endpoint-cursors.json"))
+}
+
+func (s *segmentStore) SetEndpointCursor(ctx context.Context, key string, cursor time.Time) error {
+	return writeEndpointCursorFile(filepath.Join(s.dir, "endpoint-cursors.json"), key, cursor)
+}
+
+// segmentMaxNano returns the newest recording time in a segment, from its
+// index when there is one.
+func segmentMaxNano(path string) (int64, error) {
+	index, err := readSegmentIndex(segmentIndexPath(path))
+	if err != nil {
+		return 0, err
+	}
+	var newest int64
+	if len(index) > 0 {
+		for _, e := range index {
+			newest = max(newest, e.nano)
+		}
+		return newest, nil
+	}
+	records, err := readSegment(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range records {
+		newest = max(newest, r.nano)
+	}
+	return newest, nil
+}
+
+// removeSegment deletes a segment and its index while holding the segment
+// lock, so a writer waiting for it reopens a fresh file instead.
+func removeSegment(path string) error {
+	f, err := openSegment(path, true, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(segmentIndexPath(path)); err != nil && !os.IsNotExist(err) {
+		closeSegment(f)
+		return err
+	}
+	err = os.Remove(path)
+	closeSegment(f)
+	if err != nil && !os.IsNotExist(err) {
+		// Windows can't delete a file that is still open
+		err = os.Remove(path)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Prune deletes the segments of earlier days whose records are all synced.
+// Today's segments stay even when synced, since writers may still append to
+// them; readers already skip records at or before the cursor. Unfinished pre
+// commands in a deleted segment are carried over to today's pre segment.
+func (s *segmentStore) Prune(ctx context.Context, cursor time.Time) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	// one prune at a time, across processes
+	lock, err := os.OpenFile(filepath.Join(s.dir, "LOCK"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock, true); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	today := segmentName(time.Now())
+	cursorNano := cursor.UnixNano()
+
+	// closedSynced lists the segments of a kind that can go
+	closedSynced := func(kind string) ([]string, error) {
+		paths, err := s.segmentPaths(kind)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]string, 0)
+		for _, path := range paths {
+			if strings.TrimSuffix(filepath.Base(path), segmentExt) >= today {
+				continue
+			}
+			newest, err := segmentMaxNano(path)
+			if err != nil {
+				return nil, err
+			}
+			if newest <= cursorNano {
+				result = append(result, path)
+			}
+		}
+		return result, nil
+	}
+
+	postCommands, err := s.GetPostCommands(ctx)
+	if err != nil {
+		return err
+	}
+
+	prePaths, err := closedSynced(segmentKindPre)
+	if err != nil {
+		return err
+	}
+	for _, path := range prePaths {
+		records, err := readSegment(path)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			pre := new(Command)
+			if err := unmarshalStorageRecord(rec.payload, pre); err != nil {
+				if errors.Is(err, ErrStorageKeyUnavailable) {
+					return err
+				}
+				continue
+			}
+			pre.RecordingTime = time.Unix(0, rec.nano)
+			if preHasSyncedPost(pre, postCommands, cursor) {
+				continue
+			}
+			if err := s.appendRecord(segmentKindPre, today, rec.nano, rec.payload); err != nil {
+				return err
+			}
+		}
+		if err := removeSegment(path); err != nil {
+			return err
+		}
+	}
+
+	for _, kind := range []string{segmentKindPost, segmentKindSessions} {
+		paths, err := closedSynced(kind)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err := removeSegment(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *segmentStore) Engine() string { return StorageEngineSegment }
+
+func (s *segmentStore) Close() error { return nil }
+
+// The segment engine keeps its local history archive in the file engine's
+// archive files, which are independent of the buffer files.
+
+func (s *segmentStore) AppendArchive(ctx context.Context, records []ArchivedCommand) error {
+	return newFileStore().AppendArchive(ctx, records)
+}
+
+func (s *segmentStore) QueryArchive(ctx context.Context, q ArchiveQuery) ([]ArchivedCommand, error) {
+	return newFileStore().QueryArchive(ctx, q)
+}
+
+func (s *segmentStore) PruneArchive(ctx context.Context, before time.Time) (int, error) {
+	return newFileStore().PruneArchive(ctx, before)
+}
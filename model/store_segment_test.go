@@ -0,0 +1,205 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func segmentCommand(command string, t time.Time) Command {
+	return Command{Shell: "zsh", SessionID: 7, Command: command, Username: "u", Hostname: "h", Time: t}
+}
+
+func TestSegmentStore_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := newSegmentStore(t.TempDir())
+	assert.Equal(t, StorageEngineSegment, s.Engine())
+
+	now := time.Now()
+	require.NoError(t, s.SavePre(ctx, segmentCommand("make", now), now))
+	require.NoError(t, s.SavePost(ctx, segmentCommand("make", now), 2, now.Add(time.Second)))
+	require.NoError(t, s.SaveSessionEvent(ctx, SessionEvent{Type: SessionEventStart, SessionID: 7, Shell: "zsh"}, now))
+
+	pres, err := s.GetPreCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, pres, 1)
+	assert.EqualValues(t, CommandPhasePre, pres[0].Phase)
+	assert.Equal(t, now.UnixNano(), pres[0].RecordingTime.UnixNano())
+
+	posts, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, 2, posts[0].Result)
+
+	tree, err := s.GetPreTree(ctx)
+	require.NoError(t, err)
+	assert.Len(t, tree[posts[0].GetUniqueKey()], 1)
+
+	events, err := s.GetSessionEvents(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, SessionEventStart, events[0].Type)
+
+	_, noCursor, err := s.GetLastCursor(ctx)
+	require.NoError(t, err)
+	assert.True(t, noCursor)
+	require.NoError(t, s.SetCursor(ctx, now))
+	cursor, noCursor, err := s.GetLastCursor(ctx)
+	require.NoError(t, err)
+	assert.False(t, noCursor)
+	assert.Equal(t, now.UnixNano(), cursor.UnixNano())
+}
+
+func TestSegmentStore_RecoversTornTail(t *testing.T) {
+	ctx := context.Background()
+	s := newSegmentStore(t.TempDir())
+	now := time.Now()
+	require.NoError(t, s.SavePost(ctx, segmentCommand("a", now), 0, now))
+
+	path := filepath.Join(s.dir, segmentKindPost, segmentName(now)+segmentExt)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	// half a record, as if the writer died mid-append
+	_, err = f.Write(encodeSegmentRecord(now.UnixNano(), []byte(`{"command":"torn"}`))[:12])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	posts, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+
+	// the next append cuts the torn bytes off instead of writing after them
+	require.NoError(t, s.SavePost(ctx, segmentCommand("b", now), 0, now.Add(time.Millisecond)))
+	posts, err = s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	assert.Equal(t, "b", posts[1].Command)
+
+	index, err := readSegmentIndex(segmentIndexPath(path))
+	require.NoError(t, err)
+	require.Len(t, index, 2)
+
+	// a lost index is rebuilt from the data
+	require.NoError(t, os.Remove(segmentIndexPath(path)))
+	require.NoError(t, s.SavePost(ctx, segmentCommand("c", now), 0, now.Add(2*time.Millisecond)))
+	index, err = readSegmentIndex(segmentIndexPath(path))
+	require.NoError(t, err)
+	assert.Len(t, index, 3)
+}
+
+func TestSegmentStore_SkipsCorruptRecord(t *testing.T) {
+	ctx := context.Background()
+	s := newSegmentStore(t.TempDir())
+	now := time.Now()
+	require.NoError(t, s.SavePost(ctx, segmentCommand("a", now), 0, now))
+	require.NoError(t, s.SavePost(ctx, segmentCommand("b", now), 0, now.Add(time.Millisecond)))
+
+	path := filepath.Join(s.dir, segmentKindPost, segmentName(now)+segmentExt)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-2] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	posts, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "a", posts[0].Command)
+}
+
+func TestSegmentStore_Prune(t *testing.T) {
+	ctx := context.Background()
+	s := newSegmentStore(t.TempDir())
+
+	old := time.Now().AddDate(0, 0, -2)
+	require.NoError(t, s.SavePre(ctx, segmentCommand("done", old), old))
+	require.NoError(t, s.SavePre(ctx, segmentCommand("running", old), old.Add(time.Second)))
+	require.NoError(t, s.SavePost(ctx, segmentCommand("done", old), 0, old.Add(2*time.Second)))
+	require.NoError(t, s.SaveSessionEvent(ctx, SessionEvent{Type: SessionEventStart, SessionID: 7}, old))
+
+	now := time.Now()
+	require.NoError(t, s.SavePre(ctx, segmentCommand("today", now), now))
+	require.NoError(t, s.SavePost(ctx, segmentCommand("today", now), 0, now))
+
+	require.NoError(t, s.Prune(ctx, now))
+
+	_, err := os.Stat(filepath.Join(s.dir, segmentKindPost, segmentName(old)+segmentExt))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(s.dir, segmentKindPost, segmentName(old)+segmentIndexExt))
+	assert.True(t, os.IsNotExist(err))
+
+	// today's segment stays even though it is synced
+	posts, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "today", posts[0].Command)
+
+	// the unfinished pre is carried over with its recording time
+	pres, err := s.GetPreCommands(ctx)
+	require.NoError(t, err)
+	commands := map[string]int64{}
+	for _, p := range pres {
+		commands[p.Command] = p.RecordingTime.UnixNano()
+	}
+	assert.Equal(t, map[string]int64{"today": now.UnixNano(), "running": old.Add(time.Second).UnixNano()}, commands)
+
+	events, err := s.GetSessionEvents(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// segments with unsynced records are kept
+	require.NoError(t, s.SavePost(ctx, segmentCommand("late", old), 0, old))
+	require.NoError(t, s.Prune(ctx, old.Add(-time.Hour)))
+	posts, err = s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Len(t, posts, 2)
+}
+
+func TestSegmentStore_ConcurrentAppends(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// a store per writer, like separate CLI processes
+			s := newSegmentStore(dir)
+			for i := 0; i < 25; i++ {
+				cmd := segmentCommand(fmt.Sprintf("w%d-%d", w, i), now)
+				assert.NoError(t, s.SavePost(ctx, cmd, 0, now.Add(time.Duration(w*100+i))))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	s := newSegmentStore(dir)
+	posts, err := s.GetPostCommands(ctx)
+	require.NoError(t, err)
+	assert.Len(t, posts, 200)
+
+	index, err := readSegmentIndex(segmentIndexPath(filepath.Join(dir, segmentKindPost, segmentName(now)+segmentExt)))
+	require.NoError(t, err)
+	assert.Len(t, index, 200)
+}
+
+func TestNewCommandStore_SegmentEngine(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitFolder("")
+
+	cfg := ShellTimeConfig{Storage: &StorageConfig{Engine: StorageEngineSegment}}
+	s, err := NewCommandStore(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, StorageEngineSegment, s.Engine())
+	assert.Equal(t, StorageEngineSegment, NewLocalStore(cfg).Engine())
+	assert.Equal(t, StorageEngineFile, NewLocalStore(ShellTimeConfig{Storage: &StorageConfig{Engine: StorageEngineBolt}}).Engine())
+	require.NoError(t, s.Close())
+}
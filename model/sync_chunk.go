@@ -0,0 +1,86 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Limits of a single /api/v1/track request. A larger payload is split into
+// chunks that are sent and acknowledged one after another.
+const (
+	SyncChunkMaxCommands = 500
+	SyncChunkMaxBytes    = 512 << 10
+)
+
+// SyncAckFunc is called after every chunk an endpoint acknowledged, with the
+// cursor up to which the data is synced to it.
+type SyncAckFunc func(ctx context.Context, endpoint Endpoint, cursor time.Time) error
+
+func (td TrackingData) cursorNano() int64 {
+	if td.recordingTime != 0 {
+		return td.recordingTime
+	}
+	return td.EndTimeNano
+}
+
+func (s TrackingSessionData) cursorNano() int64 {
+	if s.recordingTime != 0 {
+		return s.recordingTime
+	}
+	return s.TimeNano
+}
+
+// SplitTrackArgs splits a payload into chunks of at most maxCommands commands
+// and about maxBytes of JSON, oldest first. Every chunk but the last gets the
+// cursor of its newest command, so acknowledging it never skips unsent data;
+// the last one keeps the payload's CursorID. Session events travel with the
+// first chunk whose cursor covers them. Encrypted payloads are never split.
+func SplitTrackArgs(data PostTrackArgs, maxCommands, maxBytes int) []PostTrackArgs {
+	if data.Encrypted != "" || len(data.Data) == 0 {
+		return []PostTrackArgs{data}
+	}
+	if maxCommands <= 0 {
+		maxCommands = len(data.Data)
+	}
+
+	commands := make([]TrackingData, len(data.Data))
+	copy(commands, data.Data)
+	sort.SliceStable(commands, func(i, j int) bool { return commands[i].cursorNano() < commands[j].cursorNano() })
+
+	chunks := make([]PostTrackArgs, 0, 1)
+	start, size := 0, 0
+	for i, td := range commands {
+		buf, _ := json.Marshal(td)
+		if i > start && (i-start >= maxCommands || (maxBytes > 0 && size+len(buf) > maxBytes)) {
+			chunks = append(chunks, PostTrackArgs{CursorID: commands[i-1].cursorNano(), Data: commands[start:i], Meta: data.Meta})
+			start, size = i, 0
+		}
+		size += len(buf) + 1
+	}
+	chunks = append(chunks, PostTrackArgs{CursorID: data.CursorID, Data: commands[start:], Meta: data.Meta})
+
+	for _, session := range data.Sessions {
+		i := sort.Search(len(chunks)-1, func(i int) bool { return chunks[i].CursorID >= session.cursorNano() })
+		chunks[i].Sessions = append(chunks[i].Sessions, session)
+	}
+	return chunks
+}
+
+// After returns the part of the payload that is newer than cursor, i.e. what
+// is left to send once the chunks up to cursor were acknowledged.
+func (p PostTrackArgs) After(cursor int64) PostTrackArgs {
+	rest := PostTrackArgs{CursorID: p.CursorID, Meta: p.Meta}
+	for _, td := range p.Data {
+		if td.cursorNano() > cursor {
+			rest.Data = append(rest.Data, td)
+		}
+	}
+	for _, session := range p.Sessions {
+		if session.cursorNano() > cursor {
+			rest.Sessions = append(rest.Sessions, session)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,119 @@
+package model
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chunkTestArgs(n int) PostTrackArgs {
+	args := PostTrackArgs{Meta: TrackingMetaData{Shell: "zsh"}}
+	for i := 1; i <= n; i++ {
+		args.Data = append(args.Data, TrackingData{Command: "ls", EndTimeNano: int64(i * 10), recordingTime: int64(i * 10)})
+	}
+	args.CursorID = int64(n*10 + 5)
+	return args
+}
+
+func TestSplitTrackArgs_ByCount(t *testing.T) {
+	args := chunkTestArgs(5)
+	// out of order in the payload, oldest first in the chunks
+	args.Data[0], args.Data[4] = args.Data[4], args.Data[0]
+	args.Sessions = []TrackingSessionData{
+		{Type: "start", recordingTime: 15},
+		{Type: "end", recordingTime: 45},
+	}
+
+	chunks := SplitTrackArgs(args, 2, 0)
+	require.Len(t, chunks, 3)
+	assert.Equal(t, int64(20), chunks[0].CursorID)
+	assert.Equal(t, int64(40), chunks[1].CursorID)
+	assert.Equal(t, args.CursorID, chunks[2].CursorID)
+	assert.Equal(t, int64(10), chunks[0].Data[0].EndTimeNano)
+	assert.Len(t, chunks[2].Data, 1)
+	assert.Equal(t, "zsh", chunks[1].Meta.Shell)
+
+	require.Len(t, chunks[0].Sessions, 1)
+	assert.Equal(t, "start", chunks[0].Sessions[0].Type)
+	assert.Empty(t, chunks[1].Sessions)
+	require.Len(t, chunks[2].Sessions, 1)
+	assert.Equal(t, "end", chunks[2].Sessions[0].Type)
+}
+
+func TestSplitTrackArgs_ByBytes(t *testing.T) {
+	args := chunkTestArgs(4)
+	for i := range args.Data {
+		args.Data[i].Command = strings.Repeat("x", 1000)
+	}
+	chunks := SplitTrackArgs(args, 100, 2500)
+	require.Len(t, chunks, 2)
+	assert.Len(t, chunks[0].Data, 2)
+	assert.Len(t, chunks[1].Data, 2)
+
+	// a single command over the limit still gets its own chunk
+	chunks = SplitTrackArgs(args, 100, 10)
+	assert.Len(t, chunks, 4)
+}
+
+func TestSplitTrackArgs_Unsplittable(t *testing.T) {
+	encrypted := PostTrackArgs{Encrypted: "abc", AesKey: "k", Nonce: "n"}
+	assert.Equal(t, []PostTrackArgs{encrypted}, SplitTrackArgs(encrypted, 1, 1))
+
+	empty := PostTrackArgs{CursorID: 7, Sessions: []TrackingSessionData{{Type: "start"}}}
+	assert.Equal(t, []PostTrackArgs{empty}, SplitTrackArgs(empty, 1, 1))
+}
+
+func TestPostTrackArgs_After(t *testing.T) {
+	args := chunkTestArgs(3)
+	args.Sessions = []TrackingSessionData{{Type: "start", TimeNano: 15}, {Type: "end", TimeNano: 25}}
+
+	rest := args.After(20)
+	require.Len(t, rest.Data, 1)
+	assert.Equal(t, int64(30), rest.Data[0].EndTimeNano)
+	require.Len(t, rest.Sessions, 1)
+	assert.Equal(t, "end", rest.Sessions[0].Type)
+	assert.Equal(t, args.CursorID, rest.CursorID)
+}
+
+func TestSendLocalDataToServer_AcksEachChunk(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config := ShellTimeConfig{Token: "token", APIEndpoint: server.URL}
+	args := chunkTestArgs(SyncChunkMaxCommands*3 + 1)
+
+	var acked []time.Time
+	opts := SyncOptions{OnAck: func(ctx context.Context, endpoint Endpoint, cursor time.Time) error {
+		acked = append(acked, cursor)
+		return nil
+	}}
+	err := SendLocalDataToServer(context.Background(), config, args, opts)
+	require.Error(t, err)
+	assert.Equal(t, int32(3), requests.Load(), "the sync stops at the failing chunk")
+	require.Len(t, acked, 2)
+	assert.Equal(t, int64(SyncChunkMaxCommands*10), acked[0].UnixNano())
+	assert.Equal(t, int64(SyncChunkMaxCommands*20), acked[1].UnixNano())
+
+	// resuming sends only what's left
+	requests.Store(10)
+	rest := args.After(acked[1].UnixNano())
+	acked = nil
+	require.NoError(t, SendLocalDataToServer(context.Background(), config, rest, opts))
+	assert.Equal(t, int32(12), requests.Load())
+	require.Len(t, acked, 2)
+	assert.Equal(t, args.CursorID, acked[1].UnixNano())
+}
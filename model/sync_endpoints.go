@@ -0,0 +1,233 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// EndpointKey identifies an endpoint in the per-endpoint sync cursors and
+// retry queues. The token is hashed in, so two accounts on one server get
+// their own cursors without the token ending up on disk.
+func EndpointKey(e Endpoint) string {
+	sum := sha256.Sum256([]byte(e.APIEndpoint + "\x00" + e.Token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SyncEndpoints returns the endpoints commands are synced to: the main one
+// followed by config.Endpoints.
+func SyncEndpoints(config ShellTimeConfig) []Endpoint {
+	endpoints := make([]Endpoint, 0, len(config.Endpoints)+1)
+	endpoints = append(endpoints, Endpoint{Token: config.Token, APIEndpoint: config.APIEndpoint})
+	return append(endpoints, config.Endpoints...)
+}
+
+// EndpointError is the failure of a sync to one endpoint. The other endpoints
+// are synced regardless.
+type EndpointError struct {
+	Endpoint Endpoint
+	Err      error
+}
+
+func (e *EndpointError) Error() string {
+	return fmt.Sprintf("sync to %s failed: %v", e.Endpoint.APIEndpoint, e.Err)
+}
+
+func (e *EndpointError) Unwrap() error {
+	return e.Err
+}
+
+// EndpointErrors returns the per-endpoint failures in an error returned by
+// SendLocalDataToServer.
+func EndpointErrors(err error) []*EndpointError {
+	var result []*EndpointError
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			result = append(result, EndpointErrors(e)...)
+		}
+		return result
+	}
+	var endpointErr *EndpointError
+	if errors.As(err, &endpointErr) {
+		result = append(result, endpointErr)
+	}
+	return result
+}
+
+// SyncOptions tune SendLocalDataToServer. The zero value sends the whole
+// payload to every endpoint.
+type SyncOptions struct {
+	// Cursors holds the cursor of each endpoint by EndpointKey. An endpoint
+	// only gets the commands after its cursor.
+	Cursors map[string]time.Time
+	// Only, when set, limits the sync to the endpoint with this key.
+	Only string
+	// Check is called before syncing to an endpoint. An error skips the
+	// endpoint, e.g. while its circuit breaker is open.
+	Check func(endpoint Endpoint) error
+	// Prepare is called on every chunk before it is sent, e.g. to encrypt
+	// it. A chunk left without data or sessions is acknowledged unsent.
+	Prepare func(endpoint Endpoint, chunk PostTrackArgs) (PostTrackArgs, error)
+	// OnAck is called after every chunk an endpoint acknowledged.
+	OnAck SyncAckFunc
+}
+
+// EndpointCursors tracks the sync cursors of a store's endpoints during a
+// sync. Endpoints acknowledge chunks concurrently.
+type EndpointCursors struct {
+	mu      sync.Mutex
+	store   CommandStore
+	cursors map[string]time.Time
+}
+
+// LoadEndpointCursors reads the cursor of every endpoint in config from
+// store. An endpoint without one, e.g. one just added to the config, starts
+// at base, the store's cursor.
+func LoadEndpointCursors(ctx context.Context, store CommandStore, config ShellTimeConfig, base time.Time) (*EndpointCursors, error) {
+	stored, err := store.GetEndpointCursors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursors := make(map[string]time.Time)
+	for _, endpoint := range SyncEndpoints(config) {
+		key := EndpointKey(endpoint)
+		cursor, ok := stored[key]
+		if !ok || cursor.Before(base) {
+			cursor = base
+		}
+		cursors[key] = cursor
+	}
+	return &EndpointCursors{store: store, cursors: cursors}, nil
+}
+
+// Cursors returns a copy of the cursors, for SyncOptions.Cursors.
+func (c *EndpointCursors) Cursors() map[string]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make(map[string]time.Time, len(c.cursors))
+	for key, cursor := range c.cursors {
+		result[key] = cursor
+	}
+	return result
+}
+
+// Ack records an acknowledged chunk in the store. It is a SyncAckFunc.
+func (c *EndpointCursors) Ack(ctx context.Context, endpoint Endpoint, cursor time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := EndpointKey(endpoint)
+	if err := c.store.SetEndpointCursor(ctx, key, cursor); err != nil {
+		return err
+	}
+	c.cursors[key] = cursor
+	return nil
+}
+
+// Synced returns the cursor every endpoint has reached: the data up to it
+// can be archived and pruned.
+func (c *EndpointCursors) Synced() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var synced time.Time
+	first := true
+	for _, cursor := range c.cursors {
+		if first || cursor.Before(synced) {
+			synced, first = cursor, false
+		}
+	}
+	return synced
+}
+
+// PendingCounts returns, by EndpointKey, how many post commands in store are
+// not yet synced to each endpoint in config.
+func PendingCounts(ctx context.Context, store CommandStore, config ShellTimeConfig) (map[string]int, error) {
+	base, _, err := store.GetLastCursor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursors, err := LoadEndpointCursors(ctx, store, config, base)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := store.GetPostCommands(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(cursors.cursors))
+	for key, cursor := range cursors.cursors {
+		result[key] = 0
+		for _, post := range posts {
+			if post != nil && post.RecordingTime.After(cursor) {
+				result[key]++
+			}
+		}
+	}
+	return result, nil
+}
+
+// endpointCursorFileMu serializes the read-modify-write of the endpoint
+// cursor files of the file and segment stores.
+var endpointCursorFileMu sync.Mutex
+
+func readEndpointCursorFile(path string) (map[string]time.Time, error) {
+	result := make(map[string]time.Time)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	var nanos map[string]int64
+	if err := json.Unmarshal(content, &nanos); err != nil {
+		return nil, fmt.Errorf("invalid endpoint cursors %s: %w", path, err)
+	}
+	for key, nano := range nanos {
+		result[key] = time.Unix(0, nano)
+	}
+	return result, nil
+}
+
+// writeEndpointCursorFile sets one cursor in the file, replacing it through
+// a rename so a reader never sees it half written.
+func writeEndpointCursorFile(path, key string, cursor time.Time) error {
+	endpointCursorFileMu.Lock()
+	defer endpointCursorFileMu.Unlock()
+
+	cursors, err := readEndpointCursorFile(path)
+	if err != nil {
+		return err
+	}
+	cursors[key] = cursor
+	nanos := make(map[string]int64, len(cursors))
+	for k, c := range cursors {
+		nanos[k] = c.UnixNano()
+	}
+	content, err := json.Marshal(nanos)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "endpoint-cursors-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointCursors(t *testing.T) {
+	for name, open := range map[string]func(t *testing.T) CommandStore{
+		StorageEngineFile: func(t *testing.T) CommandStore { setupMigrateTest(t); return newFileStore() },
+		StorageEngineBolt: func(t *testing.T) CommandStore {
+			s, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+			require.NoError(t, err)
+			return s
+		},
+		StorageEngineSegment: func(t *testing.T) CommandStore { return newSegmentStore(t.TempDir()) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+
+			ctx := context.Background()
+			cursors, err := store.GetEndpointCursors(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, cursors)
+
+			first, second := time.Unix(0, 100), time.Unix(0, 200)
+			require.NoError(t, store.SetEndpointCursor(ctx, "a", first))
+			require.NoError(t, store.SetEndpointCursor(ctx, "b", first))
+			require.NoError(t, store.SetEndpointCursor(ctx, "a", second))
+
+			cursors, err = store.GetEndpointCursors(ctx)
+			require.NoError(t, err)
+			require.Len(t, cursors, 2)
+			assert.Equal(t, second.UnixNano(), cursors["a"].UnixNano())
+			assert.Equal(t, first.UnixNano(), cursors["b"].UnixNano())
+		})
+	}
+}
+
+func TestLoadEndpointCursors(t *testing.T) {
+	store := newSegmentStore(t.TempDir())
+	ctx := context.Background()
+	config := ShellTimeConfig{Token: "t", APIEndpoint: "https://main", Endpoints: []Endpoint{{Token: "t2", APIEndpoint: "https://mirror"}}}
+	main, mirror := EndpointKey(SyncEndpoints(config)[0]), EndpointKey(config.Endpoints[0])
+	assert.NotEqual(t, main, mirror)
+
+	base := time.Unix(0, 100)
+	require.NoError(t, store.SetEndpointCursor(ctx, main, time.Unix(0, 300)))
+	require.NoError(t, store.SetEndpointCursor(ctx, mirror, time.Unix(0, 50)))
+
+	cursors, err := LoadEndpointCursors(ctx, store, config, base)
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), cursors.Cursors()[main].UnixNano())
+	assert.Equal(t, base, cursors.Cursors()[mirror], "a cursor behind the store's starts at the store's")
+	assert.Equal(t, base, cursors.Synced())
+
+	require.NoError(t, cursors.Ack(ctx, config.Endpoints[0], time.Unix(0, 400)))
+	assert.Equal(t, int64(300), cursors.Synced().UnixNano())
+	stored, err := store.GetEndpointCursors(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(400), stored[mirror].UnixNano())
+}
+
+func TestSendLocalDataToServer_EndpointCursors(t *testing.T) {
+	var mainSent, mirrorSent atomic.Int32
+	main := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mainSent.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer main.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorSent.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mirror.Close()
+
+	store := newSegmentStore(t.TempDir())
+	ctx := context.Background()
+	config := ShellTimeConfig{Token: "t", APIEndpoint: main.URL, Endpoints: []Endpoint{{Token: "t2", APIEndpoint: mirror.URL}}}
+	args := chunkTestArgs(3)
+
+	cursors, err := LoadEndpointCursors(ctx, store, config, time.Unix(0, 0))
+	require.NoError(t, err)
+	err = SendLocalDataToServer(ctx, config, args, SyncOptions{Cursors: cursors.Cursors(), OnAck: cursors.Ack})
+	failed := EndpointErrors(err)
+	require.Len(t, failed, 1, "only the mirror fails")
+	assert.Equal(t, mirror.URL, failed[0].Endpoint.APIEndpoint)
+	assert.Equal(t, int64(0), cursors.Synced().UnixNano(), "the dead mirror holds back what can be pruned")
+
+	// the main endpoint has nothing left to send; the mirror gets it all
+	cursors, err = LoadEndpointCursors(ctx, store, config, time.Unix(0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, args.CursorID, cursors.Cursors()[EndpointKey(SyncEndpoints(config)[0])].UnixNano())
+	_ = SendLocalDataToServer(ctx, config, args, SyncOptions{Cursors: cursors.Cursors(), OnAck: cursors.Ack})
+	assert.Equal(t, int32(1), mainSent.Load())
+	assert.Equal(t, int32(2), mirrorSent.Load())
+
+	// Only limits a retry to the endpoint that failed it
+	_ = SendLocalDataToServer(ctx, config, args, SyncOptions{Only: EndpointKey(config.Endpoints[0])})
+	assert.Equal(t, int32(1), mainSent.Load())
+	assert.Equal(t, int32(3), mirrorSent.Load())
+}
+
+func TestPendingCounts(t *testing.T) {
+	store := newSegmentStore(t.TempDir())
+	ctx := context.Background()
+	config := ShellTimeConfig{Token: "t", APIEndpoint: "https://main", Endpoints: []Endpoint{{Token: "t2", APIEndpoint: "https://mirror"}}}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		require.NoError(t, store.SavePost(ctx, Command{Shell: "zsh", Command: "ls", Time: at}, 0, at))
+	}
+	require.NoError(t, store.SetEndpointCursor(ctx, EndpointKey(SyncEndpoints(config)[0]), start.Add(time.Second)))
+
+	counts, err := PendingCounts(ctx, store, config)
+	require.NoError(t, err)
+	assert.Equal(t, 1, counts[EndpointKey(SyncEndpoints(config)[0])])
+	assert.Equal(t, 3, counts[EndpointKey(config.Endpoints[0])])
+}
@@ -0,0 +1,353 @@
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of the payloads waiting in the pending files.
+const (
+	PendingKindSync      = "sync"
+	PendingKindHeartbeat = "heartbeat"
+	// PendingKindInvalid is a line that can't be decoded. It is never
+	// retried and can only be dropped.
+	PendingKindInvalid = "invalid"
+)
+
+// pendingFilesMu serializes the appends to the pending files with the
+// rewrites that drop payloads from them: DropPendingPayloads, and
+// RemovePendingLines for the retries of the circuit breaker and of the
+// heartbeats. rewritePendingFile is only called with it held.
+var pendingFilesMu sync.Mutex
+
+// AppendPendingLine appends one payload to the pending file at path. The
+// payload holds the commands, so it is encrypted like the stored records and
+// the file is readable by its owner only.
+func AppendPendingLine(path string, line []byte) error {
+	sealed, err := sealStorageRecord(line)
+	if err != nil {
+		return err
+	}
+
+	pendingFilesMu.Lock()
+	defer pendingFilesMu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(sealed[:len(sealed):len(sealed)], '\n'))
+	return err
+}
+
+// OpenPendingLine returns the payload of a line read from a pending file,
+// decrypting it when it was written with storage encryption on.
+func OpenPendingLine(line []byte) ([]byte, error) {
+	return openStorageRecord(line)
+}
+
+// PendingPayload describes one payload waiting in a pending file.
+type PendingPayload struct {
+	// Index numbers the payloads of all pending files from 1, in the order
+	// ListPendingPayloads returns them.
+	Index int `json:"index"`
+	// File is the pending file the payload is in, relative to $HOME.
+	File string `json:"file"`
+	Kind string `json:"kind"`
+	// Endpoint is the EndpointKey a sync payload is retried to. Payloads
+	// queued before there was a queue per endpoint are retried to all.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Items counts the commands and sessions, or the heartbeats.
+	Items int `json:"items"`
+	Size  int `json:"size"`
+	// At is when the newest item in the payload happened, or the file's
+	// modification time when the payload holds no timestamps.
+	At time.Time `json:"at"`
+}
+
+// SyncPendingFileFor returns the retry queue of the endpoint with the given
+// key, relative to $HOME.
+func SyncPendingFileFor(key string) string {
+	return strings.TrimSuffix(SYNC_PENDING_FILE, ".jsonl") + "-" + key + ".jsonl"
+}
+
+// PendingFiles returns the pending files that exist, relative to $HOME: the
+// retry queue of daemons from before the per-endpoint queues, those of the
+// endpoints, and the failed heartbeats.
+func PendingFiles() []string {
+	home := os.ExpandEnv("$HOME/")
+	files := []string{SYNC_PENDING_FILE}
+	matches, _ := filepath.Glob(home + SyncPendingFileFor("*"))
+	sort.Strings(matches)
+	for _, match := range matches {
+		files = append(files, strings.TrimPrefix(match, home))
+	}
+	files = append(files, HEARTBEAT_LOG_FILE)
+
+	result := files[:0]
+	for _, file := range files {
+		if _, err := os.Stat(home + file); err == nil {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// ListPendingPayloads describes every payload in the pending files.
+func ListPendingPayloads() ([]PendingPayload, error) {
+	var result []PendingPayload
+	err := walkPendingPayloads(func(p PendingPayload, _, _ []byte) error {
+		result = append(result, p)
+		return nil
+	})
+	return result, err
+}
+
+// ReadPendingPayload returns the payload with the given index and its
+// content: the sync or heartbeat payload itself, without the envelope the
+// daemon saved it in.
+func ReadPendingPayload(index int) (PendingPayload, json.RawMessage, error) {
+	var found PendingPayload
+	var content json.RawMessage
+	errFound := errors.New("found")
+	err := walkPendingPayloads(func(p PendingPayload, _, line []byte) error {
+		if p.Index != index {
+			return nil
+		}
+		found = p
+		content = json.RawMessage(line)
+		if p.Kind == PendingKindSync {
+			var msg struct {
+				Payload json.RawMessage `json:"payload"`
+			}
+			if json.Unmarshal(line, &msg) == nil && len(msg.Payload) > 0 {
+				content = msg.Payload
+			}
+		}
+		return errFound
+	})
+	if err != nil && err != errFound {
+		return PendingPayload{}, nil, err
+	}
+	if err == nil {
+		return PendingPayload{}, nil, fmt.Errorf("no pending payload #%d", index)
+	}
+	return found, content, nil
+}
+
+// DropPendingPayloads removes the payloads that are older than olderThan from
+// the pending files and returns how many were dropped.
+func DropPendingPayloads(olderThan time.Duration) (int, error) {
+	pendingFilesMu.Lock()
+	defer pendingFilesMu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	kept := make(map[string][]string)
+	dropped := make(map[string]int)
+	err := walkPendingPayloads(func(p PendingPayload, raw, _ []byte) error {
+		if p.At.Before(cutoff) {
+			dropped[p.File]++
+			return nil
+		}
+		kept[p.File] = append(kept[p.File], string(raw))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for file, n := range dropped {
+		if err := rewritePendingFile(os.ExpandEnv("$HOME/"+file), kept[file]); err != nil {
+			return count, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// walkPendingPayloads calls fn with every payload in the pending files, its
+// line as stored and the decrypted one. A line that can't be decrypted is
+// described as invalid, without a decrypted line. An error from fn stops the
+// walk and is returned.
+func walkPendingPayloads(fn func(p PendingPayload, raw, line []byte) error) error {
+	index := 0
+	for _, file := range PendingFiles() {
+		path := os.ExpandEnv("$HOME/" + file)
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		var modTime time.Time
+		if info, err := f.Stat(); err == nil {
+			modTime = info.ModTime()
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxPendingLineSize)
+		for scanner.Scan() {
+			raw := scanner.Bytes()
+			if len(raw) == 0 {
+				continue
+			}
+			index++
+			line, err := OpenPendingLine(raw)
+			p := PendingPayload{File: file, Kind: PendingKindInvalid, Size: len(raw)}
+			if err == nil {
+				p = describePendingLine(file, line)
+			}
+			p.Index = index
+			if p.At.IsZero() {
+				p.At = modTime
+			}
+			if err := fn(p, raw, line); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func describePendingLine(file string, line []byte) PendingPayload {
+	p := PendingPayload{File: file, Kind: PendingKindInvalid, Size: len(line)}
+
+	if file == HEARTBEAT_LOG_FILE {
+		var payload HeartbeatPayload
+		if json.Unmarshal(line, &payload) != nil {
+			return p
+		}
+		p.Kind = PendingKindHeartbeat
+		p.Items = len(payload.Heartbeats)
+		for _, hb := range payload.Heartbeats {
+			if at := time.Unix(hb.Time, 0); at.After(p.At) {
+				p.At = at
+			}
+		}
+		return p
+	}
+
+	var msg struct {
+		Type    string `json:"type"`
+		Payload struct {
+			PostTrackArgs
+			Endpoint string `json:"endpoint"`
+		} `json:"payload"`
+	}
+	if json.Unmarshal(line, &msg) != nil || msg.Type == "" {
+		return p
+	}
+	p.Kind = msg.Type
+	p.Endpoint = msg.Payload.Endpoint
+	p.Items = len(msg.Payload.Data) + len(msg.Payload.Sessions)
+	for _, td := range msg.Payload.Data {
+		at := time.Unix(0, td.EndTimeNano)
+		if td.EndTimeNano == 0 {
+			at = time.Unix(td.EndTime, 0)
+		}
+		if at.After(p.At) {
+			p.At = at
+		}
+	}
+	for _, session := range msg.Payload.Sessions {
+		if at := time.Unix(0, session.TimeNano); at.After(p.At) {
+			p.At = at
+		}
+	}
+	if p.At.Unix() <= 0 {
+		p.At = time.Time{}
+	}
+	return p
+}
+
+// RemovePendingLines removes lines, as read from the pending file at path,
+// once each. The file is read again under the lock, so the payloads appended
+// or dropped since lines were read stay as they are.
+func RemovePendingLines(path string, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	pendingFilesMu.Lock()
+	defer pendingFilesMu.Unlock()
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	remove := make(map[string]int, len(lines))
+	for _, line := range lines {
+		remove[line]++
+	}
+	var kept []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+		if remove[line] > 0 {
+			remove[line]--
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return rewritePendingFile(path, kept)
+}
+
+// rewritePendingFile replaces the content of a pending file with lines,
+// through a rename so a crash never leaves it half written. Without lines
+// the file is removed. The caller holds pendingFilesMu.
+func rewritePendingFile(path string, lines []string) error {
+	if len(lines) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove empty log file: %w", err)
+		}
+		return nil
+	}
+
+	tempFile := path + ".tmp"
+	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	for _, line := range lines {
+		if _, err := file.WriteString(line + "\n"); err != nil {
+			file.Close()
+			os.Remove(tempFile)
+			return fmt.Errorf("failed to write to temp file: %w", err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePendingFixture queues a legacy sync payload, one bound to an endpoint,
+// an undecodable line and a heartbeat payload, with their newest items at
+// the given times.
+func writePendingFixture(t *testing.T, old, recent time.Time) {
+	t.Helper()
+	setupMigrateTest(t)
+	home := os.Getenv("HOME")
+
+	legacy, err := json.Marshal(map[string]interface{}{
+		"type": "sync",
+		"payload": PostTrackArgs{CursorID: old.UnixNano(), Data: []TrackingData{
+			{Command: "make", EndTimeNano: old.UnixNano()},
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, AppendPendingLine(filepath.Join(home, SYNC_PENDING_FILE), legacy))
+
+	bound, err := json.Marshal(map[string]interface{}{
+		"type": "sync",
+		"payload": map[string]interface{}{
+			"cursorId": recent.UnixNano(),
+			"data":     []TrackingData{{Command: "ls", EndTimeNano: old.UnixNano()}, {Command: "pwd", EndTimeNano: recent.UnixNano()}},
+			"endpoint": "abc",
+		},
+	})
+	require.NoError(t, err)
+	endpointFile := filepath.Join(home, SyncPendingFileFor("abc"))
+	require.NoError(t, AppendPendingLine(endpointFile, bound))
+	require.NoError(t, AppendPendingLine(endpointFile, []byte("not json")))
+
+	heartbeat, err := json.Marshal(HeartbeatPayload{Heartbeats: []HeartbeatData{{HeartbeatID: "h", Time: old.Unix()}}})
+	require.NoError(t, err)
+	require.NoError(t, AppendPendingLine(filepath.Join(home, HEARTBEAT_LOG_FILE), heartbeat))
+}
+
+func TestListPendingPayloads(t *testing.T) {
+	old, recent := time.Now().Add(-72*time.Hour), time.Now().Add(-time.Hour)
+	writePendingFixture(t, old, recent)
+
+	assert.Equal(t, []string{SYNC_PENDING_FILE, SyncPendingFileFor("abc"), HEARTBEAT_LOG_FILE}, PendingFiles())
+
+	payloads, err := ListPendingPayloads()
+	require.NoError(t, err)
+	require.Len(t, payloads, 4)
+	for i, p := range payloads {
+		assert.Equal(t, i+1, p.Index)
+	}
+
+	assert.Equal(t, PendingKindSync, payloads[0].Kind)
+	assert.Empty(t, payloads[0].Endpoint)
+	assert.Equal(t, old.UnixNano(), payloads[0].At.UnixNano())
+
+	assert.Equal(t, "abc", payloads[1].Endpoint)
+	assert.Equal(t, 2, payloads[1].Items)
+	assert.Equal(t, recent.UnixNano(), payloads[1].At.UnixNano(), "a payload is as old as its newest command")
+
+	assert.Equal(t, PendingKindInvalid, payloads[2].Kind)
+	assert.Equal(t, len("not json"), payloads[2].Size)
+	assert.WithinDuration(t, time.Now(), payloads[2].At, time.Minute, "falls back to the file's modification time")
+
+	assert.Equal(t, PendingKindHeartbeat, payloads[3].Kind)
+	assert.Equal(t, 1, payloads[3].Items)
+	assert.Equal(t, old.Unix(), payloads[3].At.Unix())
+}
+
+func TestReadPendingPayload(t *testing.T) {
+	writePendingFixture(t, time.Now().Add(-72*time.Hour), time.Now())
+
+	p, content, err := ReadPendingPayload(2)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", p.Endpoint)
+	var args PostTrackArgs
+	require.NoError(t, json.Unmarshal(content, &args), "the envelope is stripped")
+	require.Len(t, args.Data, 2)
+	assert.Equal(t, "pwd", args.Data[1].Command)
+
+	_, content, err = ReadPendingPayload(3)
+	require.NoError(t, err)
+	assert.Equal(t, "not json", string(content))
+
+	_, _, err = ReadPendingPayload(5)
+	assert.ErrorContains(t, err, "no pending payload #5")
+}
+
+func TestDropPendingPayloads(t *testing.T) {
+	writePendingFixture(t, time.Now().Add(-72*time.Hour), time.Now().Add(-time.Hour))
+
+	dropped, err := DropPendingPayloads(48 * time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, dropped)
+
+	// the emptied files are removed
+	assert.Equal(t, []string{SyncPendingFileFor("abc")}, PendingFiles())
+	payloads, err := ListPendingPayloads()
+	require.NoError(t, err)
+	require.Len(t, payloads, 2)
+	assert.Equal(t, "abc", payloads[0].Endpoint)
+	assert.Equal(t, PendingKindInvalid, payloads[1].Kind)
+
+	dropped, err = DropPendingPayloads(time.Nanosecond)
+	require.NoError(t, err)
+	assert.Equal(t, 2, dropped)
+	assert.Empty(t, PendingFiles())
+}
+
+func TestPendingFilesEncrypted(t *testing.T) {
+	setupEncryptionTest(t, StorageEncryptionConfig{})
+	old, recent := time.Now().Add(-72*time.Hour), time.Now().Add(-time.Hour)
+	writePendingFixture(t, old, recent)
+
+	path := filepath.Join(os.Getenv("HOME"), SyncPendingFileFor("abc"))
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "pwd")
+	assert.Contains(t, string(raw), storageRecordPrefix)
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	payloads, err := ListPendingPayloads()
+	require.NoError(t, err)
+	require.Len(t, payloads, 4)
+	assert.Equal(t, PendingKindSync, payloads[1].Kind)
+	assert.Equal(t, 2, payloads[1].Items)
+	assert.Equal(t, PendingKindHeartbeat, payloads[3].Kind)
+
+	_, content, err := ReadPendingPayload(2)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "pwd")
+
+	// a drop keeps the remaining lines encrypted
+	_, err = DropPendingPayloads(48 * time.Hour)
+	require.NoError(t, err)
+	raw, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "pwd")
+	_, content, err = ReadPendingPayload(1)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "pwd")
+}
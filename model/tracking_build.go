@@ -10,13 +10,15 @@ import (
 type TrackingBuildResult struct {
 	Data                []TrackingData
 	Meta                TrackingMetaData
+	Sessions            []TrackingSessionData
 	LatestRecordingTime time.Time
 	Cursor              time.Time
 	NoCursorExist       bool
 }
 
 // BuildTrackingData assembles the tracking payload for all post commands newer
-// than the store's cursor, pairing each with its closest pre command. It is the
+// than the store's cursor, pairing each with its closest pre command, plus the
+// session events recorded in the same window. It is the
 // shared assembly used by both the CLI fallback path and the daemon's bolt path
 // (previously inlined in commands.trySyncLocalToServer).
 //
@@ -59,6 +61,7 @@ func BuildTrackingData(ctx context.Context, store CommandStore, config ShellTime
 		CliEngine: store.Engine(),
 	}
 
+	pres := newPreIndex(preTree)
 	trackingData := make([]TrackingData, 0)
 	latest := cursor
 
@@ -67,16 +70,17 @@ func BuildTrackingData(ctx context.Context, store CommandStore, config ShellTime
 			continue
 		}
 
+		// records at the cursor are synced; the segment engine keeps them
+		// until their day's segment is pruned
 		recordingTime := postCommand.RecordingTime
-		if recordingTime.Before(cursor) {
+		if !recordingTime.After(cursor) {
 			continue
 		}
 		if recordingTime.After(latest) {
 			latest = recordingTime
 		}
 
-		key := postCommand.GetUniqueKey()
-		preCommands, ok := preTree[key]
+		preCommands, ok := pres.lookup(postCommand)
 		if !ok {
 			continue
 		}
@@ -98,12 +102,17 @@ func BuildTrackingData(ctx context.Context, store CommandStore, config ShellTime
 		closestPreCommand := postCommand.FindClosestCommand(preCommands, false)
 
 		td := TrackingData{
+			ID:          postCommand.RecordID(),
 			SessionID:   postCommand.SessionID,
 			Command:     postCommand.Command,
 			EndTime:     postCommand.Time.Unix(),
 			EndTimeNano: postCommand.Time.UnixNano(),
 			Result:      postCommand.Result,
 			PPID:        postCommand.PPID,
+			Cwd:         postCommand.Cwd,
+			PipeStatus:  postCommand.PipeStatus,
+
+			recordingTime: recordingTime.UnixNano(),
 		}
 
 		if config.DataMasking != nil && *config.DataMasking {
@@ -111,15 +120,71 @@ func BuildTrackingData(ctx context.Context, store CommandStore, config ShellTime
 		}
 
 		if closestPreCommand != nil {
+			// the pre command knows when the command started
+			if !closestPreCommand.Time.IsZero() && postCommand.Duration == 0 {
+				td.ID = closestPreCommand.RecordID()
+			}
 			td.StartTime = closestPreCommand.Time.Unix()
 			td.StartTimeNano = closestPreCommand.Time.UnixNano()
+			// the pre command saw $PWD before the command ran, so a `cd` is
+			// attributed to the directory it was typed in
+			if closestPreCommand.Cwd != "" {
+				td.Cwd = closestPreCommand.Cwd
+			}
+		}
+
+		// a duration measured by the shell beats the pre/post pairing, which
+		// includes the hook's own process startup
+		if postCommand.Duration > 0 {
+			start := postCommand.Time.Add(-postCommand.Duration)
+			td.StartTime = start.Unix()
+			td.StartTimeNano = start.UnixNano()
 		}
 
 		trackingData = append(trackingData, td)
 	}
 
+	sessions, err := buildTrackingSessions(ctx, store, cursor, latest)
+	if err != nil {
+		return res, err
+	}
+
 	res.Data = trackingData
 	res.Meta = meta
+	res.Sessions = sessions
 	res.LatestRecordingTime = latest
 	return res, nil
 }
+
+// buildTrackingSessions collects the session events recorded between the
+// cursor and latest. Later ones stay in the store for the next sync, since
+// Prune only drops records up to the cursor that is about to be set.
+func buildTrackingSessions(ctx context.Context, store CommandStore, cursor, latest time.Time) ([]TrackingSessionData, error) {
+	events, err := store.GetSessionEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result []TrackingSessionData
+	for _, ev := range events {
+		if ev == nil || !ev.RecordingTime.After(cursor) || ev.RecordingTime.After(latest) {
+			continue
+		}
+		result = append(result, TrackingSessionData{
+			SessionID:     ev.SessionID,
+			Type:          string(ev.Type),
+			Shell:         ev.Shell,
+			Time:          ev.Time.Unix(),
+			TimeNano:      ev.Time.UnixNano(),
+			PPID:          ev.PPID,
+			TTY:           ev.TTY,
+			SSHConnection: ev.SSHConnection,
+			TermProgram:   ev.TermProgram,
+			Cwd:           ev.Cwd,
+			Terminal:      ev.Terminal,
+			Multiplexer:   ev.Multiplexer,
+
+			recordingTime: ev.RecordingTime.UnixNano(),
+		})
+	}
+	return result, nil
+}
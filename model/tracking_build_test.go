@@ -83,3 +83,142 @@ func TestBuildTrackingDataEmpty(t *testing.T) {
 	require.NoError(t, err)
 	require.Empty(t, res.Data)
 }
+
+func TestBuildTrackingDataCwdFromPre(t *testing.T) {
+	store, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	cmd := Command{Shell: "zsh", SessionID: 5, Command: "cd ../other", Username: "u", Time: start, Cwd: "/work/repo"}
+	require.NoError(t, store.SavePre(ctx, cmd, start))
+	post := cmd
+	post.Time = start.Add(time.Millisecond)
+	post.Cwd = "/work/other"
+	require.NoError(t, store.SavePost(ctx, post, 0, post.Time))
+
+	res, err := BuildTrackingData(ctx, store, ShellTimeConfig{})
+	require.NoError(t, err)
+	require.Len(t, res.Data, 1)
+	require.Equal(t, "/work/repo", res.Data[0].Cwd, "the directory the command was typed in wins")
+}
+
+func TestBuildTrackingDataShellTiming(t *testing.T) {
+	store, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	cmd := Command{Shell: "fish", SessionID: 3, Command: "cat a | grep b", Username: "u", Time: start}
+	require.NoError(t, store.SavePre(ctx, cmd, start))
+	post := cmd
+	post.Time = start.Add(5 * time.Second)
+	post.Duration = 1500 * time.Millisecond
+	post.PipeStatus = []int{0, 1}
+	require.NoError(t, store.SavePost(ctx, post, 1, post.Time))
+
+	res, err := BuildTrackingData(ctx, store, ShellTimeConfig{})
+	require.NoError(t, err)
+	require.Len(t, res.Data, 1)
+	require.Equal(t, []int{0, 1}, res.Data[0].PipeStatus)
+	require.Equal(t, post.Time.Add(-post.Duration).UnixNano(), res.Data[0].StartTimeNano, "the shell's duration wins over the pre time")
+	require.Equal(t, post.Time.Unix(), res.Data[0].EndTime)
+}
+
+func TestBuildTrackingDataSessions(t *testing.T) {
+	store, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	require.NoError(t, store.SaveSessionEvent(ctx, SessionEvent{Type: SessionEventStart, Shell: "bash", SessionID: 1, Time: start, Terminal: "kitty"}, start))
+
+	cmd := Command{Shell: "bash", SessionID: 1, Command: "ls", Username: "u", Time: start}
+	require.NoError(t, store.SavePre(ctx, cmd, start.Add(time.Second)))
+	post := cmd
+	post.Time = start.Add(2 * time.Second)
+	require.NoError(t, store.SavePost(ctx, post, 0, post.Time))
+
+	// recorded after the last post: waits for the next sync
+	require.NoError(t, store.SaveSessionEvent(ctx, SessionEvent{Type: SessionEventEnd, Shell: "bash", SessionID: 1, Time: start.Add(time.Minute)}, start.Add(time.Minute)))
+
+	res, err := BuildTrackingData(ctx, store, ShellTimeConfig{})
+	require.NoError(t, err)
+	require.Len(t, res.Sessions, 1)
+	require.Equal(t, "start", res.Sessions[0].Type)
+	require.Equal(t, int64(1), res.Sessions[0].SessionID)
+	require.Equal(t, "kitty", res.Sessions[0].Terminal)
+	require.Equal(t, start.UnixNano(), res.Sessions[0].TimeNano)
+}
+
+func TestBuildTrackingDataMixedSessionIDs(t *testing.T) {
+	store, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	ctx := context.Background()
+	start := time.Now()
+	legacy := Command{Shell: "bash", SessionID: 20240405123456, Command: "sleep 30", Username: "u", Time: start}
+	require.NoError(t, store.SavePre(ctx, legacy, start))
+	// an old pre of the same command in another date-shaped session does not
+	// win over the closest one
+	older := legacy
+	older.SessionID = 20240404000000
+	older.Time = start.Add(-time.Hour)
+	require.NoError(t, store.SavePre(ctx, older, older.Time))
+
+	post := legacy
+	post.SessionID = NewSessionID(start, 99)
+	post.Time = start.Add(30 * time.Second)
+	require.NoError(t, store.SavePost(ctx, post, 0, post.Time))
+
+	res, err := BuildTrackingData(ctx, store, ShellTimeConfig{})
+	require.NoError(t, err)
+	require.Len(t, res.Data, 1)
+	require.Equal(t, post.SessionID, res.Data[0].SessionID)
+	require.Equal(t, start.Unix(), res.Data[0].StartTime)
+}
+
+func TestBuildTrackingDataRecordIDs(t *testing.T) {
+	for name, open := range map[string]func(t *testing.T) CommandStore{
+		StorageEngineFile: func(t *testing.T) CommandStore { setupMigrateTest(t); return newFileStore() },
+		StorageEngineBolt: func(t *testing.T) CommandStore {
+			s, err := newBoltStore(filepath.Join(t.TempDir(), "commands.db"))
+			require.NoError(t, err)
+			return s
+		},
+		StorageEngineSegment: func(t *testing.T) CommandStore { return newSegmentStore(t.TempDir()) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+
+			ctx := context.Background()
+			start := time.Now()
+			cmd := Command{Shell: "zsh", SessionID: 3, Command: "make test", Username: "u", Time: start}
+			require.NoError(t, store.SavePre(ctx, cmd, start))
+			post := cmd
+			post.Time = start.Add(time.Second)
+			require.NoError(t, store.SavePost(ctx, post, 0, post.Time))
+
+			pres, err := store.GetPreCommands(ctx)
+			require.NoError(t, err)
+			require.Len(t, pres, 1)
+			require.Equal(t, CommandID(3, start, "make test"), pres[0].ID)
+			posts, err := store.GetPostCommands(ctx)
+			require.NoError(t, err)
+			require.Len(t, posts, 1)
+			require.NotEmpty(t, posts[0].ID)
+
+			// the command is identified by when it started, which only the
+			// pre command knows
+			res, err := BuildTrackingData(ctx, store, ShellTimeConfig{})
+			require.NoError(t, err)
+			require.Len(t, res.Data, 1)
+			require.Equal(t, pres[0].ID, res.Data[0].ID)
+		})
+	}
+}
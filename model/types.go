@@ -1,7 +1,8 @@
 package model
 
 const (
-	DefaultSocketPath = "/tmp/shelltime.sock"
+	DefaultSocketPath    = "/tmp/shelltime.sock"
+	DefaultMetricsListen = "127.0.0.1:54028"
 )
 
 type Endpoint struct {
@@ -49,6 +50,14 @@ type LogCleanup struct {
 	ThresholdMB int64 `toml:"thresholdMB,omitempty" yaml:"thresholdMB,omitempty" json:"thresholdMB,omitempty"` // default: 100 MB
 }
 
+// MetricsEndpoint configuration for the daemon's Prometheus metrics endpoint
+type MetricsEndpoint struct {
+	Enabled *bool `toml:"enabled" yaml:"enabled" json:"enabled"`
+	// Listen is a loopback host:port, or unix:<path> to serve on a unix
+	// socket. default: 127.0.0.1:54028
+	Listen string `toml:"listen,omitempty" yaml:"listen,omitempty" json:"listen,omitempty"`
+}
+
 type ShellTimeConfig struct {
 	Token       string `toml:"Token" yaml:"token" json:"token"`
 	APIEndpoint string `toml:"APIEndpoint" yaml:"apiEndpoint,omitempty" json:"apiEndpoint,omitempty"`
@@ -97,6 +106,10 @@ type ShellTimeConfig struct {
 	// LogCleanup configuration for automatic log file cleanup in daemon
 	LogCleanup *LogCleanup `toml:"logCleanup" yaml:"logCleanup" json:"logCleanup"`
 
+	// MetricsEndpoint serves the daemon's counters and gauges in the
+	// Prometheus text format. Disabled by default.
+	MetricsEndpoint *MetricsEndpoint `toml:"metricsEndpoint" yaml:"metricsEndpoint,omitempty" json:"metricsEndpoint,omitempty"`
+
 	// Storage selects the local command buffering backend. When unset the
 	// always-available txt file store is used.
 	Storage *StorageConfig `toml:"storage" yaml:"storage,omitempty" json:"storage,omitempty"`
@@ -109,9 +122,38 @@ type ShellTimeConfig struct {
 // StorageConfig selects which CommandStore backend buffers tracked commands
 // before they sync to the server.
 type StorageConfig struct {
-	// Engine is "file" (default) or "bolt". The bolt engine is daemon-owned;
-	// see CommandStore for details.
+	// Engine is "file" (default), "bolt" or "segment". The bolt engine is
+	// daemon-owned; see CommandStore for details.
 	Engine string `toml:"engine" yaml:"engine" json:"engine"`
+
+	// Archive keeps synced commands locally instead of dropping them once
+	// they reach the server.
+	Archive *ArchiveConfig `toml:"archive,omitempty" yaml:"archive,omitempty" json:"archive,omitempty"`
+
+	// Encryption encrypts the buffered and archived commands at rest, with
+	// every engine.
+	Encryption *StorageEncryptionConfig `toml:"encryption,omitempty" yaml:"encryption,omitempty" json:"encryption,omitempty"`
+}
+
+// StorageEncryptionConfig controls the at-rest encryption of the local
+// command storage.
+type StorageEncryptionConfig struct {
+	Enabled *bool `toml:"enabled" yaml:"enabled" json:"enabled"` // default: false
+	// KeyFile holds the base64 encoded AES-256 key and must be readable by
+	// its owner only. Defaults to ~/.shelltime/storage.key, which is created
+	// on first use.
+	KeyFile string `toml:"keyFile,omitempty" yaml:"keyFile,omitempty" json:"keyFile,omitempty"`
+	// KeyCommand prints the key instead, e.g. from a password manager. It
+	// runs once per process and takes precedence over KeyFile.
+	KeyCommand string `toml:"keyCommand,omitempty" yaml:"keyCommand,omitempty" json:"keyCommand,omitempty"`
+}
+
+// ArchiveConfig controls the local long-term history archive.
+type ArchiveConfig struct {
+	Enabled *bool `toml:"enabled" yaml:"enabled" json:"enabled"` // default: false
+	// RetentionDays drops archived commands older than this many days.
+	// 0 keeps them forever.
+	RetentionDays int `toml:"retentionDays,omitempty" yaml:"retentionDays,omitempty" json:"retentionDays,omitempty"`
 }
 
 var DefaultAIConfig = &AIConfig{